@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"toy-note/api/entity"
+	"toy-note/api/service"
+	"toy-note/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// AdminController
+// Work for `Gin.Router`
+//
+// Routes of the "[admin]" operations, which are not scoped to an owner.
+// Permissions are checked by the service, a non-admin gets 403 Forbidden.
+type AdminController struct {
+	logger  *zap.SugaredLogger
+	service service.ToyNoteRepo
+	users   service.UserRepo
+}
+
+func NewAdminController(
+	logger *logger.ToyNoteLogger,
+	service service.ToyNoteRepo,
+	users service.UserRepo,
+) *AdminController {
+	return &AdminController{
+		logger:  logger.NewSugar("AdminController"),
+		service: service,
+		users:   users,
+	}
+}
+
+// the service of all users, guarded by the role of the authenticated user
+func (c *AdminController) repo(ctx *gin.Context) service.ToyNoteRepo {
+	return service.Guard(c.service, currentUser(ctx).Role, currentApiKey(ctx))
+}
+
+// @Summary      get unowned affiliates
+// @Description  Get affiliates which are not bound to any post, of all users.
+// @Tags         admin
+// @Param        page  query  int  true  "page number"
+// @Param        size  query  int  true  "page size"
+// @Produce      json
+// @Success      200  {object}  entity.AffiliatePage
+// @Failure      400  {object}  errorMessage
+// @Failure      403  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /admin/get-unowned-affiliates [get]
+func (c *AdminController) GetUnownedAffiliates(ctx *gin.Context) {
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if pagination.Cursor != "" {
+		err := errors.New("cursor is not available for affiliates")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	affiliates, err := c.repo(ctx).GetUnownedAffiliates(pagination)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, affiliates)
+}
+
+// @Summary      rebind an unowned affiliate
+// @Description  Rebind an unowned affiliate to a post, both must be owned by the same user.
+// @Tags         admin
+// @Param        post_id       query  int  true  "post ID"
+// @Param        affiliate_id  query  int  true  "affiliate ID"
+// @Produce      json
+// @Success      200  {object}  successMessage
+// @Failure      400  {object}  errorMessage
+// @Failure      403  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /admin/rebind-affiliate [post]
+func (c *AdminController) RebindAffiliate(ctx *gin.Context) {
+	postId, err := strconv.ParseUint(ctx.Query("post_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	affiliateId, err := strconv.ParseUint(ctx.Query("affiliate_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := c.repo(ctx).RebindAffiliate(uint(postId), uint(affiliateId)); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, successResponse(affiliateId))
+}
+
+// @Summary      delete unowned affiliates
+// @Description  Delete unowned affiliates by IDs, along with their files. Bound affiliates are skipped.
+// @Tags         admin
+// @Param        ids  query  []int  true  "affiliate IDs"
+// @Produce      json
+// @Success      200  {object}  successMessage
+// @Failure      400  {object}  errorMessage
+// @Failure      403  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /admin/delete-unowned-affiliates [delete]
+func (c *AdminController) DeleteUnownedAffiliates(ctx *gin.Context) {
+	ids, err := getUintsFromQuery(ctx, "ids")
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if len(ids) == 0 {
+		err := errors.New("ids query is required")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := c.repo(ctx).DeleteUnownedAffiliates(ids); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, successResponse(ids))
+}
+
+// @Summary      merge tags
+// @Description  Merge the source tags into the target tag in one transaction, all the tags must be owned by the same user.
+// @Description     Posts bound to the sources are bound to the target instead, the tags nested in the sources are moved to the target,
+// @Description     then the sources are deleted.
+// @Tags         admin
+// @Param        source_ids  query  []int  true  "source tag IDs"
+// @Param        target_id   query  int    true  "target tag ID"
+// @Produce      json
+// @Success      200  {object}  entity.Tag
+// @Failure      400  {object}  errorMessage
+// @Failure      403  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /admin/merge-tags [post]
+func (c *AdminController) MergeTags(ctx *gin.Context) {
+	sourceIds, err := getUintsFromQuery(ctx, "source_ids")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if len(sourceIds) == 0 {
+		err := errors.New("source_ids query is required")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	targetId, err := strconv.ParseUint(ctx.Query("target_id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	tag, err := c.repo(ctx).MergeTags(sourceIds, uint(targetId))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tag)
+}
+
+// @Summary      set the role of a user
+// @Description  Grant `reader`, `editor` or `admin` to a user, admins can't change their own role.
+// @Description  Access tokens already issued keep the previous role until they expire.
+// @Tags         admin
+// @Param        id    path   int     true  "user ID"
+// @Param        role  query  string  true  "reader, editor or admin"
+// @Produce      json
+// @Success      200  {object}  entity.User
+// @Failure      400  {object}  errorMessage
+// @Failure      403  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /admin/set-user-role/{id} [post]
+func (c *AdminController) SetUserRole(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	role, err := entity.ParseRole(ctx.Query("role"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if currentApiKey(ctx) != nil {
+		err := fmt.Errorf("%w: not permitted to api keys", service.ErrForbidden)
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	user, err := c.users.SetRole(currentUser(ctx), uint(id), role)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"toy-note/api/entity"
+	"toy-note/api/service"
+	"toy-note/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// the keys of the authenticated user and API key in `gin.Context`
+const (
+	userKey   = "user"
+	apiKeyKey = "apiKey"
+)
+
+// AuthController
+// Work for `Gin.Router`
+//
+// Routes of accounts, and the middleware authenticating the other routes
+type AuthController struct {
+	logger *zap.SugaredLogger
+	users  service.UserRepo
+}
+
+func NewAuthController(
+	logger *logger.ToyNoteLogger,
+	users service.UserRepo,
+) *AuthController {
+	return &AuthController{
+		logger: logger.NewSugar("AuthController"),
+		users:  users,
+	}
+}
+
+// the user authenticated by `AuthController.Authenticate`, it panics if the route is
+// not behind the middleware, rather than serving data of all users
+func currentUser(ctx *gin.Context) entity.User {
+	return ctx.MustGet(userKey).(entity.User)
+}
+
+// the API key the user is authenticated by, nil if authenticated by an access token
+func currentApiKey(ctx *gin.Context) *entity.ApiKey {
+	if key, ok := ctx.Get(apiKeyKey); ok {
+		return key.(*entity.ApiKey)
+	}
+	return nil
+}
+
+// Authenticate
+//
+// A middleware which requires a valid access token or API key given by
+// `Authorization: Bearer <token>`. The authenticated user is stored in the context,
+// see `currentUser`, and so is the API key, see `currentApiKey`.
+func (c *AuthController) Authenticate(ctx *gin.Context) {
+	header := ctx.GetHeader("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == "" || token == header {
+		c.unauthorized(ctx, errors.New("bearer token is required"))
+		return
+	}
+
+	if service.IsApiKey(token) {
+		user, key, err := c.users.AuthenticateApiKey(token)
+		if err != nil {
+			c.unauthorized(ctx, err)
+			return
+		}
+		ctx.Set(userKey, user)
+		ctx.Set(apiKeyKey, &key)
+		ctx.Next()
+		return
+	}
+
+	user, err := c.users.Authenticate(token)
+	if err != nil {
+		c.unauthorized(ctx, err)
+		return
+	}
+
+	ctx.Set(userKey, user)
+	ctx.Next()
+}
+
+// API keys are managed by the user themselves, never by another API key
+func (c *AuthController) requireSession(ctx *gin.Context) bool {
+	if currentApiKey(ctx) != nil {
+		err := fmt.Errorf("%w: not permitted to api keys", service.ErrForbidden)
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return false
+	}
+	return true
+}
+
+func (c *AuthController) unauthorized(ctx *gin.Context, err error) {
+	ctx.Header("WWW-Authenticate", `Bearer realm="toy-note"`)
+	ctx.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse(err))
+}
+
+// @Summary      register a user
+// @Description  Register a user by name and password, the name is unique.
+// @Description  The password must be 8 to 72 bytes.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.Credentials  true  "name and password"
+// @Success      200   {object}  entity.User
+// @Failure      400   {object}  errorMessage
+// @Router       /auth/register [post]
+func (c *AuthController) Register(ctx *gin.Context) {
+	var credentials entity.Credentials
+	if err := ctx.ShouldBindJSON(&credentials); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	user, err := c.users.Register(credentials)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+// @Summary      log in
+// @Description  Log in by name and password, and get an access token and a refresh token.
+// @Description  The access token is given by `Authorization: Bearer <token>` to the other routes,
+// @Description     the refresh token is exchanged for new tokens once the access token expires.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.Credentials  true  "name and password"
+// @Success      200   {object}  entity.TokenPair
+// @Failure      400   {object}  errorMessage
+// @Failure      401   {object}  errorMessage
+// @Router       /auth/login [post]
+func (c *AuthController) Login(ctx *gin.Context) {
+	var credentials entity.Credentials
+	if err := ctx.ShouldBindJSON(&credentials); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	tokens, err := c.users.Login(credentials)
+	if err != nil {
+		c.tokenError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// @Summary      refresh tokens
+// @Description  Exchange a refresh token for a new access token and a new refresh token.
+// @Description  A refresh token can be used only once, using it again logs out all the sessions of the user.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.RefreshRequest  true  "refresh token"
+// @Success      200   {object}  entity.TokenPair
+// @Failure      400   {object}  errorMessage
+// @Failure      401   {object}  errorMessage
+// @Router       /auth/refresh [post]
+func (c *AuthController) Refresh(ctx *gin.Context) {
+	var req entity.RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	tokens, err := c.users.Refresh(req.RefreshToken)
+	if err != nil {
+		c.tokenError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// @Summary      log out
+// @Description  Revoke a refresh token. The access token is still valid until it expires.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.RefreshRequest  true  "refresh token"
+// @Success      200   {object}  successMessage
+// @Failure      400   {object}  errorMessage
+// @Failure      401   {object}  errorMessage
+// @Router       /auth/logout [post]
+func (c *AuthController) Logout(ctx *gin.Context) {
+	var req entity.RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := c.users.Logout(req.RefreshToken); err != nil {
+		c.tokenError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, successResponse("logged out"))
+}
+
+// @Summary      create an API key
+// @Description  Create an API key for scripts and integrations, limited to the given scopes:
+// @Description     `posts:read`, `posts:write`, `files:read` and `files:write`.
+// @Description  The key is given by `Authorization: Bearer <key>` the same as an access token,
+// @Description     and it is only responded once.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.ApiKeyRequest  true  "name and scopes"
+// @Success      200   {object}  entity.NewApiKey
+// @Failure      400   {object}  errorMessage
+// @Failure      403   {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /create-api-key [post]
+func (c *AuthController) CreateApiKey(ctx *gin.Context) {
+	if !c.requireSession(ctx) {
+		return
+	}
+
+	var req entity.ApiKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	key, err := c.users.CreateApiKey(currentUser(ctx).Id, req)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, key)
+}
+
+// @Summary      get API keys
+// @Description  Get all API keys of the user, including revoked ones, newest first.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {array}   entity.ApiKey
+// @Failure      403  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /get-api-keys [get]
+func (c *AuthController) GetApiKeys(ctx *gin.Context) {
+	if !c.requireSession(ctx) {
+		return
+	}
+
+	keys, err := c.users.GetApiKeys(currentUser(ctx).Id)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, keys)
+}
+
+// @Summary      revoke an API key
+// @Description  Revoke an API key of the user by its prefix.
+// @Tags         auth
+// @Param        prefix  path  string  true  "API key prefix"
+// @Produce      json
+// @Success      200  {object}  successMessage
+// @Failure      403  {object}  errorMessage
+// @Failure      404  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /revoke-api-key/{prefix} [delete]
+func (c *AuthController) RevokeApiKey(ctx *gin.Context) {
+	if !c.requireSession(ctx) {
+		return
+	}
+
+	prefix := ctx.Param("prefix")
+	if err := c.users.RevokeApiKey(currentUser(ctx).Id, prefix); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusNotFound, errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, successResponse(prefix))
+}
+
+// invalid credentials or tokens are 401, anything else is an internal error
+func (c *AuthController) tokenError(ctx *gin.Context, err error) {
+	if errors.Is(err, service.ErrInvalidCredentials) || errors.Is(err, service.ErrInvalidToken) {
+		c.unauthorized(ctx, err)
+		return
+	}
+
+	c.logger.Error(err)
+	ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+}
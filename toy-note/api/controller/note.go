@@ -4,16 +4,24 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"toy-note/api/entity"
 	"toy-note/api/service"
+	"toy-note/api/util"
 	"toy-note/logger"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// the group all the routes are registered under, see `main`
+const apiBasePath = "/api"
+
 // ToyNoteController
 // Work for `Gin.Router`
 //
@@ -33,6 +41,30 @@ func NewToyNoteController(
 	}
 }
 
+// the service scoped to the authenticated user, so that a user never sees data of others,
+// and guarded by the role of the user
+func (c *ToyNoteController) repo(ctx *gin.Context) service.ToyNoteRepo {
+	user := currentUser(ctx)
+	return service.Guard(c.service.ForOwner(user.Id), user.Role, currentApiKey(ctx))
+}
+
+// the repository of the user for listing and searching posts, scoped to the notebook given by
+// `?notebook_id=`, and the notebooks nested in it by `?recursive=true`
+func (c *ToyNoteController) postsRepo(ctx *gin.Context) (service.ToyNoteRepo, error) {
+	repo := c.repo(ctx)
+
+	scope, err := getNotebookScopeFromQuery(ctx)
+	if err != nil || scope == nil {
+		return repo, err
+	}
+	// an unknown notebook is reported, rather than found nothing
+	if _, err := repo.GetNotebook(scope.NotebookId); err != nil {
+		return nil, err
+	}
+
+	return repo.InNotebook(*scope), nil
+}
+
 // ============================================================================
 // Tag
 // ============================================================================
@@ -42,12 +74,13 @@ func NewToyNoteController(
 // @Tags         tag
 // @Produce      json
 // @Success      200  {array}  entity.Tag
+// @Security     BearerAuth
 // @Router       /get-tags [get]
 func (c *ToyNoteController) GetTags(ctx *gin.Context) {
-	tags, err := c.service.GetTags()
+	tags, err := c.repo(ctx).GetTags()
 	if err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
 		return
 	}
 
@@ -55,13 +88,19 @@ func (c *ToyNoteController) GetTags(ctx *gin.Context) {
 }
 
 // @Summary      create/update a tag
-// @Description  create a new tag or update an existing tag, based on whether the tag ID is provided
+// @Description  Create a new tag or update an existing tag, based on whether the tag ID is provided.
+// @Description  The version being edited can be given in the body or by `If-Match`,
+// @Description     a stale version is rejected with the current tag.
 // @Tags         tag
 // @Accept       json
 // @Produce      json
-// @Param        data  body      entity.Tag  true  "tag data"
-// @Success      200   {object}  entity.Tag
-// @Failure      400    {object}  errorMessage
+// @Param        data      body      entity.Tag  true   "tag data"
+// @Param        If-Match  header    string      false  "ETag of the version being edited"
+// @Success      200       {object}  entity.Tag
+// @Failure      400       {object}  errorMessage
+// @Failure      403       {object}  errorMessage
+// @Failure      409       {object}  conflictMessage
+// @Security     BearerAuth
 // @Router       /save-tag [post]
 func (c *ToyNoteController) SaveTag(ctx *gin.Context) {
 	var tag entity.Tag
@@ -71,13 +110,22 @@ func (c *ToyNoteController) SaveTag(ctx *gin.Context) {
 		return
 	}
 
-	tag, err := c.service.SaveTag(tag)
+	version, err := getVersion(ctx, tag.Version)
 	if err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	tag.Version = version
+
+	tag, err = c.repo(ctx).SaveTag(tag)
+	if err != nil {
+		c.logger.Error(err)
+		saveErrorResponse(ctx, err)
 		return
 	}
 
+	ctx.Header("ETag", versionETag(tag.Version))
 	ctx.JSON(http.StatusOK, tag)
 }
 
@@ -87,7 +135,9 @@ func (c *ToyNoteController) SaveTag(ctx *gin.Context) {
 // @Produce      json
 // @Param        id   path      int  true  "tag ID"
 // @Success      200  {object}  successMessage
+// @Failure      403  {object}  errorMessage
 // @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
 // @Router       /delete-tag/{id} [delete]
 func (c *ToyNoteController) DeleteTag(ctx *gin.Context) {
 	idParam := ctx.Param("id")
@@ -96,9 +146,9 @@ func (c *ToyNoteController) DeleteTag(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
-	if err := c.service.DeleteTag(uint(id)); err != nil {
+	if err := c.repo(ctx).DeleteTag(uint(id)); err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
 		return
 	}
 
@@ -106,157 +156,235 @@ func (c *ToyNoteController) DeleteTag(ctx *gin.Context) {
 }
 
 // ============================================================================
-// Post
+// Notebook
 // ============================================================================
 
-// @Summary      get all posts
-// @Description  get all posts with pagination restriction
-// @Tags         post
-// @Param        page   query  int     true   "page number"
-// @Param        size   query  int     true   "page size"
+// @Summary      get all notebooks
+// @Description  Get all notebooks as trees, each with the number of posts right in it. Notebooks are ordered by name.
+// @Tags         notebook
 // @Produce      json
-// @Success      200  {array}  entity.Post
-// @Router       /get-posts [get]
-func (c *ToyNoteController) GetPosts(ctx *gin.Context) {
-	pagination, err := getPaginationFromQuery(ctx)
+// @Success      200  {array}  entity.NotebookNode
+// @Security     BearerAuth
+// @Router       /get-notebooks [get]
+func (c *ToyNoteController) GetNotebooks(ctx *gin.Context) {
+	tree, err := c.repo(ctx).GetNotebookTree()
 	if err != nil {
 		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tree)
+}
+
+// @Summary      get a notebook by ID
+// @Tags         notebook
+// @Produce      json
+// @Param        id   path      int  true  "notebook ID"
+// @Success      200  {object}  entity.Notebook
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /get-notebook/{id} [get]
+func (c *ToyNoteController) GetNotebook(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	// get post from service
-	posts, err := c.service.GetPosts(pagination)
+	notebook, err := c.repo(ctx).GetNotebook(uint(id))
 	if err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, posts)
+	ctx.JSON(http.StatusOK, notebook)
 }
 
-// @Summary      create/update a post
-// @Description  Save post can be used to create a new post or update an existing post.
-// @Description  If id is not provided, it will create a new post; Otherwise, it will update
-// @Description     an existing post.
-// @Tags         post
-// @Accept       multipart/form-data
+// @Summary      create/update a notebook
+// @Description  Create a new notebook or update an existing notebook, based on whether the notebook ID is provided.
+// @Description  On update, both the name and the parent are replaced, an empty `parent_id` moves the notebook to the root.
+// @Description     A notebook can't be nested in itself, nor in the notebooks nested in it.
+// @Tags         notebook
+// @Accept       json
 // @Produce      json
-// @Param        data   formData  string  true   "post data"
-// @Param        files  formData  file    false  "affiliate files"
-// @Success      200    {object}  entity.Post
+// @Param        data  body      entity.Notebook  true  "notebook data"
+// @Success      200   {object}  entity.Notebook
 // @Failure      400   {object}  errorMessage
-// @Router       /save-post [post]
-func (c *ToyNoteController) SavePost(ctx *gin.Context) {
-	// get multipart form
-	form, err := ctx.MultipartForm()
-	if err != nil {
+// @Failure      403   {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /save-notebook [post]
+func (c *ToyNoteController) SaveNotebook(ctx *gin.Context) {
+	var notebook entity.Notebook
+	if err := ctx.ShouldBindJSON(&notebook); err != nil {
 		c.logger.Error(err)
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	// get `entity.Post` from "data" field, default data is an array of string.
-	// if multiple values are provided, we shall only take the first one.
-	data := form.Value["data"]
-	if len(data) < 1 {
-		err := errors.New("filed: data is missing")
+	notebook, err := c.repo(ctx).SaveNotebook(notebook)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, notebook)
+}
+
+// @Summary      delete a notebook by ID
+// @Description  Delete a notebook by ID. A notebook which still has notebooks or posts (trashed posts included)
+// @Description     is refused with 409 Conflict, unless `move_to` is given: either `root` or the ID of another notebook,
+// @Description     where its notebooks and posts are moved to.
+// @Tags         notebook
+// @Produce      json
+// @Param        id       path      int     true   "notebook ID"
+// @Param        move_to  query     string  false  "root or a notebook ID"
+// @Success      200      {object}  successMessage
+// @Failure      400      {object}  errorMessage
+// @Failure      403      {object}  errorMessage
+// @Failure      409      {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /delete-notebook/{id} [delete]
+func (c *ToyNoteController) DeleteNotebook(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	// unmarshal: string -> json
-	var post entity.Post
-	err = json.Unmarshal([]byte(data[0]), &post)
+	moveTo, err := getDestinationFromQuery(ctx, "move_to")
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, nil)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	// bind json to `post`
-	if err := ctx.ShouldBindJSON(&post); err != nil {
+	if err := c.repo(ctx).DeleteNotebook(uint(id), moveTo); err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		status := http.StatusInternalServerError
+		if errors.Is(err, entity.ErrNotebookNotEmpty) {
+			status = http.StatusConflict
+		}
+		ctx.JSON(errorStatus(err, status), errorResponse(err))
 		return
 	}
 
-	// get files from "files" field, accepting multiple "files" fields
-	files := form.File["files"]
+	ctx.JSON(http.StatusOK, successResponse(id))
+}
 
-	// check if new affiliates has the same length as multipart form's length
-	newAffiliatesLen := 0
-	filesLen := len(files)
-	for _, a := range post.Affiliates {
-		if a.Id == 0 {
-			newAffiliatesLen++
-		}
+// @Summary      move a post to a notebook
+// @Description  Move a post to a notebook, or to the root if `notebook_id` is empty. The post is not updated otherwise,
+// @Description     its version is kept.
+// @Tags         notebook
+// @Accept       json
+// @Produce      json
+// @Param        id    path      int                 true  "post ID"
+// @Param        data  body      entity.Destination  true  "destination"
+// @Success      200   {object}  entity.Post
+// @Failure      400   {object}  errorMessage
+// @Failure      403   {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /move-post/{id} [post]
+func (c *ToyNoteController) MovePost(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
 	}
-	if filesLen != newAffiliatesLen {
-		c.logger.Error(fmt.Sprintf(
-			"new affiliates length %d not match files length %d",
-			newAffiliatesLen,
-			filesLen,
-		))
+
+	var to entity.Destination
+	if err := ctx.ShouldBindJSON(&to); err != nil {
+		c.logger.Error(err)
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	// oids used for storing object ids returned from mongo service,
-	// later we need it to be recorded in post's affiliate.
-	var oids []string
-	for _, file := range files {
-		filename := file.Filename
+	post, err := c.repo(ctx).MovePost(uint(id), to)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
 
-		// open file
-		file, err := file.Open()
-		if err != nil {
-			c.logger.Error(err)
-			ctx.JSON(http.StatusBadRequest, errorResponse(err))
-			return
-		}
-		defer file.Close()
+	ctx.JSON(http.StatusOK, post)
+}
 
-		// upload file to MongoDB and get returned ObjectId
-		oid, err := c.service.UploadAffiliate(file, filename)
-		if err != nil {
-			c.logger.Error(err)
-			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
-			return
-		}
+// ============================================================================
+// Post
+// ============================================================================
 
-		oids = append(oids, oid)
+// @Summary      get all posts
+// @Description  Get all posts with pagination restriction, newest first.
+// @Description  Instead of `page`, the `next_cursor` of the previous page can be given as `cursor`,
+// @Description     which is not shifted by posts added in the meanwhile.
+// @Tags         post
+// @Param        page         query  int     false  "page number, required without cursor"
+// @Param        size         query  int     true   "page size"
+// @Param        cursor       query  string  false  "next_cursor of the previous page"
+// @Param        notebook_id  query  int     false  "only posts in the notebook"
+// @Param        recursive    query  bool    false  "including the notebooks nested in it"
+// @Param        format       query  string  false  "markdown (default) or html"
+// @Produce      json
+// @Success      200  {object}  entity.PostPage
+// @Failure      400  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /get-posts [get]
+func (c *ToyNoteController) GetPosts(ctx *gin.Context) {
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
 	}
 
-	// rebind ObjectIds to post's new affiliates
-	oidsIdx := 0
-	for idx, a := range post.Affiliates {
-		if a.Id == 0 {
-			post.Affiliates[idx].ObjectId = oids[oidsIdx]
-			oidsIdx++
-		}
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
 	}
 
-	// save post to PG
-	post, err = c.service.SavePost(post)
+	repo, err := c.postsRepo(ctx)
 	if err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, post)
+	// get post from service
+	posts, err := repo.GetPosts(pagination)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	if err := c.renderPosts(ctx, format, posts.Items); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, posts)
 }
 
-// @Summary      delete a post by ID
-// @Description  delete a post by ID
+// @Summary      get a post by ID
+// @Description  Get a post by ID, with its tags and affiliates.
+// @Description  Its version is responded as `ETag`, which can be sent back by `If-Match` on save.
+// @Description  With `format=html`, the Markdown content is rendered as sanitized HTML in `content_html`.
 // @Tags         post
+// @Param        id      path   int     true   "post ID"
+// @Param        format  query  string  false  "markdown (default) or html"
 // @Produce      json
-// @Param        id   path      string  true  "post ID"
-// @Success      200  {object}  successMessage
+// @Success      200  {object}  entity.Post
+// @Success      304  {string}  string  "not modified"
 // @Failure      500  {object}  errorMessage
-// @Router       /delete-post/{id} [delete]
-func (c *ToyNoteController) DeletePost(ctx *gin.Context) {
+// @Security     BearerAuth
+// @Router       /get-post/{id} [get]
+func (c *ToyNoteController) GetPost(ctx *gin.Context) {
 	idParam := ctx.Param("id")
 	id, err := strconv.ParseUint(idParam, 10, 64)
 	if err != nil {
@@ -264,138 +392,282 @@ func (c *ToyNoteController) DeletePost(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.service.DeletePost(uint(id)); err != nil {
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	post, err := c.repo(ctx).GetPost(uint(id))
+	if err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, successResponse(id))
+	etag := versionETag(post.Version)
+	ctx.Header("ETag", etag)
+	if ctx.GetHeader("If-None-Match") == etag {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	posts := []entity.Post{post}
+	if err := c.renderPosts(ctx, format, posts); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, posts[0])
 }
 
-// @Summary      get posts by tags
-// @Description  get posts by tags
+// @Summary      create/update a post
+// @Description  Save post can be used to create a new post or update an existing post.
+// @Description  If id is not provided, it will create a new post; Otherwise, it will update
+// @Description     an existing post.
+// @Description  The version being edited can be given in "data" or by `If-Match`,
+// @Description     a stale version is rejected with the current post.
+// @Description  The content may refer to affiliates bound to the post by `![](affiliate:42)` or
+// @Description     `[spec](affiliate:42)`, referring to any other affiliate is rejected, as well as
+// @Description     unbinding an affiliate which is still referred to.
 // @Tags         post
-// @Param        page  query  int     true  "page number"
-// @Param        size  query  int     true  "page size"
-// @Param        ids   query  string  true  "tag ids"
+// @Accept       multipart/form-data
 // @Produce      json
-// @Success      200  {array}  entity.Post
-// @Router       /search-posts-by-tags [get]
-func (c *ToyNoteController) SearchPostsByTags(ctx *gin.Context) {
-	pagination, err := getPaginationFromQuery(ctx)
+// @Description  The "data" field must precede "files" fields, since files are streamed.
+// @Param        data      formData  string  true   "post data"
+// @Param        files     formData  file    false  "affiliate files"
+// @Param        If-Match  header    string  false  "ETag of the version being edited"
+// @Success      200       {object}  entity.Post
+// @Failure      400       {object}  errorMessage
+// @Failure      403       {object}  errorMessage
+// @Failure      409       {object}  conflictMessage
+// @Failure      422       {object}  referenceMessage
+// @Security     BearerAuth
+// @Router       /save-post [post]
+func (c *ToyNoteController) SavePost(ctx *gin.Context) {
+	// read the multipart body part by part, instead of `ctx.MultipartForm`, so that
+	// files are streamed into the blob store without being buffered in memory.
+	reader, err := ctx.Request.MultipartReader()
 	if err != nil {
 		c.logger.Error(err)
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	// ids is an array of uint
-	ids := ctx.QueryArray("ids")
-	var idsUint []uint
-	for _, id := range ids {
-		idUint, err := strconv.ParseUint(id, 10, 64)
+	var post entity.Post
+	hasData := false
+	newAffiliatesLen := 0
+
+	// uploaded is used for storing object ids and file metadata returned from
+	// the blob store, later we need it to be recorded in post's affiliate.
+	var uploaded []entity.Affiliate
+
+	// files uploaded by a request which fails are bound to nothing, hence they are deleted
+	saved := false
+	defer func() {
+		if saved || len(uploaded) == 0 {
+			return
+		}
+		oids := make([]string, len(uploaded))
+		for i, a := range uploaded {
+			oids[i] = a.ObjectId
+		}
+		if err := c.repo(ctx).DiscardUploads(oids); err != nil {
+			c.logger.Error(err)
+		}
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			c.logger.Error(err)
 			ctx.JSON(http.StatusBadRequest, errorResponse(err))
 			return
 		}
-		idsUint = append(idsUint, uint(idUint))
+
+		switch part.FormName() {
+		case "data":
+			// get `entity.Post` from "data" field.
+			// if multiple values are provided, we shall only take the first one.
+			if hasData {
+				break
+			}
+			if err := json.NewDecoder(part).Decode(&post); err != nil {
+				c.logger.Error(err)
+				ctx.JSON(http.StatusBadRequest, errorResponse(err))
+				return
+			}
+			hasData = true
+
+			// reject a bad version before any file is uploaded
+			post.Version, err = getVersion(ctx, post.Version)
+			if err != nil {
+				c.logger.Error(err)
+				ctx.JSON(http.StatusBadRequest, errorResponse(err))
+				return
+			}
+
+			for _, a := range post.Affiliates {
+				if a.Id == 0 {
+					newAffiliatesLen++
+				}
+			}
+		case "files":
+			// files are consumed on the fly, hence "data" must come first,
+			// otherwise we do not know how many files are expected
+			if !hasData {
+				err := errors.New("field: data must be provided before files")
+				ctx.JSON(http.StatusBadRequest, errorResponse(err))
+				return
+			}
+			if len(uploaded) == newAffiliatesLen {
+				err := fmt.Errorf("new affiliates length %d is less than files length", newAffiliatesLen)
+				c.logger.Error(err)
+				ctx.JSON(http.StatusBadRequest, errorResponse(err))
+				return
+			}
+
+			// upload file to the blob store and get returned ObjectId and metadata
+			affiliate, err := c.repo(ctx).UploadAffiliate(part, part.FileName())
+			if err != nil {
+				c.logger.Error(err)
+				ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+				return
+			}
+
+			uploaded = append(uploaded, affiliate)
+		}
+
+		part.Close()
 	}
 
-	posts, err := c.service.SearchPostsByTags(idsUint, pagination)
-	if err != nil {
-		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+	if !hasData {
+		err := errors.New("field: data is missing")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, posts)
-}
-
-// @Summary      get posts by title
-// @Description  get posts by title
-// @Tags         post
-// @Param        page   query  int     true  "page number"
-// @Param        size   query  int     true  "page size"
-// @Param        title  query  string  true  "post title"
-// @Produce      json
-// @Success      200  {array}  entity.Post
-// @Router       /search-posts-by-title [get]
-func (c *ToyNoteController) SearchPostsByTitle(ctx *gin.Context) {
-	pagination, err := getPaginationFromQuery(ctx)
-	if err != nil {
+	// check if new affiliates has the same length as files
+	if len(uploaded) != newAffiliatesLen {
+		err := fmt.Errorf(
+			"new affiliates length %d not match files length %d",
+			newAffiliatesLen,
+			len(uploaded),
+		)
 		c.logger.Error(err)
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	titleQuery, v := ctx.GetQuery("title")
-	if !v {
-		err := errors.New("title query is required")
-		ctx.JSON(http.StatusBadRequest, errorResponse(err))
-		return
+	// rebind ObjectIds and file metadata to post's new affiliates
+	uploadedIdx := 0
+	for idx, a := range post.Affiliates {
+		if a.Id == 0 {
+			u := uploaded[uploadedIdx]
+			if a.Filename != "" {
+				u.Filename = a.Filename
+			}
+			post.Affiliates[idx] = u
+			uploadedIdx++
+		}
 	}
 
-	posts, err := c.service.SearchPostsByTitle(titleQuery, pagination)
+	// save post to PG
+	post, err = c.repo(ctx).SavePost(post)
 	if err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		saveErrorResponse(ctx, err)
 		return
 	}
+	saved = true
 
-	ctx.JSON(http.StatusOK, posts)
+	ctx.Header("ETag", versionETag(post.Version))
+	ctx.JSON(http.StatusOK, post)
 }
 
-// @Summary      get posts by title
-// @Description  get posts by title
+// @Summary      patch a post
+// @Description  Patch a post by a JSON Merge Patch (`application/merge-patch+json`, or `application/json`) or
+// @Description     a JSON Patch (`application/json-patch+json`), applied to the post as it is responded.
+// @Description  Fields omitted are kept, and so are the tags and affiliates which are not operated on. Besides
+// @Description     `tags` and `affiliates`, tags and existing affiliates are bound and unbound by ids in
+// @Description     `add_tags`, `remove_tags`, `add_affiliates` and `remove_affiliates`, which are empty arrays.
+// @Description  The version being patched can be given by `version` or `If-Match`, a stale version is rejected
+// @Description     with the current post. A failed `test` of a JSON Patch is a conflict as well.
 // @Tags         post
-// @Param        page  query  int  true  "page number"
-// @Param        size  query  int  true  "page size"
-// @Param        start  query  string  true   "time start"
-// @Param        end    query  string  true   "time end"
-// @Param        type   query  string  false  "time type"
+// @Accept       json
+// @Accept       application/merge-patch+json
+// @Accept       application/json-patch+json
 // @Produce      json
-// @Success      200  {array}  entity.Post
-// @Router       /search-posts-by-time [get]
-func (c *ToyNoteController) SearchPostsByTime(ctx *gin.Context) {
-	pagination, err := getPaginationFromQuery(ctx)
+// @Param        id        path      int     true   "post ID"
+// @Param        patch     body      object  true   "merge patch or JSON patch"
+// @Param        If-Match  header    string  false  "ETag of the version being patched"
+// @Success      200       {object}  entity.Post
+// @Failure      400       {object}  errorMessage
+// @Failure      403       {object}  errorMessage
+// @Failure      409       {object}  conflictMessage
+// @Failure      415       {object}  errorMessage
+// @Failure      422       {object}  referenceMessage
+// @Security     BearerAuth
+// @Router       /posts/{id} [patch]
+func (c *ToyNoteController) PatchPost(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
 	if err != nil {
-		c.logger.Error(err)
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	timeType, err := getTimeSearchFromQuery(ctx)
+	format, err := entity.ParsePatchFormat(ctx.GetHeader("Content-Type"))
+	if err != nil {
+		ctx.JSON(http.StatusUnsupportedMediaType, errorResponse(err))
+		return
+	}
+	version, err := getVersion(ctx, 0)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	patch, err := ioutil.ReadAll(ctx.Request.Body)
 	if err != nil {
 		c.logger.Error(err)
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	posts, err := c.service.SearchPostsByTimeRange(timeType, pagination)
+	post, err := c.repo(ctx).PatchPost(uint(id), entity.PostPatch{Format: format, Patch: patch, Version: version})
 	if err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		switch {
+		case errors.Is(err, service.ErrBadPatch):
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		case errors.Is(err, util.ErrPatchTest):
+			ctx.JSON(http.StatusConflict, errorResponse(err))
+		default:
+			saveErrorResponse(ctx, err)
+		}
 		return
 	}
 
-	ctx.JSON(http.StatusOK, posts)
+	ctx.Header("ETag", versionETag(post.Version))
+	ctx.JSON(http.StatusOK, post)
 }
 
-// ============================================================================
-// Affiliate
-// ============================================================================
-
-// @Summary      download an affiliate by ID
-// @Description  download an affiliate by ID
-// @Tags         affiliate
+// @Summary      delete a post by ID
+// @Description  Move a post to trash by ID, it can be restored by `restore-post` until it is purged.
+// @Description     The wiki-style links to the post from other posts are broken, they are responded in `broken_links`.
+// @Tags         post
 // @Produce      json
-// @Param        id   path      int  true  "affiliate ID"
-// @Success      200  {object}  downloadSuccess
+// @Param        id   path      string  true  "post ID"
+// @Success      200  {object}  deleteMessage
+// @Failure      403  {object}  errorMessage
 // @Failure      500  {object}  errorMessage
-// @Router       /download-file [get]
-func (c *ToyNoteController) DownloadAffiliate(ctx *gin.Context) {
+// @Security     BearerAuth
+// @Router       /delete-post/{id} [delete]
+func (c *ToyNoteController) DeletePost(ctx *gin.Context) {
 	idParam := ctx.Param("id")
 	id, err := strconv.ParseUint(idParam, 10, 64)
 	if err != nil {
@@ -403,17 +675,1220 @@ func (c *ToyNoteController) DownloadAffiliate(ctx *gin.Context) {
 		return
 	}
 
-	fo, err := c.service.DownloadAffiliate(uint(id))
+	brokenLinks, err := c.repo(ctx).DeletePost(uint(id))
 	if err != nil {
 		c.logger.Error(err)
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
 		return
 	}
 
-	ctx.Header("Content-Disposition", "attachment; filename="+fo.Filename)
-	ctx.Data(http.StatusOK, "application/octet-stream", fo.Content)
-	ctx.JSON(http.StatusOK, downloadSuccess{
-		Filename: fo.Filename,
-		Size:     fo.Size,
-	})
+	ctx.JSON(http.StatusOK, deleteMessage{successMessage: successResponse(id), BrokenLinks: brokenLinks})
+}
+
+// @Summary      get trashed posts
+// @Description  Get trashed posts with pagination restriction, newest first.
+// @Tags         trash
+// @Param        page         query  int     false  "page number, required without cursor"
+// @Param        size         query  int     true   "page size"
+// @Param        cursor       query  string  false  "next_cursor of the previous page"
+// @Param        notebook_id  query  int     false  "only posts in the notebook"
+// @Param        recursive    query  bool    false  "including the notebooks nested in it"
+// @Param        format       query  string  false  "markdown (default) or html"
+// @Produce      json
+// @Success      200  {object}  entity.PostPage
+// @Failure      400  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /get-trash [get]
+func (c *ToyNoteController) GetTrash(ctx *gin.Context) {
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	repo, err := c.postsRepo(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	posts, err := repo.GetTrash(pagination)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	if err := c.renderPosts(ctx, format, posts.Items); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, posts)
+}
+
+// @Summary      restore a trashed post by ID
+// @Description  Restore a trashed post by ID, with the tags and affiliates it was bound to.
+// @Tags         trash
+// @Produce      json
+// @Param        id   path      string  true  "post ID"
+// @Success      200  {object}  successMessage
+// @Failure      403  {object}  errorMessage
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /restore-post/{id} [post]
+func (c *ToyNoteController) RestorePost(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := c.repo(ctx).RestorePost(uint(id)); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, successResponse(id))
+}
+
+// @Summary      purge a trashed post by ID
+// @Description  Permanently delete a trashed post by ID, along with its affiliates and their files.
+// @Description  Only trashed posts can be purged.
+// @Tags         trash
+// @Produce      json
+// @Param        id   path      string  true  "post ID"
+// @Success      200  {object}  successMessage
+// @Failure      403  {object}  errorMessage
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /purge-post/{id} [delete]
+func (c *ToyNoteController) PurgePost(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := c.repo(ctx).PurgePost(uint(id)); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, successResponse(id))
+}
+
+// ============================================================================
+// Revision
+// ============================================================================
+
+// @Summary      get revisions of a post
+// @Description  Get revisions of a post by pagination, newest first. A revision is recorded every time the post is saved.
+// @Tags         revision
+// @Param        id    path   int  true  "post ID"
+// @Param        page  query  int  true  "page number"
+// @Param        size  query  int  true  "page size"
+// @Produce      json
+// @Success      200  {object}  entity.RevisionPage
+// @Failure      400  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /get-revisions/{id} [get]
+func (c *ToyNoteController) GetRevisions(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if pagination.Cursor != "" {
+		err := errors.New("cursor is not available for revisions")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	revisions, err := c.repo(ctx).GetRevisions(uint(id), pagination)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, revisions)
+}
+
+// @Summary      get a revision by ID
+// @Description  get a revision by ID
+// @Tags         revision
+// @Param        id  path  int  true  "revision ID"
+// @Produce      json
+// @Success      200  {object}  entity.PostRevision
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /get-revision/{id} [get]
+func (c *ToyNoteController) GetRevision(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	revision, err := c.repo(ctx).GetRevision(uint(id))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, revision)
+}
+
+// @Summary      diff two revisions
+// @Description  Line-level diff between two revisions of the same post, from `from` to `to`.
+// @Description  Title, subtitle and content are diffed line by line (`=` kept, `-` deleted, `+` inserted),
+// @Description     tags and affiliates by ids.
+// @Tags         revision
+// @Param        from  query  int  true  "revision ID"
+// @Param        to    query  int  true  "revision ID"
+// @Produce      json
+// @Success      200  {object}  entity.RevisionDiff
+// @Failure      400  {object}  errorMessage
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /diff-revisions [get]
+func (c *ToyNoteController) DiffRevisions(ctx *gin.Context) {
+	from, err := strconv.ParseUint(ctx.Query("from"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	to, err := strconv.ParseUint(ctx.Query("to"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	diff, err := c.repo(ctx).DiffRevisions(uint(from), uint(to))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, diff)
+}
+
+// @Summary      restore a post to a revision
+// @Description  Restore a post to a revision by ID, which is recorded as a new revision.
+// @Description  Tags and affiliates deleted or bound to another post since then are skipped.
+// @Tags         revision
+// @Param        id  path  int  true  "revision ID"
+// @Produce      json
+// @Success      200  {object}  entity.Post
+// @Failure      403  {object}  errorMessage
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /restore-revision/{id} [post]
+func (c *ToyNoteController) RestoreRevision(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	post, err := c.repo(ctx).RestoreRevision(uint(id))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, post)
+}
+
+// ============================================================================
+// Share
+// ============================================================================
+
+// @Summary      create a share link of a post
+// @Description  Create a public read-only link of a post, which is opened by `shared/{token}` without an account.
+// @Description  The token is only responded once. Optionally protected by a password, which visitors give
+// @Description     by HTTP basic auth, and expires at `expires_at`.
+// @Tags         share
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.ShareRequest  true  "post ID, password and expiry"
+// @Success      200   {object}  entity.ShareLink
+// @Failure      400   {object}  errorMessage
+// @Failure      403   {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /create-share [post]
+func (c *ToyNoteController) CreateShare(ctx *gin.Context) {
+	var req entity.ShareRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	link, err := c.repo(ctx).CreateShare(req)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, link)
+}
+
+// @Summary      get share links of a post
+// @Description  Get all share links of a post, including revoked and expired ones, newest first.
+// @Tags         share
+// @Param        id  path  int  true  "post ID"
+// @Produce      json
+// @Success      200  {array}   entity.Share
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /get-shares/{id} [get]
+func (c *ToyNoteController) GetShares(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	shares, err := c.repo(ctx).GetShares(uint(id))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, shares)
+}
+
+// @Summary      revoke a share link
+// @Description  Revoke a share link by ID, it can't be opened anymore.
+// @Tags         share
+// @Param        id  path  int  true  "share ID"
+// @Produce      json
+// @Success      200  {object}  successMessage
+// @Failure      403  {object}  errorMessage
+// @Failure      404  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /revoke-share/{id} [delete]
+func (c *ToyNoteController) RevokeShare(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := c.repo(ctx).RevokeShare(uint(id)); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusNotFound), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, successResponse(id))
+}
+
+// @Summary      open a share link
+// @Description  Get the post shared by a link, no account is required.
+// @Description  A password protected link requires the password by HTTP basic auth, the user name is ignored.
+// @Tags         share
+// @Param        token   path   string  true   "share token"
+// @Param        format  query  string  false  "markdown (default) or html"
+// @Produce      json
+// @Success      200  {object}  entity.SharedPost
+// @Failure      401  {object}  errorMessage
+// @Failure      404  {object}  errorMessage
+// @Router       /shared/{token} [get]
+func (c *ToyNoteController) GetSharedPost(ctx *gin.Context) {
+	_, password, _ := ctx.Request.BasicAuth()
+
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	token := ctx.Param("token")
+	post, err := c.service.OpenShare(token, password, format)
+	if err != nil {
+		c.shareErrorResponse(ctx, err)
+		return
+	}
+
+	// affiliates are downloaded by the same link
+	post.ContentHtml = util.ResolveAffiliateRefs(post.ContentHtml, func(id uint) string {
+		return fmt.Sprintf("%s/shared/%s/download-file/%d", apiBasePath, url.PathEscape(token), id)
+	})
+
+	ctx.JSON(http.StatusOK, post)
+}
+
+// @Summary      download a file of a share link
+// @Description  Download an affiliate of the post shared by a link, the same as `download-file`.
+// @Tags         share
+// @Produce      octet-stream
+// @Param        token              path      string  true   "share token"
+// @Param        id                 path      int     true   "affiliate ID"
+// @Param        inline             query     bool    false  "preview in browser"
+// @Param        Range              header    string  false  "bytes range, e.g. bytes=0-1023"
+// @Success      200                {file}    binary
+// @Success      206                {file}    binary
+// @Failure      401                {object}  errorMessage
+// @Failure      404                {object}  errorMessage
+// @Router       /shared/{token}/download-file/{id} [get]
+func (c *ToyNoteController) DownloadSharedAffiliate(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	inline, err := strconv.ParseBool(ctx.DefaultQuery("inline", "0"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	_, password, _ := ctx.Request.BasicAuth()
+	fo, err := c.service.DownloadSharedAffiliate(ctx.Param("token"), password, uint(id))
+	if err != nil {
+		c.shareErrorResponse(ctx, err)
+		return
+	}
+	defer fo.Content.Close()
+
+	serveFile(ctx, fo, inline)
+}
+
+// A wrong password is challenged by HTTP basic auth, so that browsers prompt for it
+func (c *ToyNoteController) shareErrorResponse(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSharePassword):
+		ctx.Header("WWW-Authenticate", `Basic realm="shared post"`)
+		ctx.JSON(http.StatusUnauthorized, errorResponse(err))
+	case errors.Is(err, service.ErrShareNotFound):
+		ctx.JSON(http.StatusNotFound, errorResponse(err))
+	default:
+		c.logger.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+	}
+}
+
+// ============================================================================
+// Link
+// ============================================================================
+
+// @Summary      get links of a post
+// @Description  Get the wiki-style links in the content of a post, `[[Post Title]]` or `[[post:123]]`, in order of appearance.
+// @Description     A link by title is resolved to the oldest post of the title, case-insensitive. A link to a missing or trashed post is broken.
+// @Tags         link
+// @Param        id  path  int  true  "post ID"
+// @Produce      json
+// @Success      200  {array}   entity.LinkedPost
+// @Failure      400  {object}  errorMessage
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /posts/{id}/links [get]
+func (c *ToyNoteController) GetPostLinks(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	links, err := c.repo(ctx).GetPostLinks(uint(id))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, links)
+}
+
+// @Summary      get backlinks of a post
+// @Description  Get the posts linking to a post, trashed posts are excluded.
+// @Tags         link
+// @Param        id  path  int  true  "post ID"
+// @Produce      json
+// @Success      200  {array}   entity.LinkedPost
+// @Failure      400  {object}  errorMessage
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /posts/{id}/backlinks [get]
+func (c *ToyNoteController) GetBacklinks(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	backlinks, err := c.repo(ctx).GetBacklinks(uint(id))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, backlinks)
+}
+
+// ============================================================================
+// Search
+// ============================================================================
+
+// @Summary      get posts by tags
+// @Description  get posts by tags
+// @Tags         post
+// @Param        page         query  int     false  "page number, required without cursor"
+// @Param        size         query  int     true   "page size"
+// @Param        cursor       query  string  false  "next_cursor of the previous page"
+// @Param        ids          query  string  true   "tag ids"
+// @Param        descendants  query  bool    false  "including the tags nested in them"
+// @Param        notebook_id  query  int     false  "only posts in the notebook"
+// @Param        recursive    query  bool    false  "including the notebooks nested in it"
+// @Param        format       query  string  false  "markdown (default) or html"
+// @Produce      json
+// @Success      200  {object}  entity.PostPage
+// @Security     BearerAuth
+// @Router       /search-posts-by-tags [get]
+func (c *ToyNoteController) SearchPostsByTags(ctx *gin.Context) {
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	// ids is an array of uint
+	idsUint, err := getUintsFromQuery(ctx, "ids")
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	descendants, err := strconv.ParseBool(ctx.DefaultQuery("descendants", "false"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	repo, err := c.postsRepo(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	posts, err := repo.SearchPostsByTags(idsUint, descendants, pagination)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	if err := c.renderPosts(ctx, format, posts.Items); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, posts)
+}
+
+// @Summary      get posts by title
+// @Description  get posts by title
+// @Tags         post
+// @Param        page         query  int     false  "page number, required without cursor"
+// @Param        size         query  int     true   "page size"
+// @Param        cursor       query  string  false  "next_cursor of the previous page"
+// @Param        title        query  string  true   "post title"
+// @Param        notebook_id  query  int     false  "only posts in the notebook"
+// @Param        recursive    query  bool    false  "including the notebooks nested in it"
+// @Param        format       query  string  false  "markdown (default) or html"
+// @Produce      json
+// @Success      200  {object}  entity.PostPage
+// @Security     BearerAuth
+// @Router       /search-posts-by-title [get]
+func (c *ToyNoteController) SearchPostsByTitle(ctx *gin.Context) {
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	titleQuery, v := ctx.GetQuery("title")
+	if !v {
+		err := errors.New("title query is required")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	repo, err := c.postsRepo(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	posts, err := repo.SearchPostsByTitle(titleQuery, pagination)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	if err := c.renderPosts(ctx, format, posts.Items); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, posts)
+}
+
+// @Summary      get posts by title
+// @Description  get posts by title
+// @Tags         post
+// @Param        page         query  int     false  "page number, required without cursor"
+// @Param        size         query  int     true   "page size"
+// @Param        cursor       query  string  false  "next_cursor of the previous page"
+// @Param        start        query  string  true   "time start"
+// @Param        end          query  string  true   "time end"
+// @Param        type         query  string  false  "time type"
+// @Param        notebook_id  query  int     false  "only posts in the notebook"
+// @Param        recursive    query  bool    false  "including the notebooks nested in it"
+// @Param        format       query  string  false  "markdown (default) or html"
+// @Produce      json
+// @Success      200  {object}  entity.PostPage
+// @Security     BearerAuth
+// @Router       /search-posts-by-time [get]
+func (c *ToyNoteController) SearchPostsByTime(ctx *gin.Context) {
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	timeType, err := getTimeSearchFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	repo, err := c.postsRepo(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	posts, err := repo.SearchPostsByTimeRange(timeType, pagination)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	if err := c.renderPosts(ctx, format, posts.Items); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, posts)
+}
+
+// @Summary      full-text search posts
+// @Description  Full-text search over title, subtitle and content, best matches first.
+// @Description  Quoted phrases, "or" and "-" (exclusion) are supported. Each result comes
+// @Description     with a snippet of the content, in which matched words are wrapped by `<mark></mark>`.
+// @Tags         post
+// @Param        page         query  int     true   "page number"
+// @Param        size         query  int     true   "page size"
+// @Param        text         query  string  true   "search text"
+// @Param        notebook_id  query  int     false  "only posts in the notebook"
+// @Param        recursive    query  bool    false  "including the notebooks nested in it"
+// @Param        format       query  string  false  "markdown (default) or html"
+// @Produce      json
+// @Success      200  {object}  entity.PostSearchPage
+// @Failure      400  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /search-posts [get]
+func (c *ToyNoteController) SearchPosts(ctx *gin.Context) {
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	// results are ordered by rank, which can't be continued by a cursor
+	if pagination.Cursor != "" {
+		err := errors.New("cursor is not available for full-text search")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	text := strings.TrimSpace(ctx.Query("text"))
+	if text == "" {
+		err := errors.New("text query is required")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	repo, err := c.postsRepo(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	results, err := repo.SearchPosts(text, pagination)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	posts := make([]entity.Post, len(results.Items))
+	for i, r := range results.Items {
+		posts[i] = r.Post
+	}
+	if err := c.renderPosts(ctx, format, posts); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+	for i := range results.Items {
+		results.Items[i].Post = posts[i]
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+// @Summary      query posts
+// @Description  Find posts by a combination of tags, text and time range, all the given criteria must be met.
+// @Description  `tag_match` is `all` (default) or `any`; `sort` is `date` (default), `created_at`, `updated_at` or `title`;
+// @Description     `order` is `desc` (default) or `asc`. The time range is optional, but `start` and `end` must be given together.
+// @Description  `cursor` (the `next_cursor` of the previous page) takes the place of `page` when sorted by date.
+// @Tags         post
+// @Param        page             query  int       false  "page number, required without cursor"
+// @Param        size             query  int       true   "page size"
+// @Param        cursor           query  string    false  "next_cursor of the previous page"
+// @Param        tag_ids          query  []int     false  "tag ids"
+// @Param        tag_match        query  string    false  "all or any"
+// @Param        exclude_tag_ids  query  []int     false  "excluded tag ids"
+// @Param        tag_descendants  query  bool      false  "tags stand for the tags nested in them as well"
+// @Param        text             query  string    false  "full-text search"
+// @Param        start            query  string    false  "time start"
+// @Param        end              query  string    false  "time end"
+// @Param        type             query  string    false  "time type"
+// @Param        sort             query  string    false  "sort field"
+// @Param        order            query  string    false  "asc or desc"
+// @Param        notebook_id      query  int       false  "only posts in the notebook"
+// @Param        recursive        query  bool      false  "including the notebooks nested in it"
+// @Param        format           query  string    false  "markdown (default) or html"
+// @Produce      json
+// @Success      200  {object}  entity.PostPage
+// @Failure      400  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /query-posts [get]
+func (c *ToyNoteController) QueryPosts(ctx *gin.Context) {
+	query, err := getPostQueryFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	c.queryPosts(ctx, query)
+}
+
+// @Summary      query posts by a json body
+// @Description  The same as `GET /query-posts`, but the query is given as a json body, and the notebook as query parameters.
+// @Tags         post
+// @Accept       json
+// @Produce      json
+// @Param        data         body      entity.PostQuery  true   "query"
+// @Param        notebook_id  query     int               false  "only posts in the notebook"
+// @Param        recursive    query     bool              false  "including the notebooks nested in it"
+// @Param        format       query     string            false  "markdown (default) or html"
+// @Success      200          {object}  entity.PostPage
+// @Failure      400          {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /query-posts [post]
+func (c *ToyNoteController) QueryPostsByBody(ctx *gin.Context) {
+	var query entity.PostQuery
+	if err := ctx.ShouldBindJSON(&query); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	c.queryPosts(ctx, query)
+}
+
+func (c *ToyNoteController) queryPosts(ctx *gin.Context, query entity.PostQuery) {
+	// validate before hitting the service, so that a bad query is reported as 400
+	if err := query.Normalize(); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	format, err := getFormatFromQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	repo, err := c.postsRepo(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	posts, err := repo.QueryPosts(query)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	if err := c.renderPosts(ctx, format, posts.Items); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, posts)
+}
+
+// render the content of posts in place if `?format=html` is given, affiliates referred to
+// by the content are linked to `download-file`
+func (c *ToyNoteController) renderPosts(ctx *gin.Context, format entity.ContentFormat, posts []entity.Post) error {
+	if format != entity.FORMAT_HTML {
+		return nil
+	}
+	if err := c.repo(ctx).RenderPosts(posts); err != nil {
+		return err
+	}
+
+	for i := range posts {
+		posts[i].ContentHtml = util.ResolveAffiliateRefs(posts[i].ContentHtml, func(id uint) string {
+			return fmt.Sprintf("%s/download-file/%d", apiBasePath, id)
+		})
+	}
+	return nil
+}
+
+// ============================================================================
+// Affiliate
+// ============================================================================
+
+// @Summary      download an affiliate by ID
+// @Description  Download an affiliate by ID, the file is streamed from the blob store.
+// @Description  Supports `Range` requests (206 Partial Content) and conditional requests
+// @Description     (`If-None-Match`, `If-Modified-Since`, 304 Not Modified).
+// @Description  With `inline=1`, images, audios, videos, PDF and text files are served inline
+// @Description     with their content type, so that they can be previewed by browsers.
+// @Tags         affiliate
+// @Produce      octet-stream
+// @Param        id                 path      int     true   "affiliate ID"
+// @Param        inline             query     bool    false  "preview in browser"
+// @Param        Range              header    string  false  "bytes range, e.g. bytes=0-1023"
+// @Param        If-None-Match      header    string  false  "ETag of a cached copy"
+// @Param        If-Modified-Since  header    string  false  "Last-Modified of a cached copy"
+// @Success      200                {file}    binary
+// @Success      206                {file}    binary
+// @Success      304                {string}  string  "not modified"
+// @Failure      416                {string}  string  "range not satisfiable"
+// @Failure      500                {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /download-file/{id} [get]
+func (c *ToyNoteController) DownloadAffiliate(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	inline, err := strconv.ParseBool(ctx.DefaultQuery("inline", "0"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	fo, err := c.repo(ctx).DownloadAffiliate(uint(id))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+	defer fo.Content.Close()
+
+	serveFile(ctx, fo, inline)
+}
+
+// ============================================================================
+// Export
+// ============================================================================
+
+// @Summary      export posts
+// @Description  Export the posts found by the same criteria as `GET /query-posts` as a zip archive, streamed as it is written.
+// @Description  Each post is a Markdown file with YAML front matter (title, subtitle, date, tags, created_at, updated_at),
+// @Description     its affiliates are files under `attachments/`, and references to them are rewritten into relative links.
+// @Tags         export
+// @Param        tag_ids          query  []int   false  "tag ids"
+// @Param        tag_match        query  string  false  "all or any"
+// @Param        exclude_tag_ids  query  []int   false  "excluded tag ids"
+// @Param        tag_descendants  query  bool    false  "tags stand for the tags nested in them as well"
+// @Param        text             query  string  false  "full-text search"
+// @Param        start            query  string  false  "time start"
+// @Param        end              query  string  false  "time end"
+// @Param        type             query  string  false  "time type"
+// @Param        sort             query  string  false  "sort field"
+// @Param        order            query  string  false  "asc or desc"
+// @Param        notebook_id      query  int     false  "only posts in the notebook"
+// @Param        recursive        query  bool    false  "including the notebooks nested in it"
+// @Produce      application/zip
+// @Success      200  {file}    binary
+// @Failure      400  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /export [get]
+func (c *ToyNoteController) ExportPosts(ctx *gin.Context) {
+	query, err := getPostCriteriaFromQuery(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	// validate before streaming, so that a bad query is reported as 400
+	if err := query.Normalize(); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	repo, err := c.postsRepo(ctx)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	export, err := service.NewExport(repo, query)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	c.writeExport(ctx, export, "toy-note-export.zip")
+}
+
+// @Summary      export a post
+// @Description  Export a post as a zip archive, the same as `GET /export` but for a single post.
+// @Tags         export
+// @Param        id  path  int  true  "post ID"
+// @Produce      application/zip
+// @Success      200  {file}    binary
+// @Failure      400  {object}  errorMessage
+// @Failure      500  {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /export/{id} [get]
+func (c *ToyNoteController) ExportPost(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	export, err := service.NewPostExport(c.repo(ctx), uint(id))
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	c.writeExport(ctx, export, fmt.Sprintf("toy-note-post-%d.zip", id))
+}
+
+// stream the archive into the response
+func (c *ToyNoteController) writeExport(ctx *gin.Context, export *service.Export, filename string) {
+	ctx.Header("Content-Disposition", contentDisposition("attachment", filename))
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Status(http.StatusOK)
+
+	if err := export.WriteZip(ctx.Writer); err != nil {
+		// the response has been started, the client is left with a truncated archive
+		c.logger.Error(err)
+		ctx.Abort()
+	}
+}
+
+// ============================================================================
+// Import
+// ============================================================================
+
+// @Summary      import posts
+// @Description  Import a zip archive or a tarball, optionally gzipped, of Markdown files with YAML front matter,
+// @Description     e.g. an archive of `GET /export`. Each Markdown file becomes a post, tags are matched by name
+// @Description     or created, and the attachments in the front matter as well as the files linked by relative
+// @Description     paths are uploaded as affiliates.
+// @Description  By `source`, an ENEX file of Evernote, or a RAW export directory of Joplin in a zip archive or
+// @Description     a tarball, is imported the same way: ENML is converted into Markdown, and resources become affiliates.
+// @Description  A note with the same title and date as an existing post is skipped as a duplicate, and a note
+// @Description     which can't be imported is reported as failed without aborting the others.
+// @Description  In a dry run nothing is written, and the report tells what would be created.
+// @Tags         import
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        file     formData  file    true   "archive or ENEX file"
+// @Param        source   query     string  false  "markdown (default), enex or joplin"
+// @Param        dry_run  query     bool    false  "only report what would be created"
+// @Success      200      {object}  entity.ImportReport
+// @Failure      400      {object}  errorMessage
+// @Failure      403      {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /import [post]
+func (c *ToyNoteController) ImportPosts(ctx *gin.Context) {
+	source, err := entity.ParseImportSource(ctx.Query("source"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	// the archive is spooled to a temporary file if it is large, since it is walked twice
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	defer f.Close()
+
+	var im *service.Import
+	switch source {
+	case entity.SOURCE_ENEX:
+		im, err = service.NewEnexImport(c.repo(ctx), f, header.Size)
+	case entity.SOURCE_JOPLIN:
+		im, err = service.NewJoplinImport(c.repo(ctx), f, header.Size)
+	default:
+		im, err = service.NewImport(c.repo(ctx), f, header.Size)
+	}
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	report, err := im.Run(dryRun)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// ============================================================================
+// Bulk
+// ============================================================================
+
+// @Summary      add tags to and remove tags from posts
+// @Description  Add tags to and remove tags from many posts at once, the other tags of the posts are kept. All the
+// @Description     posts are changed in one transaction, each gets a new version recorded as a revision.
+// @Description  The tags must be found, whereas a post not found is reported as failed without aborting the others.
+// @Description  At most 1000 posts can be changed at once.
+// @Tags         bulk
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.BulkTagging  true  "posts and tags"
+// @Success      200   {object}  entity.BulkReport
+// @Failure      400   {object}  errorMessage
+// @Failure      403   {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /bulk-tag-posts [post]
+func (c *ToyNoteController) BulkTagPosts(ctx *gin.Context) {
+	var tagging entity.BulkTagging
+	if err := ctx.ShouldBindJSON(&tagging); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	report, err := c.repo(ctx).BulkTagPosts(tagging)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// @Summary      move posts to trash
+// @Description  Move many posts to trash at once, in one transaction. A post not found is reported as failed without
+// @Description     aborting the others. At most 1000 posts can be deleted at once.
+// @Tags         bulk
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.BulkIds  true  "post IDs"
+// @Success      200   {object}  entity.BulkReport
+// @Failure      400   {object}  errorMessage
+// @Failure      403   {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /bulk-delete-posts [post]
+func (c *ToyNoteController) BulkDeletePosts(ctx *gin.Context) {
+	var ids entity.BulkIds
+	if err := ctx.ShouldBindJSON(&ids); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	report, err := c.repo(ctx).BulkDeletePosts(ids.Ids)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// @Summary      move posts to a date
+// @Description  Set the date of many posts at once, in one transaction. Each post gets a new version recorded as
+// @Description     a revision. A post not found is reported as failed without aborting the others.
+// @Description  At most 1000 posts can be changed at once.
+// @Tags         bulk
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.BulkDating  true  "posts and date"
+// @Success      200   {object}  entity.BulkReport
+// @Failure      400   {object}  errorMessage
+// @Failure      403   {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /bulk-date-posts [post]
+func (c *ToyNoteController) BulkDatePosts(ctx *gin.Context) {
+	var dating entity.BulkDating
+	if err := ctx.ShouldBindJSON(&dating); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	report, err := c.repo(ctx).BulkDatePosts(dating)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// @Summary      delete tags
+// @Description  Delete many tags at once, in one transaction. The tags nested in a deleted tag are moved to its
+// @Description     parent. A tag not found is reported as failed without aborting the others.
+// @Description  At most 1000 tags can be deleted at once.
+// @Tags         bulk
+// @Accept       json
+// @Produce      json
+// @Param        data  body      entity.BulkIds  true  "tag IDs"
+// @Success      200   {object}  entity.BulkReport
+// @Failure      400   {object}  errorMessage
+// @Failure      403   {object}  errorMessage
+// @Security     BearerAuth
+// @Router       /bulk-delete-tags [post]
+func (c *ToyNoteController) BulkDeleteTags(ctx *gin.Context) {
+	var ids entity.BulkIds
+	if err := ctx.ShouldBindJSON(&ids); err != nil {
+		c.logger.Error(err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	report, err := c.repo(ctx).BulkDeleteTags(ids.Ids)
+	if err != nil {
+		c.logger.Error(err)
+		ctx.JSON(errorStatus(err, http.StatusBadRequest), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
 }
@@ -0,0 +1,1465 @@
+package controller
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toy-note/api/entity"
+	"toy-note/api/service"
+	"toy-note/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+const logPath = "test.log"
+
+// the access token of a test user, `serve` authenticates requests with it by default.
+// Tokens are verified by signature only, hence it is valid for every test router
+var testToken string
+
+// a router backed by the in-memory service, no database is required
+func newTestRouter() *gin.Engine {
+	if err := logger.Init("debug", logPath, true); err != nil {
+		panic(err)
+	}
+	gin.SetMode(gin.TestMode)
+
+	tokens, err := service.NewTokens("test-only-secret-of-at-least-32-bytes", time.Minute, time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	s := service.NewMemoryToyNoteService(logger.TNLogger, tokens)
+	c := NewToyNoteController(logger.TNLogger, s)
+	a := NewAuthController(logger.TNLogger, s)
+	ad := NewAdminController(logger.TNLogger, s, s)
+
+	credentials := entity.Credentials{Name: "tester", Password: "tester password"}
+	if _, err := s.Register(credentials); err != nil {
+		panic(err)
+	}
+	pair, err := s.Login(credentials)
+	if err != nil {
+		panic(err)
+	}
+	testToken = pair.AccessToken
+
+	router := gin.New()
+	auth := router.Group("/api/auth")
+	{
+		auth.POST("/register", a.Register)
+		auth.POST("/login", a.Login)
+		auth.POST("/refresh", a.Refresh)
+		auth.POST("/logout", a.Logout)
+	}
+
+	shared := router.Group("/api/shared")
+	{
+		shared.GET("/:token", c.GetSharedPost)
+		shared.GET("/:token/download-file/:id", c.DownloadSharedAffiliate)
+		shared.HEAD("/:token/download-file/:id", c.DownloadSharedAffiliate)
+	}
+
+	api := router.Group("/api", a.Authenticate)
+	{
+		api.GET("/get-tags", c.GetTags)
+		api.POST("/save-tag", c.SaveTag)
+		api.DELETE("/delete-tag/:id", c.DeleteTag)
+
+		api.GET("/get-notebooks", c.GetNotebooks)
+		api.GET("/get-notebook/:id", c.GetNotebook)
+		api.POST("/save-notebook", c.SaveNotebook)
+		api.DELETE("/delete-notebook/:id", c.DeleteNotebook)
+		api.POST("/move-post/:id", c.MovePost)
+
+		api.GET("/get-posts", c.GetPosts)
+		api.GET("/get-post/:id", c.GetPost)
+		api.POST("/save-post", c.SavePost)
+		api.PATCH("/posts/:id", c.PatchPost)
+		api.DELETE("/delete-post/:id", c.DeletePost)
+
+		api.GET("/get-trash", c.GetTrash)
+		api.POST("/restore-post/:id", c.RestorePost)
+		api.DELETE("/purge-post/:id", c.PurgePost)
+
+		api.GET("/get-revisions/:id", c.GetRevisions)
+		api.GET("/get-revision/:id", c.GetRevision)
+		api.GET("/diff-revisions", c.DiffRevisions)
+		api.POST("/restore-revision/:id", c.RestoreRevision)
+
+		api.POST("/create-share", c.CreateShare)
+		api.GET("/get-shares/:id", c.GetShares)
+		api.DELETE("/revoke-share/:id", c.RevokeShare)
+
+		api.GET("/posts/:id/links", c.GetPostLinks)
+		api.GET("/posts/:id/backlinks", c.GetBacklinks)
+
+		api.GET("/download-file/:id", c.DownloadAffiliate)
+		api.HEAD("/download-file/:id", c.DownloadAffiliate)
+
+		api.GET("/search-posts-by-tags", c.SearchPostsByTags)
+		api.GET("/search-posts-by-title", c.SearchPostsByTitle)
+		api.GET("/search-posts-by-time", c.SearchPostsByTime)
+		api.GET("/search-posts", c.SearchPosts)
+		api.GET("/query-posts", c.QueryPosts)
+		api.POST("/query-posts", c.QueryPostsByBody)
+
+		api.GET("/export", c.ExportPosts)
+		api.GET("/export/:id", c.ExportPost)
+		api.POST("/import", c.ImportPosts)
+
+		api.POST("/bulk-tag-posts", c.BulkTagPosts)
+		api.POST("/bulk-delete-posts", c.BulkDeletePosts)
+		api.POST("/bulk-date-posts", c.BulkDatePosts)
+		api.POST("/bulk-delete-tags", c.BulkDeleteTags)
+
+		api.POST("/create-api-key", a.CreateApiKey)
+		api.GET("/get-api-keys", a.GetApiKeys)
+		api.DELETE("/revoke-api-key/:prefix", a.RevokeApiKey)
+	}
+
+	admin := api.Group("/admin")
+	{
+		admin.GET("/get-unowned-affiliates", ad.GetUnownedAffiliates)
+		admin.POST("/rebind-affiliate", ad.RebindAffiliate)
+		admin.DELETE("/delete-unowned-affiliates", ad.DeleteUnownedAffiliates)
+		admin.POST("/merge-tags", ad.MergeTags)
+		admin.POST("/set-user-role/:id", ad.SetUserRole)
+	}
+
+	return router
+}
+
+// serve a request as the test user, unless it is already authorized
+func serve(router *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	if req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+testToken)
+	}
+	return serveAnonymous(router, req)
+}
+
+func serveAnonymous(router *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// build a `save-post` request, the post is put in the "data" field
+func newSavePostRequest(t *testing.T, post entity.Post, files map[string][]byte) *http.Request {
+	data, err := json.Marshal(post)
+	require.NoError(t, err)
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	require.NoError(t, mw.WriteField("data", string(data)))
+	for filename, content := range files {
+		fw, err := mw.CreateFormFile("files", filename)
+		require.NoError(t, err)
+		_, err = fw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/save-post", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// build a request with a json body
+func newJSONRequest(t *testing.T, method, url string, v interface{}) *http.Request {
+	body, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// a field of a json object, as raw json
+func jsonField(t *testing.T, body []byte, field string) json.RawMessage {
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(body, &fields))
+	return fields[field]
+}
+
+// register a user and log in, return the access token
+func registerAndLogin(t *testing.T, router *gin.Engine, credentials entity.Credentials) (entity.User, string) {
+	var user entity.User
+	w := serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/register", credentials))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+
+	var tokens entity.TokenPair
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/login", credentials))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+
+	return user, tokens.AccessToken
+}
+
+func TestTagRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	body, _ := json.Marshal(entity.Tag{Name: "dev"})
+	w := serve(router, httptest.NewRequest(http.MethodPost, "/api/save-tag", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var tag entity.Tag
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tag))
+	require.NotEmpty(t, tag.Id)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-tags", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var tags []entity.Tag
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tags))
+	require.Len(t, tags, 1)
+
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-tag/%d", tag.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodDelete, "/api/delete-tag/abc", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a post tagged by a nested tag is found by the parent, once descendants are included
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "lang"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var lang entity.Tag
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &lang))
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "go", ParentId: &lang.Id}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var golang entity.Tag
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &golang))
+	require.Equal(t, lang.Id, *golang.ParentId)
+
+	post := entity.Post{Title: "gopher", Content: "content", Date: time.Now(), Tags: []entity.Tag{{UintId: golang.UintId}}}
+	w = serve(router, newSavePostRequest(t, post, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var page entity.PostPage
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/search-posts-by-tags?page=1&size=10&ids=%d", lang.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Empty(t, page.Items)
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/search-posts-by-tags?page=1&size=10&ids=%d&descendants=true", lang.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/query-posts?page=1&size=10&tag_ids=%d&tag_descendants=true", lang.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/search-posts-by-tags?page=1&size=10&ids=%d&descendants=maybe", lang.Id), nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPostRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{
+		Title:      "first post",
+		Content:    "content",
+		Date:       time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC),
+		Affiliates: []entity.Affiliate{{Filename: "a.txt"}},
+	}
+
+	// the number of new affiliates must match the number of files
+	w := serve(router, newSavePostRequest(t, post, nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, newSavePostRequest(t, post, map[string][]byte{"a.txt": []byte("hello")}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var saved entity.Post
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &saved))
+	require.NotEmpty(t, saved.Id)
+	require.Len(t, saved.Affiliates, 1)
+	require.NotEmpty(t, saved.Affiliates[0].ObjectId)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-posts?page=1&size=10", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var posts entity.PostPage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &posts))
+	require.Len(t, posts.Items, 1)
+	require.Equal(t, int64(1), posts.Total)
+
+	// pagination is required
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-posts", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a cursor takes the place of page, but it must be valid
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-posts?size=10&cursor=invalid", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/search-posts-by-title?page=1&size=10&title=first", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &posts))
+	require.Len(t, posts.Items, 1)
+
+	w = serve(router, httptest.NewRequest(
+		http.MethodGet,
+		"/api/search-posts-by-time?page=1&size=10&start=2021-01-01T00:00:00Z&end=2021-12-31T00:00:00Z",
+		nil,
+	))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &posts))
+	require.Len(t, posts.Items, 1)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/search-posts?page=1&size=10&text=content", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var results entity.PostSearchPage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results.Items, 1)
+	require.Equal(t, "<mark>content</mark>", results.Items[0].Snippet)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/search-posts?page=1&size=10", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// full-text search results are ordered by rank, which can't be continued by a cursor
+	cursor := entity.PostCursor{Date: saved.Date, Id: saved.Id}.Encode()
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/search-posts?size=10&text=content&cursor="+cursor, nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, httptest.NewRequest(
+		http.MethodGet,
+		"/api/query-posts?page=1&size=10&text=content&start=2021-01-01T00:00:00Z&end=2021-12-31T00:00:00Z&sort=title",
+		nil,
+	))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &posts))
+	require.Len(t, posts.Items, 1)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/query-posts?page=1&size=10&sort=unknown", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the time range must be complete
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/query-posts?page=1&size=10&start=2021-01-01T00:00:00Z", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	body := `{"text": "content", "time": {"start": "2022-01-01T00:00:00Z", "end": "2022-12-31T00:00:00Z"}, "page": 1, "size": 10}`
+	req := httptest.NewRequest(http.MethodPost, "/api/query-posts", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = serve(router, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &posts))
+	require.Empty(t, posts.Items)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/query-posts", strings.NewReader(`{"tag_match": "some"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = serve(router, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/download-file/%d", saved.Affiliates[0].Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "hello", w.Body.String())
+	require.Equal(t, "5", w.Header().Get("Content-Length"))
+
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-post/%d", saved.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-posts?page=1&size=10", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &posts))
+	require.Empty(t, posts.Items)
+}
+
+func TestSavePostFilesBeforeData(t *testing.T) {
+	router := newTestRouter()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("files", "a.txt")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, mw.WriteField("data", `{"title":"t","content":"c","affiliates":[{"filename":"a.txt"}]}`))
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/save-post", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	// files are streamed, so "data" must come first
+	w := serve(router, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// records the uploads discarded by the users, see `ForOwner`
+type discardRecorder struct {
+	service.ToyNoteRepo
+	discarded *[]string
+}
+
+func (r discardRecorder) ForOwner(userId uint) service.ToyNoteRepo {
+	return discardRecorder{ToyNoteRepo: r.ToyNoteRepo.ForOwner(userId), discarded: r.discarded}
+}
+
+func (r discardRecorder) DiscardUploads(oids []string) error {
+	*r.discarded = append(*r.discarded, oids...)
+	return r.ToyNoteRepo.DiscardUploads(oids)
+}
+
+func TestSavePostDiscardsUploads(t *testing.T) {
+	router := newTestRouter()
+
+	s := service.NewMemoryToyNoteService(logger.TNLogger, nil)
+	discarded := []string{}
+	c := NewToyNoteController(logger.TNLogger, discardRecorder{ToyNoteRepo: s, discarded: &discarded})
+	router.POST("/discard/save-post", func(ctx *gin.Context) {
+		ctx.Set(userKey, entity.User{UintId: entity.UintId{Id: 1}, Role: entity.ROLE_EDITOR})
+	}, c.SavePost)
+
+	post := entity.Post{
+		Title:      "discarded",
+		Content:    "content",
+		Date:       time.Now(),
+		Affiliates: []entity.Affiliate{{Filename: "a.txt"}},
+	}
+	files := map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b")}
+
+	// more files than new affiliates
+	req := newSavePostRequest(t, post, files)
+	req.URL.Path = "/discard/save-post"
+	w := serve(router, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Len(t, discarded, 1)
+
+	// the post fails to be saved, e.g. a missing tag
+	discarded = discarded[:0]
+	post.Tags = []entity.Tag{{UintId: entity.UintId{Id: 404}}}
+	req = newSavePostRequest(t, post, map[string][]byte{"a.txt": []byte("a")})
+	req.URL.Path = "/discard/save-post"
+	w = serve(router, req)
+	require.NotEqual(t, http.StatusOK, w.Code)
+	require.Len(t, discarded, 1)
+
+	// nothing is discarded once saved
+	discarded = discarded[:0]
+	post.Tags = nil
+	req = newSavePostRequest(t, post, map[string][]byte{"a.txt": []byte("a")})
+	req.URL.Path = "/discard/save-post"
+	w = serve(router, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, discarded)
+}
+
+func TestDownloadRangeAndConditional(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{
+		Title:      "ranged",
+		Content:    "content",
+		Affiliates: []entity.Affiliate{{Filename: "digits.txt"}},
+	}
+	w := serve(router, newSavePostRequest(t, post, map[string][]byte{"digits.txt": []byte("0123456789")}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var saved entity.Post
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &saved))
+	url := fmt.Sprintf("/api/download-file/%d", saved.Affiliates[0].Id)
+
+	// full content, with validators
+	w = serve(router, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.NotEmpty(t, w.Header().Get("Last-Modified"))
+
+	// partial content
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w = serve(router, req)
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "2345", w.Body.String())
+	require.Equal(t, "bytes 2-5/10", w.Header().Get("Content-Range"))
+
+	// suffix range
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Range", "bytes=-3")
+	w = serve(router, req)
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "789", w.Body.String())
+
+	// unsatisfiable range
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Range", "bytes=20-30")
+	w = serve(router, req)
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+	// cached copy is still valid
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("If-None-Match", etag)
+	w = serve(router, req)
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Empty(t, w.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	w = serve(router, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// head request only returns headers
+	w = serve(router, httptest.NewRequest(http.MethodHead, url, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "10", w.Header().Get("Content-Length"))
+	require.Empty(t, w.Body.String())
+}
+
+func TestDownloadInline(t *testing.T) {
+	router := newTestRouter()
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	html := []byte("<html><script>alert(1)</script></html>")
+
+	post := entity.Post{
+		Title:      "previews",
+		Content:    "content",
+		Affiliates: []entity.Affiliate{{Filename: "image.png"}, {Filename: "page.html"}},
+	}
+	w := serve(router, newSavePostRequest(t, post, map[string][]byte{"image.png": png, "page.html": html}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var saved entity.Post
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &saved))
+
+	// files are sent in the map order, find them by content type
+	urls := map[string]string{}
+	for _, a := range saved.Affiliates {
+		require.NotEmpty(t, a.Checksum)
+		require.NotEmpty(t, a.Size)
+		urls[a.ContentType] = fmt.Sprintf("/api/download-file/%d", a.Id)
+	}
+	require.Contains(t, urls, "image/png")
+	require.Contains(t, urls, "text/html; charset=utf-8")
+
+	// forced download by default
+	w = serve(router, httptest.NewRequest(http.MethodGet, urls["image/png"], nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+
+	// images are previewed inline
+	w = serve(router, httptest.NewRequest(http.MethodGet, urls["image/png"]+"?inline=1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Header().Get("Content-Disposition"), "inline")
+	require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	// html is never rendered by browsers
+	w = serve(router, httptest.NewRequest(http.MethodGet, urls["text/html; charset=utf-8"]+"?inline=1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Header().Get("Content-Disposition"), "inline")
+}
+
+func TestCursorPagination(t *testing.T) {
+	router := newTestRouter()
+
+	for i := 1; i <= 3; i++ {
+		post := entity.Post{
+			Title:   fmt.Sprintf("post %d", i),
+			Content: "content",
+			Date:    time.Date(2021, time.March, i, 0, 0, 0, 0, time.UTC),
+		}
+		w := serve(router, newSavePostRequest(t, post, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var titles []string
+	url := "/api/get-posts?page=1&size=2"
+	for url != "" {
+		w := serve(router, httptest.NewRequest(http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var page entity.PostPage
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		require.Equal(t, int64(3), page.Total)
+		for _, p := range page.Items {
+			titles = append(titles, p.Title)
+		}
+
+		url = ""
+		if page.NextCursor != "" {
+			url = "/api/get-posts?size=2&cursor=" + page.NextCursor
+		}
+	}
+
+	require.Equal(t, []string{"post 3", "post 2", "post 1"}, titles)
+}
+
+func TestTrashRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{Title: "trashed", Content: "content", Date: time.Now()}
+	w := serve(router, newSavePostRequest(t, post, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-post/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var page entity.PostPage
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-trash?page=1&size=10", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	require.True(t, page.Items[0].DeletedAt.Valid)
+
+	w = serve(router, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/restore-post/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-posts?page=1&size=10", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+
+	// not in trash
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/purge-post/%d", post.Id), nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-post/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/purge-post/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-trash?page=1&size=10", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Empty(t, page.Items)
+}
+
+func TestRevisionRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{Title: "draft", Content: "line 1", Date: time.Now()}
+	w := serve(router, newSavePostRequest(t, post, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+
+	w = serve(router, newSavePostRequest(t, entity.Post{UintId: post.UintId, Title: "final", Content: "line 1\nline 2"}, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var page entity.RevisionPage
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-revisions/%d?page=1&size=10", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 2)
+	second, first := page.Items[0], page.Items[1]
+
+	var revision entity.PostRevision
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-revision/%d", first.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revision))
+	require.Equal(t, "draft", revision.Title)
+
+	var diff entity.RevisionDiff
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/diff-revisions?from=%d&to=%d", first.Id, second.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	require.Equal(t, []entity.DiffLine{
+		{Op: entity.DIFF_EQUAL, Text: "line 1"},
+		{Op: entity.DIFF_INSERT, Text: "line 2"},
+	}, diff.Content)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/diff-revisions?from=%d", first.Id), nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/restore-revision/%d", first.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	require.Equal(t, "draft", post.Title)
+	require.Equal(t, "line 1", post.Content)
+}
+
+func TestConflictRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{Title: "shared", Content: "original", Date: time.Now()}
+	w := serve(router, newSavePostRequest(t, post, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `"1"`, w.Header().Get("ETag"))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-post/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.Equal(t, `"1"`, etag)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-post/%d", post.Id), nil)
+	req.Header.Set("If-None-Match", etag)
+	w = serve(router, req)
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	// updated by If-Match
+	req = newSavePostRequest(t, entity.Post{UintId: post.UintId, Content: "first"}, nil)
+	req.Header.Set("If-Match", etag)
+	w = serve(router, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	// stale If-Match
+	req = newSavePostRequest(t, entity.Post{UintId: post.UintId, Content: "second"}, nil)
+	req.Header.Set("If-Match", etag)
+	w = serve(router, req)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, `"2"`, w.Header().Get("ETag"))
+	var conflict struct {
+		Error   string      `json:"error"`
+		Current entity.Post `json:"current"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &conflict))
+	require.Equal(t, "first", conflict.Current.Content)
+	require.Equal(t, uint(2), conflict.Current.Version)
+
+	// stale version in the body
+	w = serve(router, newSavePostRequest(t, entity.Post{UintId: post.UintId, Content: "second", Version: 1}, nil))
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	// If-Match must agree with the body, and be a strong ETag
+	req = newSavePostRequest(t, entity.Post{UintId: post.UintId, Content: "second", Version: 1}, nil)
+	req.Header.Set("If-Match", `"2"`)
+	w = serve(router, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	req = newSavePostRequest(t, entity.Post{UintId: post.UintId, Content: "second"}, nil)
+	req.Header.Set("If-Match", `W/"2"`)
+	w = serve(router, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// tags
+	var tag entity.Tag
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "dev"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tag))
+
+	req = newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{UintId: tag.UintId, Color: "red"})
+	req.Header.Set("If-Match", `"1"`)
+	w = serve(router, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{UintId: tag.UintId, Color: "blue", Version: 1}))
+	require.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAuthRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	// every route but auth requires a token
+	w := serveAnonymous(router, httptest.NewRequest(http.MethodGet, "/api/get-tags", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+	req := httptest.NewRequest(http.MethodGet, "/api/get-tags", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	w = serveAnonymous(router, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	credentials := entity.Credentials{Name: "alice", Password: "alice password"}
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/register", credentials))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), "password")
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/register", credentials))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/login", entity.Credentials{Name: "alice", Password: "wrong password"}))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var tokens entity.TokenPair
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/login", credentials))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+
+	// alice sees nothing of the test user
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "dev"}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/get-tags", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w = serve(router, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `[]`, w.Body.String())
+
+	// refresh rotates the refresh token, and logout revokes it
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/refresh", entity.RefreshRequest{RefreshToken: tokens.RefreshToken}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var rotated entity.TokenPair
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+	require.NotEqual(t, tokens.RefreshToken, rotated.RefreshToken)
+
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/logout", entity.RefreshRequest{RefreshToken: rotated.RefreshToken}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/refresh", entity.RefreshRequest{RefreshToken: rotated.RefreshToken}))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdminRoutes(t *testing.T) {
+	router := newTestRouter()
+	// the test user is the first user, hence the admin
+	user, token := registerAndLogin(t, router, entity.Credentials{Name: "alice", Password: "alice password"})
+	require.Equal(t, entity.ROLE_EDITOR, user.Role)
+	asAlice := func(req *http.Request) *http.Request {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	// alice leaves an orphan affiliate
+	post := entity.Post{
+		Title:      "with a file",
+		Content:    "content",
+		Date:       time.Now(),
+		Affiliates: []entity.Affiliate{{Filename: "a.txt"}},
+	}
+	w := serve(router, asAlice(newSavePostRequest(t, post, map[string][]byte{"a.txt": []byte("a")})))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	aid := post.Affiliates[0].Id
+	post.Affiliates = []entity.Affiliate{}
+	w = serve(router, asAlice(newSavePostRequest(t, post, nil)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// admin routes are forbidden to editors
+	w = serve(router, asAlice(httptest.NewRequest(http.MethodGet, "/api/admin/get-unowned-affiliates?page=1&size=10", nil)))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, asAlice(httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/admin/set-user-role/%d?role=admin", user.Id), nil)))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/admin/get-unowned-affiliates?page=1&size=10", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var unowned entity.AffiliatePage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &unowned))
+	require.Len(t, unowned.Items, 1)
+	require.Equal(t, aid, unowned.Items[0].Id)
+
+	w = serve(router, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/admin/rebind-affiliate?post_id=%d&affiliate_id=%d", post.Id, aid), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/admin/delete-unowned-affiliates?ids=%d", aid), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, asAlice(httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/download-file/%d", aid), nil)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// alice ends up with duplicate tags, which only the admin can merge
+	var golang, goTag entity.Tag
+	w = serve(router, asAlice(newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "golang"})))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &golang))
+	w = serve(router, asAlice(newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "go"})))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &goTag))
+
+	merge := fmt.Sprintf("/api/admin/merge-tags?source_ids=%d&target_id=%d", goTag.Id, golang.Id)
+	w = serve(router, asAlice(httptest.NewRequest(http.MethodPost, merge, nil)))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodPost, "/api/admin/merge-tags?target_id=1", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodPost, merge, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var merged entity.Tag
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &merged))
+	require.Equal(t, golang.Id, merged.Id)
+	w = serve(router, asAlice(httptest.NewRequest(http.MethodGet, "/api/get-tags", nil)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var tags []entity.Tag
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tags))
+	require.Len(t, tags, 1)
+
+	// a reader gets 403 on writes, but still reads
+	w = serve(router, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/admin/set-user-role/%d?role=reader", user.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveAnonymous(router, newJSONRequest(t, http.MethodPost, "/api/auth/login", entity.Credentials{Name: "alice", Password: "alice password"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var tokens entity.TokenPair
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	token = tokens.AccessToken
+
+	w = serve(router, asAlice(newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "dev"})))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, asAlice(newSavePostRequest(t, entity.Post{UintId: post.UintId, Content: "edited"}, nil)))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, asAlice(httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-post/%d", post.Id), nil)))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, asAlice(httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-post/%d", post.Id), nil)))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestShareRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{
+		Title:      "shared",
+		Content:    "hello visitors",
+		Date:       time.Now(),
+		Affiliates: []entity.Affiliate{{Filename: "a.txt"}},
+	}
+	w := serve(router, newSavePostRequest(t, post, map[string][]byte{"a.txt": []byte("hello file")}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	aid := post.Affiliates[0].Id
+
+	var link entity.ShareLink
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/create-share", entity.ShareRequest{PostId: post.Id, Password: "open sesame"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &link))
+	require.NotContains(t, w.Body.String(), "open sesame")
+
+	// visitors need no account, but the password by basic auth
+	url := "/api/shared/" + link.Token
+	w = serveAnonymous(router, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.SetBasicAuth("", "open sesame")
+	w = serveAnonymous(router, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var shared entity.SharedPost
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shared))
+	require.Equal(t, "hello visitors", shared.Content)
+
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/download-file/%d", url, aid), nil)
+	req.SetBasicAuth("", "open sesame")
+	req.Header.Set("Range", "bytes=0-4")
+	w = serveAnonymous(router, req)
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "hello", w.Body.String())
+
+	w = serveAnonymous(router, httptest.NewRequest(http.MethodGet, "/api/shared/unknown", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// listed and revoked by the owner
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-shares/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var shares []entity.Share
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shares))
+	require.Len(t, shares, 1)
+	require.True(t, shares[0].Protected)
+
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/revoke-share/%d", link.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	req = httptest.NewRequest(http.MethodGet, url, nil)
+	req.SetBasicAuth("", "open sesame")
+	w = serveAnonymous(router, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestApiKeyRoutes(t *testing.T) {
+	router := newTestRouter()
+	withKey := func(key string, req *http.Request) *http.Request {
+		req.Header.Set("Authorization", "Bearer "+key)
+		return req
+	}
+
+	w := serve(router, newJSONRequest(t, http.MethodPost, "/api/create-api-key", entity.ApiKeyRequest{
+		Name:   "backup",
+		Scopes: entity.ScopeList{entity.SCOPE_POSTS_READ},
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var created entity.NewApiKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	require.NotEmpty(t, created.Key)
+	require.NotContains(t, w.Body.String(), "secret_hash")
+
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/create-api-key", entity.ApiKeyRequest{
+		Name:   "unknown",
+		Scopes: entity.ScopeList{"posts:delete"},
+	}))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the key reads within its scopes
+	w = serve(router, withKey(created.Key, httptest.NewRequest(http.MethodGet, "/api/get-tags", nil)))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, withKey(created.Key, newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "dev"})))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	// keys neither mint keys nor act as admins, though the test user is an admin
+	w = serve(router, withKey(created.Key, newJSONRequest(t, http.MethodPost, "/api/create-api-key", entity.ApiKeyRequest{
+		Name:   "escalated",
+		Scopes: entity.ScopeList{entity.SCOPE_POSTS_WRITE},
+	})))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, withKey(created.Key, httptest.NewRequest(http.MethodGet, "/api/admin/get-unowned-affiliates?page=1&size=10", nil)))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-api-keys", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var keys []entity.ApiKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &keys))
+	require.Len(t, keys, 1)
+	require.Equal(t, created.Prefix, keys[0].Prefix)
+	require.NotNil(t, keys[0].LastUsedAt)
+
+	// a revoked key is rejected
+	w = serve(router, httptest.NewRequest(http.MethodDelete, "/api/revoke-api-key/"+created.Prefix, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, withKey(created.Key, httptest.NewRequest(http.MethodGet, "/api/get-tags", nil)))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodDelete, "/api/revoke-api-key/"+created.Prefix, nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRenderRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{Title: "markdown", Content: "# Heading\n\n<script>alert(1)</script>", Date: time.Now()}
+	w := serve(router, newSavePostRequest(t, post, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+
+	// raw Markdown by default
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-post/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), "content_html")
+
+	var rendered entity.Post
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-post/%d?format=html", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rendered))
+	require.Equal(t, post.Content, rendered.Content)
+	require.Equal(t, "<h1>Heading</h1>\n", rendered.ContentHtml)
+
+	var page entity.PostPage
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-posts?page=1&size=10&format=html", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	require.Equal(t, "<h1>Heading</h1>\n", page.Items[0].ContentHtml)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/query-posts?page=1&size=10&format=html", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "content_html")
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-post/%d?format=pdf", post.Id), nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// shared posts are rendered as well
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/create-share", entity.ShareRequest{PostId: post.Id}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var link entity.ShareLink
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &link))
+
+	var shared entity.SharedPost
+	w = serveAnonymous(router, httptest.NewRequest(http.MethodGet, "/api/shared/"+link.Token+"?format=html", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shared))
+	require.Equal(t, "<h1>Heading</h1>\n", shared.ContentHtml)
+}
+
+func TestAffiliateReferenceRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{
+		Title:      "with a diagram",
+		Content:    "content",
+		Date:       time.Now(),
+		Affiliates: []entity.Affiliate{{Filename: "diagram.png"}},
+	}
+	w := serve(router, newSavePostRequest(t, post, map[string][]byte{"diagram.png": []byte("png")}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	aid := post.Affiliates[0].Id
+
+	// an unknown affiliate is rejected along with its id
+	w = serve(router, newSavePostRequest(t, entity.Post{
+		UintId:     post.UintId,
+		Content:    "[spec](affiliate:999)",
+		Affiliates: post.Affiliates,
+	}, nil))
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.JSONEq(t, `[999]`, string(jsonField(t, w.Body.Bytes(), "affiliate_ids")))
+
+	content := fmt.Sprintf("![diagram](affiliate:%d)", aid)
+	w = serve(router, newSavePostRequest(t, entity.Post{UintId: post.UintId, Content: content, Affiliates: post.Affiliates}, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// unbinding it is rejected while it is referred to
+	w = serve(router, newSavePostRequest(t, entity.Post{UintId: post.UintId, Affiliates: []entity.Affiliate{}}, nil))
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// references are rendered as download urls
+	var rendered entity.Post
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-post/%d?format=html", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rendered))
+	require.Contains(t, rendered.ContentHtml, fmt.Sprintf(`src="/api/download-file/%d"`, aid))
+	require.Equal(t, content, rendered.Content)
+
+	// and as downloads by the same link in a shared post
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/create-share", entity.ShareRequest{PostId: post.Id}))
+	require.Equal(t, http.StatusOK, w.Code)
+	var link entity.ShareLink
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &link))
+
+	var shared entity.SharedPost
+	w = serveAnonymous(router, httptest.NewRequest(http.MethodGet, "/api/shared/"+link.Token+"?format=html", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shared))
+	url := fmt.Sprintf("/api/shared/%s/download-file/%d", link.Token, aid)
+	require.Contains(t, shared.ContentHtml, fmt.Sprintf(`src="%s"`, url))
+	w = serveAnonymous(router, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLinkRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	var target, source entity.Post
+	w := serve(router, newSavePostRequest(t, entity.Post{Title: "Target", Content: "content", Date: time.Now()}, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &target))
+	w = serve(router, newSavePostRequest(t, entity.Post{
+		Title:   "source",
+		Content: fmt.Sprintf("[[target]] [[post:%d]] [[missing]]", target.Id),
+		Date:    time.Now(),
+	}, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &source))
+
+	var links []entity.LinkedPost
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/posts/%d/links", source.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &links))
+	require.Len(t, links, 3)
+	require.Equal(t, target.Id, links[0].PostId)
+	require.Equal(t, target.Id, links[1].PostId)
+	require.True(t, links[2].Broken)
+
+	var backlinks []entity.LinkedPost
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/posts/%d/backlinks", target.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &backlinks))
+	require.Len(t, backlinks, 2)
+	require.Equal(t, source.Id, backlinks[0].PostId)
+
+	// deleting the target responds the links broken by it
+	var broken []entity.LinkedPost
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-post/%d", target.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(jsonField(t, w.Body.Bytes(), "broken_links"), &broken))
+	require.Len(t, broken, 2)
+	require.True(t, broken[0].Broken)
+	require.Equal(t, "source", broken[0].Title)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/posts/%d/links", source.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &links))
+	require.True(t, links[0].Broken)
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/posts/x/links", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestNotebookRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	var work, projects entity.Notebook
+	w := serve(router, newJSONRequest(t, http.MethodPost, "/api/save-notebook", entity.Notebook{Name: "work"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &work))
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/save-notebook", entity.Notebook{Name: "projects", ParentId: &work.Id}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &projects))
+
+	// nesting a notebook in itself is rejected
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/save-notebook", entity.Notebook{UintId: work.UintId, Name: "work", ParentId: &projects.Id}))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	var post entity.Post
+	w = serve(router, newSavePostRequest(t, entity.Post{Title: "roadmap", Content: "content", Date: time.Now()}, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+
+	w = serve(router, newJSONRequest(t, http.MethodPost, fmt.Sprintf("/api/move-post/%d", post.Id), entity.Destination{NotebookId: &projects.Id}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	require.Equal(t, projects.Id, *post.NotebookId)
+
+	var tree []entity.NotebookNode
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-notebooks", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tree))
+	require.Len(t, tree, 1)
+	require.Equal(t, int64(1), tree[0].Children[0].PostCount)
+
+	// posts are listed by notebook, the notebooks nested in it only if recursive
+	var page entity.PostPage
+	url := fmt.Sprintf("/api/get-posts?page=1&size=10&notebook_id=%d", work.Id)
+	w = serve(router, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Empty(t, page.Items)
+	w = serve(router, httptest.NewRequest(http.MethodGet, url+"&recursive=true", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+
+	url = fmt.Sprintf("/api/query-posts?page=1&size=10&text=content&notebook_id=%d", projects.Id)
+	w = serve(router, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-posts?page=1&size=10&notebook_id=999", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-posts?page=1&size=10&notebook_id=1&recursive=maybe", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a notebook which is not empty is refused, unless its contents are moved
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-notebook/%d", work.Id), nil))
+	require.Equal(t, http.StatusConflict, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-notebook/%d?move_to=nowhere", work.Id), nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-notebook/%d?move_to=root", work.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var notebook entity.Notebook
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-notebook/%d", projects.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &notebook))
+	require.Nil(t, notebook.ParentId)
+}
+
+func TestExportRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{Title: "Exported", Content: "see [notes](affiliate:0)", Date: time.Now(), Affiliates: []entity.Affiliate{{Filename: "notes.txt"}}}
+	w := serve(router, newSavePostRequest(t, post, map[string][]byte{"notes.txt": []byte("notes")}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	mustSave := func(post entity.Post) {
+		w := serve(router, newSavePostRequest(t, post, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	aid := post.Affiliates[0].Id
+	mustSave(entity.Post{UintId: post.UintId, Content: fmt.Sprintf("see [notes](affiliate:%d)", aid), Affiliates: post.Affiliates})
+	mustSave(entity.Post{Title: "Other", Content: "content", Date: time.Now()})
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/export/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Header().Get("Content-Disposition"), fmt.Sprintf("toy-note-post-%d.zip", post.Id))
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	require.ElementsMatch(t, []string{fmt.Sprintf("%d-exported.md", post.Id), fmt.Sprintf("attachments/%d-notes.txt", aid)}, names)
+
+	// the whole library, or the posts found by the criteria
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/export", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	archive, err = zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	require.Len(t, archive.File, 3)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/export?text=other", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	archive, err = zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	require.Len(t, archive.File, 1)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/export?sort=nonsense", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/export/999", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+// build an `import` request, the archive is put in the "file" field
+func newImportRequest(t *testing.T, url string, archive []byte) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", "archive.zip")
+	require.NoError(t, err)
+	_, err = fw.Write(archive)
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, url, body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestImportRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	post := entity.Post{Title: "Round trip", Content: "see [notes](affiliate:0)", Date: time.Now(), Affiliates: []entity.Affiliate{{Filename: "notes.txt"}}}
+	w := serve(router, newSavePostRequest(t, post, map[string][]byte{"notes.txt": []byte("notes")}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	aid := post.Affiliates[0].Id
+	w = serve(router, newSavePostRequest(t, entity.Post{UintId: post.UintId, Content: fmt.Sprintf("see [notes](affiliate:%d)", aid), Affiliates: post.Affiliates}, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/export/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	archive := w.Body.Bytes()
+
+	// the post still exists
+	var report entity.ImportReport
+	w = serve(router, newImportRequest(t, "/api/import", archive))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Equal(t, 1, report.Duplicates)
+	require.Equal(t, post.Id, report.Files[0].PostId)
+
+	w = serve(router, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/delete-post/%d", post.Id), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, newImportRequest(t, "/api/import?dry_run=true", archive))
+	require.Equal(t, http.StatusOK, w.Code)
+	report = entity.ImportReport{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.True(t, report.DryRun)
+	require.Equal(t, 1, report.Created)
+	require.Empty(t, report.Files[0].PostId)
+
+	w = serve(router, newImportRequest(t, "/api/import", archive))
+	require.Equal(t, http.StatusOK, w.Code)
+	report = entity.ImportReport{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Equal(t, 1, report.Created)
+
+	var imported entity.Post
+	w = serve(router, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/get-post/%d", report.Files[0].PostId), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &imported))
+	require.Equal(t, "Round trip", imported.Title)
+	require.Len(t, imported.Affiliates, 1)
+	require.Equal(t, fmt.Sprintf("see [notes](affiliate:%d)", imported.Affiliates[0].Id), imported.Content)
+
+	w = serve(router, newImportRequest(t, "/api/import", []byte("not an archive")))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, newImportRequest(t, "/api/import?dry_run=maybe", archive))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/import", post))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// exports of other apps
+	enex := `<?xml version="1.0" encoding="UTF-8"?><en-export><note><title>From Evernote</title>` +
+		`<created>20220301T101500Z</created><content><![CDATA[<en-note><div>hi</div></en-note>]]></content></note></en-export>`
+	w = serve(router, newImportRequest(t, "/api/import?source=enex", []byte(enex)))
+	require.Equal(t, http.StatusOK, w.Code)
+	report = entity.ImportReport{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Equal(t, 1, report.Created)
+	require.Equal(t, "From Evernote", report.Files[0].Title)
+
+	w = serve(router, newImportRequest(t, "/api/import?source=joplin", archive))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, newImportRequest(t, "/api/import?source=onenote", archive))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBulkRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	var tag entity.Tag
+	w := serve(router, newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "go"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tag))
+
+	ids := []uint{}
+	for _, title := range []string{"p1", "p2"} {
+		var post entity.Post
+		w = serve(router, newSavePostRequest(t, entity.Post{Title: title, Content: "content", Date: time.Now()}, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+		ids = append(ids, post.Id)
+	}
+
+	var report entity.BulkReport
+	tagging := entity.BulkTagging{PostIds: append(ids, 999), AddTagIds: []uint{tag.Id}}
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/bulk-tag-posts", tagging))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Equal(t, 2, report.Succeeded)
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, uint(999), report.Items[2].Id)
+
+	// a tag not found fails the whole request
+	tagging = entity.BulkTagging{PostIds: ids, AddTagIds: []uint{999}}
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/bulk-tag-posts", tagging))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	dating := entity.BulkDating{PostIds: ids, Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/bulk-date-posts", dating))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/bulk-date-posts", entity.BulkDating{PostIds: ids}))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/bulk-delete-posts", entity.BulkIds{}))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/bulk-delete-posts", entity.BulkIds{Ids: ids}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Equal(t, 2, report.Succeeded)
+
+	w = serve(router, newJSONRequest(t, http.MethodPost, "/api/bulk-delete-tags", entity.BulkIds{Ids: []uint{tag.Id}}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Equal(t, 1, report.Succeeded)
+
+	w = serve(router, httptest.NewRequest(http.MethodGet, "/api/get-tags", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, "[]", w.Body.String())
+}
+
+// build a patch request with the content type of the format
+func newPatchRequest(url, contentType, patch string) *http.Request {
+	req := httptest.NewRequest(http.MethodPatch, url, strings.NewReader(patch))
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func TestPatchRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	var tag entity.Tag
+	w := serve(router, newJSONRequest(t, http.MethodPost, "/api/save-tag", entity.Tag{Name: "go"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tag))
+
+	var post entity.Post
+	w = serve(router, newSavePostRequest(t, entity.Post{
+		Title:   "typo",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: tag.UintId}},
+	}, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	url := fmt.Sprintf("/api/posts/%d", post.Id)
+
+	// the tags are kept by a merge patch which omits them
+	w = serve(router, newPatchRequest(url, "application/merge-patch+json", `{"title": "fixed"}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `"2"`, w.Header().Get("ETag"))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	require.Equal(t, "fixed", post.Title)
+	require.Len(t, post.Tags, 1)
+
+	w = serve(router, newPatchRequest(url, "application/json-patch+json", `[{"op": "remove", "path": "/tags/0"}]`))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &post))
+	require.Empty(t, post.Tags)
+
+	// a stale If-Match and a failed test are conflicts
+	req := newPatchRequest(url, "application/json", `{"title": "stale"}`)
+	req.Header.Set("If-Match", `"1"`)
+	w = serve(router, req)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, `"3"`, w.Header().Get("ETag"))
+	w = serve(router, newPatchRequest(url, "application/json-patch+json", `[{"op": "test", "path": "/title", "value": "typo"}]`))
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(router, newPatchRequest(url, "application/merge-patch+json", `{"owner_id": 42}`))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(router, newPatchRequest(url, "text/plain", `{"title": "plain"}`))
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	w = serve(router, newPatchRequest(url, "application/merge-patch+json", `{"content": "[x](affiliate:999)"}`))
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = serve(router, newPatchRequest("/api/posts/abc", "application/merge-patch+json", `{}`))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
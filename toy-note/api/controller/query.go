@@ -2,7 +2,9 @@ package controller
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 	"toy-note/api/entity"
 
@@ -10,22 +12,31 @@ import (
 )
 
 func getPaginationFromQuery(ctx *gin.Context) (entity.Pagination, error) {
-	// get pagination's page from query string
-	pageQuery, v := ctx.GetQuery("page")
+	// get pagination's size from query string
+	sizeQuery, v := ctx.GetQuery("size")
 	if !v {
-		return entity.Pagination{}, errors.New("page query is required")
+		return entity.Pagination{}, errors.New("size query is required")
 	}
-	page, err := strconv.ParseInt(pageQuery, 10, 64)
+	size, err := strconv.ParseInt(sizeQuery, 10, 64)
 	if err != nil {
 		return entity.Pagination{}, err
 	}
 
-	// get pagination's size from query string
-	sizeQuery, v := ctx.GetQuery("size")
+	// a cursor takes the place of page, it is validated here so that a bad cursor
+	// is reported as a bad request
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		if _, err := entity.DecodePostCursor(cursor); err != nil {
+			return entity.Pagination{}, err
+		}
+		return entity.NewCursorPagination(cursor, int(size)), nil
+	}
+
+	// get pagination's page from query string
+	pageQuery, v := ctx.GetQuery("page")
 	if !v {
-		return entity.Pagination{}, errors.New("size query is required")
+		return entity.Pagination{}, errors.New("page query is required")
 	}
-	size, err := strconv.ParseInt(sizeQuery, 10, 64)
+	page, err := strconv.ParseInt(pageQuery, 10, 64)
 	if err != nil {
 		return entity.Pagination{}, err
 	}
@@ -37,6 +48,49 @@ func getPaginationFromQuery(ctx *gin.Context) (entity.Pagination, error) {
 
 }
 
+// `?format=html` responds the content of posts rendered as HTML as well, see `entity.ContentFormat`
+func getFormatFromQuery(ctx *gin.Context) (entity.ContentFormat, error) {
+	return entity.ParseContentFormat(ctx.Query("format"))
+}
+
+// `?notebook_id=` scopes posts to a notebook, nil if not given
+func getNotebookScopeFromQuery(ctx *gin.Context) (*entity.NotebookScope, error) {
+	idQuery, v := ctx.GetQuery("notebook_id")
+	if !v {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(idQuery, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	recursive, err := strconv.ParseBool(ctx.DefaultQuery("recursive", "false"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.NotebookScope{NotebookId: uint(id), Recursive: recursive}, nil
+}
+
+// where to move, either `root` or a notebook id. nil if not given
+func getDestinationFromQuery(ctx *gin.Context, key string) (*entity.Destination, error) {
+	query, v := ctx.GetQuery(key)
+	if !v {
+		return nil, nil
+	}
+	if query == "root" {
+		return &entity.Destination{}, nil
+	}
+
+	id, err := strconv.ParseUint(query, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be root or a notebook id: %w", key, err)
+	}
+	notebookId := uint(id)
+
+	return &entity.Destination{NotebookId: &notebookId}, nil
+}
+
 func getTimeSearchFromQuery(ctx *gin.Context) (entity.TimeSearch, error) {
 	// get start time from query string
 	startQuery, v := ctx.GetQuery("start")
@@ -58,8 +112,8 @@ func getTimeSearchFromQuery(ctx *gin.Context) (entity.TimeSearch, error) {
 		return entity.TimeSearch{}, err
 	}
 
-	// get time type from query string
-	timeTypeQuery := ctx.Query("type")
+	// get time type from query string, default to `entity.DATE`
+	timeTypeQuery := ctx.DefaultQuery("type", "0")
 	tt, err := strconv.ParseInt(timeTypeQuery, 10, 64)
 	if err != nil {
 		return entity.TimeSearch{}, err
@@ -71,3 +125,100 @@ func getTimeSearchFromQuery(ctx *gin.Context) (entity.TimeSearch, error) {
 		Type:  entity.TimeType(tt),
 	}, nil
 }
+
+// parse a list of uint, e.g. `ids=1&ids=2`
+func getUintsFromQuery(ctx *gin.Context, key string) ([]uint, error) {
+	var ids []uint
+	for _, id := range ctx.QueryArray(key) {
+		idUint, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(idUint))
+	}
+
+	return ids, nil
+}
+
+func getPostQueryFromQuery(ctx *gin.Context) (entity.PostQuery, error) {
+	pagination, err := getPaginationFromQuery(ctx)
+	if err != nil {
+		return entity.PostQuery{}, err
+	}
+
+	query, err := getPostCriteriaFromQuery(ctx)
+	if err != nil {
+		return entity.PostQuery{}, err
+	}
+	query.Page = pagination.Page
+	query.Size = pagination.Size
+	query.Cursor = pagination.Cursor
+
+	return query, nil
+}
+
+// a `PostQuery` without pagination
+func getPostCriteriaFromQuery(ctx *gin.Context) (entity.PostQuery, error) {
+	tagIds, err := getUintsFromQuery(ctx, "tag_ids")
+	if err != nil {
+		return entity.PostQuery{}, err
+	}
+
+	excludeTagIds, err := getUintsFromQuery(ctx, "exclude_tag_ids")
+	if err != nil {
+		return entity.PostQuery{}, err
+	}
+
+	tagDescendants, err := strconv.ParseBool(ctx.DefaultQuery("tag_descendants", "false"))
+	if err != nil {
+		return entity.PostQuery{}, err
+	}
+
+	// time range is optional, but both start and end are required once given
+	var timeSearch *entity.TimeSearch
+	_, hasStart := ctx.GetQuery("start")
+	_, hasEnd := ctx.GetQuery("end")
+	if hasStart || hasEnd {
+		ts, err := getTimeSearchFromQuery(ctx)
+		if err != nil {
+			return entity.PostQuery{}, err
+		}
+		timeSearch = &ts
+	}
+
+	return entity.PostQuery{
+		TagIds:         tagIds,
+		TagMatch:       entity.TagMatch(ctx.Query("tag_match")),
+		ExcludeTagIds:  excludeTagIds,
+		TagDescendants: tagDescendants,
+		Text:           ctx.Query("text"),
+		Time:           timeSearch,
+		Sort:           entity.SortField(ctx.Query("sort")),
+		Order:          entity.SortOrder(ctx.Query("order")),
+	}, nil
+}
+
+// The version a post or a tag is saved with, given either in the body or by an `If-Match`
+// header with the ETag of `versionETag`. Both must agree if both are given.
+// `If-Match: *` only requires the resource to exist, which is the same as no version.
+func getVersion(ctx *gin.Context, version uint) (uint, error) {
+	ifMatch := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return version, nil
+	}
+
+	// weak ETags never match by the strong comparison of `If-Match`
+	if len(ifMatch) < 2 || ifMatch[0] != '"' || ifMatch[len(ifMatch)-1] != '"' {
+		return 0, fmt.Errorf("If-Match %s is not a single strong ETag", ifMatch)
+	}
+	v, err := strconv.ParseUint(ifMatch[1:len(ifMatch)-1], 10, 64)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("If-Match %s is not a valid version", ifMatch)
+	}
+
+	if version != 0 && version != uint(v) {
+		return 0, fmt.Errorf("version %d does not match If-Match %s", version, ifMatch)
+	}
+
+	return uint(v), nil
+}
@@ -1,6 +1,16 @@
 package controller
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"toy-note/api/entity"
+	"toy-note/api/service"
+
+	"github.com/gin-gonic/gin"
+)
 
 type errorMessage struct {
 	Err string `json:"error"`
@@ -10,6 +20,24 @@ type successMessage struct {
 	Success string `json:"success"`
 }
 
+// response to a stale write, along with the server copy
+type conflictMessage struct {
+	Err     string      `json:"error"`
+	Current interface{} `json:"current"`
+}
+
+// response to content referring to affiliates which are not bound to the post
+type referenceMessage struct {
+	Err          string `json:"error"`
+	AffiliateIds []uint `json:"affiliate_ids"`
+}
+
+// response to a deleted post, along with the links to it which are broken
+type deleteMessage struct {
+	successMessage
+	BrokenLinks []entity.LinkedPost `json:"broken_links"`
+}
+
 func errorResponse(err error) errorMessage {
 	return errorMessage{Err: err.Error()}
 }
@@ -18,7 +46,87 @@ func successResponse(data interface{}) successMessage {
 	return successMessage{Success: fmt.Sprintf("%v", data)}
 }
 
-type downloadSuccess struct {
-	Filename string `json:"filename"`
-	Size     int64  `json:"size"`
+// the status of an error from the service, a denied permission is always 403 Forbidden
+func errorStatus(err error, status int) int {
+	if errors.Is(err, service.ErrForbidden) {
+		return http.StatusForbidden
+	}
+	return status
+}
+
+// Respond an error of saving a post or a tag. A stale write is a 409 Conflict,
+// along with the server copy and its ETag, so that the client can merge and retry.
+// Broken references to affiliates are a 422 Unprocessable Entity, along with their ids.
+func saveErrorResponse(ctx *gin.Context, err error) {
+	var conflict *entity.ConflictError
+	if errors.As(err, &conflict) {
+		ctx.Header("ETag", versionETag(conflict.CurrentVersion))
+		ctx.JSON(http.StatusConflict, conflictMessage{Err: err.Error(), Current: conflict.Current})
+		return
+	}
+	var reference *entity.ReferenceError
+	if errors.As(err, &reference) {
+		ctx.JSON(http.StatusUnprocessableEntity, referenceMessage{Err: err.Error(), AffiliateIds: reference.AffiliateIds})
+		return
+	}
+
+	ctx.JSON(errorStatus(err, http.StatusInternalServerError), errorResponse(err))
+}
+
+// a strong ETag of a post or a tag, which is its version
+func versionETag(version uint) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// Content types which are safe to be rendered by browsers, and the content type
+// they should be served with. Text files are always served as plain text, so that
+// an uploaded html file never runs in our origin; svg is excluded for the same reason.
+func inlineContentType(contentType string) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+
+	switch {
+	case mediaType == "image/svg+xml":
+		return "", false
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"),
+		mediaType == "application/pdf":
+		return contentType, true
+	case strings.HasPrefix(mediaType, "text/"):
+		return "text/plain; charset=utf-8", true
+	default:
+		return "", false
+	}
+}
+
+// `Content-Disposition` header value, with the filename properly quoted
+func contentDisposition(disposition, filename string) string {
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return disposition
+}
+
+// Stream a file into the response, as an attachment or inline if it is safe to be rendered
+func serveFile(ctx *gin.Context, fo entity.FileObject, inline bool) {
+	disposition, contentType := "attachment", fo.ContentType
+	if inline {
+		if ct, ok := inlineContentType(fo.ContentType); ok {
+			disposition, contentType = "inline", ct
+		}
+	}
+
+	ctx.Header("Content-Disposition", contentDisposition(disposition, fo.Filename))
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	if fo.ETag != "" {
+		ctx.Header("ETag", fo.ETag)
+	}
+
+	// `ServeContent` takes care of `Range`, `If-Range`, `If-None-Match`, `If-Modified-Since`,
+	// and streams (part of) the file into the response
+	http.ServeContent(ctx.Writer, ctx.Request, fo.Filename, fo.ModTime, fo.Content)
 }
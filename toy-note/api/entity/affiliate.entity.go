@@ -1,21 +1,61 @@
 package entity
 
+import "fmt"
+
 /*
 Affiliate
 
 A pointer to a file stored in a remote storage.
 
 - id
-- object_id: represents the id saved in MongoDB,
+- object_id: represents the id saved in the blob store (MongoDB or filesystem),
 - filename
+- content_type: MIME type sniffed from the content on upload
+- size: in bytes
+- checksum: hex encoded sha256 of the content
 - post_refer: many-to-one relationship
+- owner_id: the user who uploaded the file
 - created_at
 - updated_at
 */
 type Affiliate struct {
 	UintId
-	ObjectId  string `json:"object_id,omitempty"`
-	Filename  string `gorm:"not null" json:"filename"`
-	PostRefer uint   `json:"post_refer,omitempty"`
+	ObjectId    string `json:"object_id,omitempty"`
+	Filename    string `gorm:"not null" json:"filename"`
+	ContentType string `gorm:"size:255" json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Checksum    string `gorm:"size:64" json:"checksum,omitempty"`
+	PostRefer   uint   `json:"post_refer,omitempty"`
+	OwnerId     uint   `gorm:"index;not null;default:0" json:"owner_id,omitempty"`
 	Dates
 }
+
+// A page of affiliates ordered by id, in response to frontend
+type AffiliatePage struct {
+	Items []Affiliate `json:"items"`
+	Total int64       `json:"total"`
+	Page  int         `json:"page"`
+	Size  int         `json:"size"`
+}
+
+// ReferenceError
+//
+// Returned when a post is saved with content referring to affiliates which are not bound to
+// the post, e.g. `![](affiliate:42)`. Either the content refers to affiliates of elsewhere,
+// or the update would unbind affiliates the content still refers to.
+type ReferenceError struct {
+	PostId       uint
+	AffiliateIds []uint
+	// whether the affiliates are bound to the post before the update
+	Unbinding bool
+}
+
+func (e *ReferenceError) Error() string {
+	if e.Unbinding {
+		return fmt.Sprintf(
+			"affiliates %v are referred to by the content of post %d, remove the references before unbinding them",
+			e.AffiliateIds, e.PostId,
+		)
+	}
+	return fmt.Sprintf("affiliates %v referred to by the content are not bound to the post", e.AffiliateIds)
+}
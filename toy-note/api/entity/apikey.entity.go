@@ -0,0 +1,133 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// what an API key is permitted to do, on top of the role of its user
+type Scope string
+
+const (
+	// read posts, tags, revisions and share links
+	SCOPE_POSTS_READ Scope = "posts:read"
+	// create, update and delete posts, tags and share links
+	SCOPE_POSTS_WRITE Scope = "posts:write"
+	// download affiliates
+	SCOPE_FILES_READ Scope = "files:read"
+	// upload affiliates
+	SCOPE_FILES_WRITE Scope = "files:write"
+)
+
+var knownScopes = map[Scope]bool{
+	SCOPE_POSTS_READ:  true,
+	SCOPE_POSTS_WRITE: true,
+	SCOPE_FILES_READ:  true,
+	SCOPE_FILES_WRITE: true,
+}
+
+// Parse a comma separated list of scopes, e.g. `posts:read,files:read`
+func ParseScopes(s string) (ScopeList, error) {
+	scopes := ScopeList{}
+	for _, part := range strings.Split(s, ",") {
+		if scope := Scope(strings.TrimSpace(part)); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, scopes.Validate()
+}
+
+/*
+ApiKey
+
+A long-lived credential of a user for scripts and integrations, e.g. `tn_1a2b3c4d_<secret>`.
+Only the sha256 hash of the secret is stored, the prefix is kept to identify the key.
+
+- id
+- user_id: many-to-one relationship
+- name: what the key is used for
+- prefix: unique, the public part of the key
+- secret_hash: hex encoded sha256 of the secret
+- scopes: what the key is permitted to do, see `Scope`
+- last_used_at
+- revoked_at
+- created_at
+*/
+type ApiKey struct {
+	UintId
+	UserId     uint       `gorm:"index;not null" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	Prefix     string     `gorm:"size:16;not null;unique" json:"prefix"`
+	SecretHash string     `gorm:"size:64;not null" json:"-"`
+	Scopes     ScopeList  `gorm:"type:jsonb;not null" json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (k ApiKey) Allows(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// an API key to be created, request from frontend
+type ApiKeyRequest struct {
+	Name   string    `json:"name" binding:"required"`
+	Scopes ScopeList `json:"scopes" binding:"required"`
+}
+
+// an API key just created, the key itself is only responded once
+type NewApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+// A list of scopes stored as a json array
+type ScopeList []Scope
+
+// Check all the scopes are known, and there is at least one
+func (l ScopeList) Validate() error {
+	if len(l) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range l {
+		if !knownScopes[scope] {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+func (l ScopeList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]Scope(l))
+	return string(data), err
+}
+
+func (l *ScopeList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*l = ScopeList{}
+		return nil
+	default:
+		return fmt.Errorf("can't scan %T into ScopeList", value)
+	}
+
+	return json.Unmarshal(data, (*[]Scope)(l))
+}
@@ -0,0 +1,86 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// the most items changed by a bulk request
+const MaxBulkItems = 1000
+
+// Ids of the items changed by a bulk request, request from frontend
+type BulkIds struct {
+	Ids []uint `json:"ids"`
+}
+
+// Tags added to and removed from posts at once, request from frontend
+type BulkTagging struct {
+	PostIds      []uint `json:"post_ids"`
+	AddTagIds    []uint `json:"add_tag_ids,omitempty"`
+	RemoveTagIds []uint `json:"remove_tag_ids,omitempty"`
+}
+
+// The date set to posts at once, request from frontend
+type BulkDating struct {
+	PostIds []uint    `json:"post_ids"`
+	Date    time.Time `json:"date"`
+}
+
+// Check the number of the items, and drop duplicated ids keeping the order
+func NormalizeBulkIds(ids []uint) ([]uint, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("ids are required")
+	}
+	if len(ids) > MaxBulkItems {
+		return nil, fmt.Errorf("at most %d items can be changed at once", MaxBulkItems)
+	}
+
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique, nil
+}
+
+// The outcome of an item of a bulk request
+type BulkItemResult struct {
+	Id    uint   `json:"id"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+/*
+BulkReport
+
+The report of a bulk request, in response to frontend. The items which succeeded are
+changed in one transaction, the ones failed are left as they are.
+
+- succeeded: the number of items changed
+- failed: the number of items failed, e.g. not found
+- items: the outcome of every item, in the order of the request
+*/
+type BulkReport struct {
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Items     []BulkItemResult `json:"items"`
+}
+
+// Build the report of the items, the ones without errors succeeded
+func NewBulkReport(ids []uint, errs map[uint]error) BulkReport {
+	report := BulkReport{Items: make([]BulkItemResult, 0, len(ids))}
+	for _, id := range ids {
+		if err := errs[id]; err != nil {
+			report.Failed++
+			report.Items = append(report.Items, BulkItemResult{Id: id, Error: err.Error()})
+			continue
+		}
+		report.Succeeded++
+		report.Items = append(report.Items, BulkItemResult{Id: id, Ok: true})
+	}
+	return report
+}
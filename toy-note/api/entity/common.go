@@ -1,7 +1,11 @@
 package entity
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"gorm.io/gorm"
@@ -31,10 +35,34 @@ func (d *Dates) BeforeUpdate(tx *gorm.DB) (err error) {
 	return
 }
 
+// ConflictError
+//
+// Returned when a post or a tag is saved with a stale version, i.e. someone else has
+// updated it since the version was read. A zero version is never stale.
+type ConflictError struct {
+	// "post" or "tag"
+	Resource string
+	Id       uint
+	// the stale version given
+	Version uint
+	// the server copy, `Post` or `Tag`, and its version
+	Current        interface{}
+	CurrentVersion uint
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf(
+		"%s %d has been modified, version %d is stale, current version is %d",
+		e.Resource, e.Id, e.Version, e.CurrentVersion,
+	)
+}
+
 // request from frontend
 type Pagination struct {
 	Page int
 	Size int
+	// an opaque keyset cursor, see `PostCursor`. Page is ignored once a cursor is given
+	Cursor string
 }
 
 func NewPagination(page, size int) Pagination {
@@ -44,11 +72,62 @@ func NewPagination(page, size int) Pagination {
 	}
 }
 
-// response to frontend
-type FileObject struct {
-	Filename string
-	Content  []byte
+func NewCursorPagination(cursor string, size int) Pagination {
+	return Pagination{
+		Size:   size,
+		Cursor: cursor,
+	}
+}
+
+// PostCursor
+//
+// The position of the last post of a page, posts ordered by (date, id) are continued
+// right after it. Unlike offsets, a cursor is not shifted by posts added in the meanwhile.
+type PostCursor struct {
+	Date time.Time `json:"d"`
+	Id   uint      `json:"i"`
+}
+
+// opaque to the frontend, url safe
+func (c PostCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodePostCursor(cursor string) (PostCursor, error) {
+	var c PostCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.Id == 0 {
+		return c, errors.New("invalid cursor")
+	}
+
+	return c, nil
+}
+
+// metadata of a file saved in a blob store
+type FileMeta struct {
+	ObjectId string
 	Size     int64
+	// hex encoded sha256 of the content
+	Checksum string
+}
+
+// response to frontend.
+// Content is streamed from the storage, and it must be closed by the receiver.
+// It is seekable, so that a part of the file can be served.
+type FileObject struct {
+	Filename    string
+	ContentType string
+	Content     io.ReadSeekCloser
+	Size        int64
+	// when the affiliate was last modified, zero if unknown
+	ModTime time.Time
+	// a strong validator of the content, already quoted. Empty if unknown
+	ETag string
 }
 
 type TimeType uint
@@ -60,7 +139,7 @@ const (
 )
 
 type TimeSearch struct {
-	Start time.Time
-	End   time.Time
-	Type  TimeType
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Type  TimeType  `json:"type"`
 }
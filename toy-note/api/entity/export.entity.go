@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+/*
+FrontMatter
+
+The YAML front matter of a post exported as a Markdown file, followed by the content.
+
+- id: id of the post when it was exported
+- title
+- subtitle
+- date
+- tags: names of the tags, prefixed by the names of the tags they are nested in, e.g. `lang/go`
+- attachments: paths of the files of the affiliates in the archive, relative to the post
+- created_at
+- updated_at
+*/
+type FrontMatter struct {
+	Id          uint      `yaml:"id,omitempty"`
+	Title       string    `yaml:"title"`
+	Subtitle    string    `yaml:"subtitle,omitempty"`
+	Date        time.Time `yaml:"date"`
+	Tags        []string  `yaml:"tags,omitempty"`
+	Attachments []string  `yaml:"attachments,omitempty"`
+	CreatedAt   time.Time `yaml:"created_at"`
+	UpdatedAt   time.Time `yaml:"updated_at"`
+}
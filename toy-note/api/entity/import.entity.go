@@ -0,0 +1,85 @@
+package entity
+
+import "fmt"
+
+// the app an imported archive is exported from
+type ImportSource string
+
+const (
+	// Markdown files with YAML front matter, e.g. an archive of `Export`
+	SOURCE_MARKDOWN ImportSource = "markdown"
+	// an ENEX file of Evernote
+	SOURCE_ENEX ImportSource = "enex"
+	// a RAW export directory of Joplin, in a zip archive or a tarball
+	SOURCE_JOPLIN ImportSource = "joplin"
+)
+
+// Parse an import source, markdown by default
+func ParseImportSource(s string) (ImportSource, error) {
+	switch ImportSource(s) {
+	case "", SOURCE_MARKDOWN:
+		return SOURCE_MARKDOWN, nil
+	case SOURCE_ENEX, SOURCE_JOPLIN:
+		return ImportSource(s), nil
+	default:
+		return "", fmt.Errorf("unknown source %q", s)
+	}
+}
+
+// the outcome of importing a note
+type ImportStatus string
+
+const (
+	// a post is created from the note, or would be in a dry run
+	IMPORT_CREATED ImportStatus = "created"
+	// a post with the same title and date exists, the note is skipped
+	IMPORT_DUPLICATE ImportStatus = "duplicate"
+	// the note can't be imported, see the error
+	IMPORT_FAILED ImportStatus = "failed"
+)
+
+/*
+ImportFileResult
+
+The outcome of a note in an imported archive.
+
+  - path: the path of the file in the archive, or the position of the note in an ENEX file,
+    e.g. `note-3`
+  - status: `created`, `duplicate` or `failed`
+  - post_id: the created post, or the existing post it duplicates. Empty in a dry run
+  - title
+  - tags: paths of the tags the post is bound to, e.g. `lang/go`
+  - attachments: paths of the files in the archive bound to the post as affiliates
+  - error: why the note failed
+*/
+type ImportFileResult struct {
+	Path        string       `json:"path"`
+	Status      ImportStatus `json:"status"`
+	PostId      uint         `json:"post_id,omitempty"`
+	Title       string       `json:"title,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	Attachments []string     `json:"attachments,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+/*
+ImportReport
+
+The report of an import, in response to frontend. In a dry run nothing is written, and
+the report tells what would be created.
+
+- dry_run
+- created: the number of posts created
+- duplicates: the number of notes skipped as duplicates
+- failed: the number of notes failed
+- tags_created: paths of the tags created, the tags matched by name are not listed
+- files: the outcome of every note, in the order they are imported
+*/
+type ImportReport struct {
+	DryRun      bool               `json:"dry_run"`
+	Created     int                `json:"created"`
+	Duplicates  int                `json:"duplicates"`
+	Failed      int                `json:"failed"`
+	TagsCreated []string           `json:"tags_created"`
+	Files       []ImportFileResult `json:"files"`
+}
@@ -0,0 +1,60 @@
+package entity
+
+import (
+	"strconv"
+	"strings"
+)
+
+// `[[post:123]]` links to a post by id, rather than by title
+const postLinkPrefix = "post:"
+
+/*
+PostLink
+
+A wiki-style link in the content of a post to another post of the same owner, either by
+title `[[Post Title]]` or by id `[[post:123]]`. Links are replaced every time the post is saved.
+A link by title is resolved when it is read, to the oldest post of the title (case-insensitive),
+so that it follows a post being renamed or created afterwards.
+
+- id
+- source_id: many-to-one relationship, the post whose content has the link
+- target_id: the linked post of a link by id
+- target_title: the linked title of a link by title
+*/
+type PostLink struct {
+	UintId
+	SourceId    uint   `gorm:"index;not null" json:"source_id"`
+	TargetId    *uint  `gorm:"index" json:"target_id,omitempty"`
+	TargetTitle string `gorm:"index" json:"target_title,omitempty"`
+}
+
+// A link of a post by the target written in `[[]]`, e.g. `Post Title` or `post:123`
+func NewPostLink(sourceId uint, target string) PostLink {
+	if strings.HasPrefix(target, postLinkPrefix) {
+		id, err := strconv.ParseUint(strings.TrimPrefix(target, postLinkPrefix), 10, 64)
+		if err == nil && id != 0 {
+			targetId := uint(id)
+			return PostLink{SourceId: sourceId, TargetId: &targetId}
+		}
+	}
+	return PostLink{SourceId: sourceId, TargetTitle: target}
+}
+
+// The link as written in `[[]]`
+func (l PostLink) Target() string {
+	if l.TargetId != nil {
+		return postLinkPrefix + strconv.FormatUint(uint64(*l.TargetId), 10)
+	}
+	return l.TargetTitle
+}
+
+// A post on the other side of a link, in response to frontend:
+// the target of a link of the post, or the source of a backlink to the post
+type LinkedPost struct {
+	// as written in `[[]]`, e.g. `Post Title` or `post:123`
+	Link   string `json:"link"`
+	PostId uint   `json:"post_id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	// the target doesn't exist or is trashed, only links can be broken
+	Broken bool `json:"broken"`
+}
@@ -0,0 +1,87 @@
+package entity
+
+import (
+	"errors"
+	"sort"
+)
+
+// a notebook still has notebooks or posts, and it is deleted without moving them
+var ErrNotebookNotEmpty = errors.New("notebook is not empty, move its notebooks and posts first")
+
+/*
+Notebook
+
+A folder of posts, which can be nested in another notebook. A post is in at most one
+notebook, or at the root if it is in none.
+
+- id
+- name
+- parent_id: the notebook it is nested in, empty at the root
+- owner_id: the user who owns the notebook
+- created_at
+- updated_at
+*/
+type Notebook struct {
+	UintId
+	Name     string `gorm:"size:100;not null" json:"name"`
+	ParentId *uint  `gorm:"index" json:"parent_id,omitempty"`
+	OwnerId  uint   `gorm:"index;not null;default:0" json:"owner_id,omitempty"`
+	Dates
+}
+
+// A notebook along with the notebooks nested in it, in response to frontend
+type NotebookNode struct {
+	Notebook
+	// posts right in the notebook, trashed posts are not counted
+	PostCount int64          `json:"post_count"`
+	Children  []NotebookNode `json:"children"`
+}
+
+// Build the trees of notebooks from their parents, ordered by name then id.
+// counts are the numbers of posts by notebook ids.
+func NewNotebookTree(notebooks []Notebook, counts map[uint]int64) []NotebookNode {
+	sorted := append([]Notebook{}, notebooks...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Id < sorted[j].Id
+	})
+
+	children := make(map[uint][]Notebook)
+	for _, n := range sorted {
+		var parent uint
+		if n.ParentId != nil {
+			parent = *n.ParentId
+		}
+		children[parent] = append(children[parent], n)
+	}
+
+	var build func(parent uint) []NotebookNode
+	build = func(parent uint) []NotebookNode {
+		nodes := []NotebookNode{}
+		for _, n := range children[parent] {
+			nodes = append(nodes, NotebookNode{
+				Notebook:  n,
+				PostCount: counts[n.Id],
+				Children:  build(n.Id),
+			})
+		}
+		return nodes
+	}
+
+	return build(0)
+}
+
+// Where posts and notebooks are moved to, request from frontend. Either a notebook,
+// or the root if the notebook id is empty
+type Destination struct {
+	NotebookId *uint `json:"notebook_id"`
+}
+
+// The notebook which posts are listed or searched in
+type NotebookScope struct {
+	NotebookId uint
+	// the notebooks nested in it at any depth are included as well
+	Recursive bool
+}
@@ -0,0 +1,77 @@
+package entity
+
+import (
+	"fmt"
+	"mime"
+	"time"
+)
+
+// the format of a patch of a post, told by its content type
+type PatchFormat string
+
+const (
+	// JSON Merge Patch, RFC 7396
+	PATCH_MERGE PatchFormat = "application/merge-patch+json"
+	// JSON Patch, RFC 6902
+	PATCH_JSON PatchFormat = "application/json-patch+json"
+)
+
+// Parse the content type of a patch, plain JSON is taken as a merge patch
+func ParsePatchFormat(contentType string) (PatchFormat, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid content type %q", contentType)
+	}
+
+	switch PatchFormat(mediaType) {
+	case "application/json", PATCH_MERGE:
+		return PATCH_MERGE, nil
+	case PATCH_JSON:
+		return PATCH_JSON, nil
+	default:
+		return "", fmt.Errorf("unsupported content type %q", mediaType)
+	}
+}
+
+/*
+PostPatch
+
+A patch of a post, request from frontend. The patch is applied to the post as it is
+responded, along with empty arrays of operations on its associations:
+
+  - add_tags, remove_tags: ids of tags to bind to and unbind from the post
+  - add_affiliates, remove_affiliates: ids of existing affiliates to bind to and unbind
+    from the post
+
+Members omitted by a merge patch are kept, and so are the tags and affiliates which are
+not operated on. `version` is the version being patched, the same as by `If-Match`.
+*/
+type PostPatch struct {
+	Format  PatchFormat
+	Patch   []byte
+	Version uint
+}
+
+// PostChanges
+//
+// The changes of a patched post, nil fields are kept. Tags and affiliates are bound and
+// unbound by ids, the others stay bound as they are.
+type PostChanges struct {
+	// the version being patched, a zero version is never stale
+	Version            uint
+	Title              *string
+	Subtitle           *string
+	Content            *string
+	Date               *time.Time
+	AddTagIds          []uint
+	RemoveTagIds       []uint
+	AddAffiliateIds    []uint
+	RemoveAffiliateIds []uint
+}
+
+// whether nothing is changed
+func (c PostChanges) Empty() bool {
+	return c.Title == nil && c.Subtitle == nil && c.Content == nil && c.Date == nil &&
+		len(c.AddTagIds) == 0 && len(c.RemoveTagIds) == 0 &&
+		len(c.AddAffiliateIds) == 0 && len(c.RemoveAffiliateIds) == 0
+}
@@ -1,7 +1,10 @@
 package entity
 
 import (
+	"fmt"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 /*
@@ -12,17 +15,75 @@ A data structure used for storing notes.
 - id
 - title
 - subtitle
-- content
+- content: Markdown
+- content_html: sanitized HTML rendered from the content, only responded on `?format=html`
+- version: incremented on every update, see `ConflictError`
+- owner_id: the user who owns the post
+- notebook_id: the notebook the post is in, empty at the root
 - created_at
 - updated_at
+- deleted_at: set once the post is moved to trash
 */
 type Post struct {
 	UintId
-	Title      string      `gorm:"size:100;not null" json:"title"`
-	Subtitle   string      `gorm:"size:100" json:"subtitle,omitempty"`
-	Content    string      `gorm:"text;not null" json:"content"`
-	Date       time.Time   `gorm:"index;not null" json:"date"`
-	Affiliates []Affiliate `gorm:"foreignKey:PostRefer;references:Id" json:"affiliates"`
-	Tags       []Tag       `gorm:"many2many:posts_tags;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"tags"`
+	Title       string      `gorm:"size:100;not null" json:"title"`
+	Subtitle    string      `gorm:"size:100" json:"subtitle,omitempty"`
+	Content     string      `gorm:"text;not null" json:"content"`
+	ContentHtml string      `gorm:"-" json:"content_html,omitempty"`
+	Date        time.Time   `gorm:"index;not null" json:"date"`
+	Affiliates  []Affiliate `gorm:"foreignKey:PostRefer;references:Id" json:"affiliates"`
+	Tags        []Tag       `gorm:"many2many:posts_tags;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"tags"`
+	Version     uint        `gorm:"not null;default:1" json:"version,omitempty"`
+	OwnerId     uint        `gorm:"index;not null;default:0" json:"owner_id,omitempty"`
+	NotebookId  *uint       `gorm:"index" json:"notebook_id,omitempty"`
 	Dates
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty" swaggertype:"string" format:"date-time"`
+}
+
+// how the content of a post is responded
+type ContentFormat string
+
+const (
+	// the content as it is stored, Markdown
+	FORMAT_MARKDOWN ContentFormat = "markdown"
+	// the content along with its rendering, see `Post.ContentHtml`
+	FORMAT_HTML ContentFormat = "html"
+)
+
+// Parse a content format, markdown by default
+func ParseContentFormat(s string) (ContentFormat, error) {
+	switch ContentFormat(s) {
+	case "", FORMAT_MARKDOWN:
+		return FORMAT_MARKDOWN, nil
+	case FORMAT_HTML:
+		return FORMAT_HTML, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", s)
+	}
+}
+
+// A post found by full-text search, with its rank and a highlighted snippet of the content.
+// Matched words in the snippet are wrapped by `<mark></mark>`.
+type PostSearchResult struct {
+	Post    Post    `json:"post"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// A page of posts, in response to frontend.
+// `next_cursor` is given if there are more posts ordered by date, see `PostCursor`.
+type PostPage struct {
+	Items      []Post `json:"items"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page"`
+	Size       int    `json:"size"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// A page of full-text search results, which are ordered by rank, hence no cursor
+type PostSearchPage struct {
+	Items []PostSearchResult `json:"items"`
+	Total int64              `json:"total"`
+	Page  int                `json:"page"`
+	Size  int                `json:"size"`
 }
@@ -0,0 +1,113 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+)
+
+// how the tags of a `PostQuery` are matched
+type TagMatch string
+
+const (
+	// a post must be bound to all the given tags
+	MATCH_ALL TagMatch = "all"
+	// a post must be bound to at least one of the given tags
+	MATCH_ANY TagMatch = "any"
+)
+
+type SortField string
+
+const (
+	SORT_DATE       SortField = "date"
+	SORT_CREATED_AT SortField = "created_at"
+	SORT_UPDATED_AT SortField = "updated_at"
+	SORT_TITLE      SortField = "title"
+)
+
+type SortOrder string
+
+const (
+	ASC  SortOrder = "asc"
+	DESC SortOrder = "desc"
+)
+
+/*
+PostQuery
+
+Criteria for finding posts, all the given criteria are combined with AND.
+
+  - tag_ids: posts bound to these tags, see `tag_match`
+  - tag_match: `all` (default) or `any`
+  - exclude_tag_ids: posts bound to any of these tags are excluded
+  - tag_descendants: a tag of `tag_ids` and `exclude_tag_ids` stands for the tags nested in it
+    at any depth as well
+  - text: full-text search over title, subtitle and content, the same syntax as `SearchPosts`
+  - time: time range of `date`, `created_at` or `updated_at`
+  - sort: `date` (default), `created_at`, `updated_at` or `title`
+  - order: `desc` (default) or `asc`, posts with the same sort value are ordered by id
+  - page, size: pagination
+  - cursor: `next_cursor` of the previous page, instead of page. Only available when sorted by date
+*/
+type PostQuery struct {
+	TagIds         []uint      `json:"tag_ids,omitempty"`
+	TagMatch       TagMatch    `json:"tag_match,omitempty"`
+	ExcludeTagIds  []uint      `json:"exclude_tag_ids,omitempty"`
+	TagDescendants bool        `json:"tag_descendants,omitempty"`
+	Text           string      `json:"text,omitempty"`
+	Time           *TimeSearch `json:"time,omitempty"`
+	Sort           SortField   `json:"sort,omitempty"`
+	Order          SortOrder   `json:"order,omitempty"`
+	Page           int         `json:"page"`
+	Size           int         `json:"size"`
+	Cursor         string      `json:"cursor,omitempty"`
+}
+
+// Fill the defaults, and check all the enumerations are known
+func (q *PostQuery) Normalize() error {
+	switch q.TagMatch {
+	case "":
+		q.TagMatch = MATCH_ALL
+	case MATCH_ALL, MATCH_ANY:
+	default:
+		return fmt.Errorf("unknown tag_match %q", q.TagMatch)
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = SORT_DATE
+	case SORT_DATE, SORT_CREATED_AT, SORT_UPDATED_AT, SORT_TITLE:
+	default:
+		return fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	switch q.Order {
+	case "":
+		q.Order = DESC
+	case ASC, DESC:
+	default:
+		return fmt.Errorf("unknown order %q", q.Order)
+	}
+
+	if q.Cursor != "" {
+		if q.Sort != SORT_DATE {
+			return errors.New("cursor is only available when sorted by date")
+		}
+		if _, err := DecodePostCursor(q.Cursor); err != nil {
+			return err
+		}
+	}
+
+	if q.Time != nil && q.Time.Type > MODIFY {
+		return fmt.Errorf("unknown time type %d", q.Time.Type)
+	}
+
+	return nil
+}
+
+func (q PostQuery) Pagination() Pagination {
+	return Pagination{
+		Page:   q.Page,
+		Size:   q.Size,
+		Cursor: q.Cursor,
+	}
+}
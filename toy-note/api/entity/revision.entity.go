@@ -0,0 +1,135 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/*
+PostRevision
+
+An immutable snapshot of a post, recorded every time the post is saved.
+
+- id
+- post_id: many-to-one relationship
+- title
+- subtitle
+- content
+- date
+- tag_ids: ids of the tags bound to the post at that time
+- affiliate_ids: ids of the affiliates bound to the post at that time
+- version: version of the post
+- author_id: who saved the post, empty if unknown
+- content_html: the content rendered as HTML, cached once the post is fetched by `?format=html`
+- created_at
+*/
+type PostRevision struct {
+	UintId
+	PostId       uint      `gorm:"index;not null" json:"post_id"`
+	Title        string    `gorm:"size:100;not null" json:"title"`
+	Subtitle     string    `gorm:"size:100" json:"subtitle,omitempty"`
+	Content      string    `gorm:"text;not null" json:"content"`
+	Date         time.Time `gorm:"not null" json:"date"`
+	TagIds       UintList  `gorm:"type:jsonb;not null" json:"tag_ids"`
+	AffiliateIds UintList  `gorm:"type:jsonb;not null" json:"affiliate_ids"`
+	Version      uint      `json:"version"`
+	AuthorId     *uint     `json:"author_id,omitempty"`
+	ContentHtml  string    `gorm:"text;not null;default:''" json:"-"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Take a snapshot of a post, which is supposed to be loaded with its tags and affiliates
+func NewPostRevision(post Post) PostRevision {
+	tagIds := UintList{}
+	for _, t := range post.Tags {
+		tagIds = append(tagIds, t.Id)
+	}
+	affiliateIds := UintList{}
+	for _, a := range post.Affiliates {
+		affiliateIds = append(affiliateIds, a.Id)
+	}
+
+	return PostRevision{
+		PostId:       post.Id,
+		Title:        post.Title,
+		Subtitle:     post.Subtitle,
+		Content:      post.Content,
+		Date:         post.Date,
+		TagIds:       tagIds,
+		AffiliateIds: affiliateIds,
+		Version:      post.Version,
+	}
+}
+
+// A list of ids stored as a json array
+type UintList []uint
+
+func (l UintList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]uint(l))
+	return string(data), err
+}
+
+func (l *UintList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*l = UintList{}
+		return nil
+	default:
+		return fmt.Errorf("can't scan %T into UintList", value)
+	}
+
+	return json.Unmarshal(data, (*[]uint)(l))
+}
+
+// A page of revisions, newest first
+type RevisionPage struct {
+	Items []PostRevision `json:"items"`
+	Total int64          `json:"total"`
+	Page  int            `json:"page"`
+	Size  int            `json:"size"`
+}
+
+type DiffOp string
+
+const (
+	DIFF_EQUAL  DiffOp = "="
+	DIFF_INSERT DiffOp = "+"
+	DIFF_DELETE DiffOp = "-"
+)
+
+// a line of a diff, which is kept, inserted or deleted
+type DiffLine struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+/*
+RevisionDiff
+
+Differences between two revisions of the same post.
+Title, subtitle and content are diffed line by line, tags and affiliates by ids.
+*/
+type RevisionDiff struct {
+	PostId            uint       `json:"post_id"`
+	From              uint       `json:"from"`
+	To                uint       `json:"to"`
+	Title             []DiffLine `json:"title"`
+	Subtitle          []DiffLine `json:"subtitle"`
+	Content           []DiffLine `json:"content"`
+	DateFrom          time.Time  `json:"date_from"`
+	DateTo            time.Time  `json:"date_to"`
+	TagsAdded         []uint     `json:"tags_added"`
+	TagsRemoved       []uint     `json:"tags_removed"`
+	AffiliatesAdded   []uint     `json:"affiliates_added"`
+	AffiliatesRemoved []uint     `json:"affiliates_removed"`
+}
@@ -0,0 +1,95 @@
+package entity
+
+import "time"
+
+/*
+Share
+
+A public read-only link of a post, which can be opened without an account.
+Only the sha256 hash of the token is stored, the link is only known by whom it is given to.
+
+- id
+- post_id: many-to-one relationship
+- owner_id: the owner of the post
+- token_hash: hex encoded sha256 of the token
+- password_hash: bcrypt hash of the password, empty if not protected
+- protected: whether a password is required
+- expires_at: never expires if empty
+- revoked_at
+- created_at
+*/
+type Share struct {
+	UintId
+	PostId       uint       `gorm:"index;not null" json:"post_id"`
+	OwnerId      uint       `gorm:"index;not null" json:"owner_id"`
+	TokenHash    string     `gorm:"size:64;not null;unique" json:"-"`
+	PasswordHash string     `gorm:"size:100" json:"-"`
+	Protected    bool       `gorm:"not null;default:false" json:"protected"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Whether the link can be opened at the given time
+func (s Share) Active(now time.Time) bool {
+	return s.RevokedAt == nil && (s.ExpiresAt == nil || s.ExpiresAt.After(now))
+}
+
+// a share link to be created, request from frontend
+type ShareRequest struct {
+	PostId uint `json:"post_id" binding:"required"`
+	// optional, visitors give it by HTTP basic auth
+	Password  string     `json:"password,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// a share link just created, the token is only responded once
+type ShareLink struct {
+	Share
+	Token string `json:"token"`
+}
+
+/*
+SharedPost
+
+A post as seen by visitors of a share link, without anything internal.
+*/
+type SharedPost struct {
+	Title       string      `json:"title"`
+	Subtitle    string      `json:"subtitle,omitempty"`
+	Content     string      `json:"content"`
+	ContentHtml string      `json:"content_html,omitempty"`
+	Date        time.Time   `json:"date"`
+	Tags        []string    `json:"tags"`
+	Affiliates  []Affiliate `json:"affiliates"`
+	ExpiresAt   *time.Time  `json:"expires_at,omitempty"`
+}
+
+// The public view of a post, which is supposed to be loaded with its tags and affiliates
+func NewSharedPost(post Post, share Share) SharedPost {
+	tags := []string{}
+	for _, t := range post.Tags {
+		tags = append(tags, t.Name)
+	}
+	affiliates := []Affiliate{}
+	for _, a := range post.Affiliates {
+		affiliates = append(affiliates, Affiliate{
+			UintId:      a.UintId,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Checksum:    a.Checksum,
+		})
+	}
+
+	return SharedPost{
+		Title:       post.Title,
+		Subtitle:    post.Subtitle,
+		Content:     post.Content,
+		ContentHtml: post.ContentHtml,
+		Date:        post.Date,
+		Tags:        tags,
+		Affiliates:  affiliates,
+		ExpiresAt:   share.ExpiresAt,
+	}
+}
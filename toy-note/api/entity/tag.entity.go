@@ -4,20 +4,29 @@ package entity
 Tag
 
 Various tags for notes, which later on can be used as search criteria.
+Tags can be nested, e.g. `go` in `lang`, so that searching by a tag optionally finds
+the posts bound to the tags nested in it as well.
 
-- id
-- name
-- description
-- color
-- posts
-- created_at
-- updated_at
+  - id
+  - name: unique for each owner
+  - description
+  - color
+  - parent_id: the tag it is nested in, empty at the top. When a tag is updated, an empty
+    parent keeps the current one, and 0 moves the tag to the top
+  - posts
+  - version: incremented on every update, see `ConflictError`
+  - owner_id: the user who owns the tag
+  - created_at
+  - updated_at
 */
 type Tag struct {
 	UintId
-	Name        string `gorm:"size:100;not null;unique" json:"name"`
+	Name        string `gorm:"size:100;not null;uniqueIndex:idx_tags_owner_name,priority:2" json:"name"`
 	Description string `gorm:"size:100" json:"description,omitempty"`
 	Color       string `gorm:"size:100" json:"color,omitempty"`
+	ParentId    *uint  `gorm:"index" json:"parent_id,omitempty"`
 	Posts       []Post `gorm:"many2many:posts_tags;constraint:OnDelete:SET NULL;" json:"posts"`
+	Version     uint   `gorm:"not null;default:1" json:"version,omitempty"`
+	OwnerId     uint   `gorm:"not null;default:0;uniqueIndex:idx_tags_owner_name,priority:1" json:"owner_id,omitempty"`
 	Dates
 }
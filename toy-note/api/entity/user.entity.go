@@ -0,0 +1,99 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+)
+
+// what a user is permitted to do, each role is granted everything of the roles below it
+type Role string
+
+const (
+	// read own notes
+	ROLE_READER Role = "reader"
+	// read and write own notes
+	ROLE_EDITOR Role = "editor"
+	// manage users and the affiliates of all users
+	ROLE_ADMIN Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	ROLE_READER: 1,
+	ROLE_EDITOR: 2,
+	ROLE_ADMIN:  3,
+}
+
+func ParseRole(s string) (Role, error) {
+	if _, ok := roleRanks[Role(s)]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return Role(s), nil
+}
+
+// Whether the role is granted everything of the required role
+func (r Role) Includes(required Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[required]
+}
+
+/*
+User
+
+An account of the API, every tag, post and affiliate is owned by a user.
+
+- id
+- name: unique
+- password_hash: bcrypt hash of the password, never responded
+- role: `reader`, `editor` (default) or `admin`
+- created_at
+- updated_at
+*/
+type User struct {
+	UintId
+	Name         string `gorm:"size:100;not null;unique" json:"name"`
+	PasswordHash string `gorm:"size:100;not null" json:"-"`
+	Role         Role   `gorm:"size:20;not null;default:editor" json:"role"`
+	Dates
+}
+
+/*
+RefreshToken
+
+A long-lived token which can be exchanged for a new access token once.
+Only the sha256 hash of the token is stored, the token itself is only known by the client.
+
+- id
+- user_id: many-to-one relationship
+- token_hash: hex encoded sha256 of the token
+- expires_at
+- revoked_at: set once the token is used or revoked
+- created_at
+*/
+type RefreshToken struct {
+	UintId
+	UserId    uint      `gorm:"index;not null"`
+	TokenHash string    `gorm:"size:64;not null;unique"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// name and password, request from frontend
+type Credentials struct {
+	Name     string `json:"name" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// response to frontend once logged in or refreshed
+type TokenPair struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// seconds before the access token expires
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// a refresh token to be exchanged or revoked, request from frontend
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
@@ -0,0 +1,56 @@
+package persistence
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"toy-note/api/entity"
+)
+
+// BlobStore
+//
+// A storage for affiliate files. The id returned by `UploadFile` is recorded as
+// `entity.Affiliate.ObjectId`, and it is the only thing needed for finding the file later.
+//
+// Implementations:
+// - MongoRepository: GridFS
+// - FsRepository: local filesystem
+type BlobStore interface {
+	// Upload a file and return its metadata, the reader is consumed as a stream.
+	// contentType is kept along with the file if the store supports it.
+	UploadFile(reader io.Reader, filename, contentType string) (entity.FileMeta, error)
+
+	// Open a file by its id, filename is only used to fill the `entity.FileObject`.
+	// The content is a seekable stream, and the caller is responsible for closing it.
+	DownloadFile(filename, id string) (entity.FileObject, error)
+
+	// Delete files by their ids
+	DeleteFiles(ids []string) error
+}
+
+// digestReader records the size and the sha256 of everything read through it
+type digestReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+func newDigestReader(reader io.Reader) *digestReader {
+	return &digestReader{
+		reader: reader,
+		hash:   sha256.New(),
+	}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	d.hash.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+// hex encoded sha256 of the content read so far
+func (d *digestReader) checksum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
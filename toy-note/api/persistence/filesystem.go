@@ -0,0 +1,161 @@
+package persistence
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"toy-note/api/entity"
+	"toy-note/logger"
+
+	"go.uber.org/zap"
+)
+
+// A FsRepository stores files in a local directory, which can be a mounted volume.
+//
+// Files are content-addressed: the id of a file is the hex encoded sha256 of its content,
+// and the file is stored at `<root>/objects/<id[0:2]>/<id[2:4]>/<id>`. Uploading the same
+// content twice results in the same id and a single file on disk.
+//
+// Uploads are written to `<root>/tmp` first, then renamed to their final location, so
+// that a partially written file can never be read.
+type FsRepository struct {
+	logger *zap.SugaredLogger
+	root   string
+}
+
+type FsConn struct {
+	Root string
+}
+
+const (
+	fsObjectsDir = "objects"
+	fsTmpDir     = "tmp"
+)
+
+// constructor
+func NewFsRepository(logger *logger.ToyNoteLogger, conn FsConn) (FsRepository, error) {
+	slog := logger.NewSugar("FsRepository")
+	slog.Debug(fmt.Sprintf("Opening file storage: %v", conn.Root))
+
+	root, err := filepath.Abs(conn.Root)
+	if err != nil {
+		return FsRepository{}, err
+	}
+
+	for _, dir := range []string{fsObjectsDir, fsTmpDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			return FsRepository{}, err
+		}
+	}
+
+	slog.Debug("Opened file storage")
+
+	return FsRepository{
+		logger: slog,
+		root:   root,
+	}, nil
+}
+
+// Make sure the FsRepository implements the BlobStore interface
+var _ BlobStore = (*FsRepository)(nil)
+
+// a valid id is a hex encoded sha256, which also prevents path traversal
+func (r *FsRepository) objectPath(id string) (string, error) {
+	if b, err := hex.DecodeString(id); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid file id %q", id)
+	}
+
+	return filepath.Join(r.root, fsObjectsDir, id[0:2], id[2:4], id), nil
+}
+
+// Upload file to the local filesystem.
+// The object id is the sha256 of the content, which is supposed to be stored in PG.
+// Content type is not kept, since the same content can be uploaded under different names.
+func (r *FsRepository) UploadFile(reader io.Reader, filename, contentType string) (entity.FileMeta, error) {
+	tmp, err := ioutil.TempFile(filepath.Join(r.root, fsTmpDir), "upload-")
+	if err != nil {
+		return entity.FileMeta{}, err
+	}
+	// no-op once the temp file has been renamed
+	defer os.Remove(tmp.Name())
+
+	digest := newDigestReader(reader)
+	if _, err := io.Copy(tmp, digest); err != nil {
+		tmp.Close()
+		return entity.FileMeta{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return entity.FileMeta{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return entity.FileMeta{}, err
+	}
+
+	id := digest.checksum()
+	path, err := r.objectPath(id)
+	if err != nil {
+		return entity.FileMeta{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return entity.FileMeta{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return entity.FileMeta{}, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("File %s upload completed, id: %v, size: %v", filename, id, digest.size))
+
+	return entity.FileMeta{
+		ObjectId: id,
+		Size:     digest.size,
+		Checksum: id,
+	}, nil
+}
+
+// Open a file from the local filesystem, according to the id
+func (r *FsRepository) DownloadFile(filename, id string) (entity.FileObject, error) {
+	path, err := r.objectPath(id)
+	if err != nil {
+		return entity.FileObject{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return entity.FileObject{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return entity.FileObject{}, err
+	}
+
+	return entity.FileObject{
+		Filename: filename,
+		Content:  file,
+		Size:     info.Size(),
+	}, nil
+}
+
+// Delete files from the local filesystem, according to the ids.
+// Files already gone are ignored.
+func (r *FsRepository) DeleteFiles(ids []string) error {
+	for _, id := range ids {
+		path, err := r.objectPath(id)
+		if err != nil {
+			return err
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package persistence
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"toy-note/logger"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newFsRepo(t *testing.T) FsRepository {
+	if err := logger.Init("debug", logPath, true); err != nil {
+		panic(err)
+	}
+
+	r, err := NewFsRepository(logger.TNLogger, FsConn{Root: t.TempDir()})
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestFsUploadAndDownloadFile(t *testing.T) {
+	r := newFsRepo(t)
+	content := []byte("hello filesystem")
+
+	meta, err := r.UploadFile(bytes.NewReader(content), "hello.txt", "text/plain")
+	require.NoError(t, err)
+	require.Len(t, meta.ObjectId, 64)
+	require.Equal(t, meta.ObjectId, meta.Checksum)
+	require.Equal(t, int64(len(content)), meta.Size)
+
+	fo, err := r.DownloadFile("hello.txt", meta.ObjectId)
+	require.NoError(t, err)
+	require.Equal(t, "hello.txt", fo.Filename)
+	require.Equal(t, int64(len(content)), fo.Size)
+	data, err := ioutil.ReadAll(fo.Content)
+	require.NoError(t, err)
+	require.NoError(t, fo.Content.Close())
+	require.Equal(t, content, data)
+
+	// temp files are cleaned up after being renamed
+	tmp, err := ioutil.ReadDir(filepath.Join(r.root, fsTmpDir))
+	require.NoError(t, err)
+	require.Empty(t, tmp)
+}
+
+func TestFsContentAddressed(t *testing.T) {
+	r := newFsRepo(t)
+
+	meta1, err := r.UploadFile(bytes.NewReader([]byte("same")), "a.txt", "text/plain")
+	require.NoError(t, err)
+	meta2, err := r.UploadFile(bytes.NewReader([]byte("same")), "b.txt", "text/plain")
+	require.NoError(t, err)
+	meta3, err := r.UploadFile(bytes.NewReader([]byte("different")), "c.txt", "text/plain")
+	require.NoError(t, err)
+
+	id := meta1.ObjectId
+	require.Equal(t, id, meta2.ObjectId)
+	require.NotEqual(t, id, meta3.ObjectId)
+	require.FileExists(t, filepath.Join(r.root, fsObjectsDir, id[0:2], id[2:4], id))
+}
+
+func TestFsDeleteFiles(t *testing.T) {
+	r := newFsRepo(t)
+
+	meta, err := r.UploadFile(bytes.NewReader([]byte("to be deleted")), "a.txt", "text/plain")
+	require.NoError(t, err)
+
+	require.NoError(t, r.DeleteFiles([]string{meta.ObjectId}))
+	_, err = r.DownloadFile("a.txt", meta.ObjectId)
+	require.Error(t, err)
+
+	// deleting a missing file is not an error
+	require.NoError(t, r.DeleteFiles([]string{meta.ObjectId}))
+}
+
+func TestFsInvalidId(t *testing.T) {
+	r := newFsRepo(t)
+
+	_, err := r.DownloadFile("passwd", "../../../etc/passwd")
+	require.Error(t, err)
+	require.Error(t, r.DeleteFiles([]string{"not-a-hash"}))
+}
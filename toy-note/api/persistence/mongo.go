@@ -1,11 +1,10 @@
 package persistence
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"time"
 
 	"toy-note/api/entity"
@@ -56,43 +55,61 @@ func NewMongoRepository(ctx context.Context, logger *logger.ToyNoteLogger, conn
 	}, nil
 }
 
-// Make sure the MongoRepository implements the Repository interface
-type mongoRepositoryInterface interface {
-	UploadFile(reader io.Reader, filename string) (string, error)
-	DownloadFile(filename, id string) (entity.FileObject, error)
-	DeleteFiles(ids []string) error
-}
-
-var _ mongoRepositoryInterface = (*MongoRepository)(nil)
+// Make sure the MongoRepository implements the BlobStore interface
+var _ BlobStore = (*MongoRepository)(nil)
 
-// Upload file to MongoDB
-// The result string is the object id from the MongoDB, which is supposed to be stored in PG.
-func (r *MongoRepository) UploadFile(reader io.Reader, filename string) (string, error) {
-	data, err := ioutil.ReadAll(reader)
+// Upload file to MongoDB, the reader is copied into the GridFS upload stream chunk by chunk.
+// The content type and the sha256 of the content are saved in the GridFS metadata.
+// The object id from the MongoDB is supposed to be stored in PG.
+func (r *MongoRepository) UploadFile(reader io.Reader, filename, contentType string) (entity.FileMeta, error) {
+	bucket, err := gridfs.NewBucket(r.db)
 	if err != nil {
-		return "", err
+		return entity.FileMeta{}, err
 	}
 
-	bucket, err := gridfs.NewBucket(r.db)
+	opts := options.GridFSUpload().SetMetadata(bson.M{"contentType": contentType})
+	uploadStream, err := bucket.OpenUploadStream(filename, opts)
 	if err != nil {
-		return "", err
+		return entity.FileMeta{}, err
 	}
 
-	uploadStream, err := bucket.OpenUploadStream(filename)
-	if err != nil {
-		return "", err
+	digest := newDigestReader(reader)
+	if _, err := io.Copy(uploadStream, digest); err != nil {
+		// remove chunks that have already been written
+		uploadStream.Abort()
+		return entity.FileMeta{}, err
+	}
+
+	// the file document is only written on close
+	if err := uploadStream.Close(); err != nil {
+		return entity.FileMeta{}, err
 	}
-	defer uploadStream.Close()
 
-	_, err = uploadStream.Write(data)
+	oid := uploadStream.FileID.(primitive.ObjectID)
+	checksum := digest.checksum()
+
+	// the checksum is only known after the whole content has been read
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = r.db.Collection(CollectionName).UpdateOne(
+		ctx,
+		bson.M{"_id": oid},
+		bson.M{"$set": bson.M{"metadata.sha256": checksum}},
+	)
 	if err != nil {
-		return "", err
+		return entity.FileMeta{}, err
 	}
 
-	return uploadStream.FileID.(primitive.ObjectID).Hex(), nil
+	r.logger.Debug(fmt.Sprintf("File %s upload completed, size: %v", filename, digest.size))
+
+	return entity.FileMeta{
+		ObjectId: oid.Hex(),
+		Size:     digest.size,
+		Checksum: checksum,
+	}, nil
 }
 
-// Download file from MongoDB, according to the id
+// Open a seekable download stream from MongoDB, according to the id
 func (r *MongoRepository) DownloadFile(filename, id string) (entity.FileObject, error) {
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -104,22 +121,25 @@ func (r *MongoRepository) DownloadFile(filename, id string) (entity.FileObject,
 		return entity.FileObject{}, err
 	}
 
-	var buf bytes.Buffer
-	size, err := bucket.DownloadToStream(oid, &buf)
+	downloadStream, err := bucket.OpenDownloadStream(oid)
 	if err != nil {
 		return entity.FileObject{}, err
 	}
 
-	r.logger.Debug(fmt.Sprintf("File download completed, size: %v", size))
-
 	return entity.FileObject{
 		Filename: filename,
-		Content:  buf.Bytes(),
-		Size:     size,
+		Content: &gridfsFile{
+			bucket: bucket,
+			oid:    oid,
+			size:   downloadStream.GetFile().Length,
+			stream: downloadStream,
+		},
+		Size: downloadStream.GetFile().Length,
 	}, nil
 }
 
-// Delete files from MongoDB, according to the ids
+// Delete files from MongoDB along with their chunks, according to the ids. A file which is
+// already gone is skipped, its orphaned chunks are still deleted
 func (r *MongoRepository) DeleteFiles(ids []string) error {
 	var oids []primitive.ObjectID
 	for _, id := range ids {
@@ -131,12 +151,94 @@ func (r *MongoRepository) DeleteFiles(ids []string) error {
 		oids = append(oids, oid)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if _, err := r.db.Collection(CollectionName).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": oids}}); err != nil {
+	bucket, err := gridfs.NewBucket(r.db)
+	if err != nil {
 		return err
 	}
+	for _, oid := range oids {
+		if err := bucket.Delete(oid); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
 
 	return nil
 }
+
+// gridfsFile makes a GridFS download stream seekable, which is required by ranged requests.
+//
+// A GridFS download stream can only move forward, so seeking only records the new offset.
+// The next read skips forward on the current stream, or reopens the stream if it has to
+// move backward.
+type gridfsFile struct {
+	bucket *gridfs.Bucket
+	oid    primitive.ObjectID
+	size   int64
+	// offset seen by the caller
+	offset int64
+	// position of the underlying stream
+	pos    int64
+	stream *gridfs.DownloadStream
+}
+
+func (f *gridfsFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.stream != nil && f.pos > f.offset {
+		f.stream.Close()
+		f.stream = nil
+	}
+	if f.stream == nil {
+		stream, err := f.bucket.OpenDownloadStream(f.oid)
+		if err != nil {
+			return 0, err
+		}
+		f.stream = stream
+		f.pos = 0
+	}
+	if f.pos < f.offset {
+		skipped, err := f.stream.Skip(f.offset - f.pos)
+		f.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.stream.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+
+	return n, err
+}
+
+func (f *gridfsFile) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position %d", abs)
+	}
+	f.offset = abs
+
+	return abs, nil
+}
+
+func (f *gridfsFile) Close() error {
+	if f.stream == nil {
+		return nil
+	}
+
+	err := f.stream.Close()
+	f.stream = nil
+
+	return err
+}
@@ -2,12 +2,16 @@ package persistence
 
 import (
 	"context"
+	"io"
 	"os"
+	"strings"
 	"testing"
 	"time"
 	"toy-note/logger"
 
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var mongoConn = MongoConn{
@@ -45,9 +49,64 @@ func TestUploadAndDownloadFile(t *testing.T) {
 	reader, err := os.Open("test.log")
 	require.NoError(t, err)
 
-	id, err := r.UploadFile(reader, filenameUsedForSaving)
+	meta, err := r.UploadFile(reader, filenameUsedForSaving, "text/plain")
 	require.NoError(t, err)
+	require.NotEmpty(t, meta.Checksum)
 
-	_, err = r.DownloadFile(filenameUsedForSaving, id)
+	_, err = r.DownloadFile(filenameUsedForSaving, meta.ObjectId)
 	require.NoError(t, err)
 }
+
+func TestDownloadFileSeek(t *testing.T) {
+	r, err := newMongoRepo()
+	require.NoError(t, err)
+
+	meta, err := r.UploadFile(strings.NewReader("0123456789"), "digits.txt", "text/plain")
+	require.NoError(t, err)
+
+	fo, err := r.DownloadFile("digits.txt", meta.ObjectId)
+	require.NoError(t, err)
+	defer fo.Content.Close()
+
+	// seek forward
+	_, err = fo.Content.Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(fo.Content, buf)
+	require.NoError(t, err)
+	require.Equal(t, "67", string(buf))
+
+	// seek backward reopens the stream
+	_, err = fo.Content.Seek(1, io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadFull(fo.Content, buf)
+	require.NoError(t, err)
+	require.Equal(t, "12", string(buf))
+
+	size, err := fo.Content.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), size)
+}
+
+func TestDeleteFilesWithChunks(t *testing.T) {
+	r, err := newMongoRepo()
+	require.NoError(t, err)
+
+	meta, err := r.UploadFile(strings.NewReader("purged"), "purged.txt", "text/plain")
+	require.NoError(t, err)
+	oid, err := primitive.ObjectIDFromHex(meta.ObjectId)
+	require.NoError(t, err)
+
+	require.NoError(t, r.DeleteFiles([]string{meta.ObjectId}))
+	// deleting again is not an error
+	require.NoError(t, r.DeleteFiles([]string{meta.ObjectId}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	files, err := r.db.Collection(CollectionName).CountDocuments(ctx, bson.M{"_id": oid})
+	require.NoError(t, err)
+	require.Zero(t, files)
+	chunks, err := r.db.Collection("fs.chunks").CountDocuments(ctx, bson.M{"files_id": oid})
+	require.NoError(t, err)
+	require.Zero(t, chunks)
+}
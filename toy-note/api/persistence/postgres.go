@@ -1,8 +1,12 @@
 package persistence
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 	"toy-note/api/entity"
+	"toy-note/api/util"
 	"toy-note/logger"
 
 	"go.uber.org/zap"
@@ -15,6 +19,10 @@ import (
 type PgRepository struct {
 	logger *zap.SugaredLogger
 	db     *gorm.DB
+	// the user which tags, posts and affiliates are scoped to, 0 means not scoped
+	owner uint
+	// the notebook which listings and searches of posts are scoped to, 0 means not scoped
+	notebook entity.NotebookScope
 }
 
 type PgConn struct {
@@ -55,15 +63,101 @@ func NewPgRepository(logger *logger.ToyNoteLogger, conn PgConn) (PgRepository, e
 	}, nil
 }
 
+// A copy of the repository scoped to a user, see `owned`
+func (r *PgRepository) ForOwner(userId uint) *PgRepository {
+	scoped := *r
+	scoped.owner = userId
+	return &scoped
+}
+
+// A copy of the repository whose listings and searches of posts are scoped to a notebook,
+// see `inNotebook`
+func (r *PgRepository) InNotebook(scope entity.NotebookScope) *PgRepository {
+	scoped := *r
+	scoped.notebook = scope
+	return &scoped
+}
+
 // Auto Migrate. Create tables if not exists
 func (r *PgRepository) AutoMigrate() error {
-	err := r.db.AutoMigrate(&entity.Tag{}, &entity.Affiliate{}, &entity.Post{})
+	err := r.db.AutoMigrate(
+		&entity.User{},
+		&entity.RefreshToken{},
+		&entity.Tag{},
+		&entity.Affiliate{},
+		&entity.Post{},
+		&entity.PostRevision{},
+		&entity.Share{},
+		&entity.ApiKey{},
+		&entity.PostLink{},
+		&entity.Notebook{},
+	)
 	r.logger.Debug(fmt.Sprintf("AutoMigrate: %v", err))
-	return err
+	if err != nil {
+		return err
+	}
+
+	// tag names used to be unique globally, now they are unique for each owner
+	if r.db.Migrator().HasConstraint(&entity.Tag{}, "tags_name_key") {
+		if err := r.db.Migrator().DropConstraint(&entity.Tag{}, "tags_name_key"); err != nil {
+			return err
+		}
+	}
+
+	// full-text search index, which is not supported by gorm tags
+	err = r.db.Exec(createSearchIndex).Error
+	r.logger.Debug(fmt.Sprintf("AutoMigrate search index: %v", err))
+	if err != nil {
+		return err
+	}
+
+	// rows created before users existed are owned by nobody, and visible to nobody.
+	// They are given to the first admin, or to the first user once registered, see `CreateUser`
+	var admin entity.User
+	err = r.db.Where("role = ?", entity.ROLE_ADMIN).Order("id").Limit(1).Find(&admin).Error
+	if err != nil || admin.Id == 0 {
+		return err
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return r.claimUnowned(tx, admin.Id)
+	})
+}
+
+// tables whose rows are owned by a user, see `owned`
+var ownedTables = []string{"notebooks", "tags", "posts", "affiliates"}
+
+// Give the rows owned by nobody to a user, trashed posts included. A tag whose name is
+// already taken by the user is left as it is, since names are unique for each owner
+func (r *PgRepository) claimUnowned(tx *gorm.DB, userId uint) error {
+	for _, table := range ownedTables {
+		sql := fmt.Sprintf("UPDATE %s SET owner_id = ? WHERE owner_id = 0", table)
+		args := []interface{}{userId}
+		if table == "tags" {
+			sql += " AND name NOT IN (SELECT name FROM tags WHERE owner_id = ?)"
+			args = append(args, userId)
+		}
+
+		result := tx.Exec(sql, args...)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			r.logger.Info(fmt.Sprintf("claimUnowned: %d %s given to user %d", result.RowsAffected, table, userId))
+		}
+	}
+
+	var left int64
+	if err := tx.Model(&entity.Tag{}).Where("owner_id = 0").Count(&left).Error; err != nil {
+		return err
+	}
+	if left > 0 {
+		r.logger.Warn(fmt.Sprintf("claimUnowned: %d tags are left unowned, their names are taken by user %d", left, userId))
+	}
+	return nil
 }
 
 func (r *PgRepository) TruncateAll() error {
-	err := r.db.Exec("TRUNCATE TABLE posts, tags, affiliates RESTART IDENTITY CASCADE;").Error
+	err := r.db.Exec("TRUNCATE TABLE posts, tags, affiliates, post_revisions, shares, users, refresh_tokens, api_keys, post_links, notebooks RESTART IDENTITY CASCADE;").Error
 	r.logger.Debug(fmt.Sprintf("TruncateAll: %v", err))
 	return err
 }
@@ -82,16 +176,25 @@ type pgRepositoryInterface interface {
 	// Update an existing tag, based on id
 	UpdateTag(entity.Tag) (entity.Tag, error)
 
-	// Delete an existing tag by id
+	// Delete an existing tag by id, the tags nested in it are moved to its parent
 	DeleteTag(uint) error
 
-	// Get posts by pagination, ordered by created_at desc
-	GetPosts(entity.Pagination) ([]entity.Post, error)
+	// Re-point all posts bound to the source tags to the target tag, then delete the sources.
+	// The tags nested in the sources are moved to the target
+	MergeTags([]uint, uint) (entity.Tag, error)
+
+	// Delete tags one by one in one transaction, the same as `DeleteTag`.
+	// A tag not found is reported as failed
+	BulkDeleteTags([]uint) (entity.BulkReport, error)
+
+	// Get posts by pagination, ordered by date and id desc
+	GetPosts(entity.Pagination) (entity.PostPage, error)
 
 	// Get a post by id, including tags and affiliates
 	GetPost(uint) (entity.Post, error)
 
-	// Create a new post, and associate it with existing tags and affiliates
+	// Create a new post, and associate it with existing tags and affiliates.
+	// The first revision of the post is recorded, along with its links.
 	CreatePost(entity.Post) (entity.Post, error)
 
 	// Update an existing post, tags and affiliates are updated as well.
@@ -100,11 +203,59 @@ type pgRepositoryInterface interface {
 	// Any tags or affiliates was previously given and not given by now will be
 	// unbounded from the post. They will not be deleted, so later if we need
 	// them to be appeared in the post, we can still bind them to the post.
+	// A new revision of the post is recorded, and its links are replaced.
 	UpdatePost(entity.Post) (entity.Post, error)
 
-	// Delete an existing post, disassociate it with all tags and affiliates
+	// Change an existing post by the changes only, tags and affiliates are bound and
+	// unbound by ids, the others stay bound. A new revision of the post is recorded, and
+	// its links are replaced.
+	PatchPost(uint, entity.PostChanges) (entity.Post, error)
+
+	// Move an existing post to trash (soft delete). Tags and affiliates are kept bound,
+	// so that the post can be restored as it was
 	DeletePost(uint) error
 
+	// Add tags to and remove tags from posts in one transaction, a new revision of every
+	// post found is recorded. A post not found is reported as failed
+	BulkTagPosts(entity.BulkTagging) (entity.BulkReport, error)
+
+	// Move posts to trash in one transaction. A post not found is reported as failed
+	BulkDeletePosts([]uint) (entity.BulkReport, error)
+
+	// Set the date of posts in one transaction, a new revision of every post found is
+	// recorded. A post not found is reported as failed
+	BulkDatePosts(entity.BulkDating) (entity.BulkReport, error)
+
+	// Get trashed posts by pagination
+	GetTrash(entity.Pagination) (entity.PostPage, error)
+
+	// Restore a trashed post, along with its tags and affiliates
+	RestorePost(uint) error
+
+	// Permanently delete a trashed post and its affiliates, disassociate it with all tags.
+	// Object ids of the deleted affiliates are returned, so that their files can be removed
+	PurgePost(uint) ([]string, error)
+
+	// Find ids of posts trashed before the given time
+	GetTrashedBefore(time.Time) ([]uint, error)
+
+	// Get revisions of a post by pagination, newest first
+	GetRevisions(uint, entity.Pagination) (entity.RevisionPage, error)
+
+	// Get a revision by id
+	GetRevision(uint) (entity.PostRevision, error)
+
+	// Restore a post to a revision, which is recorded as a new revision along with its links.
+	// Tags and affiliates deleted or bound to another post since then are skipped.
+	RestoreRevision(uint) (entity.Post, error)
+
+	// Get the cached HTML of posts at their versions, by post id. Posts whose revision of the
+	// version has not been rendered yet are absent
+	GetRenderedContents([]entity.Post) (map[uint]string, error)
+
+	// Cache the HTML of a post at a version, in the newest revision of the version
+	SaveRenderedContent(postId, version uint, html string) error
+
 	// Create/Update a new affiliate, notice that the affiliate don't need to be
 	// associated to any post.
 	// This method should not be exposed to the user.
@@ -117,30 +268,201 @@ type pgRepositoryInterface interface {
 	GetUnownedAffiliatesByIds([]uint) ([]entity.Affiliate, error)
 
 	// Find all unowned affiliates by pagination
-	GetUnownedAffiliates(entity.Pagination) ([]entity.Affiliate, error)
+	GetUnownedAffiliates(entity.Pagination) (entity.AffiliatePage, error)
+
+	// Bind an affiliate to a post by ids, both must be owned by the same user.
+	// Only the reference of the affiliate is updated, the post is kept as it is
+	RebindAffiliate(affiliateId, postId uint) error
 
 	// Delete an unowned affiliate
 	DeleteUnownedAffiliates([]uint) error
 
-	// Find posts by tags
-	GetPostsByTags([]uint, entity.Pagination) ([]entity.Post, error)
+	// Find object ids which are still referred by any affiliate
+	GetReferredObjectIds([]string) ([]string, error)
+
+	// Find posts by tags, optionally including the tags nested in them
+	GetPostsByTags([]uint, bool, entity.Pagination) (entity.PostPage, error)
 
 	// Find posts by title
-	GetPostsByTitle(string, entity.Pagination) ([]entity.Post, error)
+	GetPostsByTitle(string, entity.Pagination) (entity.PostPage, error)
 
 	// Find posts by time range
-	GetPostsByTimeRange(entity.TimeSearch, entity.Pagination) ([]entity.Post, error)
+	GetPostsByTimeRange(entity.TimeSearch, entity.Pagination) (entity.PostPage, error)
+
+	// Full-text search over title, subtitle and content, ordered by rank
+	SearchPosts(string, entity.Pagination) (entity.PostSearchPage, error)
+
+	// Find posts by a combination of tags, text and time range, in a single query
+	QueryPosts(entity.PostQuery) (entity.PostPage, error)
+
+	// Create a share link of a post, the post must be owned
+	CreateShare(entity.Share) (entity.Share, error)
+
+	// Get all share links of a post, newest first
+	GetShares(uint) ([]entity.Share, error)
+
+	// Revoke a share link by id, if it is not revoked yet
+	RevokeShare(uint) error
+
+	// Find a share link by the hash of its token, of any owner
+	GetShareByTokenHash(string) (entity.Share, error)
+
+	// Get all notebooks as trees, along with the numbers of posts in them
+	GetNotebookTree() ([]entity.NotebookNode, error)
+
+	// Get a notebook by id
+	GetNotebook(uint) (entity.Notebook, error)
+
+	// Create a notebook, the parent must be owned
+	CreateNotebook(entity.Notebook) (entity.Notebook, error)
+
+	// Update the name and the parent of a notebook, it can't be nested in itself
+	UpdateNotebook(entity.Notebook) (entity.Notebook, error)
+
+	// Delete a notebook. Its notebooks and posts, trashed posts included, are moved to the
+	// destination if given, otherwise a notebook which is not empty is not deleted
+	DeleteNotebook(uint, *entity.Destination) error
+
+	// Move a post to a notebook or the root, the post is not updated otherwise
+	MovePost(uint, entity.Destination) (entity.Post, error)
+
+	// Get the links of a post along with the posts they are resolved to, in order of
+	// appearance. Links to missing or trashed posts are broken
+	GetPostLinks(uint) ([]entity.LinkedPost, error)
+
+	// Get the links to a post from other posts which are not trashed, in order of creation
+	GetBacklinks(uint) ([]entity.LinkedPost, error)
+
+	// Create a new user, the name is unique. The first user is the admin whatever the role
+	// given, and is given the rows owned by nobody
+	CreateUser(entity.User) (entity.User, error)
+
+	// Find a user by id
+	GetUser(uint) (entity.User, error)
+
+	// Find a user by name
+	GetUserByName(string) (entity.User, error)
+
+	// Update the role of a user, and return the updated user
+	UpdateUserRole(uint, entity.Role) (entity.User, error)
+
+	// Save a refresh token
+	CreateRefreshToken(entity.RefreshToken) error
+
+	// Revoke a refresh token by its hash, if it is neither revoked nor expired.
+	// The revoked token is returned, so that only one caller can use it
+	UseRefreshToken(string) (entity.RefreshToken, error)
+
+	// Find a refresh token by its hash, no matter whether it is revoked or expired
+	GetRefreshToken(string) (entity.RefreshToken, error)
+
+	// Revoke all the refresh tokens of a user
+	RevokeRefreshTokens(uint) error
+
+	// Save an API key, the prefix is unique
+	CreateApiKey(entity.ApiKey) (entity.ApiKey, error)
+
+	// Get all API keys of a user, newest first
+	GetApiKeys(uint) ([]entity.ApiKey, error)
+
+	// Find an API key by its prefix
+	GetApiKeyByPrefix(string) (entity.ApiKey, error)
+
+	// Revoke an API key by its prefix, of the given user or any user if 0
+	RevokeApiKey(uint, string) error
+
+	// Record when an API key is used
+	TouchApiKey(uint, time.Time) error
 }
 
 var _ pgRepositoryInterface = (*PgRepository)(nil)
 
+// ============================================================================
+// Owner
+// ============================================================================
+
+// only rows owned by the user the repository is scoped to, see `ForOwner`.
+// The column is qualified by the current table, so that it still works with joins.
+func (r *PgRepository) owned(db *gorm.DB) *gorm.DB {
+	if r.owner == 0 {
+		return db
+	}
+	return db.Where(clause.Eq{
+		Column: clause.Column{Table: clause.CurrentTable, Name: "owner_id"},
+		Value:  r.owner,
+	})
+}
+
+// revisions of posts owned by the user, including trashed posts
+func (r *PgRepository) ownedRevisions(db *gorm.DB) *gorm.DB {
+	if r.owner == 0 {
+		return db
+	}
+	posts := r.db.Unscoped().Model(&entity.Post{}).Select("id").Where("owner_id = ?", r.owner)
+	return db.Where("post_id IN (?)", posts)
+}
+
+// Make sure tags and existing affiliates to be bound to a post are owned by the user.
+// New affiliates are owned by the user.
+func (r *PgRepository) checkAssociations(tx *gorm.DB, post *entity.Post) error {
+	for i := range post.Affiliates {
+		post.Affiliates[i].OwnerId = r.owner
+	}
+	// unlike tags and affiliates, there is no foreign key of the notebook
+	if post.NotebookId != nil {
+		if err := r.checkOwned(tx, &entity.Notebook{}, "notebook", map[uint]bool{*post.NotebookId: true}); err != nil {
+			return err
+		}
+	}
+	if r.owner == 0 {
+		return nil
+	}
+
+	tagIds := make(map[uint]bool)
+	for _, t := range post.Tags {
+		tagIds[t.Id] = true
+	}
+	if err := r.checkOwned(tx, &entity.Tag{}, "tag", tagIds); err != nil {
+		return err
+	}
+
+	affiliateIds := make(map[uint]bool)
+	for _, a := range post.Affiliates {
+		if a.Id != 0 {
+			affiliateIds[a.Id] = true
+		}
+	}
+	return r.checkOwned(tx, &entity.Affiliate{}, "affiliate", affiliateIds)
+}
+
+func (r *PgRepository) checkOwned(tx *gorm.DB, model interface{}, name string, ids map[uint]bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	list := make([]uint, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+
+	var n int64
+	if err := tx.Model(model).Scopes(r.owned).Where("id IN ?", list).Count(&n).Error; err != nil {
+		return err
+	}
+	if n != int64(len(list)) {
+		return fmt.Errorf("%s %v not found", name, list)
+	}
+
+	return nil
+}
+
 // ============================================================================
 // Tag
 // ============================================================================
 
 func (r *PgRepository) GetTags() ([]entity.Tag, error) {
 	var tags []entity.Tag
-	if err := r.db.Find(&tags).Error; err != nil {
+	if err := r.db.Scopes(r.owned).Order("id").Find(&tags).Error; err != nil {
 		return nil, err
 	}
 
@@ -149,33 +471,210 @@ func (r *PgRepository) GetTags() ([]entity.Tag, error) {
 
 func (r *PgRepository) GetTag(id uint) (entity.Tag, error) {
 	var tag entity.Tag
-	if err := r.db.First(&tag, id).Error; err != nil {
+	if err := r.db.Scopes(r.owned).First(&tag, id).Error; err != nil {
 		return tag, err
 	}
 
 	return tag, nil
 }
 
+// Make sure the parent of a tag is owned, and it is neither the tag itself nor nested in it,
+// which would make a cycle
+func (r *PgRepository) checkTagParent(tx *gorm.DB, id uint, parentId *uint) error {
+	for ancestor := parentId; ancestor != nil; {
+		if *ancestor == id {
+			return fmt.Errorf("tag %d can't be nested in itself", id)
+		}
+		var tag entity.Tag
+		if err := tx.Scopes(r.owned).First(&tag, *ancestor).Error; err != nil {
+			return fmt.Errorf("tag %d not found: %w", *ancestor, err)
+		}
+		ancestor = tag.ParentId
+	}
+
+	return nil
+}
+
 func (r *PgRepository) CreateTag(tag entity.Tag) (entity.Tag, error) {
-	if err := r.db.Create(&tag).Error; err != nil {
+	tag.Version = 1
+	tag.OwnerId = r.owner
+	if tag.ParentId != nil && *tag.ParentId == 0 {
+		tag.ParentId = nil
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := r.checkTagParent(tx, 0, tag.ParentId); err != nil {
+			return err
+		}
+		return tx.Create(&tag).Error
+	})
+	if err != nil {
 		return entity.Tag{}, err
 	}
+
 	return tag, nil
 }
 
 func (r *PgRepository) UpdateTag(tag entity.Tag) (entity.Tag, error) {
-	if err := r.db.Updates(&tag).Error; err != nil {
+	var saved entity.Tag
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// the row is locked until the transaction ends, so that concurrent updates
+		// can't both pass the version check
+		var current entity.Tag
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(r.owned).First(&current, tag.Id).Error
+		if err != nil {
+			return err
+		}
+		if tag.Version != 0 && tag.Version != current.Version {
+			return &entity.ConflictError{
+				Resource:       "tag",
+				Id:             tag.Id,
+				Version:        tag.Version,
+				Current:        current,
+				CurrentVersion: current.Version,
+			}
+		}
+		tag.Version = current.Version + 1
+		// a tag is never transferred to another user
+		tag.OwnerId = 0
+
+		// an empty parent keeps the current one, which `Updates` does as well.
+		// 0 moves the tag to the top, which must be updated explicitly
+		parentId := tag.ParentId
+		tag.ParentId = nil
+		if parentId != nil && *parentId == 0 {
+			if err := tx.Model(&current).Update("parent_id", nil).Error; err != nil {
+				return err
+			}
+		} else if parentId != nil {
+			if err := r.checkTagParent(tx, tag.Id, parentId); err != nil {
+				return err
+			}
+			tag.ParentId = parentId
+		}
+
+		if err := tx.Updates(&tag).Error; err != nil {
+			return err
+		}
+
+		return tx.First(&saved, tag.Id).Error
+	})
+	if err != nil {
 		return entity.Tag{}, err
 	}
-	return tag, nil
+
+	return saved, nil
 }
 
 func (r *PgRepository) DeleteTag(id uint) error {
-	result := r.db.Delete(entity.Tag{}, id)
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("tag %d not found", id)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var tag entity.Tag
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(r.owned).First(&tag, id).Error
+		if err != nil {
+			return fmt.Errorf("tag %d not found: %w", id, err)
+		}
+
+		// the tags nested in it are kept, one level up
+		err = tx.Model(&entity.Tag{}).
+			Where("parent_id = ?", id).
+			Update("parent_id", tag.ParentId).
+			Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&tag).Error
+	})
+}
+
+func (r *PgRepository) MergeTags(sourceIds []uint, targetId uint) (entity.Tag, error) {
+	var saved entity.Tag
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		sources := make(map[uint]bool)
+		for _, id := range sourceIds {
+			if id == targetId {
+				return fmt.Errorf("tag %d can't be merged into itself", id)
+			}
+			sources[id] = true
+		}
+
+		// the tags are locked, so that no post is bound to a source meanwhile
+		var tags []entity.Tag
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Scopes(r.owned).
+			Where("id IN ?", append([]uint{targetId}, sourceIds...)).
+			Find(&tags).
+			Error
+		if err != nil {
+			return err
+		}
+		found := make(map[uint]entity.Tag, len(tags))
+		for _, t := range tags {
+			found[t.Id] = t
+		}
+
+		target, ok := found[targetId]
+		if !ok {
+			return fmt.Errorf("tag %d not found", targetId)
+		}
+		for id := range sources {
+			source, ok := found[id]
+			if !ok {
+				return fmt.Errorf("tag %d not found", id)
+			}
+			if source.OwnerId != target.OwnerId {
+				return fmt.Errorf("tag %d and tag %d are owned by different users", id, targetId)
+			}
+		}
+
+		// otherwise the target would be nested in itself, once the tags nested in the
+		// sources are moved to it
+		for ancestor := target.ParentId; ancestor != nil; {
+			if sources[*ancestor] {
+				return fmt.Errorf("tag %d is nested in tag %d, which can't be merged into it", targetId, *ancestor)
+			}
+			var parent entity.Tag
+			if err := tx.First(&parent, *ancestor).Error; err != nil {
+				return err
+			}
+			ancestor = parent.ParentId
+		}
+
+		// a post bound to both a source and the target is bound to the target only once
+		err = tx.Exec(
+			"INSERT INTO posts_tags (post_id, tag_id) "+
+				"SELECT DISTINCT post_id, ? FROM posts_tags WHERE tag_id IN ? "+
+				"ON CONFLICT DO NOTHING",
+			targetId, sourceIds,
+		).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM posts_tags WHERE tag_id IN ?", sourceIds).Error; err != nil {
+			return err
+		}
+
+		err = tx.Model(&entity.Tag{}).
+			Where("parent_id IN ?", sourceIds).
+			Update("parent_id", targetId).
+			Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&entity.Tag{}, sourceIds).Error; err != nil {
+			return err
+		}
+
+		return tx.First(&saved, targetId).Error
+	})
+	if err != nil {
+		return entity.Tag{}, err
 	}
-	return result.Error
+
+	return saved, nil
 }
 
 // ============================================================================
@@ -188,40 +687,104 @@ func paginationToLimitOffset(pagination entity.Pagination) (int, int) {
 	return pagination.Size, offset
 }
 
+// columns of sort fields, posts are never sorted by user input directly
+var sortColumns = map[entity.SortField]string{
+	entity.SORT_DATE:       "date",
+	entity.SORT_CREATED_AT: "created_at",
+	entity.SORT_UPDATED_AT: "updated_at",
+	entity.SORT_TITLE:      "title",
+}
+
 // private method
-func (r *PgRepository) getPosts(ids []uint, pagination entity.Pagination) ([]entity.Post, error) {
-	var posts []entity.Post
-	// calc limit & offset
-	limit, offset := paginationToLimitOffset(pagination)
+//
+// Find a page of posts matched by the filter, ordered by the sort field, ties are broken by id.
+// The total is counted regardless of pagination. A keyset cursor continues after (date, id),
+// which only makes sense when sorted by date.
+func (r *PgRepository) getPosts(
+	filter func(*gorm.DB) *gorm.DB,
+	sort entity.SortField,
+	order entity.SortOrder,
+	pagination entity.Pagination,
+) (entity.PostPage, error) {
+	column, ok := sortColumns[sort]
+	if !ok {
+		return entity.PostPage{}, fmt.Errorf("unknown sort %q", sort)
+	}
+	direction, compare := "DESC", "<"
+	if order == entity.ASC {
+		direction, compare = "ASC", ">"
+	}
+
+	page := entity.PostPage{
+		Items: []entity.Post{},
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+	}
+
+	if err := r.db.Model(&entity.Post{}).Scopes(r.owned, r.inNotebook, filter).Count(&page.Total).Error; err != nil {
+		return entity.PostPage{}, err
+	}
+
 	// preload all associations so that each post would be filled with tags and affiliates;
 	// otherwise, the tags and affiliates would be empty
 	que := r.db.
+		Scopes(r.owned, r.inNotebook, filter).
 		Preload(clause.Associations).
-		Limit(limit).
-		Offset(offset)
+		Order(fmt.Sprintf("%s %s, id %s", column, direction, direction))
 
-	var err error
-
-	if len(ids) == 0 {
-		err = que.Find(&posts).Error
+	if pagination.Cursor != "" {
+		if sort != entity.SORT_DATE {
+			return entity.PostPage{}, errors.New("cursor is only available when sorted by date")
+		}
+		cursor, err := entity.DecodePostCursor(pagination.Cursor)
+		if err != nil {
+			return entity.PostPage{}, err
+		}
+		que = que.Where(fmt.Sprintf("(date, id) %s (?, ?)", compare), cursor.Date, cursor.Id)
 	} else {
-		err = que.Where("id IN ?", ids).Find(&posts).Error
+		_, offset := paginationToLimitOffset(pagination)
+		que = que.Offset(offset)
 	}
 
-	if err != nil {
-		return posts, err
+	// one more post is fetched to tell whether there is a next page
+	if pagination.Size > 0 {
+		que = que.Limit(pagination.Size + 1)
+	}
+
+	var posts []entity.Post
+	if err := que.Find(&posts).Error; err != nil {
+		return entity.PostPage{}, err
+	}
+
+	if pagination.Size > 0 && len(posts) > pagination.Size {
+		posts = posts[:pagination.Size]
+		if sort == entity.SORT_DATE {
+			last := posts[len(posts)-1]
+			page.NextCursor = entity.PostCursor{Date: last.Date, Id: last.Id}.Encode()
+		}
 	}
+	page.Items = append(page.Items, posts...)
+
+	return page, nil
+}
 
-	return posts, nil
+// filter posts by ids, `nil` means all posts
+func postsIn(ids []uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if ids == nil {
+			return db
+		}
+		return db.Where("id IN ?", ids)
+	}
 }
 
-func (r *PgRepository) GetPosts(pagination entity.Pagination) ([]entity.Post, error) {
-	return r.getPosts(nil, pagination)
+func (r *PgRepository) GetPosts(pagination entity.Pagination) (entity.PostPage, error) {
+	return r.getPosts(postsIn(nil), entity.SORT_DATE, entity.DESC, pagination)
 }
 
 func (r *PgRepository) GetPost(id uint) (entity.Post, error) {
 	var post entity.Post
-	err := r.db.Preload(clause.Associations).First(&post, id).Error
+	err := r.db.Scopes(r.owned).Preload(clause.Associations).First(&post, id).Error
 	if err != nil {
 		return post, err
 	}
@@ -230,15 +793,50 @@ func (r *PgRepository) GetPost(id uint) (entity.Post, error) {
 }
 
 func (r *PgRepository) CreatePost(post entity.Post) (entity.Post, error) {
-	if err := r.db.Save(&post).Error; err != nil {
+	var saved entity.Post
+
+	post.Version = 1
+	post.OwnerId = r.owner
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := r.checkAssociations(tx, &post); err != nil {
+			return err
+		}
+
+		if err := tx.Save(&post).Error; err != nil {
+			return err
+		}
+
+		var err error
+		if saved, err = r.createRevision(tx, post.Id); err != nil {
+			return err
+		}
+		return r.replacePostLinks(tx, saved)
+	})
+	if err != nil {
 		return entity.Post{}, err
 	}
-	return post, nil
+
+	return saved, nil
 }
 
 func (r *PgRepository) UpdatePost(post entity.Post) (entity.Post, error) {
+	var saved entity.Post
+
 	// transaction here to make sure all the data modification is atomic
-	r.db.Transaction(func(tx *gorm.DB) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		current, err := r.lockVersion(tx, post.Id, post.Version)
+		if err != nil {
+			return err
+		}
+		post.Version = current + 1
+		// a post is never transferred to another user
+		post.OwnerId = 0
+
+		if err := r.checkAssociations(tx, &post); err != nil {
+			return err
+		}
+
 		// update tags by replacing it
 		if err := tx.Model(&post).Association("Tags").Replace(post.Tags); err != nil {
 			return err
@@ -253,50 +851,450 @@ func (r *PgRepository) UpdatePost(post entity.Post) (entity.Post, error) {
 		if err := tx.Model(&post).Updates(post).Error; err != nil {
 			return err
 		}
-		return nil
+
+		if saved, err = r.createRevision(tx, post.Id); err != nil {
+			return err
+		}
+		return r.replacePostLinks(tx, saved)
 	})
+	if err != nil {
+		return entity.Post{}, err
+	}
 
-	return post, nil
+	return saved, nil
 }
 
-func (r *PgRepository) DeletePost(id uint) error {
-	// transaction here to make sure all the data deletion is atomic
-	return r.db.Transaction(func(tx *gorm.DB) error {
+// Lock a post to be updated, and return its current version. A trashed post can't be
+// updated until it is restored. The row is locked until the transaction ends, so that
+// concurrent updates can't both pass the version check
+func (r *PgRepository) lockVersion(tx *gorm.DB, id, version uint) (uint, error) {
+	var current entity.Post
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Scopes(r.owned).
+		Select("id", "version").
+		First(&current, id).
+		Error
+	if err != nil {
+		return 0, err
+	}
+	if version != 0 && version != current.Version {
+		if err := tx.Preload(clause.Associations).First(&current, id).Error; err != nil {
+			return 0, err
+		}
+		return 0, &entity.ConflictError{
+			Resource:       "post",
+			Id:             id,
+			Version:        version,
+			Current:        current,
+			CurrentVersion: current.Version,
+		}
+	}
 
-		var post entity.Post
-		if err := tx.First(&post, id).Error; err != nil {
+	return current.Version, nil
+}
+
+func (r *PgRepository) PatchPost(id uint, changes entity.PostChanges) (entity.Post, error) {
+	var saved entity.Post
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.lockVersion(tx, id, changes.Version); err != nil {
 			return err
 		}
 
-		// do not delete data, but unbound from the post
-		if err := tx.Model(&post).Association("Tags").Clear(); err != nil {
+		tagIds := make(map[uint]bool)
+		for _, t := range changes.AddTagIds {
+			tagIds[t] = true
+		}
+		if err := r.checkOwned(tx, &entity.Tag{}, "tag", tagIds); err != nil {
 			return err
 		}
-
-		// do not delete data, but unbound from the post
-		if err := tx.Model(&post).Association("Affiliates").Clear(); err != nil {
+		affiliateIds := make(map[uint]bool)
+		for _, a := range changes.AddAffiliateIds {
+			affiliateIds[a] = true
+		}
+		if err := r.checkOwned(tx, &entity.Affiliate{}, "affiliate", affiliateIds); err != nil {
 			return err
 		}
 
-		// delete post
-		if err := tx.Delete(&post).Error; err != nil {
+		// only the columns changed, e.g. an empty subtitle clears it
+		updates := map[string]interface{}{
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": time.Now(),
+		}
+		if changes.Title != nil {
+			updates["title"] = *changes.Title
+		}
+		if changes.Subtitle != nil {
+			updates["subtitle"] = *changes.Subtitle
+		}
+		if changes.Content != nil {
+			updates["content"] = *changes.Content
+		}
+		if changes.Date != nil {
+			updates["date"] = *changes.Date
+		}
+		if err := tx.Model(&entity.Post{}).Where("id = ?", id).UpdateColumns(updates).Error; err != nil {
 			return err
 		}
 
-		return nil
-	})
-}
+		if len(changes.AddTagIds) > 0 {
+			err := tx.Exec(
+				"INSERT INTO posts_tags (post_id, tag_id) SELECT ?, id FROM tags WHERE id IN ? "+
+					"ON CONFLICT DO NOTHING",
+				id, changes.AddTagIds,
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(changes.RemoveTagIds) > 0 {
+			err := tx.Exec("DELETE FROM posts_tags WHERE post_id = ? AND tag_id IN ?", id, changes.RemoveTagIds).Error
+			if err != nil {
+				return err
+			}
+		}
 
-func (r *PgRepository) SaveAffiliate(affiliate entity.Affiliate) (entity.Affiliate, error) {
-	if err := r.db.Save(&affiliate).Error; err != nil {
-		return entity.Affiliate{}, err
+		// affiliates are rebound and unbound the same as by `Association.Replace`
+		if len(changes.AddAffiliateIds) > 0 {
+			err := tx.Model(&entity.Affiliate{}).
+				Where("id IN ?", changes.AddAffiliateIds).
+				UpdateColumn("post_refer", id).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(changes.RemoveAffiliateIds) > 0 {
+			err := tx.Model(&entity.Affiliate{}).
+				Where("id IN ? AND post_refer = ?", changes.RemoveAffiliateIds, id).
+				UpdateColumn("post_refer", nil).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if saved, err = r.createRevision(tx, id); err != nil {
+			return err
+		}
+		return r.replacePostLinks(tx, saved)
+	})
+	if err != nil {
+		return entity.Post{}, err
+	}
+
+	return saved, nil
+}
+
+// Record a revision of a post in the transaction which saved it, the author is the owner
+// the repository is scoped to. Return the saved post, loaded with its tags and affiliates.
+func (r *PgRepository) createRevision(tx *gorm.DB, id uint) (entity.Post, error) {
+	var post entity.Post
+	if err := tx.Preload(clause.Associations).First(&post, id).Error; err != nil {
+		return entity.Post{}, err
+	}
+
+	revision := entity.NewPostRevision(post)
+	if r.owner != 0 {
+		author := r.owner
+		revision.AuthorId = &author
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return entity.Post{}, err
+	}
+
+	return post, nil
+}
+
+func (r *PgRepository) DeletePost(id uint) error {
+	// soft delete, tags and affiliates stay bound to the post
+	result := r.db.Scopes(r.owned).Delete(&entity.Post{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("post %d not found", id)
+	}
+
+	return nil
+}
+
+// only trashed posts
+func trashed(db *gorm.DB) *gorm.DB {
+	return db.Unscoped().Where("deleted_at IS NOT NULL")
+}
+
+func (r *PgRepository) GetTrash(pagination entity.Pagination) (entity.PostPage, error) {
+	return r.getPosts(trashed, entity.SORT_DATE, entity.DESC, pagination)
+}
+
+func (r *PgRepository) RestorePost(id uint) error {
+	result := r.db.
+		Model(&entity.Post{}).
+		Scopes(trashed, r.owned).
+		Where("id = ?", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("post %d not found in trash", id)
+	}
+
+	return nil
+}
+
+func (r *PgRepository) PurgePost(id uint) ([]string, error) {
+	var oids []string
+
+	// transaction here to make sure all the data deletion is atomic
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var post entity.Post
+		if err := tx.Scopes(trashed, r.owned).Preload("Affiliates").First(&post, id).Error; err != nil {
+			return fmt.Errorf("post %d not found in trash: %w", id, err)
+		}
+
+		// do not delete tags, but unbound from the post
+		if err := tx.Model(&post).Association("Tags").Clear(); err != nil {
+			return err
+		}
+
+		// affiliates belong to the post, they are deleted along with it
+		for _, a := range post.Affiliates {
+			oids = append(oids, a.ObjectId)
+		}
+		if err := tx.Where("post_refer = ?", post.Id).Delete(&entity.Affiliate{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("post_id = ?", post.Id).Delete(&entity.PostRevision{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("post_id = ?", post.Id).Delete(&entity.Share{}).Error; err != nil {
+			return err
+		}
+
+		// links to the post are kept, they are reported as broken
+		if err := tx.Where("source_id = ?", post.Id).Delete(&entity.PostLink{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(&post).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return oids, nil
+}
+
+func (r *PgRepository) GetTrashedBefore(before time.Time) ([]uint, error) {
+	var ids []uint
+
+	err := r.db.
+		Model(&entity.Post{}).
+		Scopes(trashed, r.owned, r.inNotebook).
+		Where("deleted_at < ?", before).
+		Pluck("id", &ids).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (r *PgRepository) GetRevisions(postId uint, pagination entity.Pagination) (entity.RevisionPage, error) {
+	page := entity.RevisionPage{
+		Items: []entity.PostRevision{},
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+	}
+
+	err := r.db.
+		Model(&entity.PostRevision{}).
+		Scopes(r.ownedRevisions).
+		Where("post_id = ?", postId).
+		Count(&page.Total).
+		Error
+	if err != nil {
+		return entity.RevisionPage{}, err
+	}
+
+	limit, offset := paginationToLimitOffset(pagination)
+
+	err = r.db.
+		Scopes(r.ownedRevisions).
+		Where("post_id = ?", postId).
+		Order("id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&page.Items).
+		Error
+	if err != nil {
+		return entity.RevisionPage{}, err
+	}
+
+	return page, nil
+}
+
+func (r *PgRepository) GetRevision(id uint) (entity.PostRevision, error) {
+	var revision entity.PostRevision
+	if err := r.db.Scopes(r.ownedRevisions).First(&revision, id).Error; err != nil {
+		return revision, err
+	}
+
+	return revision, nil
+}
+
+func (r *PgRepository) RestoreRevision(id uint) (entity.Post, error) {
+	var saved entity.Post
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var revision entity.PostRevision
+		if err := tx.Scopes(r.ownedRevisions).First(&revision, id).Error; err != nil {
+			return err
+		}
+
+		// a trashed post can't be restored to a revision until it is restored from trash
+		var post entity.Post
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Scopes(r.owned).
+			Select("id").
+			First(&post, revision.PostId).
+			Error
+		if err != nil {
+			return err
+		}
+
+		// tags deleted since then are skipped
+		var tagIds []uint
+		if len(revision.TagIds) > 0 {
+			err := tx.
+				Model(&entity.Tag{}).
+				Scopes(r.owned).
+				Where("id IN ?", []uint(revision.TagIds)).
+				Pluck("id", &tagIds).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+		tags := []entity.Tag{}
+		for _, tid := range tagIds {
+			tags = append(tags, entity.Tag{UintId: entity.UintId{Id: tid}})
+		}
+
+		// affiliates deleted or bound to another post since then are skipped
+		var affiliateIds []uint
+		if len(revision.AffiliateIds) > 0 {
+			err := tx.
+				Model(&entity.Affiliate{}).
+				Scopes(r.owned).
+				Where("id IN ? AND (post_refer IS NULL OR post_refer = ?)", []uint(revision.AffiliateIds), post.Id).
+				Pluck("id", &affiliateIds).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+		affiliates := []entity.Affiliate{}
+		for _, aid := range affiliateIds {
+			affiliates = append(affiliates, entity.Affiliate{UintId: entity.UintId{Id: aid}})
+		}
+
+		if err := tx.Model(&post).Association("Tags").Replace(tags); err != nil {
+			return err
+		}
+		if err := tx.Model(&post).Association("Affiliates").Replace(affiliates); err != nil {
+			return err
+		}
+
+		// unlike `UpdatePost`, zero values (e.g. an empty subtitle) are restored as well
+		err = tx.Model(&post).Updates(map[string]interface{}{
+			"title":    revision.Title,
+			"subtitle": revision.Subtitle,
+			"content":  revision.Content,
+			"date":     revision.Date,
+			"version":  gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if saved, err = r.createRevision(tx, post.Id); err != nil {
+			return err
+		}
+		return r.replacePostLinks(tx, saved)
+	})
+	if err != nil {
+		return entity.Post{}, err
+	}
+
+	return saved, nil
+}
+
+func (r *PgRepository) GetRenderedContents(posts []entity.Post) (map[uint]string, error) {
+	rendered := make(map[uint]string)
+	if len(posts) == 0 {
+		return rendered, nil
+	}
+
+	keys := make([][]interface{}, len(posts))
+	for i, post := range posts {
+		keys[i] = []interface{}{post.Id, post.Version}
+	}
+
+	var revisions []entity.PostRevision
+	err := r.db.
+		Scopes(r.ownedRevisions).
+		Select("id", "post_id", "content_html").
+		Where("(post_id, version) IN ?", keys).
+		Where("content_html <> ''").
+		Order("id").
+		Find(&revisions).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	// ordered by id, so that the newest revision of a version wins
+	for _, revision := range revisions {
+		rendered[revision.PostId] = revision.ContentHtml
+	}
+
+	return rendered, nil
+}
+
+func (r *PgRepository) SaveRenderedContent(postId, version uint, html string) error {
+	newest := r.db.
+		Model(&entity.PostRevision{}).
+		Select("MAX(id)").
+		Where("post_id = ? AND version = ?", postId, version)
+
+	return r.db.
+		Model(&entity.PostRevision{}).
+		Scopes(r.ownedRevisions).
+		Where("id = (?)", newest).
+		Update("content_html", html).
+		Error
+}
+
+func (r *PgRepository) SaveAffiliate(affiliate entity.Affiliate) (entity.Affiliate, error) {
+	affiliate.OwnerId = r.owner
+	if err := r.db.Save(&affiliate).Error; err != nil {
+		return entity.Affiliate{}, err
 	}
 	return affiliate, nil
 }
 
 func (r *PgRepository) GetAffiliate(id uint) (entity.Affiliate, error) {
 	var affiliate entity.Affiliate
-	if err := r.db.First(&affiliate, id).Error; err != nil {
+	if err := r.db.Scopes(r.owned).First(&affiliate, id).Error; err != nil {
 		return affiliate, err
 	}
 
@@ -307,6 +1305,7 @@ func (r *PgRepository) GetUnownedAffiliatesByIds(ids []uint) ([]entity.Affiliate
 	var affiliates []entity.Affiliate
 
 	err := r.db.
+		Scopes(r.owned).
 		Where("post_refer IS NULL").
 		Find(&affiliates, ids).
 		Error
@@ -318,109 +1317,1098 @@ func (r *PgRepository) GetUnownedAffiliatesByIds(ids []uint) ([]entity.Affiliate
 	return affiliates, nil
 }
 
-func (r *PgRepository) GetUnownedAffiliates(pagination entity.Pagination) ([]entity.Affiliate, error) {
-	var affiliates []entity.Affiliate
-	offset := (pagination.Page - 1) * pagination.Size
+func (r *PgRepository) GetUnownedAffiliates(pagination entity.Pagination) (entity.AffiliatePage, error) {
+	page := entity.AffiliatePage{
+		Items: []entity.Affiliate{},
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+	}
 
 	err := r.db.
-		Limit(pagination.Size).
-		Offset(offset).
+		Model(&entity.Affiliate{}).
+		Scopes(r.owned).
 		Where("post_refer IS NULL").
-		Find(&affiliates).
+		Count(&page.Total).
 		Error
+	if err != nil {
+		return entity.AffiliatePage{}, err
+	}
 
+	limit, offset := paginationToLimitOffset(pagination)
+
+	err = r.db.
+		Limit(limit).
+		Offset(offset).
+		Scopes(r.owned).
+		Where("post_refer IS NULL").
+		Order("id").
+		Find(&page.Items).
+		Error
 	if err != nil {
-		return nil, err
+		return entity.AffiliatePage{}, err
 	}
 
-	return affiliates, nil
+	return page, nil
+}
+
+func (r *PgRepository) RebindAffiliate(affiliateId, postId uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var affiliate entity.Affiliate
+		if err := tx.Scopes(r.owned).First(&affiliate, affiliateId).Error; err != nil {
+			return fmt.Errorf("affiliate %d not found: %w", affiliateId, err)
+		}
+		// the post is locked, so that it is not purged before the affiliate is bound
+		var post entity.Post
+		err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Scopes(r.owned).First(&post, postId).Error
+		if err != nil {
+			return fmt.Errorf("post %d not found: %w", postId, err)
+		}
+		if post.OwnerId != affiliate.OwnerId {
+			return fmt.Errorf("affiliate %d and post %d are owned by different users", affiliateId, postId)
+		}
+
+		return tx.Model(&affiliate).UpdateColumn("post_refer", postId).Error
+	})
 }
 
 func (r *PgRepository) DeleteUnownedAffiliates(ids []uint) error {
-	return r.db.Where("post_refer IS NULL").Delete(entity.Affiliate{}, ids).Error
+	return r.db.Scopes(r.owned).Where("post_refer IS NULL").Delete(entity.Affiliate{}, ids).Error
+}
+
+// Files are content-addressed, the same file can be referred by affiliates of different users.
+// Hence it is never scoped to the owner.
+func (r *PgRepository) GetReferredObjectIds(oids []string) ([]string, error) {
+	var referred []string
+	if len(oids) == 0 {
+		return referred, nil
+	}
+
+	err := r.db.
+		Model(&entity.Affiliate{}).
+		Distinct("object_id").
+		Where("object_id IN ?", oids).
+		Pluck("object_id", &referred).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return referred, nil
 }
 
 type PostsTags struct {
 	PostID uint
 }
 
-const searchByTagQuery = `
-SELECT
-	post_id
-FROM
-	posts_tags
-WHERE
-	tag_id IN ?
-GROUP BY
-	post_id
-HAVING
-	count(distinct tag_id) = ?
+// The tags along with the tags nested in them, and the posts bound to any of them.
+// `root` is the given tag each row is found by
+const taggedTreeQuery = `
+WITH RECURSIVE tree AS (
+	SELECT id, id AS root FROM tags WHERE id IN ?
+	UNION
+	SELECT tags.id, tree.root FROM tags JOIN tree ON tags.parent_id = tree.id
+)
+SELECT posts_tags.post_id FROM posts_tags JOIN tree ON posts_tags.tag_id = tree.id
 `
 
-func (r *PgRepository) GetPostsByTags(
-	tagIds []uint,
-	pagination entity.Pagination,
-) ([]entity.Post, error) {
-	var postIds []uint
+// A sub-query of ids of the posts bound to the given tags.
+// With `entity.MATCH_ALL`, a post must be bound to all the given tags, i.e.
+//
+//	SELECT post_id FROM posts_tags WHERE tag_id IN ? GROUP BY post_id HAVING count(distinct tag_id) = ?
+//
+// If descendants are included, a post bound to a tag nested in a given tag is bound to the
+// given tag as well, see `taggedTreeQuery`
+func (r *PgRepository) postsTaggedWith(tagIds []uint, match entity.TagMatch, descendants bool) *gorm.DB {
+	if descendants {
+		if match == entity.MATCH_ANY {
+			return r.db.Raw(taggedTreeQuery, tagIds)
+		}
+		return r.db.Raw(
+			taggedTreeQuery+"GROUP BY posts_tags.post_id HAVING count(DISTINCT tree.root) = ?",
+			tagIds, len(tagIds),
+		)
+	}
 
-	err := r.db.
-		Raw(searchByTagQuery, tagIds, len(tagIds)).
-		Scan(&postIds).
-		Error
-	if err != nil {
-		return nil, err
+	tagged := r.db.
+		Table("posts_tags").
+		Select("post_id").
+		Where("tag_id IN ?", tagIds)
+	if match != entity.MATCH_ANY {
+		tagged = tagged.
+			Group("post_id").
+			Having("count(distinct tag_id) = ?", len(tagIds))
 	}
+	return tagged
+}
+
+// Filter posts by tags, see `postsTaggedWith`
+func (r *PgRepository) taggedWith(tagIds []uint, match entity.TagMatch, descendants bool) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN (?)", r.postsTaggedWith(tagIds, match, descendants))
+	}
+}
 
-	return r.getPosts(postIds, pagination)
+func (r *PgRepository) GetPostsByTags(
+	tagIds []uint,
+	descendants bool,
+	pagination entity.Pagination,
+) (entity.PostPage, error) {
+	filter := r.taggedWith(tagIds, entity.MATCH_ALL, descendants)
+	return r.getPosts(filter, entity.SORT_DATE, entity.DESC, pagination)
 }
 
 func (r *PgRepository) GetPostsByTitle(
 	title string,
 	pagination entity.Pagination,
-) ([]entity.Post, error) {
-	var postIds []uint
+) (entity.PostPage, error) {
+	filter := func(db *gorm.DB) *gorm.DB {
+		return db.Where("title LIKE ?", "%"+title+"%")
+	}
 
-	err := r.db.
-		Raw("SELECT id FROM posts WHERE title LIKE ?", "%"+title+"%").
-		Scan(&postIds).
-		Error
-	if err != nil {
-		return nil, err
+	return r.getPosts(filter, entity.SORT_DATE, entity.DESC, pagination)
+}
+
+// column of a time type
+func timeColumn(t entity.TimeType) string {
+	switch t {
+	case entity.CREATE:
+		return "created_at"
+	case entity.MODIFY:
+		return "updated_at"
+	default:
+		return "date"
 	}
+}
 
-	return r.getPosts(postIds, pagination)
+// column name cannot be a bound parameter, it is one of the whitelisted columns
+func inTimeRange(timeSearch entity.TimeSearch) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(
+			fmt.Sprintf("%s BETWEEN ? AND ?", timeColumn(timeSearch.Type)),
+			timeSearch.Start,
+			timeSearch.End,
+		)
+	}
 }
 
 func (r *PgRepository) GetPostsByTimeRange(
 	timeSearch entity.TimeSearch,
 	pagination entity.Pagination,
-) ([]entity.Post, error) {
-	var postIds []uint
-
-	var d string
-	switch timeSearch.Type {
-	case 0:
-		d = "date"
-	case 1:
-		d = "created_at"
-	case 2:
-		d = "updated_at"
-	default:
-		d = "date"
+) (entity.PostPage, error) {
+	return r.getPosts(inTimeRange(timeSearch), entity.SORT_DATE, entity.DESC, pagination)
+}
+
+// ============================================================================
+// Full-text search
+// ============================================================================
+
+// The `simple` configuration does not stem words, since notes are not limited to one
+// language. Title weighs more than subtitle, and subtitle weighs more than content.
+const searchVector = `(
+	setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('simple', coalesce(subtitle, '')), 'B') ||
+	setweight(to_tsvector('simple', coalesce(content, '')), 'C')
+)`
+
+// an expression index, the same expression must be used by queries to hit the index
+var createSearchIndex = `
+CREATE INDEX IF NOT EXISTS idx_posts_search ON posts USING GIN ` + searchVector
+
+// `websearch_to_tsquery` accepts quoted phrases, "or" and "-" for exclusion
+var searchQuery = `
+SELECT
+	id,
+	ts_rank(` + searchVector + `, query) AS rank,
+	ts_headline(
+		'simple',
+		content,
+		query,
+		'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10'
+	) AS snippet
+FROM
+	posts,
+	websearch_to_tsquery('simple', ?) query
+WHERE
+	deleted_at IS NULL AND
+	(? = 0 OR owner_id = ?) AND
+	(? = 0 OR notebook_id IN (?)) AND
+	` + searchVector + ` @@ query
+ORDER BY
+	rank DESC, id DESC
+LIMIT ? OFFSET ?
+`
+
+var searchCountQuery = `
+SELECT
+	count(*)
+FROM
+	posts
+WHERE
+	deleted_at IS NULL AND
+	(? = 0 OR owner_id = ?) AND
+	(? = 0 OR notebook_id IN (?)) AND
+	` + searchVector + ` @@ websearch_to_tsquery('simple', ?)
+`
+
+type searchHit struct {
+	Id      uint
+	Rank    float64
+	Snippet string
+}
+
+func (r *PgRepository) SearchPosts(
+	text string,
+	pagination entity.Pagination,
+) (entity.PostSearchPage, error) {
+	page := entity.PostSearchPage{
+		Items: []entity.PostSearchResult{},
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+	}
+
+	if err := r.db.Raw(searchCountQuery, r.owner, r.owner, r.notebook.NotebookId, r.notebookTree(), text).Scan(&page.Total).Error; err != nil {
+		return entity.PostSearchPage{}, err
+	}
+
+	var hits []searchHit
+
+	limit, offset := paginationToLimitOffset(pagination)
+	// `LIMIT NULL` means no limit, the same as `gorm.DB.Limit` with a non-positive value
+	var limitArg interface{}
+	if limit > 0 {
+		limitArg = limit
+	}
+	if offset < 0 {
+		offset = 0
 	}
 
 	err := r.db.
-		Raw(
-			"SELECT id FROM posts WHERE ? BETWEEN ? AND ?",
-			d,
-			timeSearch.Start,
-			timeSearch.End,
-		).
-		Scan(&postIds).
+		Raw(searchQuery, text, r.owner, r.owner, r.notebook.NotebookId, r.notebookTree(), limitArg, offset).
+		Scan(&hits).
 		Error
 	if err != nil {
-		return nil, err
+		return entity.PostSearchPage{}, err
+	}
+
+	if len(hits) == 0 {
+		return page, nil
+	}
+
+	ids := make([]uint, len(hits))
+	for i, h := range hits {
+		ids[i] = h.Id
+	}
+
+	var posts []entity.Post
+	err = r.db.
+		Preload(clause.Associations).
+		Where("id IN ?", ids).
+		Find(&posts).
+		Error
+	if err != nil {
+		return entity.PostSearchPage{}, err
 	}
 
-	return r.getPosts(postIds, pagination)
+	// keep the order of rank
+	byId := make(map[uint]entity.Post, len(posts))
+	for _, p := range posts {
+		byId[p.Id] = p
+	}
+	for _, h := range hits {
+		page.Items = append(page.Items, entity.PostSearchResult{
+			Post:    byId[h.Id],
+			Rank:    h.Rank,
+			Snippet: h.Snippet,
+		})
+	}
+
+	return page, nil
+}
+
+// ============================================================================
+// Query
+// ============================================================================
+
+// Compile a `PostQuery` into one SQL query, tags are matched by sub-queries on `posts_tags`.
+// The query is supposed to be normalized already.
+func (r *PgRepository) QueryPosts(query entity.PostQuery) (entity.PostPage, error) {
+	filter := func(db *gorm.DB) *gorm.DB {
+		if len(query.TagIds) > 0 {
+			db = db.Scopes(r.taggedWith(query.TagIds, query.TagMatch, query.TagDescendants))
+		}
+
+		if len(query.ExcludeTagIds) > 0 {
+			excluded := r.postsTaggedWith(query.ExcludeTagIds, entity.MATCH_ANY, query.TagDescendants)
+			db = db.Where("id NOT IN (?)", excluded)
+		}
+
+		if query.Text != "" {
+			db = db.Where(searchVector+" @@ websearch_to_tsquery('simple', ?)", query.Text)
+		}
+
+		if query.Time != nil {
+			db = db.Scopes(inTimeRange(*query.Time))
+		}
+
+		return db
+	}
+
+	return r.getPosts(filter, query.Sort, query.Order, query.Pagination())
+}
+
+// ============================================================================
+// Share
+// ============================================================================
+
+func (r *PgRepository) CreateShare(share entity.Share) (entity.Share, error) {
+	var post entity.Post
+	if err := r.db.Scopes(r.owned).First(&post, share.PostId).Error; err != nil {
+		return entity.Share{}, fmt.Errorf("post %d not found: %w", share.PostId, err)
+	}
+
+	share.OwnerId = post.OwnerId
+	if err := r.db.Create(&share).Error; err != nil {
+		return entity.Share{}, err
+	}
+
+	return share, nil
+}
+
+func (r *PgRepository) GetShares(postId uint) ([]entity.Share, error) {
+	shares := []entity.Share{}
+
+	err := r.db.
+		Scopes(r.owned).
+		Where("post_id = ?", postId).
+		Order("id DESC").
+		Find(&shares).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+func (r *PgRepository) RevokeShare(id uint) error {
+	res := r.db.
+		Model(&entity.Share{}).
+		Scopes(r.owned).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("share %d not found", id)
+	}
+
+	return nil
+}
+
+// The token is what grants the access, hence it is never scoped to the owner
+func (r *PgRepository) GetShareByTokenHash(hash string) (entity.Share, error) {
+	var share entity.Share
+	if err := r.db.Where("token_hash = ?", hash).First(&share).Error; err != nil {
+		return share, err
+	}
+
+	return share, nil
+}
+
+// ============================================================================
+// Notebook
+// ============================================================================
+
+// The notebook the repository is scoped to and, if recursive, the notebooks nested in it.
+// `WHERE ?` stops the recursion unless it is recursive
+const notebookTreeQuery = `
+WITH RECURSIVE tree AS (
+	SELECT id FROM notebooks WHERE id = ?
+	UNION
+	SELECT notebooks.id FROM notebooks JOIN tree ON notebooks.parent_id = tree.id WHERE ?
+)
+SELECT id FROM tree
+`
+
+// ids of the notebooks in the scope of the repository, as a sub-query
+func (r *PgRepository) notebookTree() *gorm.DB {
+	return r.db.Raw(notebookTreeQuery, r.notebook.NotebookId, r.notebook.Recursive)
+}
+
+// only posts in the notebook the repository is scoped to, see `InNotebook`
+func (r *PgRepository) inNotebook(db *gorm.DB) *gorm.DB {
+	if r.notebook.NotebookId == 0 {
+		return db
+	}
+	return db.Where(
+		"? IN (?)",
+		clause.Column{Table: clause.CurrentTable, Name: "notebook_id"},
+		r.notebookTree(),
+	)
+}
+
+// Make sure the parent of a notebook is owned, and it is neither the notebook itself nor
+// nested in it, which would make a cycle
+func (r *PgRepository) checkParent(tx *gorm.DB, id uint, parentId *uint) error {
+	for ancestor := parentId; ancestor != nil; {
+		if *ancestor == id {
+			return fmt.Errorf("notebook %d can't be nested in itself", id)
+		}
+		var notebook entity.Notebook
+		if err := tx.Scopes(r.owned).First(&notebook, *ancestor).Error; err != nil {
+			return fmt.Errorf("notebook %d not found: %w", *ancestor, err)
+		}
+		ancestor = notebook.ParentId
+	}
+
+	return nil
+}
+
+func (r *PgRepository) GetNotebookTree() ([]entity.NotebookNode, error) {
+	var notebooks []entity.Notebook
+	if err := r.db.Scopes(r.owned).Find(&notebooks).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		NotebookId uint
+		Count      int64
+	}
+	err := r.db.
+		Model(&entity.Post{}).
+		Scopes(r.owned).
+		Select("notebook_id, count(*) AS count").
+		Where("notebook_id IS NOT NULL").
+		Group("notebook_id").
+		Scan(&rows).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.NotebookId] = row.Count
+	}
+
+	return entity.NewNotebookTree(notebooks, counts), nil
+}
+
+func (r *PgRepository) GetNotebook(id uint) (entity.Notebook, error) {
+	var notebook entity.Notebook
+	if err := r.db.Scopes(r.owned).First(&notebook, id).Error; err != nil {
+		return entity.Notebook{}, fmt.Errorf("notebook %d not found: %w", id, err)
+	}
+	return notebook, nil
+}
+
+func (r *PgRepository) CreateNotebook(notebook entity.Notebook) (entity.Notebook, error) {
+	notebook.OwnerId = r.owner
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := r.checkParent(tx, 0, notebook.ParentId); err != nil {
+			return err
+		}
+		return tx.Create(&notebook).Error
+	})
+	if err != nil {
+		return entity.Notebook{}, err
+	}
+
+	return notebook, nil
+}
+
+func (r *PgRepository) UpdateNotebook(notebook entity.Notebook) (entity.Notebook, error) {
+	var saved entity.Notebook
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// the row is locked, so that concurrent moves can't make a cycle together
+		var current entity.Notebook
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(r.owned).First(&current, notebook.Id).Error
+		if err != nil {
+			return err
+		}
+		if err := r.checkParent(tx, notebook.Id, notebook.ParentId); err != nil {
+			return err
+		}
+
+		// the parent is replaced as well, an empty parent moves the notebook to the root
+		err = tx.Model(&current).Updates(map[string]interface{}{
+			"name":       notebook.Name,
+			"parent_id":  notebook.ParentId,
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.First(&saved, notebook.Id).Error
+	})
+	if err != nil {
+		return entity.Notebook{}, err
+	}
+
+	return saved, nil
+}
+
+func (r *PgRepository) DeleteNotebook(id uint, moveTo *entity.Destination) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var notebook entity.Notebook
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(r.owned).First(&notebook, id).Error
+		if err != nil {
+			return fmt.Errorf("notebook %d not found: %w", id, err)
+		}
+
+		if moveTo == nil {
+			// trashed posts are counted as well, otherwise they would be restored nowhere
+			var notebooks, posts int64
+			if err := tx.Model(&entity.Notebook{}).Where("parent_id = ?", id).Count(&notebooks).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&entity.Post{}).Unscoped().Where("notebook_id = ?", id).Count(&posts).Error; err != nil {
+				return err
+			}
+			if notebooks > 0 || posts > 0 {
+				return entity.ErrNotebookNotEmpty
+			}
+		} else {
+			if err := r.checkParent(tx, id, moveTo.NotebookId); err != nil {
+				return err
+			}
+			err := tx.Model(&entity.Notebook{}).
+				Where("parent_id = ?", id).
+				Update("parent_id", moveTo.NotebookId).
+				Error
+			if err != nil {
+				return err
+			}
+			// moving is not an update of the posts themselves
+			err = tx.Model(&entity.Post{}).
+				Unscoped().
+				Where("notebook_id = ?", id).
+				UpdateColumn("notebook_id", moveTo.NotebookId).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete(&notebook).Error
+	})
+}
+
+func (r *PgRepository) MovePost(id uint, to entity.Destination) (entity.Post, error) {
+	var saved entity.Post
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var post entity.Post
+		if err := tx.Scopes(r.owned).Select("id").First(&post, id).Error; err != nil {
+			return fmt.Errorf("post %d not found: %w", id, err)
+		}
+		if to.NotebookId != nil {
+			if err := r.checkOwned(tx, &entity.Notebook{}, "notebook", map[uint]bool{*to.NotebookId: true}); err != nil {
+				return err
+			}
+		}
+
+		// moving is not an update of the post itself, the version is kept
+		if err := tx.Model(&post).UpdateColumn("notebook_id", to.NotebookId).Error; err != nil {
+			return err
+		}
+
+		return tx.Preload(clause.Associations).First(&saved, id).Error
+	})
+	if err != nil {
+		return entity.Post{}, err
+	}
+
+	return saved, nil
+}
+
+// ============================================================================
+// Bulk
+// ============================================================================
+
+// Lock the posts, the ones not found, e.g. trashed or owned by another user, are failed
+func (r *PgRepository) lockPosts(tx *gorm.DB, ids []uint) ([]uint, map[uint]error, error) {
+	var found []uint
+	err := tx.
+		Model(&entity.Post{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Scopes(r.owned).
+		Where("id IN ?", ids).
+		Pluck("id", &found).
+		Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errs := make(map[uint]error)
+	exists := make(map[uint]bool, len(found))
+	for _, id := range found {
+		exists[id] = true
+	}
+	locked := make([]uint, 0, len(found))
+	for _, id := range ids {
+		if exists[id] {
+			locked = append(locked, id)
+		} else {
+			errs[id] = fmt.Errorf("post %d not found", id)
+		}
+	}
+
+	return locked, errs, nil
+}
+
+// Save a new version of the locked posts, along with the columns, and record their revisions
+func (r *PgRepository) bumpVersions(tx *gorm.DB, ids []uint, columns map[string]interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	updates := map[string]interface{}{
+		"version":    gorm.Expr("version + 1"),
+		"updated_at": time.Now(),
+	}
+	for column, value := range columns {
+		updates[column] = value
+	}
+	if err := tx.Model(&entity.Post{}).Where("id IN ?", ids).UpdateColumns(updates).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := r.createRevision(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PgRepository) BulkTagPosts(tagging entity.BulkTagging) (entity.BulkReport, error) {
+	var errs map[uint]error
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		tagIds := make(map[uint]bool)
+		for _, id := range append(append([]uint{}, tagging.AddTagIds...), tagging.RemoveTagIds...) {
+			tagIds[id] = true
+		}
+		if err := r.checkOwned(tx, &entity.Tag{}, "tag", tagIds); err != nil {
+			return err
+		}
+
+		var (
+			locked []uint
+			err    error
+		)
+		locked, errs, err = r.lockPosts(tx, tagging.PostIds)
+		if err != nil || len(locked) == 0 {
+			return err
+		}
+
+		if len(tagging.AddTagIds) > 0 {
+			// a post already bound to a tag stays bound once
+			err = tx.Exec(
+				"INSERT INTO posts_tags (post_id, tag_id) "+
+					"SELECT p.id, t.id FROM posts p CROSS JOIN tags t WHERE p.id IN ? AND t.id IN ? "+
+					"ON CONFLICT DO NOTHING",
+				locked, tagging.AddTagIds,
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(tagging.RemoveTagIds) > 0 {
+			err = tx.Exec(
+				"DELETE FROM posts_tags WHERE post_id IN ? AND tag_id IN ?",
+				locked, tagging.RemoveTagIds,
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return r.bumpVersions(tx, locked, nil)
+	})
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+
+	return entity.NewBulkReport(tagging.PostIds, errs), nil
+}
+
+func (r *PgRepository) BulkDeletePosts(ids []uint) (entity.BulkReport, error) {
+	var errs map[uint]error
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var (
+			locked []uint
+			err    error
+		)
+		locked, errs, err = r.lockPosts(tx, ids)
+		if err != nil || len(locked) == 0 {
+			return err
+		}
+
+		// soft delete, tags and affiliates stay bound to the posts
+		return tx.Delete(&entity.Post{}, locked).Error
+	})
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+
+	return entity.NewBulkReport(ids, errs), nil
+}
+
+func (r *PgRepository) BulkDatePosts(dating entity.BulkDating) (entity.BulkReport, error) {
+	var errs map[uint]error
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var (
+			locked []uint
+			err    error
+		)
+		locked, errs, err = r.lockPosts(tx, dating.PostIds)
+		if err != nil {
+			return err
+		}
+
+		return r.bumpVersions(tx, locked, map[string]interface{}{"date": dating.Date})
+	})
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+
+	return entity.NewBulkReport(dating.PostIds, errs), nil
+}
+
+func (r *PgRepository) BulkDeleteTags(ids []uint) (entity.BulkReport, error) {
+	errs := make(map[uint]error)
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// one by one, since a tag may be nested in another one deleted before it
+		for _, id := range ids {
+			var tag entity.Tag
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(r.owned).First(&tag, id).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				errs[id] = fmt.Errorf("tag %d not found", id)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			err = tx.Model(&entity.Tag{}).
+				Where("parent_id = ?", id).
+				Update("parent_id", tag.ParentId).
+				Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Delete(&tag).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+
+	return entity.NewBulkReport(ids, errs), nil
+}
+
+// ============================================================================
+// Link
+// ============================================================================
+
+// Record the wiki-style links in the content of a post in the transaction which saved it,
+// replacing the links recorded before, see `entity.PostLink`
+func (r *PgRepository) replacePostLinks(tx *gorm.DB, post entity.Post) error {
+	if err := tx.Where("source_id = ?", post.Id).Delete(&entity.PostLink{}).Error; err != nil {
+		return err
+	}
+
+	links := []entity.PostLink{}
+	for _, target := range util.WikiLinks(post.Content) {
+		links = append(links, entity.NewPostLink(post.Id, target))
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return tx.Create(&links).Error
+}
+
+func (r *PgRepository) GetPostLinks(sourceId uint) ([]entity.LinkedPost, error) {
+	var source entity.Post
+	if err := r.db.Scopes(r.owned).Select("id", "owner_id").First(&source, sourceId).Error; err != nil {
+		return nil, fmt.Errorf("post %d not found: %w", sourceId, err)
+	}
+
+	var links []entity.PostLink
+	if err := r.db.Where("source_id = ?", sourceId).Order("id").Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	ids := []uint{}
+	titles := []string{}
+	for _, l := range links {
+		if l.TargetId != nil {
+			ids = append(ids, *l.TargetId)
+		} else {
+			titles = append(titles, strings.ToLower(l.TargetTitle))
+		}
+	}
+
+	// only posts of the same owner are linked, trashed posts are excluded by default
+	var targets []entity.Post
+	err := r.db.
+		Select("id", "title").
+		Where("owner_id = ?", source.OwnerId).
+		Where(r.db.Where("id IN ?", ids).Or("lower(title) IN ?", titles)).
+		Order("id").
+		Find(&targets).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[uint]entity.Post)
+	byTitle := make(map[string]entity.Post)
+	for _, t := range targets {
+		byId[t.Id] = t
+		// ordered by id, so that the oldest post of a title wins
+		if _, ok := byTitle[strings.ToLower(t.Title)]; !ok {
+			byTitle[strings.ToLower(t.Title)] = t
+		}
+	}
+
+	linked := []entity.LinkedPost{}
+	for _, l := range links {
+		var target entity.Post
+		var ok bool
+		if l.TargetId != nil {
+			target, ok = byId[*l.TargetId]
+		} else {
+			target, ok = byTitle[strings.ToLower(l.TargetTitle)]
+		}
+		linked = append(linked, entity.LinkedPost{
+			Link:   l.Target(),
+			PostId: target.Id,
+			Title:  target.Title,
+			Broken: !ok,
+		})
+	}
+
+	return linked, nil
+}
+
+func (r *PgRepository) GetBacklinks(targetId uint) ([]entity.LinkedPost, error) {
+	var target entity.Post
+	if err := r.db.Scopes(r.owned).Select("id", "title", "owner_id").First(&target, targetId).Error; err != nil {
+		return nil, fmt.Errorf("post %d not found: %w", targetId, err)
+	}
+
+	// links by title are resolved to the oldest post of the title
+	var oldest uint
+	err := r.db.
+		Model(&entity.Post{}).
+		Select("id").
+		Where("owner_id = ? AND lower(title) = lower(?)", target.OwnerId, target.Title).
+		Order("id").
+		Limit(1).
+		Scan(&oldest).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	linksTo := r.db.Where("post_links.target_id = ?", target.Id)
+	if oldest == target.Id {
+		linksTo = linksTo.Or("lower(post_links.target_title) = lower(?)", target.Title)
+	}
+
+	var rows []struct {
+		entity.PostLink
+		SourceTitle string
+	}
+	err = r.db.
+		Model(&entity.PostLink{}).
+		Select("post_links.*, posts.title AS source_title").
+		Joins("JOIN posts ON posts.id = post_links.source_id AND posts.deleted_at IS NULL").
+		Where("posts.owner_id = ? AND posts.id <> ?", target.OwnerId, target.Id).
+		Where(linksTo).
+		Order("post_links.id").
+		Scan(&rows).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	linked := []entity.LinkedPost{}
+	for _, row := range rows {
+		linked = append(linked, entity.LinkedPost{
+			Link:   row.Target(),
+			PostId: row.SourceId,
+			Title:  row.SourceTitle,
+		})
+	}
+
+	return linked, nil
+}
+
+// ============================================================================
+// User
+// ============================================================================
+
+func (r *PgRepository) CreateUser(user entity.User) (entity.User, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// users registering at the same time are serialized, so that only one of them is
+		// the first. Reads are not blocked by this lock mode
+		if err := tx.Exec("LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+			return err
+		}
+		var n int64
+		if err := tx.Model(&entity.User{}).Count(&n).Error; err != nil {
+			return err
+		}
+		if n == 0 {
+			user.Role = entity.ROLE_ADMIN
+		}
+
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if n == 0 {
+			return r.claimUnowned(tx, user.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		return entity.User{}, err
+	}
+
+	return user, nil
+}
+
+func (r *PgRepository) GetUser(id uint) (entity.User, error) {
+	var user entity.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+func (r *PgRepository) GetUserByName(name string) (entity.User, error) {
+	var user entity.User
+	if err := r.db.Where("name = ?", name).First(&user).Error; err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+func (r *PgRepository) UpdateUserRole(id uint, role entity.Role) (entity.User, error) {
+	var user entity.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return user, err
+	}
+	if err := r.db.Model(&user).Update("role", role).Error; err != nil {
+		return entity.User{}, err
+	}
+	user.Role = role
+
+	return user, nil
+}
+
+func (r *PgRepository) CreateRefreshToken(token entity.RefreshToken) error {
+	return r.db.Create(&token).Error
+}
+
+func (r *PgRepository) UseRefreshToken(hash string) (entity.RefreshToken, error) {
+	var token entity.RefreshToken
+
+	// the row is locked until the transaction ends, so that concurrent requests can't both use it
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hash, time.Now()).
+			First(&token).
+			Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&token).Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return entity.RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+func (r *PgRepository) GetRefreshToken(hash string) (entity.RefreshToken, error) {
+	var token entity.RefreshToken
+	if err := r.db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return token, err
+	}
+
+	return token, nil
+}
+
+func (r *PgRepository) RevokeRefreshTokens(userId uint) error {
+	return r.db.
+		Model(&entity.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).
+		Error
+}
+
+func (r *PgRepository) CreateApiKey(key entity.ApiKey) (entity.ApiKey, error) {
+	if err := r.db.Create(&key).Error; err != nil {
+		return entity.ApiKey{}, err
+	}
+	return key, nil
+}
+
+func (r *PgRepository) GetApiKeys(userId uint) ([]entity.ApiKey, error) {
+	keys := []entity.ApiKey{}
+	if err := r.db.Where("user_id = ?", userId).Order("id DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (r *PgRepository) GetApiKeyByPrefix(prefix string) (entity.ApiKey, error) {
+	var key entity.ApiKey
+	if err := r.db.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+func (r *PgRepository) RevokeApiKey(userId uint, prefix string) error {
+	tx := r.db.Model(&entity.ApiKey{}).Where("prefix = ? AND revoked_at IS NULL", prefix)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+
+	res := tx.Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("api key %s not found", prefix)
+	}
+
+	return nil
+}
+
+func (r *PgRepository) TouchApiKey(id uint, at time.Time) error {
+	return r.db.Model(&entity.ApiKey{}).Where("id = ?", id).Update("last_used_at", at).Error
 }
@@ -179,17 +179,33 @@ func TestGetAllPosts(t *testing.T) {
 	r, err := newPgRepo()
 	require.NoError(t, err)
 
-	posts, err := r.GetPosts(entity.NewPagination(0, 10))
+	page, err := r.GetPosts(entity.NewPagination(1, 10))
 	require.NoError(t, err)
 	// since we have already created two posts, we should get two posts
-	require.Len(t, posts, 2)
-	// the 1st post should have two affiliates and one tag
-	require.Equal(t, posts[0].Affiliates[0].Id, uint(1))
-	require.Equal(t, posts[0].Affiliates[1].Id, uint(2))
-	require.Equal(t, posts[0].Tags[0].Id, uint(1))
-	require.Equal(t, posts[1].Affiliates[0].Id, uint(3))
+	require.Equal(t, int64(2), page.Total)
+	require.Len(t, page.Items, 2)
+	require.Empty(t, page.NextCursor)
+	// newest first, the 1st post should have two affiliates and one tag
+	posts := page.Items
+	require.Equal(t, posts[1].Affiliates[0].Id, uint(1))
+	require.Equal(t, posts[1].Affiliates[1].Id, uint(2))
 	require.Equal(t, posts[1].Tags[0].Id, uint(1))
-	require.Equal(t, posts[1].Tags[1].Id, uint(2))
+	require.Equal(t, posts[0].Affiliates[0].Id, uint(3))
+	require.Equal(t, posts[0].Tags[0].Id, uint(1))
+	require.Equal(t, posts[0].Tags[1].Id, uint(2))
+
+	// keyset pagination continues after the last post of the previous page
+	page, err = r.GetPosts(entity.NewPagination(1, 1))
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, uint(2), page.Items[0].Id)
+	require.NotEmpty(t, page.NextCursor)
+
+	page, err = r.GetPosts(entity.NewCursorPagination(page.NextCursor, 1))
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, uint(1), page.Items[0].Id)
+	require.Empty(t, page.NextCursor)
 }
 
 func TestUpdatePost(t *testing.T) {
@@ -236,37 +252,39 @@ func TestGetPostsByTags(t *testing.T) {
 	r, err := newPgRepo()
 	require.NoError(t, err)
 
-	posts, err := r.GetPostsByTags([]uint{1, 2}, entity.NewPagination(0, 10))
+	page, err := r.GetPostsByTags([]uint{1, 2}, false, entity.NewPagination(0, 10))
 	require.NoError(t, err)
-	require.Len(t, posts, 1)
-	require.Equal(t, posts[0].Id, uint(2))
+	require.Len(t, page.Items, 1)
+	require.Equal(t, page.Items[0].Id, uint(2))
 }
 
 func TestGetPostsByTitle(t *testing.T) {
 	r, err := newPgRepo()
 	require.NoError(t, err)
 
-	posts, err := r.GetPostsByTitle("first", entity.NewPagination(0, 10))
+	page, err := r.GetPostsByTitle("first", entity.NewPagination(0, 10))
 	require.NoError(t, err)
-	require.Len(t, posts, 1)
-	require.Equal(t, posts[0].Id, uint(1))
+	require.Len(t, page.Items, 1)
+	require.Equal(t, page.Items[0].Id, uint(1))
 }
 
 // ============================================================================
 // Test cases for Affiliates table
 // - GetUnownedAffiliates
 // - DeleteUnownedAffiliates
+// - RebindAffiliate
 // ============================================================================
 
 func TestGetUnownedAffiliates(t *testing.T) {
 	r, err := newPgRepo()
 	require.NoError(t, err)
 
-	affiliates, err := r.GetUnownedAffiliates(entity.NewPagination(0, 10))
+	page, err := r.GetUnownedAffiliates(entity.NewPagination(0, 10))
 	require.NoError(t, err)
 
-	require.Len(t, affiliates, 1)
-	require.Equal(t, affiliates[0].Filename, "test3.txt")
+	require.Equal(t, int64(1), page.Total)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, page.Items[0].Filename, "test3.txt")
 }
 
 func TestDeleteUnownedAffiliate(t *testing.T) {
@@ -278,3 +296,90 @@ func TestDeleteUnownedAffiliate(t *testing.T) {
 	err = r.DeleteUnownedAffiliates([]uint{1, 3})
 	require.NoError(t, err)
 }
+
+func TestRebindAffiliate(t *testing.T) {
+	r, err := newPgRepo()
+	require.NoError(t, err)
+
+	post, err := r.CreatePost(entity.Post{
+		Title:   "rebound",
+		Content: "content",
+		Date:    time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	affiliate, err := r.SaveAffiliate(entity.Affiliate{Filename: "rebound.txt"})
+	require.NoError(t, err)
+
+	// the post is kept as it is, along with its dates
+	require.NoError(t, r.RebindAffiliate(affiliate.Id, post.Id))
+	rebound, err := r.GetPost(post.Id)
+	require.NoError(t, err)
+	require.Equal(t, post.Version, rebound.Version)
+	require.Len(t, rebound.Affiliates, 1)
+	require.Equal(t, affiliate.Id, rebound.Affiliates[0].Id)
+
+	require.Error(t, r.RebindAffiliate(affiliate.Id, 404))
+}
+
+// ============================================================================
+// Test cases for links
+// - PostLinksSaved
+// ============================================================================
+
+// links are recorded in the transaction which saves the post
+func TestPostLinksSaved(t *testing.T) {
+	r, err := newPgRepo()
+	require.NoError(t, err)
+
+	post, err := r.CreatePost(entity.Post{
+		Title:   "linking",
+		Content: "see [[rebound]] and [[post:404]]",
+		Date:    time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	links, err := r.GetPostLinks(post.Id)
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+
+	content := "only [[rebound]]"
+	post, err = r.PatchPost(post.Id, entity.PostChanges{Content: &content})
+	require.NoError(t, err)
+	links, err = r.GetPostLinks(post.Id)
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	require.False(t, links[0].Broken)
+}
+
+// ============================================================================
+// Test cases for unowned rows
+// - ClaimUnowned
+// ============================================================================
+
+func TestClaimUnowned(t *testing.T) {
+	r, err := newPgRepo()
+	require.NoError(t, err)
+	require.NoError(t, r.TruncateAll())
+
+	// created before users existed
+	_, err = r.CreateTag(entity.Tag{Name: "go"})
+	require.NoError(t, err)
+	_, err = r.CreateTag(entity.Tag{Name: "rust"})
+	require.NoError(t, err)
+
+	admin, err := r.CreateUser(entity.User{Name: "admin", PasswordHash: "x", Role: entity.ROLE_ADMIN})
+	require.NoError(t, err)
+	_, err = r.ForOwner(admin.Id).CreateTag(entity.Tag{Name: "go"})
+	require.NoError(t, err)
+
+	require.NoError(t, r.AutoMigrate())
+
+	tags, err := r.ForOwner(admin.Id).GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 2)
+
+	// the name is taken by the admin, hence it is left unowned
+	var left []entity.Tag
+	require.NoError(t, r.db.Where("owner_id = 0").Find(&left).Error)
+	require.Len(t, left, 1)
+	require.Equal(t, "go", left[0].Name)
+}
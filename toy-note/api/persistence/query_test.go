@@ -0,0 +1,246 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"toy-note/api/entity"
+	"toy-note/logger"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// ============================================================================
+// Test cases of the SQL sent to Postgres, no database is required
+// - KeysetCursorQuery
+// - TagTreeQuery
+// - NotebookTreeQuery
+// - SearchQuery
+// - BulkTagQuery
+// - PostLinksInTransaction
+// ============================================================================
+
+// queryRecorder
+//
+// A database/sql driver which records the statements it is given instead of running them,
+// transactions are recorded as `BEGIN`, `COMMIT` and `ROLLBACK`. Queries are answered by
+// `respond`, which gives the columns and rows of a query, no rows by default.
+type queryRecorder struct {
+	mu         sync.Mutex
+	statements []string
+	respond    func(query string, args []driver.NamedValue) ([]string, [][]driver.Value)
+}
+
+func (q *queryRecorder) record(statement string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.statements = append(q.statements, statement)
+}
+
+// the statements recorded so far, recording starts over
+func (q *queryRecorder) take() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	statements := q.statements
+	q.statements = nil
+	return statements
+}
+
+func (q *queryRecorder) Connect(context.Context) (driver.Conn, error) { return recordedConn{q}, nil }
+func (q *queryRecorder) Driver() driver.Driver                        { return nil }
+
+type recordedConn struct{ q *queryRecorder }
+
+func (c recordedConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c recordedConn) Close() error                        { return nil }
+func (c recordedConn) Begin() (driver.Tx, error) {
+	c.q.record("BEGIN")
+	return recordedTx{c.q}, nil
+}
+
+// every argument is taken as it is, e.g. `gorm.DeletedAt`
+func (c recordedConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c recordedConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.q.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c recordedConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.q.record(query)
+	rows := &recordedRows{}
+	if c.q.respond != nil {
+		rows.columns, rows.values = c.q.respond(query, args)
+	}
+	return rows, nil
+}
+
+type recordedTx struct{ q *queryRecorder }
+
+func (t recordedTx) Commit() error   { t.q.record("COMMIT"); return nil }
+func (t recordedTx) Rollback() error { t.q.record("ROLLBACK"); return nil }
+
+type recordedRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *recordedRows) Columns() []string { return r.columns }
+func (r *recordedRows) Close() error      { return nil }
+func (r *recordedRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func newRecordedRepo(t *testing.T) (*PgRepository, *queryRecorder) {
+	if err := logger.Init("debug", logPath, true); err != nil {
+		panic(err)
+	}
+
+	q := &queryRecorder{}
+	db, err := gorm.Open(
+		postgres.New(postgres.Config{Conn: sql.OpenDB(q)}),
+		&gorm.Config{Logger: gormlogger.Discard},
+	)
+	require.NoError(t, err)
+
+	return &PgRepository{logger: logger.TNLogger.NewSugar("PgRepository"), db: db}, q
+}
+
+// the statement recorded which contains the fragment
+func findStatement(t *testing.T, statements []string, fragment string) string {
+	for _, s := range statements {
+		if strings.Contains(s, fragment) {
+			return s
+		}
+	}
+	require.Failf(t, "statement not found", "%q in %q", fragment, statements)
+	return ""
+}
+
+func countStatements(statements []string, statement string) int {
+	n := 0
+	for _, s := range statements {
+		if s == statement {
+			n++
+		}
+	}
+	return n
+}
+
+func TestKeysetCursorQuery(t *testing.T) {
+	r, q := newRecordedRepo(t)
+
+	cursor := entity.PostCursor{Date: time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC), Id: 7}.Encode()
+	_, err := r.GetPosts(entity.NewCursorPagination(cursor, 10))
+	require.NoError(t, err)
+
+	// the page continues after (date, id), in the order of the sort
+	query := findStatement(t, q.take(), "(date, id) < (")
+	require.Contains(t, query, "ORDER BY date DESC, id DESC")
+	require.Contains(t, query, "LIMIT 11")
+	require.NotContains(t, query, "OFFSET")
+}
+
+func TestTagTreeQuery(t *testing.T) {
+	r, q := newRecordedRepo(t)
+
+	_, err := r.GetPostsByTags([]uint{1, 2}, true, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+
+	query := findStatement(t, q.take(), "WITH RECURSIVE tree")
+	require.Contains(t, query, "SELECT tags.id, tree.root FROM tags JOIN tree ON tags.parent_id = tree.id")
+	require.Contains(t, query, "HAVING count(DISTINCT tree.root) = ")
+}
+
+func TestNotebookTreeQuery(t *testing.T) {
+	r, q := newRecordedRepo(t)
+
+	_, err := r.InNotebook(entity.NotebookScope{NotebookId: 3, Recursive: true}).GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+
+	query := findStatement(t, q.take(), "WITH RECURSIVE tree")
+	require.Contains(t, query, `"posts"."notebook_id" IN (`)
+	require.Contains(t, query, "FROM notebooks JOIN tree ON notebooks.parent_id = tree.id")
+}
+
+func TestSearchQuery(t *testing.T) {
+	r, q := newRecordedRepo(t)
+
+	_, err := r.SearchPosts(`"exact phrase" -excluded`, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+
+	statements := q.take()
+	count := findStatement(t, statements, "count(*)")
+	require.Contains(t, count, "@@ websearch_to_tsquery('simple', $6)")
+	query := findStatement(t, statements, "ts_headline(")
+	require.Contains(t, query, "websearch_to_tsquery('simple', $1) query")
+	require.Contains(t, query, "ORDER BY\n\trank DESC, id DESC")
+}
+
+func TestBulkTagQuery(t *testing.T) {
+	r, q := newRecordedRepo(t)
+	// every post and tag asked for is found
+	q.respond = func(query string, args []driver.NamedValue) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, `FROM "posts"`) && !strings.Contains(query, `FROM "tags"`) {
+			return nil, nil
+		}
+		rows := [][]driver.Value{}
+		for _, a := range args {
+			if id, ok := a.Value.(uint); ok {
+				rows = append(rows, []driver.Value{int64(id)})
+			}
+		}
+		return []string{"id"}, rows
+	}
+
+	_, err := r.BulkTagPosts(entity.BulkTagging{PostIds: []uint{1, 2}, AddTagIds: []uint{3}, RemoveTagIds: []uint{4}})
+	require.NoError(t, err)
+
+	statements := q.take()
+	require.Equal(t, "BEGIN", statements[0])
+	require.Equal(t, "COMMIT", statements[len(statements)-1])
+	require.Equal(t, 1, countStatements(statements, "BEGIN"))
+	insert := findStatement(t, statements, "INSERT INTO posts_tags")
+	require.Contains(t, insert, "CROSS JOIN tags t WHERE p.id IN ($1,$2) AND t.id IN ($3)")
+	require.True(t, strings.HasSuffix(insert, "ON CONFLICT DO NOTHING"))
+	findStatement(t, statements, "DELETE FROM posts_tags WHERE post_id IN ($1,$2) AND tag_id IN ($3)")
+	findStatement(t, statements, "FOR UPDATE")
+}
+
+func TestPostLinksInTransaction(t *testing.T) {
+	r, q := newRecordedRepo(t)
+	// the post is found by the id it is given
+	q.respond = func(query string, args []driver.NamedValue) ([]string, [][]driver.Value) {
+		if strings.HasPrefix(query, `INSERT INTO "posts"`) {
+			return []string{"id"}, [][]driver.Value{{int64(1)}}
+		}
+		if strings.HasPrefix(query, `SELECT * FROM "posts"`) {
+			return []string{"id", "title", "content"}, [][]driver.Value{{int64(1), "linking", "see [[other]]"}}
+		}
+		return nil, nil
+	}
+
+	_, err := r.CreatePost(entity.Post{Title: "linking", Content: "see [[other]]", Date: time.Now()})
+	require.NoError(t, err)
+
+	// links are replaced in the only transaction, before it commits
+	statements := q.take()
+	require.Equal(t, "BEGIN", statements[0])
+	require.Equal(t, "COMMIT", statements[len(statements)-1])
+	require.Equal(t, 1, countStatements(statements, "BEGIN"))
+	findStatement(t, statements, `DELETE FROM "post_links" WHERE source_id = $1`)
+	findStatement(t, statements, `INSERT INTO "post_links"`)
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"bufio"
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// the maximum number of bytes used by `http.DetectContentType`
+const sniffLen = 512
+
+// Detect the content type of an upload from its first bytes, then fall back to the
+// extension of the filename if the content is not conclusive.
+// The first bytes are only peeked, so the reader can still be uploaded as a whole.
+func sniffContentType(reader *bufio.Reader, filename string) string {
+	head, _ := reader.Peek(sniffLen)
+	contentType := http.DetectContentType(head)
+
+	// generic results, e.g. markdown, csv or svg files are only recognized as text
+	if contentType == "application/octet-stream" || contentType == "text/plain; charset=utf-8" {
+		if byExt := mime.TypeByExtension(filepath.Ext(filename)); byExt != "" {
+			return byExt
+		}
+	}
+
+	return contentType
+}
+
+// content type of an affiliate uploaded before content types were recorded
+func guessContentType(filename string) string {
+	if byExt := mime.TypeByExtension(filepath.Ext(filename)); byExt != "" {
+		return byExt
+	}
+
+	return "application/octet-stream"
+}
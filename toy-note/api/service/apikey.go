@@ -0,0 +1,120 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"toy-note/api/entity"
+)
+
+// API keys look like `tn_<prefix>_<secret>`, so that they are told apart from access tokens
+const apiKeyPrefix = "tn_"
+
+// `last_used_at` is not updated more often than this, every request would be a write otherwise
+const apiKeyTouchInterval = time.Minute
+
+// Whether a bearer token is an API key rather than an access token
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// A new API key of a user, the key itself is returned along with its stored record
+func newApiKey(userId uint, req entity.ApiKeyRequest) (string, entity.ApiKey, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return "", entity.ApiKey{}, errors.New("name must be 1 to 100 characters")
+	}
+	if err := req.Scopes.Validate(); err != nil {
+		return "", entity.ApiKey{}, err
+	}
+
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", entity.ApiKey{}, err
+	}
+	prefix := hex.EncodeToString(buf)
+	secret, err := randomToken()
+	if err != nil {
+		return "", entity.ApiKey{}, err
+	}
+
+	return apiKeyPrefix + prefix + "_" + secret, entity.ApiKey{
+		UserId:     userId,
+		Name:       name,
+		Prefix:     prefix,
+		SecretHash: hashToken(secret),
+		Scopes:     req.Scopes,
+	}, nil
+}
+
+// split an API key into its prefix and secret
+func parseApiKey(key string) (string, string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if !IsApiKey(key) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func (a accounts) CreateApiKey(userId uint, req entity.ApiKeyRequest) (entity.NewApiKey, error) {
+	if _, err := a.store.GetUser(userId); err != nil {
+		return entity.NewApiKey{}, err
+	}
+
+	key, record, err := newApiKey(userId, req)
+	if err != nil {
+		return entity.NewApiKey{}, err
+	}
+	record, err = a.store.CreateApiKey(record)
+	if err != nil {
+		return entity.NewApiKey{}, err
+	}
+
+	return entity.NewApiKey{ApiKey: record, Key: key}, nil
+}
+
+func (a accounts) GetApiKeys(userId uint) ([]entity.ApiKey, error) {
+	return a.store.GetApiKeys(userId)
+}
+
+func (a accounts) RevokeApiKey(userId uint, prefix string) error {
+	return a.store.RevokeApiKey(userId, prefix)
+}
+
+func (a accounts) AuthenticateApiKey(key string) (entity.User, entity.ApiKey, error) {
+	prefix, secret, ok := parseApiKey(key)
+	if !ok {
+		return entity.User{}, entity.ApiKey{}, ErrInvalidToken
+	}
+
+	stored, err := a.store.GetApiKeyByPrefix(prefix)
+	if err != nil || stored.RevokedAt != nil {
+		return entity.User{}, entity.ApiKey{}, ErrInvalidToken
+	}
+	if subtle.ConstantTimeCompare([]byte(stored.SecretHash), []byte(hashToken(secret))) != 1 {
+		return entity.User{}, entity.ApiKey{}, ErrInvalidToken
+	}
+
+	// the role is read every time, unlike access tokens, since keys live long
+	user, err := a.store.GetUser(stored.UserId)
+	if err != nil {
+		return entity.User{}, entity.ApiKey{}, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) > apiKeyTouchInterval {
+		if err := a.store.TouchApiKey(stored.Id, now); err != nil {
+			return entity.User{}, entity.ApiKey{}, err
+		}
+		stored.LastUsedAt = &now
+	}
+
+	return user, stored, nil
+}
+
+func (a accounts) FindUser(name string) (entity.User, error) {
+	return a.store.GetUserByName(strings.TrimSpace(name))
+}
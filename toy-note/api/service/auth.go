@@ -0,0 +1,293 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"toy-note/api/entity"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	tokenIssuer = "toy-note"
+	// HMAC keys shorter than the hash output weaken the signature
+	minSecretLen   = 32
+	minPasswordLen = 8
+	// bcrypt ignores anything beyond 72 bytes
+	maxPasswordLen = 72
+)
+
+// the same error for an unknown name and a wrong password, so that names can't be probed
+var ErrInvalidCredentials = errors.New("invalid name or password")
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// a bcrypt hash of the default cost which no password matches, compared for an unknown
+// name so that it takes as long as a wrong password
+const dummyPasswordHash = "$2a$10$b0vXldbZWWNzPKoAXf3V5.MzNPCZjUoUfWOnbTmrbS2dQcGvOMniW"
+
+// Tokens
+//
+// Access tokens are JWTs signed by HS256, they are verified without touching the database.
+// Refresh tokens are opaque random strings, only their hashes are stored so that they
+// can be revoked.
+type Tokens struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokens(secret string, accessTTL, refreshTTL time.Duration) (*Tokens, error) {
+	if len(secret) < minSecretLen {
+		return nil, fmt.Errorf("signing key must be at least %d bytes", minSecretLen)
+	}
+	if accessTTL <= 0 || refreshTTL <= 0 {
+		return nil, errors.New("token ttl must be positive")
+	}
+
+	return &Tokens{
+		secret:     []byte(secret),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}, nil
+}
+
+// claims of an access token, the subject is the user id
+type accessClaims struct {
+	Name string      `json:"name"`
+	Role entity.Role `json:"role"`
+	jwt.RegisteredClaims
+}
+
+func (t *Tokens) signAccessToken(user entity.User) (string, error) {
+	now := time.Now()
+	claims := accessClaims{
+		Name: user.Name,
+		Role: user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.FormatUint(uint64(user.Id), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.accessTTL)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.secret)
+}
+
+// Verify an access token, and return the user it was issued to. Only id, name and role are filled.
+func (t *Tokens) parseAccessToken(token string) (entity.User, error) {
+	var claims accessClaims
+
+	// the algorithm is pinned, otherwise a token could choose how it is verified
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	_, err := parser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return t.secret, nil
+	})
+	if err != nil || claims.ExpiresAt == nil || !claims.VerifyIssuer(tokenIssuer, true) {
+		return entity.User{}, ErrInvalidToken
+	}
+
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return entity.User{}, ErrInvalidToken
+	}
+	role, err := entity.ParseRole(string(claims.Role))
+	if err != nil {
+		return entity.User{}, ErrInvalidToken
+	}
+
+	return entity.User{UintId: entity.UintId{Id: uint(id)}, Name: claims.Name, Role: role}, nil
+}
+
+// A new refresh token of a user, the token itself is returned along with its stored record
+func (t *Tokens) newRefreshToken(userId uint) (string, entity.RefreshToken, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", entity.RefreshToken{}, err
+	}
+
+	return token, entity.RefreshToken{
+		UserId:    userId,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(t.refreshTTL),
+	}, nil
+}
+
+func (t *Tokens) tokenPair(accessToken, refreshToken string) entity.TokenPair {
+	return entity.TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(t.accessTTL / time.Second),
+		RefreshToken: refreshToken,
+	}
+}
+
+// an opaque token of 256 random bits, safe to be put in urls
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// opaque tokens are stored by their hashes, so that a leaked database grants nothing
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// check the name and password of a new user, and hash the password
+func newUser(credentials entity.Credentials) (entity.User, error) {
+	name := strings.TrimSpace(credentials.Name)
+	if name == "" || len(name) > 100 {
+		return entity.User{}, errors.New("name must be 1 to 100 characters")
+	}
+	if len(credentials.Password) < minPasswordLen || len(credentials.Password) > maxPasswordLen {
+		return entity.User{}, fmt.Errorf(
+			"password must be %d to %d bytes", minPasswordLen, maxPasswordLen,
+		)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(credentials.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return entity.User{}, err
+	}
+
+	return entity.User{Name: name, PasswordHash: string(hash), Role: entity.ROLE_EDITOR}, nil
+}
+
+func checkPassword(user entity.User, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+// the storage of users and refresh tokens, see `persistence.PgRepository` for the semantic
+type userStore interface {
+	CreateUser(entity.User) (entity.User, error)
+	GetUser(uint) (entity.User, error)
+	GetUserByName(string) (entity.User, error)
+	UpdateUserRole(uint, entity.Role) (entity.User, error)
+	CreateRefreshToken(entity.RefreshToken) error
+	UseRefreshToken(string) (entity.RefreshToken, error)
+	GetRefreshToken(string) (entity.RefreshToken, error)
+	RevokeRefreshTokens(uint) error
+	CreateApiKey(entity.ApiKey) (entity.ApiKey, error)
+	GetApiKeys(uint) ([]entity.ApiKey, error)
+	GetApiKeyByPrefix(string) (entity.ApiKey, error)
+	RevokeApiKey(uint, string) error
+	TouchApiKey(uint, time.Time) error
+}
+
+// accounts
+//
+// `UserRepo` implemented on top of a user store, shared by `ToyNoteService` and
+// `MemoryToyNoteService`
+type accounts struct {
+	store  userStore
+	tokens *Tokens
+}
+
+func (a accounts) Register(credentials entity.Credentials) (entity.User, error) {
+	user, err := newUser(credentials)
+	if err != nil {
+		return entity.User{}, err
+	}
+
+	if _, err := a.store.GetUserByName(user.Name); err == nil {
+		return entity.User{}, fmt.Errorf("user %s already exists", user.Name)
+	}
+
+	// the first user is the admin, who grants roles to the others. It is told by the store
+	// when the user is created, so that two users registering at once are not both admins
+	return a.store.CreateUser(user)
+}
+
+func (a accounts) Login(credentials entity.Credentials) (entity.TokenPair, error) {
+	user, err := a.store.GetUserByName(strings.TrimSpace(credentials.Name))
+	if err != nil {
+		checkPassword(entity.User{PasswordHash: dummyPasswordHash}, credentials.Password)
+		return entity.TokenPair{}, ErrInvalidCredentials
+	}
+	if !checkPassword(user, credentials.Password) {
+		return entity.TokenPair{}, ErrInvalidCredentials
+	}
+
+	return a.issue(user)
+}
+
+func (a accounts) Refresh(refreshToken string) (entity.TokenPair, error) {
+	hash := hashToken(refreshToken)
+
+	used, err := a.store.UseRefreshToken(hash)
+	if err != nil {
+		// a revoked token is used again, which means it may have been stolen.
+		// All the sessions of the user are revoked, so that the thief is logged out as well
+		if stored, err := a.store.GetRefreshToken(hash); err == nil && stored.RevokedAt != nil {
+			if err := a.store.RevokeRefreshTokens(stored.UserId); err != nil {
+				return entity.TokenPair{}, err
+			}
+		}
+		return entity.TokenPair{}, ErrInvalidToken
+	}
+
+	user, err := a.store.GetUser(used.UserId)
+	if err != nil {
+		return entity.TokenPair{}, ErrInvalidToken
+	}
+
+	return a.issue(user)
+}
+
+func (a accounts) Logout(refreshToken string) error {
+	if _, err := a.store.UseRefreshToken(hashToken(refreshToken)); err != nil {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func (a accounts) Authenticate(accessToken string) (entity.User, error) {
+	return a.tokens.parseAccessToken(accessToken)
+}
+
+func (a accounts) SetRole(by entity.User, userId uint, role entity.Role) (entity.User, error) {
+	if err := authorize(by.Role, entity.ROLE_ADMIN); err != nil {
+		return entity.User{}, err
+	}
+	// otherwise the last admin could lock everyone out
+	if by.Id == userId {
+		return entity.User{}, errors.New("can't change the role of yourself")
+	}
+	if _, err := entity.ParseRole(string(role)); err != nil {
+		return entity.User{}, err
+	}
+
+	return a.store.UpdateUserRole(userId, role)
+}
+
+// issue a new pair of tokens to a user
+func (a accounts) issue(user entity.User) (entity.TokenPair, error) {
+	accessToken, err := a.tokens.signAccessToken(user)
+	if err != nil {
+		return entity.TokenPair{}, err
+	}
+
+	refreshToken, record, err := a.tokens.newRefreshToken(user.Id)
+	if err != nil {
+		return entity.TokenPair{}, err
+	}
+	if err := a.store.CreateRefreshToken(record); err != nil {
+		return entity.TokenPair{}, err
+	}
+
+	return a.tokens.tokenPair(accessToken, refreshToken), nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"toy-note/api/entity"
+)
+
+// check the posts and the tags of a bulk tagging, duplicated ids are dropped
+func checkBulkTagging(tagging entity.BulkTagging) (entity.BulkTagging, error) {
+	postIds, err := entity.NormalizeBulkIds(tagging.PostIds)
+	if err != nil {
+		return entity.BulkTagging{}, err
+	}
+	if len(tagging.AddTagIds) == 0 && len(tagging.RemoveTagIds) == 0 {
+		return entity.BulkTagging{}, errors.New("tags to add or remove are required")
+	}
+
+	added := make(map[uint]bool, len(tagging.AddTagIds))
+	for _, id := range tagging.AddTagIds {
+		added[id] = true
+	}
+	for _, id := range tagging.RemoveTagIds {
+		if added[id] {
+			return entity.BulkTagging{}, fmt.Errorf("tag %d can't be both added and removed", id)
+		}
+	}
+
+	tagging.PostIds = postIds
+	return tagging, nil
+}
+
+// check the posts and the date of a bulk dating, duplicated ids are dropped
+func checkBulkDating(dating entity.BulkDating) (entity.BulkDating, error) {
+	postIds, err := entity.NormalizeBulkIds(dating.PostIds)
+	if err != nil {
+		return entity.BulkDating{}, err
+	}
+	if dating.Date.IsZero() {
+		return entity.BulkDating{}, errors.New("date is required")
+	}
+
+	dating.PostIds = postIds
+	return dating, nil
+}
@@ -0,0 +1,2473 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+	"toy-note/api/entity"
+	"toy-note/api/util"
+	"toy-note/logger"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+Contract test suite
+
+The same scenarios are run against every `ToyNoteRepo` implementation, so that the
+in-memory service can be trusted as a stand-in of the real one. Each scenario
+receives a brand new (or truncated) repository.
+
+The real `ToyNoteService` requires a live Postgres and MongoDB, and it is skipped
+when they are not reachable.
+*/
+
+type repoFactory func(t *testing.T) ToyNoteRepo
+
+func newMemoryRepo(t *testing.T) ToyNoteRepo {
+	if err := logger.Init("debug", logPath, true); err != nil {
+		panic(err)
+	}
+
+	return NewMemoryToyNoteService(logger.TNLogger, newTestTokens())
+}
+
+func newServiceRepo(t *testing.T) ToyNoteRepo {
+	s, err := newService()
+	if err != nil {
+		t.Skipf("database not available: %v", err)
+	}
+	require.NoError(t, s.Init())
+	require.NoError(t, s.pg.TruncateAll())
+
+	return s
+}
+
+var contractScenarios = map[string]func(*testing.T, ToyNoteRepo){
+	"Tags":              contractTags,
+	"Posts":             contractPosts,
+	"Affiliates":        contractAffiliates,
+	"SearchByTags":      contractSearchByTags,
+	"SearchByTitle":     contractSearchByTitle,
+	"SearchByTimeRange": contractSearchByTimeRange,
+	"FullTextSearch":    contractFullTextSearch,
+	"Query":             contractQuery,
+	"Pagination":        contractPagination,
+	"Trash":             contractTrash,
+	"Revisions":         contractRevisions,
+	"Conflicts":         contractConflicts,
+	"Accounts":          contractAccounts,
+	"Ownership":         contractOwnership,
+	"Roles":             contractRoles,
+	"FirstAdmin":        contractFirstAdmin,
+	"Shares":            contractShares,
+	"ApiKeys":           contractApiKeys,
+	"Rendering":         contractRendering,
+	"References":        contractReferences,
+	"Links":             contractLinks,
+	"Notebooks":         contractNotebooks,
+	"TagHierarchy":      contractTagHierarchy,
+	"MergeTags":         contractMergeTags,
+	"Export":            contractExport,
+	"Import":            contractImport,
+	"ImportEnex":        contractImportEnex,
+	"ImportJoplin":      contractImportJoplin,
+	"ImportDiscards":    contractImportDiscards,
+	"Bulk":              contractBulk,
+	"Patch":             contractPatch,
+}
+
+func runContract(t *testing.T, factory repoFactory) {
+	for name, scenario := range contractScenarios {
+		scenario := scenario
+		t.Run(name, func(t *testing.T) {
+			scenario(t, factory(t))
+		})
+	}
+}
+
+func TestMemoryContract(t *testing.T) {
+	runContract(t, newMemoryRepo)
+}
+
+func TestServiceContract(t *testing.T) {
+	runContract(t, newServiceRepo)
+}
+
+// ============================================================================
+// Scenarios
+// ============================================================================
+
+func mustSaveTag(t *testing.T, r ToyNoteRepo, name string) entity.Tag {
+	tag, err := r.SaveTag(entity.Tag{Name: name})
+	require.NoError(t, err)
+	require.NotEmpty(t, tag.Id)
+	return tag
+}
+
+func mustSavePost(t *testing.T, r ToyNoteRepo, post entity.Post) entity.Post {
+	post, err := r.SavePost(post)
+	require.NoError(t, err)
+	require.NotEmpty(t, post.Id)
+	return post
+}
+
+// move a post to trash, return the links which are broken
+func mustDeletePost(t *testing.T, r ToyNoteRepo, id uint) []entity.LinkedPost {
+	broken, err := r.DeletePost(id)
+	require.NoError(t, err)
+	return broken
+}
+
+func postIds(posts []entity.Post) []uint {
+	ids := []uint{}
+	for _, p := range posts {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+func contractTags(t *testing.T, r ToyNoteRepo) {
+	dev := mustSaveTag(t, r, "dev")
+	mustSaveTag(t, r, "test")
+
+	// tag name is unique
+	_, err := r.SaveTag(entity.Tag{Name: "dev"})
+	require.Error(t, err)
+
+	tags, err := r.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 2)
+
+	// update only touches the given fields
+	updated, err := r.SaveTag(entity.Tag{UintId: dev.UintId, Description: "development"})
+	require.NoError(t, err)
+	require.Equal(t, "development", updated.Description)
+
+	require.NoError(t, r.DeleteTag(dev.Id))
+	require.Error(t, r.DeleteTag(dev.Id))
+
+	tags, err = r.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	require.Equal(t, "test", tags[0].Name)
+}
+
+func contractPosts(t *testing.T, r ToyNoteRepo) {
+	t1 := mustSaveTag(t, r, "t1")
+	t2 := mustSaveTag(t, r, "t2")
+
+	for i := 0; i < 5; i++ {
+		mustSavePost(t, r, entity.Post{
+			Title:   "post",
+			Content: "content",
+			Date:    time.Date(2021, time.January, i+1, 0, 0, 0, 0, time.UTC),
+			Tags:    []entity.Tag{{UintId: t1.UintId}},
+		})
+	}
+
+	// pagination
+	posts, err := r.GetPosts(entity.NewPagination(1, 2))
+	require.NoError(t, err)
+	require.Len(t, posts.Items, 2)
+	posts, err = r.GetPosts(entity.NewPagination(3, 2))
+	require.NoError(t, err)
+	require.Len(t, posts.Items, 1)
+	posts, err = r.GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, posts.Items, 5)
+
+	// update replaces tags and keeps untouched fields
+	post := posts.Items[0]
+	post.Content = "updated"
+	post.Tags = []entity.Tag{{UintId: t2.UintId}}
+	_, err = r.SavePost(post)
+	require.NoError(t, err)
+
+	posts, err = r.SearchPostsByTags([]uint{t2.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, posts.Items, 1)
+	require.Equal(t, "updated", posts.Items[0].Content)
+	require.Equal(t, "post", posts.Items[0].Title)
+
+	// delete
+	mustDeletePost(t, r, post.Id)
+	_, err = r.DeletePost(post.Id)
+	require.Error(t, err)
+
+	posts, err = r.GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, posts.Items, 4)
+}
+
+func contractAffiliates(t *testing.T, r ToyNoteRepo) {
+	content := []byte("hello affiliate")
+
+	uploaded, err := r.UploadAffiliate(bytes.NewReader(content), "hello.txt")
+	require.NoError(t, err)
+	require.NotEmpty(t, uploaded.ObjectId)
+	require.Equal(t, "hello.txt", uploaded.Filename)
+	require.Equal(t, "text/plain; charset=utf-8", uploaded.ContentType)
+	require.Equal(t, int64(len(content)), uploaded.Size)
+	// sha256 of the content
+	require.Equal(t, "914c42105ba56894e4bbfc8b447d47f260cdf45395e98808b582bb50928da34c", uploaded.Checksum)
+
+	another := uploaded
+	another.Filename = "another.txt"
+	post := mustSavePost(t, r, entity.Post{
+		Title:      "with affiliates",
+		Content:    "content",
+		Date:       time.Now(),
+		Affiliates: []entity.Affiliate{uploaded, another},
+	})
+	require.Len(t, post.Affiliates, 2)
+	aid1 := post.Affiliates[0].Id
+	aid2 := post.Affiliates[1].Id
+	require.NotEmpty(t, aid1)
+	require.NotEmpty(t, aid2)
+
+	// download
+	fo, err := r.DownloadAffiliate(aid1)
+	require.NoError(t, err)
+	require.Equal(t, "hello.txt", fo.Filename)
+	require.Equal(t, "text/plain; charset=utf-8", fo.ContentType)
+	require.Equal(t, int64(len(content)), fo.Size)
+	data, err := ioutil.ReadAll(fo.Content)
+	require.NoError(t, err)
+	require.NoError(t, fo.Content.Close())
+	require.Equal(t, content, data)
+
+	// unbind all affiliates, they are kept as unowned affiliates
+	post.Affiliates = []entity.Affiliate{}
+	_, err = r.SavePost(post)
+	require.NoError(t, err)
+
+	unowned, err := r.GetUnownedAffiliates(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, unowned.Items, 2)
+
+	// rebind the first one
+	require.NoError(t, r.RebindAffiliate(post.Id, aid1))
+	unowned, err = r.GetUnownedAffiliates(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, unowned.Items, 1)
+	require.Equal(t, aid2, unowned.Items[0].Id)
+
+	// only unowned affiliates can be deleted
+	require.NoError(t, r.DeleteUnownedAffiliates([]uint{aid2}))
+	unowned, err = r.GetUnownedAffiliates(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, unowned.Items)
+
+	_, err = r.DownloadAffiliate(aid2)
+	require.Error(t, err)
+
+	// discarding an upload keeps the file while an affiliate refers to it
+	require.NoError(t, r.DiscardUploads([]string{uploaded.ObjectId}))
+	fo, err = r.DownloadAffiliate(aid1)
+	require.NoError(t, err)
+	require.NoError(t, fo.Content.Close())
+}
+
+func contractSearchByTags(t *testing.T, r ToyNoteRepo) {
+	t1 := mustSaveTag(t, r, "t1")
+	t2 := mustSaveTag(t, r, "t2")
+	t3 := mustSaveTag(t, r, "t3")
+
+	p1 := mustSavePost(t, r, entity.Post{
+		Title:   "p1",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: t1.UintId}},
+	})
+	p2 := mustSavePost(t, r, entity.Post{
+		Title:   "p2",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: t1.UintId}, {UintId: t2.UintId}},
+	})
+
+	// posts must be bound to all the given tags
+	posts, err := r.SearchPostsByTags([]uint{t1.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint{p1.Id, p2.Id}, postIds(posts.Items))
+
+	posts, err = r.SearchPostsByTags([]uint{t1.Id, t2.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{p2.Id}, postIds(posts.Items))
+
+	posts, err = r.SearchPostsByTags([]uint{t3.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, posts.Items)
+}
+
+func contractSearchByTitle(t *testing.T, r ToyNoteRepo) {
+	p1 := mustSavePost(t, r, entity.Post{Title: "My first post", Content: "content", Date: time.Now()})
+	mustSavePost(t, r, entity.Post{Title: "The second post", Content: "content", Date: time.Now()})
+
+	posts, err := r.SearchPostsByTitle("first", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{p1.Id}, postIds(posts.Items))
+
+	posts, err = r.SearchPostsByTitle("post", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, posts.Items, 2)
+
+	posts, err = r.SearchPostsByTitle("nothing", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, posts.Items)
+}
+
+func contractSearchByTimeRange(t *testing.T, r ToyNoteRepo) {
+	p1 := mustSavePost(t, r, entity.Post{
+		Title:   "2020",
+		Content: "content",
+		Date:    time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
+	})
+	p2 := mustSavePost(t, r, entity.Post{
+		Title:   "2021",
+		Content: "content",
+		Date:    time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC),
+	})
+
+	posts, err := r.SearchPostsByTimeRange(entity.TimeSearch{
+		Start: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2020, time.December, 31, 0, 0, 0, 0, time.UTC),
+		Type:  entity.DATE,
+	}, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{p1.Id}, postIds(posts.Items))
+
+	posts, err = r.SearchPostsByTimeRange(entity.TimeSearch{
+		Start: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC),
+		Type:  entity.DATE,
+	}, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint{p1.Id, p2.Id}, postIds(posts.Items))
+
+	// both posts are created just now
+	posts, err = r.SearchPostsByTimeRange(entity.TimeSearch{
+		Start: time.Now().Add(-time.Hour),
+		End:   time.Now().Add(time.Hour),
+		Type:  entity.CREATE,
+	}, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, posts.Items, 2)
+}
+
+func contractFullTextSearch(t *testing.T, r ToyNoteRepo) {
+	inTitle := mustSavePost(t, r, entity.Post{
+		Title:   "Golang tips",
+		Content: "a few tips about error handling",
+		Date:    time.Now(),
+	})
+	inContent := mustSavePost(t, r, entity.Post{
+		Title:    "Weekly notes",
+		Subtitle: "week 12",
+		Content:  "we migrated the service from python to golang this week",
+		Date:     time.Now(),
+	})
+	mustSavePost(t, r, entity.Post{
+		Title:   "Groceries",
+		Content: "milk, eggs",
+		Date:    time.Now(),
+	})
+
+	// title weighs more than content
+	results, err := r.SearchPosts("golang", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, results.Items, 2)
+	require.Equal(t, inTitle.Id, results.Items[0].Post.Id)
+	require.Equal(t, inContent.Id, results.Items[1].Post.Id)
+	require.Greater(t, results.Items[0].Rank, results.Items[1].Rank)
+	require.Contains(t, results.Items[1].Snippet, "<mark>golang</mark>")
+
+	// all words must match
+	results, err = r.SearchPosts("golang python", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, results.Items, 1)
+	require.Equal(t, inContent.Id, results.Items[0].Post.Id)
+
+	// subtitle is searched as well, and words can be excluded
+	results, err = r.SearchPosts("golang -week", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, results.Items, 1)
+	require.Equal(t, inTitle.Id, results.Items[0].Post.Id)
+
+	// pagination
+	results, err = r.SearchPosts("golang", entity.NewPagination(2, 1))
+	require.NoError(t, err)
+	require.Len(t, results.Items, 1)
+	require.Equal(t, inContent.Id, results.Items[0].Post.Id)
+
+	results, err = r.SearchPosts("nothing", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, results.Items)
+}
+
+func contractQuery(t *testing.T, r ToyNoteRepo) {
+	work := mustSaveTag(t, r, "work")
+	golang := mustSaveTag(t, r, "golang")
+	draft := mustSaveTag(t, r, "draft")
+
+	march := func(day int) time.Time {
+		return time.Date(2022, time.March, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	p1 := mustSavePost(t, r, entity.Post{
+		Title:   "Gorm associations",
+		Content: "how gorm replaces associations",
+		Date:    march(1),
+		Tags:    []entity.Tag{{UintId: work.UintId}, {UintId: golang.UintId}},
+	})
+	p2 := mustSavePost(t, r, entity.Post{
+		Title:   "Gorm hooks",
+		Content: "before create and before update",
+		Date:    march(20),
+		Tags:    []entity.Tag{{UintId: work.UintId}, {UintId: golang.UintId}},
+	})
+	p3 := mustSavePost(t, r, entity.Post{
+		Title:   "Gorm draft",
+		Content: "not finished",
+		Date:    march(10),
+		Tags:    []entity.Tag{{UintId: work.UintId}, {UintId: golang.UintId}, {UintId: draft.UintId}},
+	})
+	p4 := mustSavePost(t, r, entity.Post{
+		Title:   "Weekend",
+		Content: "hiking",
+		Date:    march(5),
+		Tags:    []entity.Tag{{UintId: draft.UintId}},
+	})
+	mustSavePost(t, r, entity.Post{
+		Title:   "Gorm in april",
+		Content: "gorm again",
+		Date:    time.Date(2022, time.April, 1, 0, 0, 0, 0, time.UTC),
+		Tags:    []entity.Tag{{UintId: work.UintId}, {UintId: golang.UintId}},
+	})
+
+	// tagged work AND golang, mentioning gorm, in March, not a draft, newest first
+	posts, err := r.QueryPosts(entity.PostQuery{
+		TagIds:        []uint{work.Id, golang.Id},
+		ExcludeTagIds: []uint{draft.Id},
+		Text:          "gorm",
+		Time:          &entity.TimeSearch{Start: march(1), End: march(31)},
+		Page:          1,
+		Size:          10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint{p2.Id, p1.Id}, postIds(posts.Items))
+
+	// any of the tags, oldest first
+	posts, err = r.QueryPosts(entity.PostQuery{
+		TagIds:   []uint{draft.Id},
+		TagMatch: entity.MATCH_ANY,
+		Order:    entity.ASC,
+		Page:     1,
+		Size:     10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint{p4.Id, p3.Id}, postIds(posts.Items))
+
+	// sorted by title, paginated
+	posts, err = r.QueryPosts(entity.PostQuery{
+		Time:  &entity.TimeSearch{Start: march(1), End: march(31)},
+		Sort:  entity.SORT_TITLE,
+		Order: entity.ASC,
+		Page:  2,
+		Size:  2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint{p2.Id, p4.Id}, postIds(posts.Items))
+
+	posts, err = r.QueryPosts(entity.PostQuery{Text: "nothing", Page: 1, Size: 10})
+	require.NoError(t, err)
+	require.Empty(t, posts.Items)
+
+	_, err = r.QueryPosts(entity.PostQuery{Sort: "id; DROP TABLE posts", Page: 1, Size: 10})
+	require.Error(t, err)
+}
+
+func contractPagination(t *testing.T, r ToyNoteRepo) {
+	day := func(d int) time.Time {
+		return time.Date(2022, time.May, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	// two posts share the same date, they are ordered by id
+	p1 := mustSavePost(t, r, entity.Post{Title: "p1", Content: "content", Date: day(1)})
+	p2 := mustSavePost(t, r, entity.Post{Title: "p2", Content: "content", Date: day(3)})
+	p3 := mustSavePost(t, r, entity.Post{Title: "p3", Content: "content", Date: day(2)})
+	p4 := mustSavePost(t, r, entity.Post{Title: "p4", Content: "content", Date: day(2)})
+
+	// newest first, with the total count
+	page, err := r.GetPosts(entity.NewPagination(1, 3))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), page.Total)
+	require.Equal(t, 1, page.Page)
+	require.Equal(t, 3, page.Size)
+	require.Equal(t, []uint{p2.Id, p4.Id, p3.Id}, postIds(page.Items))
+	require.NotEmpty(t, page.NextCursor)
+
+	// a post added in the meanwhile does not shift the next page
+	mustSavePost(t, r, entity.Post{Title: "p5", Content: "content", Date: day(4)})
+
+	page, err = r.GetPosts(entity.NewCursorPagination(page.NextCursor, 3))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), page.Total)
+	require.Equal(t, []uint{p1.Id}, postIds(page.Items))
+	require.Empty(t, page.NextCursor)
+
+	// the same cursor works for ascending queries
+	cursor := entity.PostCursor{Date: day(2), Id: p3.Id}.Encode()
+	page, err = r.QueryPosts(entity.PostQuery{Order: entity.ASC, Size: 1, Cursor: cursor})
+	require.NoError(t, err)
+	require.Equal(t, []uint{p4.Id}, postIds(page.Items))
+	require.NotEmpty(t, page.NextCursor)
+
+	_, err = r.GetPosts(entity.NewCursorPagination("not a cursor", 3))
+	require.Error(t, err)
+
+	_, err = r.QueryPosts(entity.PostQuery{Sort: entity.SORT_TITLE, Size: 1, Cursor: cursor})
+	require.Error(t, err)
+}
+
+func contractTrash(t *testing.T, r ToyNoteRepo) {
+	tag := mustSaveTag(t, r, "trash")
+	uploaded, err := r.UploadAffiliate(bytes.NewReader([]byte("trashed file")), "trashed.txt")
+	require.NoError(t, err)
+
+	p1 := mustSavePost(t, r, entity.Post{
+		Title:      "to be trashed",
+		Content:    "trashed content",
+		Date:       time.Now(),
+		Tags:       []entity.Tag{{UintId: tag.UintId}},
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	p2 := mustSavePost(t, r, entity.Post{Title: "kept", Content: "content", Date: time.Now()})
+	aid := p1.Affiliates[0].Id
+
+	mustDeletePost(t, r, p1.Id)
+	_, err = r.DeletePost(p1.Id)
+	require.Error(t, err)
+
+	// trashed posts are excluded from all the queries
+	posts, err := r.GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{p2.Id}, postIds(posts.Items))
+	posts, err = r.SearchPostsByTags([]uint{tag.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, posts.Items)
+	results, err := r.SearchPosts("trashed", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, results.Items)
+	posts, err = r.QueryPosts(entity.PostQuery{TagIds: []uint{tag.Id}, Page: 1, Size: 10})
+	require.NoError(t, err)
+	require.Empty(t, posts.Items)
+
+	// a trashed post can't be updated
+	_, err = r.SavePost(entity.Post{UintId: p1.UintId, Title: "updated"})
+	require.Error(t, err)
+
+	// affiliates of trashed posts are not unowned
+	unowned, err := r.GetUnownedAffiliates(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, unowned.Items)
+
+	trash, err := r.GetTrash(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), trash.Total)
+	require.Equal(t, []uint{p1.Id}, postIds(trash.Items))
+	require.True(t, trash.Items[0].DeletedAt.Valid)
+
+	// restored with the original bindings
+	require.NoError(t, r.RestorePost(p1.Id))
+	require.Error(t, r.RestorePost(p1.Id))
+
+	posts, err = r.SearchPostsByTags([]uint{tag.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{p1.Id}, postIds(posts.Items))
+	require.Len(t, posts.Items[0].Affiliates, 1)
+	require.Equal(t, aid, posts.Items[0].Affiliates[0].Id)
+	require.False(t, posts.Items[0].DeletedAt.Valid)
+
+	// only trashed posts can be purged
+	require.Error(t, r.PurgePost(p1.Id))
+	mustDeletePost(t, r, p1.Id)
+	require.NoError(t, r.PurgePost(p1.Id))
+	require.Error(t, r.RestorePost(p1.Id))
+
+	trash, err = r.GetTrash(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, trash.Items)
+
+	// affiliates and files are purged along with the post, tags are kept
+	_, err = r.DownloadAffiliate(aid)
+	require.Error(t, err)
+	tags, err := r.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+
+	// expired trash
+	mustDeletePost(t, r, p2.Id)
+	n, err := r.PurgeTrash(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, n)
+	n, err = r.PurgeTrash(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	trash, err = r.GetTrash(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, trash.Items)
+}
+
+func contractRevisions(t *testing.T, r ToyNoteRepo) {
+	dev := mustSaveTag(t, r, "dev")
+	test := mustSaveTag(t, r, "test")
+	date := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := mustSavePost(t, r, entity.Post{
+		Title:    "draft",
+		Subtitle: "sub",
+		Content:  "line 1\nline 2",
+		Date:     date,
+		Tags:     []entity.Tag{{UintId: dev.UintId}},
+	})
+	mustSavePost(t, r, entity.Post{
+		UintId:  p.UintId,
+		Title:   "final",
+		Content: "line 1\nline 2 changed\nline 3",
+		Tags:    []entity.Tag{{UintId: test.UintId}},
+	})
+	other := mustSavePost(t, r, entity.Post{Title: "other", Content: "content", Date: date})
+
+	// every save is recorded, newest first
+	revisions, err := r.GetRevisions(p.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), revisions.Total)
+	require.Len(t, revisions.Items, 2)
+	second, first := revisions.Items[0], revisions.Items[1]
+	require.Greater(t, second.Id, first.Id)
+	require.Equal(t, "draft", first.Title)
+	require.Equal(t, entity.UintList{dev.Id}, first.TagIds)
+	require.Equal(t, "final", second.Title)
+	require.Equal(t, "sub", second.Subtitle)
+	require.Equal(t, entity.UintList{test.Id}, second.TagIds)
+
+	revision, err := r.GetRevision(first.Id)
+	require.NoError(t, err)
+	require.Equal(t, "line 1\nline 2", revision.Content)
+	_, err = r.GetRevision(second.Id + 100)
+	require.Error(t, err)
+
+	diff, err := r.DiffRevisions(first.Id, second.Id)
+	require.NoError(t, err)
+	require.Equal(t, p.Id, diff.PostId)
+	require.Equal(t, []entity.DiffLine{
+		{Op: entity.DIFF_DELETE, Text: "draft"},
+		{Op: entity.DIFF_INSERT, Text: "final"},
+	}, diff.Title)
+	require.Equal(t, []entity.DiffLine{{Op: entity.DIFF_EQUAL, Text: "sub"}}, diff.Subtitle)
+	require.Equal(t, []entity.DiffLine{
+		{Op: entity.DIFF_EQUAL, Text: "line 1"},
+		{Op: entity.DIFF_DELETE, Text: "line 2"},
+		{Op: entity.DIFF_INSERT, Text: "line 2 changed"},
+		{Op: entity.DIFF_INSERT, Text: "line 3"},
+	}, diff.Content)
+	require.Equal(t, []uint{test.Id}, diff.TagsAdded)
+	require.Equal(t, []uint{dev.Id}, diff.TagsRemoved)
+
+	// revisions of different posts can't be diffed
+	others, err := r.GetRevisions(other.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, others.Items, 1)
+	_, err = r.DiffRevisions(first.Id, others.Items[0].Id)
+	require.Error(t, err)
+
+	// restoring is recorded as a new revision
+	restored, err := r.RestoreRevision(first.Id)
+	require.NoError(t, err)
+	require.Equal(t, "draft", restored.Title)
+	require.Equal(t, "line 1\nline 2", restored.Content)
+	require.True(t, date.Equal(restored.Date))
+	require.Len(t, restored.Tags, 1)
+	require.Equal(t, dev.Id, restored.Tags[0].Id)
+
+	revisions, err = r.GetRevisions(p.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), revisions.Total)
+	require.Equal(t, "draft", revisions.Items[0].Title)
+
+	// an empty subtitle is restored as well
+	noSubtitle := mustSavePost(t, r, entity.Post{Title: "no subtitle", Content: "content", Date: date})
+	r2, err := r.GetRevisions(noSubtitle.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	mustSavePost(t, r, entity.Post{UintId: noSubtitle.UintId, Subtitle: "added"})
+	restored, err = r.RestoreRevision(r2.Items[0].Id)
+	require.NoError(t, err)
+	require.Empty(t, restored.Subtitle)
+
+	// revisions are purged along with the post
+	mustDeletePost(t, r, p.Id)
+	_, err = r.RestoreRevision(first.Id)
+	require.Error(t, err)
+	require.NoError(t, r.PurgePost(p.Id))
+	revisions, err = r.GetRevisions(p.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, revisions.Items)
+	_, err = r.GetRevision(first.Id)
+	require.Error(t, err)
+}
+
+func contractConflicts(t *testing.T, r ToyNoteRepo) {
+	dev := mustSaveTag(t, r, "dev")
+	test := mustSaveTag(t, r, "test")
+	require.Equal(t, uint(1), dev.Version)
+
+	// a tag is updated only with its current version
+	dev, err := r.SaveTag(entity.Tag{UintId: dev.UintId, Description: "first", Version: dev.Version})
+	require.NoError(t, err)
+	require.Equal(t, uint(2), dev.Version)
+
+	_, err = r.SaveTag(entity.Tag{UintId: dev.UintId, Description: "stale", Version: 1})
+	var conflict *entity.ConflictError
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, uint(2), conflict.CurrentVersion)
+	require.Equal(t, "first", conflict.Current.(entity.Tag).Description)
+
+	// without a version, the last write wins
+	dev, err = r.SaveTag(entity.Tag{UintId: dev.UintId, Description: "unchecked"})
+	require.NoError(t, err)
+	require.Equal(t, uint(3), dev.Version)
+
+	p := mustSavePost(t, r, entity.Post{
+		Title:   "shared",
+		Content: "original",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: dev.UintId}},
+	})
+	require.Equal(t, uint(1), p.Version)
+
+	// two editors read version 1, the first one wins
+	first := mustSavePost(t, r, entity.Post{
+		UintId:  p.UintId,
+		Content: "edited by the first",
+		Tags:    []entity.Tag{{UintId: test.UintId}},
+		Version: 1,
+	})
+	require.Equal(t, uint(2), first.Version)
+
+	_, err = r.SavePost(entity.Post{
+		UintId:  p.UintId,
+		Content: "edited by the second",
+		Version: 1,
+	})
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, "post", conflict.Resource)
+	require.Equal(t, uint(1), conflict.Version)
+	require.Equal(t, uint(2), conflict.CurrentVersion)
+	current := conflict.Current.(entity.Post)
+	require.Equal(t, "edited by the first", current.Content)
+	require.Len(t, current.Tags, 1)
+	require.Equal(t, test.Id, current.Tags[0].Id)
+
+	// the stale write touched nothing, neither the post nor its associations
+	stored, err := r.GetPost(p.Id)
+	require.NoError(t, err)
+	require.Equal(t, "edited by the first", stored.Content)
+	require.Equal(t, uint(2), stored.Version)
+	require.Len(t, stored.Tags, 1)
+	require.Equal(t, test.Id, stored.Tags[0].Id)
+
+	// restoring a revision is an update as well
+	revisions, err := r.GetRevisions(p.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, uint(1), revisions.Items[1].Version)
+	restored, err := r.RestoreRevision(revisions.Items[1].Id)
+	require.NoError(t, err)
+	require.Equal(t, uint(3), restored.Version)
+	_, err = r.SavePost(entity.Post{UintId: p.UintId, Content: "stale", Version: 2})
+	require.ErrorAs(t, err, &conflict)
+}
+
+func contractAccounts(t *testing.T, r ToyNoteRepo) {
+	users := r.(UserRepo)
+	credentials := entity.Credentials{Name: "alice", Password: "correct horse"}
+
+	user, err := users.Register(credentials)
+	require.NoError(t, err)
+	require.NotEmpty(t, user.Id)
+	require.NotEqual(t, credentials.Password, user.PasswordHash)
+
+	_, err = users.Register(credentials)
+	require.Error(t, err)
+	_, err = users.Register(entity.Credentials{Name: "bob", Password: "short"})
+	require.Error(t, err)
+
+	// an unknown name and a wrong password are not distinguished
+	_, err = users.Login(entity.Credentials{Name: "alice", Password: "wrong password"})
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = users.Login(entity.Credentials{Name: "nobody", Password: "correct horse"})
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+	// nor by timing, the hash compared for an unknown name costs as much as a password's
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	require.NoError(t, err)
+	require.Equal(t, bcrypt.DefaultCost, cost)
+
+	tokens, err := users.Login(credentials)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer", tokens.TokenType)
+	require.Equal(t, int64(60), tokens.ExpiresIn)
+
+	authenticated, err := users.Authenticate(tokens.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, user.Id, authenticated.Id)
+	require.Equal(t, "alice", authenticated.Name)
+
+	_, err = users.Authenticate(tokens.AccessToken + "x")
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = users.Authenticate(tokens.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// refresh tokens are rotated
+	rotated, err := users.Refresh(tokens.RefreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, tokens.RefreshToken, rotated.RefreshToken)
+
+	// reusing a rotated token revokes every session of the user
+	another, err := users.Login(credentials)
+	require.NoError(t, err)
+	_, err = users.Refresh(tokens.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = users.Refresh(rotated.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = users.Refresh(another.RefreshToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// logout revokes the refresh token
+	tokens, err = users.Login(credentials)
+	require.NoError(t, err)
+	require.NoError(t, users.Logout(tokens.RefreshToken))
+	require.ErrorIs(t, users.Logout(tokens.RefreshToken), ErrInvalidToken)
+	_, err = users.Refresh("unknown")
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func contractOwnership(t *testing.T, r ToyNoteRepo) {
+	users := r.(UserRepo)
+	alice, err := users.Register(entity.Credentials{Name: "alice", Password: "alice password"})
+	require.NoError(t, err)
+	bob, err := users.Register(entity.Credentials{Name: "bob", Password: "bob password"})
+	require.NoError(t, err)
+	ra := r.ForOwner(alice.Id)
+	rb := r.ForOwner(bob.Id)
+
+	// tag names are unique per owner
+	aliceTag := mustSaveTag(t, ra, "golang")
+	bobTag := mustSaveTag(t, rb, "golang")
+	require.Equal(t, alice.Id, aliceTag.OwnerId)
+	_, err = ra.SaveTag(entity.Tag{Name: "golang"})
+	require.Error(t, err)
+
+	tags, err := rb.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	require.Equal(t, bobTag.Id, tags[0].Id)
+
+	// a tag of another user can neither be updated nor deleted
+	_, err = rb.SaveTag(entity.Tag{UintId: aliceTag.UintId, Description: "mine"})
+	require.Error(t, err)
+	require.Error(t, rb.DeleteTag(aliceTag.Id))
+
+	uploaded, err := ra.UploadAffiliate(bytes.NewReader([]byte("secret")), "secret.txt")
+	require.NoError(t, err)
+	post := mustSavePost(t, ra, entity.Post{
+		Title:      "golang secrets",
+		Content:    "only for alice",
+		Date:       time.Now(),
+		Tags:       []entity.Tag{{UintId: aliceTag.UintId}},
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	require.Equal(t, alice.Id, post.OwnerId)
+	require.Equal(t, alice.Id, post.Affiliates[0].OwnerId)
+	aid := post.Affiliates[0].Id
+
+	// bob finds nothing of alice
+	page, err := rb.GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, page.Items)
+	_, err = rb.GetPost(post.Id)
+	require.Error(t, err)
+	page, err = rb.SearchPostsByTags([]uint{aliceTag.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, page.Items)
+	page, err = rb.SearchPostsByTitle("golang", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, page.Items)
+	results, err := rb.SearchPosts("golang", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, results.Items)
+	page, err = rb.QueryPosts(entity.PostQuery{Text: "golang", Page: 1, Size: 10})
+	require.NoError(t, err)
+	require.Empty(t, page.Items)
+	_, err = rb.DownloadAffiliate(aid)
+	require.Error(t, err)
+	revisions, err := rb.GetRevisions(post.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, revisions.Items)
+
+	// nor can bob change it
+	_, err = rb.SavePost(entity.Post{UintId: post.UintId, Content: "hacked"})
+	require.Error(t, err)
+	_, err = rb.DeletePost(post.Id)
+	require.Error(t, err)
+
+	// nor bind alice's tags and affiliates to his own posts
+	_, err = rb.SavePost(entity.Post{
+		Title:   "stolen tag",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: aliceTag.UintId}},
+	})
+	require.Error(t, err)
+	_, err = rb.SavePost(entity.Post{
+		Title:      "stolen affiliate",
+		Content:    "content",
+		Date:       time.Now(),
+		Affiliates: []entity.Affiliate{{UintId: entity.UintId{Id: aid}}},
+	})
+	require.Error(t, err)
+
+	// while alice sees all of her own
+	page, err = ra.GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{post.Id}, postIds(page.Items))
+	results, err = ra.SearchPosts("golang", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, results.Items, 1)
+	fo, err := ra.DownloadAffiliate(aid)
+	require.NoError(t, err)
+	require.NoError(t, fo.Content.Close())
+
+	revisions, err = ra.GetRevisions(post.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, revisions.Items, 1)
+	require.NotNil(t, revisions.Items[0].AuthorId)
+	require.Equal(t, alice.Id, *revisions.Items[0].AuthorId)
+	_, err = rb.GetRevision(revisions.Items[0].Id)
+	require.Error(t, err)
+}
+
+// users registering at once, only one of them is the admin
+func contractFirstAdmin(t *testing.T, r ToyNoteRepo) {
+	users := r.(UserRepo)
+
+	const n = 8
+	registered := make([]entity.User, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			registered[i], errs[i] = users.Register(entity.Credentials{
+				Name:     fmt.Sprintf("user%d", i),
+				Password: "user password",
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	admins := 0
+	for i := range registered {
+		require.NoError(t, errs[i])
+		if registered[i].Role == entity.ROLE_ADMIN {
+			admins++
+		}
+	}
+	require.Equal(t, 1, admins)
+}
+
+func contractRoles(t *testing.T, r ToyNoteRepo) {
+	users := r.(UserRepo)
+
+	// the first user is the admin
+	admin, err := users.Register(entity.Credentials{Name: "admin", Password: "admin password"})
+	require.NoError(t, err)
+	require.Equal(t, entity.ROLE_ADMIN, admin.Role)
+	editor, err := users.Register(entity.Credentials{Name: "editor", Password: "editor password"})
+	require.NoError(t, err)
+	require.Equal(t, entity.ROLE_EDITOR, editor.Role)
+
+	// the role is carried by the access token
+	tokens, err := users.Login(entity.Credentials{Name: "editor", Password: "editor password"})
+	require.NoError(t, err)
+	authenticated, err := users.Authenticate(tokens.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, entity.ROLE_EDITOR, authenticated.Role)
+
+	// only admins grant roles, and never to themselves
+	_, err = users.SetRole(editor, admin.Id, entity.ROLE_READER)
+	require.ErrorIs(t, err, ErrForbidden)
+	_, err = users.SetRole(admin, admin.Id, entity.ROLE_READER)
+	require.Error(t, err)
+	_, err = users.SetRole(admin, editor.Id, entity.Role("owner"))
+	require.Error(t, err)
+	reader, err := users.SetRole(admin, editor.Id, entity.ROLE_READER)
+	require.NoError(t, err)
+	require.Equal(t, entity.ROLE_READER, reader.Role)
+
+	// a refreshed access token carries the new role
+	tokens, err = users.Refresh(tokens.RefreshToken)
+	require.NoError(t, err)
+	authenticated, err = users.Authenticate(tokens.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, entity.ROLE_READER, authenticated.Role)
+
+	// editors write their own notes
+	re := Guard(r, entity.ROLE_EDITOR, nil).ForOwner(editor.Id)
+	tag := mustSaveTag(t, re, "dev")
+	uploaded, err := re.UploadAffiliate(bytes.NewReader([]byte("orphan")), "orphan.txt")
+	require.NoError(t, err)
+	post := mustSavePost(t, re, entity.Post{
+		Title:      "written by an editor",
+		Content:    "content",
+		Date:       time.Now(),
+		Tags:       []entity.Tag{{UintId: tag.UintId}},
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	aid := post.Affiliates[0].Id
+
+	// readers only read
+	rr := Guard(r, entity.ROLE_READER, nil).ForOwner(editor.Id)
+	tags, err := rr.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	_, err = rr.GetPost(post.Id)
+	require.NoError(t, err)
+
+	_, err = rr.SaveTag(entity.Tag{Name: "ops"})
+	require.ErrorIs(t, err, ErrForbidden)
+	require.ErrorIs(t, rr.DeleteTag(tag.Id), ErrForbidden)
+	_, err = rr.SavePost(entity.Post{UintId: post.UintId, Content: "edited"})
+	require.ErrorIs(t, err, ErrForbidden)
+	_, err = rr.DeletePost(post.Id)
+	require.ErrorIs(t, err, ErrForbidden)
+	_, err = rr.UploadAffiliate(bytes.NewReader([]byte("file")), "file.txt")
+	require.ErrorIs(t, err, ErrForbidden)
+
+	// nothing was changed by the denied calls
+	stored, err := r.GetPost(post.Id)
+	require.NoError(t, err)
+	require.Equal(t, "content", stored.Content)
+
+	// admin operations are denied to editors
+	_, err = re.GetUnownedAffiliates(entity.NewPagination(1, 10))
+	require.ErrorIs(t, err, ErrForbidden)
+	require.ErrorIs(t, re.RebindAffiliate(post.Id, aid), ErrForbidden)
+	require.ErrorIs(t, re.DeleteUnownedAffiliates([]uint{aid}), ErrForbidden)
+	_, err = re.PurgeTrash(time.Now())
+	require.ErrorIs(t, err, ErrForbidden)
+
+	// while admins see the orphans of all users
+	post.Affiliates = []entity.Affiliate{}
+	_, err = re.SavePost(post)
+	require.NoError(t, err)
+
+	ra := Guard(r, entity.ROLE_ADMIN, nil)
+	unowned, err := ra.GetUnownedAffiliates(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Len(t, unowned.Items, 1)
+	require.Equal(t, aid, unowned.Items[0].Id)
+
+	// an orphan is only rebound to a post of the same owner
+	other := mustSavePost(t, r.ForOwner(admin.Id), entity.Post{
+		Title:   "written by the admin",
+		Content: "content",
+		Date:    time.Now(),
+	})
+	require.Error(t, ra.RebindAffiliate(other.Id, aid))
+	require.NoError(t, ra.RebindAffiliate(post.Id, aid))
+	unowned, err = ra.GetUnownedAffiliates(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, unowned.Items)
+}
+
+func contractShares(t *testing.T, r ToyNoteRepo) {
+	users := r.(UserRepo)
+	alice, err := users.Register(entity.Credentials{Name: "alice", Password: "alice password"})
+	require.NoError(t, err)
+	bob, err := users.Register(entity.Credentials{Name: "bob", Password: "bob password"})
+	require.NoError(t, err)
+	ra := r.ForOwner(alice.Id)
+	rb := r.ForOwner(bob.Id)
+
+	tag := mustSaveTag(t, ra, "public")
+	uploaded, err := ra.UploadAffiliate(bytes.NewReader([]byte("shared file")), "shared.txt")
+	require.NoError(t, err)
+	post := mustSavePost(t, ra, entity.Post{
+		Title:      "shared",
+		Content:    "hello visitors",
+		Date:       time.Now(),
+		Tags:       []entity.Tag{{UintId: tag.UintId}},
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	aid := post.Affiliates[0].Id
+	secret := mustSavePost(t, ra, entity.Post{Title: "secret", Content: "not shared", Date: time.Now()})
+
+	// only the owner shares a post
+	_, err = rb.CreateShare(entity.ShareRequest{PostId: post.Id})
+	require.Error(t, err)
+	past := time.Now().Add(-time.Hour)
+	_, err = ra.CreateShare(entity.ShareRequest{PostId: post.Id, ExpiresAt: &past})
+	require.Error(t, err)
+
+	link, err := ra.CreateShare(entity.ShareRequest{PostId: post.Id})
+	require.NoError(t, err)
+	require.NotEmpty(t, link.Token)
+	require.Equal(t, alice.Id, link.OwnerId)
+	require.False(t, link.Protected)
+
+	// anyone with the link sees the post, without anything internal
+	shared, err := r.OpenShare(link.Token, "", entity.FORMAT_MARKDOWN)
+	require.NoError(t, err)
+	require.Equal(t, "hello visitors", shared.Content)
+	require.Equal(t, []string{"public"}, shared.Tags)
+	require.Len(t, shared.Affiliates, 1)
+	require.Empty(t, shared.Affiliates[0].ObjectId)
+
+	fo, err := r.DownloadSharedAffiliate(link.Token, "", aid)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(fo.Content)
+	require.NoError(t, err)
+	require.NoError(t, fo.Content.Close())
+	require.Equal(t, "shared file", string(data))
+
+	_, err = r.OpenShare("unknown", "", entity.FORMAT_MARKDOWN)
+	require.ErrorIs(t, err, ErrShareNotFound)
+
+	// only the affiliates of the shared post can be downloaded
+	other, err := ra.UploadAffiliate(bytes.NewReader([]byte("other file")), "other.txt")
+	require.NoError(t, err)
+	secret.Affiliates = []entity.Affiliate{other}
+	secret = mustSavePost(t, ra, secret)
+	_, err = r.DownloadSharedAffiliate(link.Token, "", secret.Affiliates[0].Id)
+	require.ErrorIs(t, err, ErrShareNotFound)
+
+	// password protected, with expiry
+	future := time.Now().Add(time.Hour)
+	protected, err := ra.CreateShare(entity.ShareRequest{PostId: post.Id, Password: "open sesame", ExpiresAt: &future})
+	require.NoError(t, err)
+	require.True(t, protected.Protected)
+	_, err = r.OpenShare(protected.Token, "", entity.FORMAT_MARKDOWN)
+	require.ErrorIs(t, err, ErrSharePassword)
+	_, err = r.DownloadSharedAffiliate(protected.Token, "wrong", aid)
+	require.ErrorIs(t, err, ErrSharePassword)
+	_, err = r.OpenShare(protected.Token, "open sesame", entity.FORMAT_MARKDOWN)
+	require.NoError(t, err)
+
+	shares, err := ra.GetShares(post.Id)
+	require.NoError(t, err)
+	require.Len(t, shares, 2)
+	require.Equal(t, protected.Id, shares[0].Id)
+	shares, err = rb.GetShares(post.Id)
+	require.NoError(t, err)
+	require.Empty(t, shares)
+
+	// revoked by the owner only
+	require.Error(t, rb.RevokeShare(link.Id))
+	require.NoError(t, ra.RevokeShare(link.Id))
+	require.Error(t, ra.RevokeShare(link.Id))
+	_, err = r.OpenShare(link.Token, "", entity.FORMAT_MARKDOWN)
+	require.ErrorIs(t, err, ErrShareNotFound)
+
+	// a trashed post can't be opened, and its links are gone once purged
+	mustDeletePost(t, ra, post.Id)
+	_, err = r.OpenShare(protected.Token, "open sesame", entity.FORMAT_MARKDOWN)
+	require.ErrorIs(t, err, ErrShareNotFound)
+	require.NoError(t, ra.RestorePost(post.Id))
+	_, err = r.OpenShare(protected.Token, "open sesame", entity.FORMAT_MARKDOWN)
+	require.NoError(t, err)
+
+	mustDeletePost(t, ra, post.Id)
+	require.NoError(t, ra.PurgePost(post.Id))
+	shares, err = ra.GetShares(post.Id)
+	require.NoError(t, err)
+	require.Empty(t, shares)
+}
+
+func contractApiKeys(t *testing.T, r ToyNoteRepo) {
+	users := r.(UserRepo)
+
+	admin, err := users.Register(entity.Credentials{Name: "admin", Password: "admin password"})
+	require.NoError(t, err)
+	editor, err := users.Register(entity.Credentials{Name: "editor", Password: "editor password"})
+	require.NoError(t, err)
+	found, err := users.FindUser("editor")
+	require.NoError(t, err)
+	require.Equal(t, editor.Id, found.Id)
+
+	// scopes must be known
+	_, err = users.CreateApiKey(editor.Id, entity.ApiKeyRequest{Name: "bad", Scopes: entity.ScopeList{"posts:delete"}})
+	require.Error(t, err)
+	_, err = users.CreateApiKey(editor.Id, entity.ApiKeyRequest{Name: "none", Scopes: entity.ScopeList{}})
+	require.Error(t, err)
+
+	created, err := users.CreateApiKey(editor.Id, entity.ApiKeyRequest{
+		Name:   "reader script",
+		Scopes: entity.ScopeList{entity.SCOPE_POSTS_READ},
+	})
+	require.NoError(t, err)
+	require.True(t, IsApiKey(created.Key))
+	require.Contains(t, created.Key, created.Prefix)
+	require.NotContains(t, created.SecretHash, created.Key)
+
+	// only the prefix is listed, never the key
+	keys, err := users.GetApiKeys(editor.Id)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, created.Prefix, keys[0].Prefix)
+	require.Nil(t, keys[0].LastUsedAt)
+	keys, err = users.GetApiKeys(admin.Id)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	// the key authenticates its user, and the use is recorded
+	user, key, err := users.AuthenticateApiKey(created.Key)
+	require.NoError(t, err)
+	require.Equal(t, editor.Id, user.Id)
+	require.Equal(t, entity.ROLE_EDITOR, user.Role)
+	require.True(t, key.Allows(entity.SCOPE_POSTS_READ))
+	require.False(t, key.Allows(entity.SCOPE_POSTS_WRITE))
+	keys, err = users.GetApiKeys(editor.Id)
+	require.NoError(t, err)
+	require.NotNil(t, keys[0].LastUsedAt)
+
+	// a wrong secret or a malformed key is rejected
+	_, _, err = users.AuthenticateApiKey(created.Key + "x")
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, _, err = users.AuthenticateApiKey("tn_" + created.Prefix)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// the key is limited by its scopes
+	rk := Guard(r, user.Role, &key).ForOwner(editor.Id)
+	tag := mustSaveTag(t, r.ForOwner(editor.Id), "dev")
+	tags, err := rk.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	_, err = rk.SaveTag(entity.Tag{Name: "ops"})
+	require.ErrorIs(t, err, ErrForbidden)
+	require.ErrorIs(t, rk.DeleteTag(tag.Id), ErrForbidden)
+	_, err = rk.UploadAffiliate(bytes.NewReader([]byte("file")), "file.txt")
+	require.ErrorIs(t, err, ErrForbidden)
+
+	// and by the role of its user, whatever its scopes
+	writer, err := users.CreateApiKey(editor.Id, entity.ApiKeyRequest{
+		Name:   "writer script",
+		Scopes: entity.ScopeList{entity.SCOPE_POSTS_READ, entity.SCOPE_POSTS_WRITE},
+	})
+	require.NoError(t, err)
+	_, err = users.SetRole(admin, editor.Id, entity.ROLE_READER)
+	require.NoError(t, err)
+	user, key, err = users.AuthenticateApiKey(writer.Key)
+	require.NoError(t, err)
+	_, err = Guard(r, user.Role, &key).ForOwner(editor.Id).SaveTag(entity.Tag{Name: "ops"})
+	require.ErrorIs(t, err, ErrForbidden)
+
+	// keys are never admins
+	adminKey, err := users.CreateApiKey(admin.Id, entity.ApiKeyRequest{
+		Name:   "admin script",
+		Scopes: entity.ScopeList{entity.SCOPE_POSTS_READ, entity.SCOPE_POSTS_WRITE},
+	})
+	require.NoError(t, err)
+	user, key, err = users.AuthenticateApiKey(adminKey.Key)
+	require.NoError(t, err)
+	_, err = Guard(r, user.Role, &key).GetUnownedAffiliates(entity.NewPagination(1, 10))
+	require.ErrorIs(t, err, ErrForbidden)
+
+	// a key is only revoked by its owner, or by anyone with user id 0
+	require.Error(t, users.RevokeApiKey(admin.Id, created.Prefix))
+	require.NoError(t, users.RevokeApiKey(editor.Id, created.Prefix))
+	_, _, err = users.AuthenticateApiKey(created.Key)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.Error(t, users.RevokeApiKey(editor.Id, created.Prefix))
+	require.NoError(t, users.RevokeApiKey(0, writer.Prefix))
+	_, _, err = users.AuthenticateApiKey(writer.Key)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func contractRendering(t *testing.T, r ToyNoteRepo) {
+	post := mustSavePost(t, r, entity.Post{
+		Title:   "markdown",
+		Content: "# Heading\n\n<script>alert(1)</script>",
+		Date:    time.Now(),
+	})
+
+	posts := []entity.Post{post}
+	require.NoError(t, r.RenderPosts(posts))
+	require.Equal(t, "<h1>Heading</h1>\n", posts[0].ContentHtml)
+	require.Equal(t, post.Content, posts[0].Content)
+
+	// the HTML is cached in the revision of the version
+	revisions, err := r.GetRevisions(post.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, posts[0].ContentHtml, revisions.Items[0].ContentHtml)
+
+	// and fetched posts are not rendered unless asked for
+	fetched, err := r.GetPost(post.Id)
+	require.NoError(t, err)
+	require.Empty(t, fetched.ContentHtml)
+	posts = []entity.Post{fetched}
+	require.NoError(t, r.RenderPosts(posts))
+	require.Equal(t, "<h1>Heading</h1>\n", posts[0].ContentHtml)
+
+	// an update renders the new content
+	fetched.Content = "*emphasis*"
+	updated, err := r.SavePost(fetched)
+	require.NoError(t, err)
+	posts = []entity.Post{updated}
+	require.NoError(t, r.RenderPosts(posts))
+	require.Equal(t, "<p><em>emphasis</em></p>\n", posts[0].ContentHtml)
+
+	// a shared post is rendered on demand
+	link, err := r.CreateShare(entity.ShareRequest{PostId: post.Id})
+	require.NoError(t, err)
+	shared, err := r.OpenShare(link.Token, "", entity.FORMAT_MARKDOWN)
+	require.NoError(t, err)
+	require.Empty(t, shared.ContentHtml)
+	shared, err = r.OpenShare(link.Token, "", entity.FORMAT_HTML)
+	require.NoError(t, err)
+	require.Equal(t, "<p><em>emphasis</em></p>\n", shared.ContentHtml)
+}
+
+func contractReferences(t *testing.T, r ToyNoteRepo) {
+	uploaded, err := r.UploadAffiliate(bytes.NewReader([]byte("diagram")), "diagram.png")
+	require.NoError(t, err)
+	post := mustSavePost(t, r, entity.Post{
+		Title:      "with a diagram",
+		Content:    "content",
+		Date:       time.Now(),
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	bound := post.Affiliates[0]
+	other := mustSavePost(t, r, entity.Post{
+		Title:      "with another file",
+		Content:    "content",
+		Date:       time.Now(),
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	elsewhere := other.Affiliates[0]
+
+	// affiliates bound to the post can be referred to
+	saved, err := r.SavePost(entity.Post{
+		UintId:     post.UintId,
+		Content:    fmt.Sprintf("![diagram](affiliate:%d)", bound.Id),
+		Affiliates: []entity.Affiliate{bound},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint{bound.Id}, util.AffiliateRefs(saved.Content))
+
+	// but not affiliates of other posts, nor unknown ones
+	var refErr *entity.ReferenceError
+	_, err = r.SavePost(entity.Post{
+		UintId:     post.UintId,
+		Content:    fmt.Sprintf("[spec](affiliate:%d) [gone](affiliate:%d)", elsewhere.Id, 999),
+		Affiliates: []entity.Affiliate{bound},
+	})
+	require.ErrorAs(t, err, &refErr)
+	require.False(t, refErr.Unbinding)
+	require.Equal(t, []uint{elsewhere.Id, 999}, refErr.AffiliateIds)
+	_, err = r.SavePost(entity.Post{
+		Title:   "new post",
+		Content: fmt.Sprintf("[spec](affiliate:%d)", elsewhere.Id),
+		Date:    time.Now(),
+	})
+	require.ErrorAs(t, err, &refErr)
+
+	// an affiliate still referred to can't be unbound, even if the content is kept as it is
+	_, err = r.SavePost(entity.Post{UintId: post.UintId, Affiliates: []entity.Affiliate{}})
+	require.ErrorAs(t, err, &refErr)
+	require.True(t, refErr.Unbinding)
+	require.Equal(t, []uint{bound.Id}, refErr.AffiliateIds)
+
+	stored, err := r.GetPost(post.Id)
+	require.NoError(t, err)
+	require.Len(t, stored.Affiliates, 1)
+	require.Equal(t, saved.Version, stored.Version)
+
+	// once the reference is removed along with it
+	_, err = r.SavePost(entity.Post{UintId: post.UintId, Content: "no diagram", Affiliates: []entity.Affiliate{}})
+	require.NoError(t, err)
+}
+
+func linkedIds(linked []entity.LinkedPost) []uint {
+	ids := []uint{}
+	for _, l := range linked {
+		ids = append(ids, l.PostId)
+	}
+	return ids
+}
+
+func contractLinks(t *testing.T, r ToyNoteRepo) {
+	golang := mustSavePost(t, r, entity.Post{Title: "Golang", Content: "content", Date: time.Now()})
+	index := mustSavePost(t, r, entity.Post{
+		Title:   "index",
+		Content: fmt.Sprintf("see [[golang]], [[post:%d]] and [[Rust]]\n\n`[[in code]]`", golang.Id),
+		Date:    time.Now(),
+	})
+
+	// links by title are case-insensitive, a link to a missing post is broken
+	links, err := r.GetPostLinks(index.Id)
+	require.NoError(t, err)
+	require.Equal(t, []entity.LinkedPost{
+		{Link: "golang", PostId: golang.Id, Title: "Golang"},
+		{Link: fmt.Sprintf("post:%d", golang.Id), PostId: golang.Id, Title: "Golang"},
+		{Link: "Rust", Broken: true},
+	}, links)
+
+	backlinks, err := r.GetBacklinks(golang.Id)
+	require.NoError(t, err)
+	require.Equal(t, []uint{index.Id, index.Id}, linkedIds(backlinks))
+
+	// a link by title follows a post created afterwards, and the oldest post of a title wins
+	rust := mustSavePost(t, r, entity.Post{Title: "rust", Content: "content", Date: time.Now()})
+	mustSavePost(t, r, entity.Post{Title: "Rust", Content: "newer", Date: time.Now()})
+	links, err = r.GetPostLinks(index.Id)
+	require.NoError(t, err)
+	require.Equal(t, []uint{golang.Id, golang.Id, rust.Id}, linkedIds(links))
+	require.False(t, links[2].Broken)
+
+	// links are replaced once the post is saved
+	_, err = r.SavePost(entity.Post{UintId: index.UintId, Content: "only [[rust]]"})
+	require.NoError(t, err)
+	backlinks, err = r.GetBacklinks(golang.Id)
+	require.NoError(t, err)
+	require.Empty(t, backlinks)
+	backlinks, err = r.GetBacklinks(rust.Id)
+	require.NoError(t, err)
+	require.Equal(t, []entity.LinkedPost{{Link: "rust", PostId: index.Id, Title: "index"}}, backlinks)
+
+	// deleting a linked post reports the links broken by it
+	broken := mustDeletePost(t, r, rust.Id)
+	require.Equal(t, []entity.LinkedPost{{Link: "rust", PostId: index.Id, Title: "index", Broken: true}}, broken)
+	links, err = r.GetPostLinks(index.Id)
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	require.NotEqual(t, rust.Id, links[0].PostId)
+
+	// a trashed post has no backlinks, nor are its links shown as backlinks
+	_, err = r.GetBacklinks(rust.Id)
+	require.Error(t, err)
+	mustDeletePost(t, r, index.Id)
+	backlinks, err = r.GetBacklinks(golang.Id)
+	require.NoError(t, err)
+	require.Empty(t, backlinks)
+
+	// posts of other users are never linked
+	users := r.(UserRepo)
+	alice, err := users.Register(entity.Credentials{Name: "alice", Password: "alice password"})
+	require.NoError(t, err)
+	ra := r.ForOwner(alice.Id)
+	mine := mustSavePost(t, ra, entity.Post{
+		Title:   "mine",
+		Content: fmt.Sprintf("[[Golang]] [[post:%d]]", golang.Id),
+		Date:    time.Now(),
+	})
+	links, err = ra.GetPostLinks(mine.Id)
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	require.True(t, links[0].Broken)
+	require.True(t, links[1].Broken)
+	_, err = ra.GetBacklinks(golang.Id)
+	require.Error(t, err)
+}
+
+func mustSaveNotebook(t *testing.T, r ToyNoteRepo, name string, parentId *uint) entity.Notebook {
+	notebook, err := r.SaveNotebook(entity.Notebook{Name: name, ParentId: parentId})
+	require.NoError(t, err)
+	require.NotEmpty(t, notebook.Id)
+	return notebook
+}
+
+func contractNotebooks(t *testing.T, r ToyNoteRepo) {
+	work := mustSaveNotebook(t, r, "work", nil)
+	projects := mustSaveNotebook(t, r, "projects", &work.Id)
+	home := mustSaveNotebook(t, r, "home", nil)
+
+	_, err := r.SaveNotebook(entity.Notebook{Name: " "})
+	require.Error(t, err)
+	missing := uint(999)
+	_, err = r.SaveNotebook(entity.Notebook{Name: "orphan", ParentId: &missing})
+	require.Error(t, err)
+
+	// a notebook can't be nested in itself, nor in its descendants
+	_, err = r.SaveNotebook(entity.Notebook{UintId: work.UintId, Name: "work", ParentId: &work.Id})
+	require.Error(t, err)
+	_, err = r.SaveNotebook(entity.Notebook{UintId: work.UintId, Name: "work", ParentId: &projects.Id})
+	require.Error(t, err)
+
+	inWork := mustSavePost(t, r, entity.Post{Title: "standup", Content: "notes", Date: time.Now(), NotebookId: &work.Id})
+	inProjects := mustSavePost(t, r, entity.Post{Title: "roadmap", Content: "notes", Date: time.Now(), NotebookId: &projects.Id})
+	atRoot := mustSavePost(t, r, entity.Post{Title: "groceries", Content: "notes", Date: time.Now()})
+	_, err = r.SavePost(entity.Post{Title: "lost", Content: "notes", Date: time.Now(), NotebookId: &missing})
+	require.Error(t, err)
+
+	// updating a post without a notebook keeps it where it is
+	updated, err := r.SavePost(entity.Post{UintId: inWork.UintId, Content: "more notes"})
+	require.NoError(t, err)
+	require.Equal(t, work.Id, *updated.NotebookId)
+
+	tree, err := r.GetNotebookTree()
+	require.NoError(t, err)
+	require.Len(t, tree, 2)
+	require.Equal(t, "home", tree[0].Name)
+	require.Equal(t, "work", tree[1].Name)
+	require.Equal(t, int64(1), tree[1].PostCount)
+	require.Len(t, tree[1].Children, 1)
+	require.Equal(t, projects.Id, tree[1].Children[0].Id)
+	require.Equal(t, int64(1), tree[1].Children[0].PostCount)
+
+	// listings and searches are scoped to a notebook, optionally recursive
+	page, err := r.InNotebook(entity.NotebookScope{NotebookId: work.Id}).GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{inWork.Id}, postIds(page.Items))
+	page, err = r.InNotebook(entity.NotebookScope{NotebookId: work.Id, Recursive: true}).GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint{inWork.Id, inProjects.Id}, postIds(page.Items))
+	require.Equal(t, int64(2), page.Total)
+
+	page, err = r.InNotebook(entity.NotebookScope{NotebookId: projects.Id}).SearchPostsByTitle("road", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{inProjects.Id}, postIds(page.Items))
+	page, err = r.InNotebook(entity.NotebookScope{NotebookId: home.Id}).QueryPosts(entity.PostQuery{Text: "notes", Page: 1, Size: 10})
+	require.NoError(t, err)
+	require.Empty(t, page.Items)
+	results, err := r.InNotebook(entity.NotebookScope{NotebookId: work.Id, Recursive: true}).SearchPosts("notes", entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), results.Total)
+
+	// moving a post keeps its version
+	moved, err := r.MovePost(atRoot.Id, entity.Destination{NotebookId: &home.Id})
+	require.NoError(t, err)
+	require.Equal(t, home.Id, *moved.NotebookId)
+	require.Equal(t, atRoot.Version, moved.Version)
+	_, err = r.MovePost(atRoot.Id, entity.Destination{NotebookId: &missing})
+	require.Error(t, err)
+
+	// a notebook which is not empty is not deleted, trashed posts included
+	mustDeletePost(t, r, moved.Id)
+	require.ErrorIs(t, r.DeleteNotebook(home.Id, nil), entity.ErrNotebookNotEmpty)
+	require.ErrorIs(t, r.DeleteNotebook(work.Id, nil), entity.ErrNotebookNotEmpty)
+
+	// unless its contents are moved, but not into itself
+	require.Error(t, r.DeleteNotebook(work.Id, &entity.Destination{NotebookId: &projects.Id}))
+	require.NoError(t, r.DeleteNotebook(home.Id, &entity.Destination{}))
+	require.NoError(t, r.RestorePost(moved.Id))
+	restored, err := r.GetPost(moved.Id)
+	require.NoError(t, err)
+	require.Nil(t, restored.NotebookId)
+
+	require.NoError(t, r.DeleteNotebook(work.Id, &entity.Destination{}))
+	_, err = r.GetNotebook(work.Id)
+	require.Error(t, err)
+	stored, err := r.GetNotebook(projects.Id)
+	require.NoError(t, err)
+	require.Nil(t, stored.ParentId)
+	standup, err := r.GetPost(inWork.Id)
+	require.NoError(t, err)
+	require.Nil(t, standup.NotebookId)
+
+	// moved to the root, then to another notebook
+	moved, err = r.MovePost(inProjects.Id, entity.Destination{})
+	require.NoError(t, err)
+	require.Nil(t, moved.NotebookId)
+	require.NoError(t, r.DeleteNotebook(projects.Id, nil))
+
+	// notebooks of other users can neither be found nor used
+	users := r.(UserRepo)
+	alice, err := users.Register(entity.Credentials{Name: "alice", Password: "alice password"})
+	require.NoError(t, err)
+	ra := r.ForOwner(alice.Id)
+	mine := mustSaveNotebook(t, ra, "mine", nil)
+	rb := r.ForOwner(alice.Id + 1)
+	_, err = rb.GetNotebook(mine.Id)
+	require.Error(t, err)
+	theirs := mustSavePost(t, rb, entity.Post{Title: "theirs", Content: "notes", Date: time.Now()})
+	_, err = rb.MovePost(theirs.Id, entity.Destination{NotebookId: &mine.Id})
+	require.Error(t, err)
+	_, err = rb.SavePost(entity.Post{Title: "theirs", Content: "notes", Date: time.Now(), NotebookId: &mine.Id})
+	require.Error(t, err)
+	_, err = rb.SaveNotebook(entity.Notebook{Name: "nested", ParentId: &mine.Id})
+	require.Error(t, err)
+	tree, err = ra.GetNotebookTree()
+	require.NoError(t, err)
+	require.Len(t, tree, 1)
+}
+
+func mustSaveChildTag(t *testing.T, r ToyNoteRepo, name string, parentId uint) entity.Tag {
+	tag, err := r.SaveTag(entity.Tag{Name: name, ParentId: &parentId})
+	require.NoError(t, err)
+	require.Equal(t, parentId, *tag.ParentId)
+	return tag
+}
+
+func contractTagHierarchy(t *testing.T, r ToyNoteRepo) {
+	lang := mustSaveTag(t, r, "lang")
+	golang := mustSaveChildTag(t, r, "go", lang.Id)
+	generics := mustSaveChildTag(t, r, "generics", golang.Id)
+	rust := mustSaveChildTag(t, r, "rust", lang.Id)
+	misc := mustSaveTag(t, r, "misc")
+
+	missing := uint(999)
+	_, err := r.SaveTag(entity.Tag{Name: "orphan", ParentId: &missing})
+	require.Error(t, err)
+
+	// a tag can't be nested in itself, nor in its descendants
+	_, err = r.SaveTag(entity.Tag{UintId: lang.UintId, ParentId: &lang.Id})
+	require.Error(t, err)
+	_, err = r.SaveTag(entity.Tag{UintId: lang.UintId, ParentId: &generics.Id})
+	require.Error(t, err)
+
+	// an update without a parent keeps the current one
+	updated, err := r.SaveTag(entity.Tag{UintId: golang.UintId, Description: "gopher"})
+	require.NoError(t, err)
+	require.Equal(t, lang.Id, *updated.ParentId)
+
+	p1 := mustSavePost(t, r, entity.Post{
+		Title:   "p1",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: generics.UintId}},
+	})
+	p2 := mustSavePost(t, r, entity.Post{
+		Title:   "p2",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: rust.UintId}, {UintId: misc.UintId}},
+	})
+
+	posts, err := r.SearchPostsByTags([]uint{lang.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Empty(t, posts.Items)
+
+	// descendants at any depth are included
+	posts, err = r.SearchPostsByTags([]uint{lang.Id}, true, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint{p1.Id, p2.Id}, postIds(posts.Items))
+
+	posts, err = r.SearchPostsByTags([]uint{lang.Id, misc.Id}, true, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{p2.Id}, postIds(posts.Items))
+
+	posts, err = r.SearchPostsByTags([]uint{golang.Id, lang.Id}, true, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{p1.Id}, postIds(posts.Items))
+
+	page, err := r.QueryPosts(entity.PostQuery{
+		TagIds:         []uint{lang.Id},
+		ExcludeTagIds:  []uint{golang.Id},
+		TagDescendants: true,
+		Size:           10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint{p2.Id}, postIds(page.Items))
+
+	// 0 moves a tag to the top
+	moved, err := r.SaveTag(entity.Tag{UintId: rust.UintId, ParentId: new(uint)})
+	require.NoError(t, err)
+	require.Nil(t, moved.ParentId)
+	posts, err = r.SearchPostsByTags([]uint{lang.Id}, true, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, []uint{p1.Id}, postIds(posts.Items))
+
+	// the tags nested in a deleted tag are moved to its parent
+	require.NoError(t, r.DeleteTag(golang.Id))
+	tags, err := r.GetTags()
+	require.NoError(t, err)
+	for _, tag := range tags {
+		if tag.Id == generics.Id {
+			require.Equal(t, lang.Id, *tag.ParentId)
+		}
+	}
+}
+
+func contractMergeTags(t *testing.T, r ToyNoteRepo) {
+	golang := mustSaveTag(t, r, "golang")
+	goTag := mustSaveTag(t, r, "go")
+	upper := mustSaveTag(t, r, "Go")
+	generics := mustSaveChildTag(t, r, "generics", goTag.Id)
+
+	p1 := mustSavePost(t, r, entity.Post{
+		Title:   "p1",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: goTag.UintId}},
+	})
+	p2 := mustSavePost(t, r, entity.Post{
+		Title:   "p2",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: golang.UintId}, {UintId: upper.UintId}},
+	})
+
+	// neither into itself, nor into a tag nested in a source
+	_, err := r.MergeTags([]uint{golang.Id}, golang.Id)
+	require.Error(t, err)
+	_, err = r.MergeTags([]uint{goTag.Id}, generics.Id)
+	require.Error(t, err)
+	_, err = r.MergeTags([]uint{999}, golang.Id)
+	require.Error(t, err)
+
+	target, err := r.MergeTags([]uint{goTag.Id, upper.Id}, golang.Id)
+	require.NoError(t, err)
+	require.Equal(t, golang.Id, target.Id)
+
+	tags, err := r.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 2)
+
+	// a post bound to both a source and the target is bound to the target once
+	post, err := r.GetPost(p2.Id)
+	require.NoError(t, err)
+	require.Len(t, post.Tags, 1)
+	require.Equal(t, golang.Id, post.Tags[0].Id)
+
+	posts, err := r.SearchPostsByTags([]uint{golang.Id}, false, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint{p1.Id, p2.Id}, postIds(posts.Items))
+
+	nested, err := r.SaveTag(entity.Tag{UintId: generics.UintId, Color: "blue"})
+	require.NoError(t, err)
+	require.Equal(t, golang.Id, *nested.ParentId)
+
+	// tags of different users can't be merged
+	users := r.(UserRepo)
+	alice, err := users.Register(entity.Credentials{Name: "alice", Password: "alice password"})
+	require.NoError(t, err)
+	aliceTag := mustSaveTag(t, r.ForOwner(alice.Id), "golang")
+	_, err = r.MergeTags([]uint{aliceTag.Id}, golang.Id)
+	require.Error(t, err)
+}
+
+// read all the files of a zip archive by their names
+func readZip(t *testing.T, data []byte) map[string]string {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func contractExport(t *testing.T, r ToyNoteRepo) {
+	lang := mustSaveTag(t, r, "lang")
+	golang := mustSaveChildTag(t, r, "go", lang.Id)
+	uploaded, err := r.UploadAffiliate(bytes.NewReader([]byte("png bytes")), "my diagram.png")
+	require.NoError(t, err)
+
+	post := mustSavePost(t, r, entity.Post{
+		Title:      "Hello, World!",
+		Subtitle:   "greetings",
+		Content:    "a diagram follows",
+		Date:       time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC),
+		Tags:       []entity.Tag{{UintId: golang.UintId}},
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	aid := post.Affiliates[0].Id
+	post = mustSavePost(t, r, entity.Post{
+		UintId:     post.UintId,
+		Content:    fmt.Sprintf("![diagram](affiliate:%d) `affiliate:%d`", aid, aid),
+		Tags:       post.Tags,
+		Affiliates: post.Affiliates,
+	})
+
+	var buf bytes.Buffer
+	export, err := NewPostExport(r, post.Id)
+	require.NoError(t, err)
+	require.NoError(t, export.WriteZip(&buf))
+	files := readZip(t, buf.Bytes())
+	require.Len(t, files, 2)
+
+	attachment := fmt.Sprintf("attachments/%d-my-diagram.png", aid)
+	require.Equal(t, "png bytes", files[attachment])
+	md := files[fmt.Sprintf("%d-hello-world.md", post.Id)]
+	require.Contains(t, md, "---\nid: ")
+	require.Contains(t, md, "title: Hello, World!\n")
+	require.Contains(t, md, "subtitle: greetings\n")
+	require.Contains(t, md, "date: 2022-03-01T00:00:00Z\n")
+	require.Contains(t, md, "tags:\n    - lang/go\n")
+	require.Contains(t, md, "attachments:\n    - "+attachment+"\n")
+	require.Contains(t, md, "created_at: ")
+	require.Contains(t, md, fmt.Sprintf("---\n\n![diagram](%s) `affiliate:%d`", attachment, aid))
+
+	_, err = NewPostExport(r, 999)
+	require.Error(t, err)
+
+	// more posts than a page, either by cursor or by page
+	for i := 0; i < exportPageSize; i++ {
+		mustSavePost(t, r, entity.Post{Title: fmt.Sprintf("post %d", i), Content: "content", Date: time.Now()})
+	}
+	for _, sort := range []entity.SortField{entity.SORT_DATE, entity.SORT_TITLE} {
+		buf.Reset()
+		export, err = NewExport(r, entity.PostQuery{Sort: sort})
+		require.NoError(t, err)
+		require.NoError(t, export.WriteZip(&buf))
+		require.Len(t, readZip(t, buf.Bytes()), exportPageSize+2)
+	}
+
+	// filtered by the search criteria
+	buf.Reset()
+	export, err = NewExport(r, entity.PostQuery{TagIds: []uint{lang.Id}, TagDescendants: true})
+	require.NoError(t, err)
+	require.NoError(t, export.WriteZip(&buf))
+	require.Len(t, readZip(t, buf.Bytes()), 2)
+
+	_, err = NewExport(r, entity.PostQuery{Sort: "nonsense"})
+	require.Error(t, err)
+}
+
+func writeTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func mustDownload(t *testing.T, r ToyNoteRepo, id uint) string {
+	fo, err := r.DownloadAffiliate(id)
+	require.NoError(t, err)
+	defer fo.Content.Close()
+	data, err := ioutil.ReadAll(fo.Content)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func mustImport(t *testing.T, r ToyNoteRepo, data []byte, dryRun bool) entity.ImportReport {
+	im, err := NewImport(r, bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	report, err := im.Run(dryRun)
+	require.NoError(t, err)
+	return report
+}
+
+// datedRepo
+//
+// Rejects saving a post along with dated tags or affiliates, the same as the `Dates` hooks
+// do once gorm saves the associations
+type datedRepo struct {
+	ToyNoteRepo
+}
+
+func (r datedRepo) SavePost(post entity.Post) (entity.Post, error) {
+	for _, t := range post.Tags {
+		if !t.CreatedAt.IsZero() || !t.UpdatedAt.IsZero() {
+			return entity.Post{}, errors.New("can't manually set CreatedAt or UpdatedAt")
+		}
+	}
+	for _, a := range post.Affiliates {
+		if !a.CreatedAt.IsZero() || !a.UpdatedAt.IsZero() {
+			return entity.Post{}, errors.New("can't manually set CreatedAt or UpdatedAt")
+		}
+	}
+	return r.ToyNoteRepo.SavePost(post)
+}
+
+func TestImportRewritesLinksWithoutDates(t *testing.T) {
+	r := datedRepo{newMemoryRepo(t)}
+	archive := writeTarGz(t, map[string]string{
+		"a.md":  "---\ntitle: A\ndate: 2021-05-04T10:00:00Z\ntags: [lang]\n---\n\n![p](p.png)\n",
+		"p.png": "p bytes",
+	})
+
+	report := mustImport(t, r, archive, false)
+	require.Equal(t, 1, report.Created, report.Files[0].Error)
+	post, err := r.GetPost(report.Files[0].PostId)
+	require.NoError(t, err)
+	require.Len(t, post.Tags, 1)
+	require.Len(t, post.Affiliates, 1)
+	require.Equal(t, fmt.Sprintf("![p](affiliate:%d)\n", post.Affiliates[0].Id), post.Content)
+}
+
+func contractImport(t *testing.T, r ToyNoteRepo) {
+	// an export is imported back, once its post is trashed
+	lang := mustSaveTag(t, r, "lang")
+	golang := mustSaveChildTag(t, r, "go", lang.Id)
+	uploaded, err := r.UploadAffiliate(bytes.NewReader([]byte("png bytes")), "diagram.png")
+	require.NoError(t, err)
+	post := mustSavePost(t, r, entity.Post{
+		Title:      "Exported",
+		Content:    "draft",
+		Date:       time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC),
+		Tags:       []entity.Tag{{UintId: golang.UintId}},
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	aid := post.Affiliates[0].Id
+	post = mustSavePost(t, r, entity.Post{
+		UintId:     post.UintId,
+		Content:    fmt.Sprintf("![diagram](affiliate:%d)", aid),
+		Tags:       []entity.Tag{{UintId: golang.UintId}},
+		Affiliates: []entity.Affiliate{{UintId: entity.UintId{Id: aid}}},
+	})
+
+	var buf bytes.Buffer
+	export, err := NewPostExport(r, post.Id)
+	require.NoError(t, err)
+	require.NoError(t, export.WriteZip(&buf))
+
+	report := mustImport(t, r, buf.Bytes(), false)
+	require.Equal(t, 0, report.Created)
+	require.Equal(t, 1, report.Duplicates)
+	require.Equal(t, post.Id, report.Files[0].PostId)
+
+	mustDeletePost(t, r, post.Id)
+	report = mustImport(t, r, buf.Bytes(), false)
+	require.Equal(t, 1, report.Created)
+	require.Empty(t, report.TagsCreated)
+	imported, err := r.GetPost(report.Files[0].PostId)
+	require.NoError(t, err)
+	require.Equal(t, "Exported", imported.Title)
+	require.True(t, post.Date.Equal(imported.Date))
+	require.Len(t, imported.Tags, 1)
+	require.Equal(t, golang.Id, imported.Tags[0].Id)
+	require.Len(t, imported.Affiliates, 1)
+	require.Equal(t, "png bytes", mustDownload(t, r, imported.Affiliates[0].Id))
+	require.Equal(t, fmt.Sprintf("![diagram](affiliate:%d)", imported.Affiliates[0].Id), imported.Content)
+
+	// a hand-made tarball, a file failed doesn't abort the others
+	archive := writeTarGz(t, map[string]string{
+		"notes/a.md": "---\ntitle: First\ndate: 2021-05-04T10:00:00Z\ntags: [topic/sub, lang]\n" +
+			"attachments: [img/p.png]\n---\n\n![p](img/p.png) [doc](../files/d%20e.txt) [web](https://example.com)\n",
+		"notes/img/p.png": "p bytes",
+		"files/d e.txt":   "d bytes",
+		"notes/b.md":      "no front matter",
+		"notes/bad.md":    "---\ntitle: [broken\n---\n",
+		"notes/gone.md":   "---\ntitle: Gone\nattachments: [missing.png]\n---\n",
+		"notes/z.md":      "---\ntitle: First\ndate: 2021-05-04T10:00:00.5Z\n---\nthe same as a.md",
+	})
+
+	report = mustImport(t, r, archive, true)
+	require.True(t, report.DryRun)
+	require.Equal(t, 2, report.Created)
+	require.Equal(t, 1, report.Duplicates)
+	require.Equal(t, 2, report.Failed)
+	require.Equal(t, []string{"topic", "topic/sub"}, report.TagsCreated)
+	require.Len(t, report.Files, 5)
+	require.Equal(t, "notes/a.md", report.Files[0].Path)
+	require.Equal(t, entity.IMPORT_CREATED, report.Files[0].Status)
+	require.Empty(t, report.Files[0].PostId)
+	require.Equal(t, []string{"topic/sub", "lang"}, report.Files[0].Tags)
+	require.Equal(t, []string{"notes/img/p.png", "files/d e.txt"}, report.Files[0].Attachments)
+	require.Equal(t, "b", report.Files[1].Title)
+	require.Equal(t, entity.IMPORT_FAILED, report.Files[2].Status)
+	require.Contains(t, report.Files[2].Error, "front matter")
+	require.Equal(t, entity.IMPORT_FAILED, report.Files[3].Status)
+	require.Contains(t, report.Files[3].Error, "missing.png")
+	require.Equal(t, entity.IMPORT_DUPLICATE, report.Files[4].Status)
+	// nothing is written in a dry run
+	tags, err := r.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 2)
+	page, err := r.GetPosts(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, page.Total)
+
+	report = mustImport(t, r, archive, false)
+	require.Equal(t, 2, report.Created)
+	require.Equal(t, []string{"topic", "topic/sub"}, report.TagsCreated)
+	first, err := r.GetPost(report.Files[0].PostId)
+	require.NoError(t, err)
+	require.Len(t, first.Tags, 2)
+	require.Len(t, first.Affiliates, 2)
+	files := make(map[string]uint)
+	for _, a := range first.Affiliates {
+		files[a.Filename] = a.Id
+	}
+	require.Equal(t, "p bytes", mustDownload(t, r, files["p.png"]))
+	require.Equal(t, "d bytes", mustDownload(t, r, files["d e.txt"]))
+	require.Equal(t, fmt.Sprintf(
+		"![p](affiliate:%d) [doc](affiliate:%d) [web](https://example.com)\n", files["p.png"], files["d e.txt"],
+	), first.Content)
+
+	tags, err = r.GetTags()
+	require.NoError(t, err)
+	byName := make(map[string]entity.Tag)
+	for _, tag := range tags {
+		byName[tag.Name] = tag
+	}
+	require.Equal(t, byName["topic"].Id, *byName["sub"].ParentId)
+
+	// imported again, all duplicates
+	report = mustImport(t, r, archive, false)
+	require.Equal(t, 0, report.Created)
+	require.Equal(t, 3, report.Duplicates)
+	require.Equal(t, first.Id, report.Files[0].PostId)
+
+	// tags are matched along their paths, a tag nested elsewhere is a conflict
+	report = mustImport(t, r, writeTarGz(t, map[string]string{
+		"moved.md":  "---\ntitle: Moved\ntags: [other/topic]\n---\n",
+		"top.md":    "---\ntitle: Top\ntags: [sub]\n---\n",
+		"nested.md": "---\ntitle: Nested\ntags: [topic/sub, new/a, fresh/a]\n---\n",
+	}), false)
+	require.Equal(t, 0, report.Created)
+	require.Equal(t, 3, report.Failed)
+	require.Contains(t, report.Files[0].Error, "topic is nested elsewhere")
+	require.Contains(t, report.Files[1].Error, "a is nested elsewhere")
+	require.Contains(t, report.Files[2].Error, "sub is nested elsewhere")
+	require.Empty(t, report.TagsCreated)
+
+	_, err = NewImport(r, bytes.NewReader([]byte("not an archive")), 14)
+	require.ErrorIs(t, err, ErrBadArchive)
+	_, err = NewImport(r, bytes.NewReader([]byte("PK broken")), 9)
+	require.ErrorIs(t, err, ErrBadArchive)
+}
+
+// failingRepo
+//
+// Fails saving the posts with a title, and records the files uploaded
+type failingRepo struct {
+	ToyNoteRepo
+	title    string
+	uploaded *[]entity.Affiliate
+}
+
+func (r failingRepo) SavePost(post entity.Post) (entity.Post, error) {
+	if post.Title == r.title {
+		return entity.Post{}, errors.New("post is not saved")
+	}
+	return r.ToyNoteRepo.SavePost(post)
+}
+
+func (r failingRepo) UploadAffiliate(reader io.Reader, filename string) (entity.Affiliate, error) {
+	affiliate, err := r.ToyNoteRepo.UploadAffiliate(reader, filename)
+	if err == nil {
+		*r.uploaded = append(*r.uploaded, affiliate)
+	}
+	return affiliate, err
+}
+
+func contractImportDiscards(t *testing.T, r ToyNoteRepo) {
+	uploaded := []entity.Affiliate{}
+	report := mustImport(t, failingRepo{ToyNoteRepo: r, title: "Fails", uploaded: &uploaded}, writeTarGz(t, map[string]string{
+		"fails.md":   "---\ntitle: Fails\ntags: [gone/deep, kept]\nattachments: [f.png, shared.png]\n---\n",
+		"saved.md":   "---\ntitle: Saved\ntags: [kept]\nattachments: [shared.png]\n---\n",
+		"f.png":      "f bytes",
+		"shared.png": "shared bytes",
+	}), false)
+	require.Equal(t, 1, report.Created)
+	require.Equal(t, 1, report.Failed)
+	require.Contains(t, report.Files[0].Error, "post is not saved")
+	require.Len(t, uploaded, 2)
+
+	// only the tags of the post saved are created
+	require.Equal(t, []string{"kept"}, report.TagsCreated)
+	tags, err := r.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	require.Equal(t, "kept", tags[0].Name)
+
+	// the file shared with the post saved is kept
+	saved, err := r.GetPost(report.Files[1].PostId)
+	require.NoError(t, err)
+	require.Len(t, saved.Affiliates, 1)
+	require.Equal(t, "shared bytes", mustDownload(t, r, saved.Affiliates[0].Id))
+
+	// the file only the note failed is bound to is gone from the blob store
+	for _, a := range uploaded {
+		if a.Filename != "f.png" {
+			continue
+		}
+		post := mustSavePost(t, r, entity.Post{Title: "Bound", Affiliates: []entity.Affiliate{a}})
+		_, err := r.DownloadAffiliate(post.Affiliates[0].Id)
+		require.Error(t, err)
+	}
+}
+
+func contractImportEnex(t *testing.T, r ToyNoteRepo) {
+	mustSaveTag(t, r, "travel")
+	png, pdf := []byte("png bytes"), []byte("pdf bytes")
+	enex := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20220401T000000Z" application="Evernote" version="10.0">
+<note>
+	<title>Trip</title>
+	<created>20220301T101500Z</created>
+	<updated>20220302T101500Z</updated>
+	<tag>travel</tag>
+	<tag>plans</tag>
+	<content><![CDATA[<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div>Day <b>one</b></div><div><en-media hash="%x" type="image/png"/></div></en-note>]]></content>
+	<resource>
+		<data encoding="base64">%s</data>
+		<mime>image/png</mime>
+		<resource-attributes><file-name>map.png</file-name></resource-attributes>
+	</resource>
+	<resource>
+		<data encoding="base64">
+%s
+		</data>
+		<mime>application/pdf</mime>
+	</resource>
+</note>
+<note>
+	<title>Broken</title>
+	<content><![CDATA[<en-note>text</en-note>]]></content>
+	<resource><data encoding="base64">!!!</data><mime>image/png</mime></resource>
+</note>
+<note>
+	<title>Plain</title>
+	<updated>20220305T000000Z</updated>
+	<content><![CDATA[<en-note><div>just text</div></en-note>]]></content>
+</note>
+</en-export>`, md5.Sum(png), base64.StdEncoding.EncodeToString(png), base64.StdEncoding.EncodeToString(pdf))
+
+	im, err := NewEnexImport(r, bytes.NewReader([]byte(enex)), int64(len(enex)))
+	require.NoError(t, err)
+	report, err := im.Run(true)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Created)
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, []string{"plans"}, report.TagsCreated)
+	require.Equal(t, "note-1", report.Files[0].Path)
+	require.Equal(t, []string{"note-1/resource-1", "note-1/resource-2"}, report.Files[0].Attachments)
+	require.Equal(t, "note-2", report.Files[1].Path)
+	require.Contains(t, report.Files[1].Error, "invalid resource")
+
+	report, err = im.Run(false)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Created)
+
+	trip, err := r.GetPost(report.Files[0].PostId)
+	require.NoError(t, err)
+	require.Equal(t, "Trip", trip.Title)
+	require.True(t, time.Date(2022, time.March, 1, 10, 15, 0, 0, time.UTC).Equal(trip.Date))
+	require.Len(t, trip.Tags, 2)
+	require.Len(t, trip.Affiliates, 2)
+	files := make(map[string]uint)
+	for _, a := range trip.Affiliates {
+		files[a.Filename] = a.Id
+	}
+	require.Equal(t, "png bytes", mustDownload(t, r, files["map.png"]))
+	require.Equal(t, "pdf bytes", mustDownload(t, r, files["resource-2.pdf"]))
+	require.Equal(t, fmt.Sprintf("Day **one**\n\n![map.png](affiliate:%d)\n", files["map.png"]), trip.Content)
+
+	plain, err := r.GetPost(report.Files[2].PostId)
+	require.NoError(t, err)
+	require.Equal(t, "just text\n", plain.Content)
+	require.True(t, time.Date(2022, time.March, 5, 0, 0, 0, 0, time.UTC).Equal(plain.Date))
+
+	// imported again, all duplicates
+	report, err = im.Run(false)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Duplicates)
+
+	for _, data := range []string{"not xml", `<?xml version="1.0"?><notes><note/></notes>`} {
+		_, err = NewEnexImport(r, bytes.NewReader([]byte(data)), int64(len(data)))
+		require.ErrorIs(t, err, ErrBadArchive)
+	}
+}
+
+func contractImportJoplin(t *testing.T, r ToyNoteRepo) {
+	const (
+		noteId     = "0123456789abcdef0123456789abcdef"
+		resourceId = "fedcba9876543210fedcba9876543210"
+		tagId      = "11111111111111111111111111111111"
+	)
+	archive := writeTarGz(t, map[string]string{
+		noteId + ".md": "Recipe\n\nMix well.\n\n![photo](:/" + resourceId + ") [web](https://example.com)\n\n" +
+			"id: " + noteId + "\nparent_id: 22222222222222222222222222222222\n" +
+			"created_time: 2021-06-01T08:00:00.000Z\nupdated_time: 2021-06-02T08:00:00.000Z\n" +
+			"user_created_time: 2021-05-01T08:00:00.000Z\nencryption_applied: 0\ntype_: 1",
+		resourceId + ".md": "cake.jpg\n\nid: " + resourceId + "\nmime: image/jpeg\nfile_extension: jpg\ntype_: 4",
+		tagId + ".md":      "baking\n\nid: " + tagId + "\ntype_: 5",
+		"33333333333333333333333333333333.md": "id: 33333333333333333333333333333333\nnote_id: " + noteId +
+			"\ntag_id: " + tagId + "\ntype_: 6",
+		"22222222222222222222222222222222.md": "Kitchen\n\nid: 22222222222222222222222222222222\ntype_: 2",
+		"44444444444444444444444444444444.md": "Secret\n\nciphertext\n\nid: 44444444444444444444444444444444\n" +
+			"encryption_applied: 1\ntype_: 1",
+		"resources/" + resourceId + ".jpg": "jpeg bytes",
+	})
+
+	im, err := NewJoplinImport(r, bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+	report, err := im.Run(false)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Created)
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, []string{"baking"}, report.TagsCreated)
+	require.Contains(t, report.Files[1].Error, "encrypted")
+
+	recipe, err := r.GetPost(report.Files[0].PostId)
+	require.NoError(t, err)
+	require.Equal(t, "Recipe", recipe.Title)
+	require.True(t, time.Date(2021, time.May, 1, 8, 0, 0, 0, time.UTC).Equal(recipe.Date))
+	require.Len(t, recipe.Tags, 1)
+	require.Len(t, recipe.Affiliates, 1)
+	require.Equal(t, "cake.jpg", recipe.Affiliates[0].Filename)
+	require.Equal(t, "jpeg bytes", mustDownload(t, r, recipe.Affiliates[0].Id))
+	require.Equal(t, fmt.Sprintf(
+		"Mix well.\n\n![photo](affiliate:%d) [web](https://example.com)", recipe.Affiliates[0].Id,
+	), recipe.Content)
+
+	// a Markdown archive is not a Joplin export
+	markdown := writeTarGz(t, map[string]string{"a.md": "---\ntitle: A\n---\n"})
+	_, err = NewJoplinImport(r, bytes.NewReader(markdown), int64(len(markdown)))
+	require.ErrorIs(t, err, ErrBadArchive)
+}
+
+func contractBulk(t *testing.T, r ToyNoteRepo) {
+	golang := mustSaveTag(t, r, "go")
+	rust := mustSaveTag(t, r, "rust")
+	p1 := mustSavePost(t, r, entity.Post{
+		Title:   "p1",
+		Content: "content",
+		Date:    time.Now(),
+		Tags:    []entity.Tag{{UintId: golang.UintId}},
+	})
+	p2 := mustSavePost(t, r, entity.Post{Title: "p2", Content: "content", Date: time.Now()})
+	p3 := mustSavePost(t, r, entity.Post{Title: "p3", Content: "content", Date: time.Now()})
+
+	// requests are checked before anything is changed
+	_, err := r.BulkTagPosts(entity.BulkTagging{PostIds: []uint{p1.Id}})
+	require.Error(t, err)
+	_, err = r.BulkTagPosts(entity.BulkTagging{
+		PostIds:      []uint{p1.Id},
+		AddTagIds:    []uint{golang.Id},
+		RemoveTagIds: []uint{golang.Id},
+	})
+	require.Error(t, err)
+	_, err = r.BulkTagPosts(entity.BulkTagging{PostIds: []uint{p1.Id}, AddTagIds: []uint{999}})
+	require.Error(t, err)
+	_, err = r.BulkDeletePosts(nil)
+	require.Error(t, err)
+	_, err = r.BulkDeletePosts(make([]uint, entity.MaxBulkItems+1))
+	require.Error(t, err)
+	_, err = r.BulkDatePosts(entity.BulkDating{PostIds: []uint{p1.Id}})
+	require.Error(t, err)
+
+	// a post not found fails alone, duplicated ids are dropped
+	report, err := r.BulkTagPosts(entity.BulkTagging{
+		PostIds:      []uint{p1.Id, p2.Id, 999, p2.Id},
+		AddTagIds:    []uint{rust.Id},
+		RemoveTagIds: []uint{golang.Id},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Succeeded)
+	require.Equal(t, 1, report.Failed)
+	require.Len(t, report.Items, 3)
+	require.Equal(t, entity.BulkItemResult{Id: p1.Id, Ok: true}, report.Items[0])
+	require.Equal(t, uint(999), report.Items[2].Id)
+	require.False(t, report.Items[2].Ok)
+	require.NotEmpty(t, report.Items[2].Error)
+
+	for _, id := range []uint{p1.Id, p2.Id} {
+		post, err := r.GetPost(id)
+		require.NoError(t, err)
+		require.Len(t, post.Tags, 1)
+		require.Equal(t, rust.Id, post.Tags[0].Id)
+		require.Equal(t, uint(2), post.Version)
+	}
+	revisions, err := r.GetRevisions(p1.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), revisions.Total)
+
+	// tagging twice binds a tag once
+	_, err = r.BulkTagPosts(entity.BulkTagging{PostIds: []uint{p1.Id}, AddTagIds: []uint{rust.Id}})
+	require.NoError(t, err)
+	post, err := r.GetPost(p1.Id)
+	require.NoError(t, err)
+	require.Len(t, post.Tags, 1)
+
+	date := time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)
+	report, err = r.BulkDatePosts(entity.BulkDating{PostIds: []uint{p2.Id, p3.Id}, Date: date})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Succeeded)
+	post, err = r.GetPost(p3.Id)
+	require.NoError(t, err)
+	require.True(t, date.Equal(post.Date))
+	require.Equal(t, uint(2), post.Version)
+	require.Equal(t, "p3", post.Title)
+
+	report, err = r.BulkDeletePosts([]uint{p1.Id, p2.Id})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Succeeded)
+	trash, err := r.GetTrash(entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint{p1.Id, p2.Id}, postIds(trash.Items))
+
+	// a trashed post can't be changed until it is restored
+	report, err = r.BulkDeletePosts([]uint{p1.Id, p3.Id})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Succeeded)
+	require.False(t, report.Items[0].Ok)
+
+	// a tag nested in another one deleted before it is moved up, then deleted
+	lang := mustSaveTag(t, r, "lang")
+	nested := mustSaveChildTag(t, r, "nested", lang.Id)
+	report, err = r.BulkDeleteTags([]uint{lang.Id, nested.Id, 999})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Succeeded)
+	require.Equal(t, 1, report.Failed)
+	tags, err := r.GetTags()
+	require.NoError(t, err)
+	require.Len(t, tags, 2)
+
+	// posts and tags of other users are not found
+	users := r.(UserRepo)
+	alice, err := users.Register(entity.Credentials{Name: "alice", Password: "alice password"})
+	require.NoError(t, err)
+	aliceRepo := r.ForOwner(alice.Id)
+	alicePost := mustSavePost(t, aliceRepo, entity.Post{Title: "alice", Content: "content", Date: time.Now()})
+	_, err = aliceRepo.BulkTagPosts(entity.BulkTagging{PostIds: []uint{alicePost.Id}, AddTagIds: []uint{rust.Id}})
+	require.Error(t, err)
+	report, err = aliceRepo.BulkDeletePosts([]uint{p3.Id, alicePost.Id})
+	require.NoError(t, err)
+	require.False(t, report.Items[0].Ok)
+	require.True(t, report.Items[1].Ok)
+	report, err = aliceRepo.BulkDeleteTags([]uint{golang.Id})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Failed)
+}
+
+func tagIds(tags []entity.Tag) []uint {
+	ids := []uint{}
+	for _, t := range tags {
+		ids = append(ids, t.Id)
+	}
+	return ids
+}
+
+func mustPatchPost(t *testing.T, r ToyNoteRepo, id uint, format entity.PatchFormat, patch string) entity.Post {
+	post, err := r.PatchPost(id, entity.PostPatch{Format: format, Patch: []byte(patch)})
+	require.NoError(t, err)
+	return post
+}
+
+func contractPatch(t *testing.T, r ToyNoteRepo) {
+	golang := mustSaveTag(t, r, "go")
+	rust := mustSaveTag(t, r, "rust")
+	misc := mustSaveTag(t, r, "misc")
+	uploaded, err := r.UploadAffiliate(bytes.NewReader([]byte("diagram")), "diagram.png")
+	require.NoError(t, err)
+	post := mustSavePost(t, r, entity.Post{
+		Title:      "typo",
+		Subtitle:   "subtitle",
+		Content:    "content",
+		Date:       time.Now(),
+		Tags:       []entity.Tag{{UintId: golang.UintId}, {UintId: rust.UintId}},
+		Affiliates: []entity.Affiliate{uploaded},
+	})
+	diagram := post.Affiliates[0]
+
+	// omitted fields and associations are kept, a null clears the subtitle
+	patched := mustPatchPost(t, r, post.Id, entity.PATCH_MERGE, `{"title": "fixed", "subtitle": null}`)
+	require.Equal(t, "fixed", patched.Title)
+	require.Empty(t, patched.Subtitle)
+	require.Equal(t, "content", patched.Content)
+	require.ElementsMatch(t, []uint{golang.Id, rust.Id}, tagIds(patched.Tags))
+	require.Len(t, patched.Affiliates, 1)
+	require.Equal(t, uint(2), patched.Version)
+
+	// tags are bound and unbound by the operations
+	patched = mustPatchPost(t, r, post.Id, entity.PATCH_MERGE, fmt.Sprintf(
+		`{"add_tags": [%d], "remove_tags": [%d]}`, misc.Id, golang.Id,
+	))
+	require.ElementsMatch(t, []uint{rust.Id, misc.Id}, tagIds(patched.Tags))
+
+	// as well as by a JSON Patch of the arrays
+	patched = mustPatchPost(t, r, post.Id, entity.PATCH_JSON, fmt.Sprintf(`[
+		{"op": "test", "path": "/title", "value": "fixed"},
+		{"op": "replace", "path": "/content", "value": "![diagram](affiliate:%d)"},
+		{"op": "add", "path": "/tags/-", "value": {"id": %d}},
+		{"op": "add", "path": "/remove_tags/-", "value": %d}
+	]`, diagram.Id, golang.Id, misc.Id))
+	require.ElementsMatch(t, []uint{rust.Id, golang.Id}, tagIds(patched.Tags))
+	require.Equal(t, uint(4), patched.Version)
+
+	// a patch changing nothing keeps the version
+	patched = mustPatchPost(t, r, post.Id, entity.PATCH_MERGE, `{"title": "fixed"}`)
+	require.Equal(t, uint(4), patched.Version)
+
+	revisions, err := r.GetRevisions(post.Id, entity.NewPagination(1, 10))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), revisions.Total)
+
+	for _, patch := range []entity.PostPatch{
+		{Format: entity.PATCH_MERGE, Patch: []byte(`{"title": null}`)},
+		{Format: entity.PATCH_MERGE, Patch: []byte(`{"date": null}`)},
+		{Format: entity.PATCH_MERGE, Patch: []byte(`{"id": 999}`)},
+		{Format: entity.PATCH_MERGE, Patch: []byte(`{"notebook_id": 1}`)},
+		{Format: entity.PATCH_MERGE, Patch: []byte(`{"unknown": 1}`)},
+		{Format: entity.PATCH_MERGE, Patch: []byte(`{"tags": [{"name": "new"}]}`)},
+		{Format: entity.PATCH_MERGE, Patch: []byte(fmt.Sprintf(`{"add_tags": [%d], "remove_tags": [%d]}`, misc.Id, misc.Id))},
+		{Format: entity.PATCH_JSON, Patch: []byte(`[{"op": "remove", "path": "/tags/5"}]`)},
+		{Format: entity.PATCH_JSON, Patch: []byte(`{"op": "remove"}`)},
+		{Format: "text/plain", Patch: []byte(`{}`)},
+	} {
+		_, err := r.PatchPost(post.Id, patch)
+		require.ErrorIs(t, err, ErrBadPatch, string(patch.Patch))
+	}
+
+	// a failed test or a stale version is told apart
+	_, err = r.PatchPost(post.Id, entity.PostPatch{
+		Format: entity.PATCH_JSON,
+		Patch:  []byte(`[{"op": "test", "path": "/title", "value": "typo"}]`),
+	})
+	require.ErrorIs(t, err, util.ErrPatchTest)
+	var conflict *entity.ConflictError
+	_, err = r.PatchPost(post.Id, entity.PostPatch{Format: entity.PATCH_MERGE, Patch: []byte(`{"title": "stale"}`), Version: 2})
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, uint(4), conflict.CurrentVersion)
+	_, err = r.PatchPost(post.Id, entity.PostPatch{Format: entity.PATCH_MERGE, Patch: []byte(`{"title": "stale", "version": 3}`)})
+	require.ErrorAs(t, err, &conflict)
+	patched = mustPatchPost(t, r, post.Id, entity.PATCH_MERGE, `{"subtitle": "fresh", "version": 4}`)
+	require.Equal(t, "fresh", patched.Subtitle)
+
+	// an affiliate still referred to can't be unbound, unless the reference is removed as well
+	var refErr *entity.ReferenceError
+	_, err = r.PatchPost(post.Id, entity.PostPatch{
+		Format: entity.PATCH_MERGE,
+		Patch:  []byte(fmt.Sprintf(`{"remove_affiliates": [%d]}`, diagram.Id)),
+	})
+	require.ErrorAs(t, err, &refErr)
+	require.True(t, refErr.Unbinding)
+	patched = mustPatchPost(t, r, post.Id, entity.PATCH_MERGE, `{"content": "no diagram", "affiliates": []}`)
+	require.Empty(t, patched.Affiliates)
+
+	// and an existing affiliate can be bound again
+	patched = mustPatchPost(t, r, post.Id, entity.PATCH_MERGE, fmt.Sprintf(`{"add_affiliates": [%d]}`, diagram.Id))
+	require.Len(t, patched.Affiliates, 1)
+	require.Equal(t, "diagram.png", patched.Affiliates[0].Filename)
+
+	// tags must be found, and the post must be live
+	_, err = r.PatchPost(post.Id, entity.PostPatch{Format: entity.PATCH_MERGE, Patch: []byte(`{"add_tags": [999]}`)})
+	require.Error(t, err)
+	mustDeletePost(t, r, post.Id)
+	_, err = r.PatchPost(post.Id, entity.PostPatch{Format: entity.PATCH_MERGE, Patch: []byte(`{"title": "gone"}`)})
+	require.Error(t, err)
+}
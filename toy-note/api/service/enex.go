@@ -0,0 +1,186 @@
+package service
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+	"toy-note/api/util"
+)
+
+// the layout of the dates in ENEX, e.g. `20220301T101500Z`
+const enexTimeLayout = "20060102T150405Z"
+
+// a note of an ENEX file, the content is ENML
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Updated   string         `xml:"updated"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+// a file of a note, embedded in base64
+type enexResource struct {
+	Data     string `xml:"data"`
+	Mime     string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+func (r enexResource) reader() io.Reader {
+	data := strings.Map(func(c rune) rune {
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			return -1
+		}
+		return c
+	}, r.Data)
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
+}
+
+// Prepare an import of an Evernote export, an ENEX file. The content of a note is converted
+// from ENML into Markdown, its resources become attachments, and it is dated by when it
+// was created, or else updated.
+//
+// In the report, a note is identified by its position in the file, e.g. `note-3`. Notes are
+// decoded one at a time, so that at most the resources of a note are held in memory.
+func NewEnexImport(repo ToyNoteRepo, r io.ReaderAt, size int64) (*Import, error) {
+	a := enexArchive{r: r, size: size}
+	im := &Import{repo: repo, archive: a, files: make(map[string]bool), names: make(map[string]string)}
+
+	err := a.notes(func(n int, en enexNote) error {
+		note := importNote{path: fmt.Sprintf("note-%d", n), links: make(map[string]string)}
+		note.matter.Title = strings.TrimSpace(en.Title)
+		if note.matter.Title == "" {
+			note.matter.Title = note.path
+		}
+		note.matter.Tags = en.Tags
+		for _, t := range []string{en.Created, en.Updated} {
+			if date, err := time.Parse(enexTimeLayout, strings.TrimSpace(t)); err == nil {
+				note.matter.Date = date
+				break
+			}
+		}
+		if note.matter.Date.IsZero() {
+			note.matter.Date = time.Now()
+		}
+
+		// en-media refers to resources by the md5 hashes of their data
+		for i, res := range en.Resources {
+			p := enexResourcePath(n, i)
+			hash := md5.New()
+			if _, err := io.Copy(hash, res.reader()); err != nil {
+				note.err = fmt.Errorf("invalid resource %d: %w", i+1, err)
+				break
+			}
+			im.files[p] = true
+			im.names[p] = enexFilename(res, i)
+			note.matter.Attachments = append(note.matter.Attachments, p)
+			note.links[hex.EncodeToString(hash.Sum(nil))] = p
+		}
+
+		if note.err == nil {
+			content, err := util.EnmlToMarkdown(en.Content, func(hash string) (string, string, bool) {
+				p, ok := note.links[hash]
+				return im.names[p], hash, ok
+			})
+			if err != nil {
+				note.err = fmt.Errorf("invalid content: %w", err)
+			}
+			note.content = content
+		}
+
+		im.notes = append(im.notes, note)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+	}
+
+	return im, nil
+}
+
+// the path of a resource of the n-th note, which is unique in the file
+func enexResourcePath(n, i int) string {
+	return fmt.Sprintf("note-%d/resource-%d", n, i+1)
+}
+
+// the file name of a resource, or a name told by its MIME type if it has none
+func enexFilename(res enexResource, i int) string {
+	if name := path.Base(strings.ReplaceAll(strings.TrimSpace(res.FileName), `\`, "/")); name != "." && name != "/" {
+		return name
+	}
+
+	name := fmt.Sprintf("resource-%d", i+1)
+	if exts, err := mime.ExtensionsByType(res.Mime); err == nil && len(exts) > 0 {
+		name += exts[0]
+	}
+	return name
+}
+
+// enexArchive
+//
+// An ENEX file walked as an archive, the resources of the notes are its files
+type enexArchive struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// decode the notes one by one, n counts from 1
+func (a enexArchive) notes(fn func(n int, note enexNote) error) error {
+	d := xml.NewDecoder(io.NewSectionReader(a.r, 0, a.size))
+	root := false
+	n := 0
+
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			if !root {
+				return errors.New("not an ENEX file")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "en-export":
+			root = true
+		case "note":
+			if !root {
+				return errors.New("not an ENEX file")
+			}
+			var note enexNote
+			if err := d.DecodeElement(&note, &start); err != nil {
+				return err
+			}
+			n++
+			if err := fn(n, note); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (a enexArchive) walk(fn func(name string, r io.Reader) error) error {
+	return a.notes(func(n int, note enexNote) error {
+		for i, res := range note.Resources {
+			if err := fn(enexResourcePath(n, i), res.reader()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strings"
+	"toy-note/api/entity"
+	"toy-note/api/util"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the number of posts fetched at a time
+const exportPageSize = 50
+
+// Export
+//
+// A zip archive of posts: one Markdown file per post with its YAML front matter (see
+// `entity.FrontMatter`), and the files of its affiliates under `attachments/`. References
+// to the affiliates in the content are rewritten into relative links to the files.
+//
+// The archive is streamed, posts are fetched page by page and each file is copied from the
+// blob store right into the archive, so that neither is held in memory.
+type Export struct {
+	repo  ToyNoteRepo
+	query entity.PostQuery
+	// the page being exported, the first page is fetched up front
+	page entity.PostPage
+	// paths of the tags by ids, e.g. `lang/go`
+	tags map[uint]string
+}
+
+// Prepare an export of the posts found by a query, its pagination is ignored.
+// The first page is fetched right away, so that a bad query or a denied call is reported
+// before anything is written.
+func NewExport(repo ToyNoteRepo, query entity.PostQuery) (*Export, error) {
+	query.Page, query.Size, query.Cursor = 1, exportPageSize, ""
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	page, err := repo.QueryPosts(query)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := tagPaths(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Export{repo: repo, query: query, page: page, tags: tags}, nil
+}
+
+// Prepare an export of a single post
+func NewPostExport(repo ToyNoteRepo, id uint) (*Export, error) {
+	post, err := repo.GetPost(id)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := tagPaths(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	page := entity.PostPage{Items: []entity.Post{post}, Total: 1}
+	return &Export{repo: repo, page: page, tags: tags}, nil
+}
+
+// Write the archive. Once an error is returned, what has been written is a truncated archive
+func (e *Export) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	for {
+		for _, post := range e.page.Items {
+			if err := e.writePost(archive, post); err != nil {
+				return err
+			}
+		}
+
+		more, err := e.next()
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+
+	return archive.Close()
+}
+
+// fetch the page after the current one, false once all the posts are fetched
+func (e *Export) next() (bool, error) {
+	switch {
+	case e.page.NextCursor != "":
+		e.query.Page, e.query.Cursor = 0, e.page.NextCursor
+	// without a cursor, i.e. not sorted by date
+	case e.query.Size > 0 && e.query.Sort != entity.SORT_DATE && int64(e.query.Page*e.query.Size) < e.page.Total:
+		e.query.Page++
+	default:
+		return false, nil
+	}
+
+	page, err := e.repo.QueryPosts(e.query)
+	if err != nil {
+		return false, err
+	}
+	e.page = page
+
+	return len(page.Items) > 0, nil
+}
+
+func (e *Export) writePost(archive *zip.Writer, post entity.Post) error {
+	matter := entity.FrontMatter{
+		Id:        post.Id,
+		Title:     post.Title,
+		Subtitle:  post.Subtitle,
+		Date:      post.Date,
+		CreatedAt: post.CreatedAt,
+		UpdatedAt: post.UpdatedAt,
+	}
+	for _, t := range post.Tags {
+		matter.Tags = append(matter.Tags, e.tags[t.Id])
+	}
+	attachments := make(map[uint]string, len(post.Affiliates))
+	for _, a := range post.Affiliates {
+		attachments[a.Id] = attachmentPath(a)
+		matter.Attachments = append(matter.Attachments, attachments[a.Id])
+	}
+
+	data, err := yaml.Marshal(matter)
+	if err != nil {
+		return err
+	}
+	content := util.RewriteAffiliateRefs(post.Content, func(id uint) (string, bool) {
+		path, ok := attachments[id]
+		return path, ok
+	})
+
+	f, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     fmt.Sprintf("%d-%s.md", post.Id, slug(post.Title)),
+		Method:   zip.Deflate,
+		Modified: post.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "---\n%s---\n\n%s", data, content); err != nil {
+		return err
+	}
+
+	for _, a := range post.Affiliates {
+		if err := e.writeAttachment(archive, a.Id, attachments[a.Id]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copy the file of an affiliate from the blob store into the archive
+func (e *Export) writeAttachment(archive *zip.Writer, id uint, path string) error {
+	fo, err := e.repo.DownloadAffiliate(id)
+	if err != nil {
+		return err
+	}
+	defer fo.Content.Close()
+
+	f, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     path,
+		Method:   zip.Deflate,
+		Modified: fo.ModTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, fo.Content)
+	return err
+}
+
+// paths of all the tags of the user by ids, the names of the tags they are nested in
+// joined by `/`
+func tagPaths(repo ToyNoteRepo) (map[uint]string, error) {
+	tags, err := repo.GetTags()
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[uint]entity.Tag, len(tags))
+	for _, t := range tags {
+		byId[t.Id] = t
+	}
+
+	paths := make(map[uint]string, len(tags))
+	for _, t := range tags {
+		names := []string{t.Name}
+		for parent := t.ParentId; parent != nil; parent = byId[*parent].ParentId {
+			if _, ok := byId[*parent]; !ok {
+				break
+			}
+			names = append([]string{byId[*parent].Name}, names...)
+		}
+		paths[t.Id] = strings.Join(names, "/")
+	}
+
+	return paths, nil
+}
+
+// the path of the file of an affiliate in the archive, unique by the affiliate id
+func attachmentPath(affiliate entity.Affiliate) string {
+	name := affiliate.Filename
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	// the path is put in Markdown links as it is, hence no spaces or brackets
+	safe := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+	if strings.Trim(safe, ".") == "" {
+		safe = "file"
+	}
+
+	return fmt.Sprintf("attachments/%d-%s", affiliate.Id, safe)
+}
+
+// lower-cased letters and digits of a title separated by `-`, at most 50 runes
+func slug(title string) string {
+	var b strings.Builder
+	n := 0
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if n >= 50 {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash {
+				b.WriteByte('-')
+				n++
+				dash = false
+			}
+			b.WriteRune(r)
+			n++
+		} else if b.Len() > 0 {
+			dash = true
+		}
+	}
+
+	if b.Len() == 0 {
+		return "post"
+	}
+	return b.String()
+}
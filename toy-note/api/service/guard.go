@@ -0,0 +1,387 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"toy-note/api/entity"
+)
+
+var ErrForbidden = errors.New("permission denied")
+
+func authorize(role, required entity.Role) error {
+	if !role.Includes(required) {
+		return fmt.Errorf("%w: %s is required", ErrForbidden, required)
+	}
+	return nil
+}
+
+// guardedRepo
+//
+// A permission check layer around a `ToyNoteRepo`: readers can only read, editors can
+// write as well, and the "[admin]" methods are only permitted to admins.
+// A caller authenticated by an API key is further limited to the scopes of the key,
+// and is never permitted the "[admin]" methods.
+// A denied call results in `ErrForbidden`, and never reaches the repository.
+//
+// Every method is checked, except the "[public]" ones which are promoted from the
+// embedded repository. Hence every new method must be overridden here, which is
+// asserted by `TestGuardOverridesEveryMethod`.
+type guardedRepo struct {
+	ToyNoteRepo
+	role entity.Role
+	// nil if the caller is not authenticated by an API key
+	key *entity.ApiKey
+}
+
+var _ ToyNoteRepo = (*guardedRepo)(nil)
+
+// Guard a repository by the role of the caller, and the API key if the caller is
+// authenticated by one
+func Guard(repo ToyNoteRepo, role entity.Role, key *entity.ApiKey) ToyNoteRepo {
+	return &guardedRepo{ToyNoteRepo: repo, role: role, key: key}
+}
+
+// check the role of the caller, and the scope of the API key
+func (g *guardedRepo) check(required entity.Role, scope entity.Scope) error {
+	if err := authorize(g.role, required); err != nil {
+		return err
+	}
+	if g.key != nil && !g.key.Allows(scope) {
+		return fmt.Errorf("%w: api key scope %s is required", ErrForbidden, scope)
+	}
+	return nil
+}
+
+func (g *guardedRepo) checkAdmin() error {
+	if err := authorize(g.role, entity.ROLE_ADMIN); err != nil {
+		return err
+	}
+	if g.key != nil {
+		return fmt.Errorf("%w: not permitted to api keys", ErrForbidden)
+	}
+	return nil
+}
+
+func (g *guardedRepo) ForOwner(userId uint) ToyNoteRepo {
+	return Guard(g.ToyNoteRepo.ForOwner(userId), g.role, g.key)
+}
+
+func (g *guardedRepo) InNotebook(scope entity.NotebookScope) ToyNoteRepo {
+	return Guard(g.ToyNoteRepo.InNotebook(scope), g.role, g.key)
+}
+
+// ============================================================================
+// Reader
+// ============================================================================
+
+func (g *guardedRepo) GetNotebookTree() ([]entity.NotebookNode, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return nil, err
+	}
+	return g.ToyNoteRepo.GetNotebookTree()
+}
+
+func (g *guardedRepo) GetNotebook(id uint) (entity.Notebook, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.Notebook{}, err
+	}
+	return g.ToyNoteRepo.GetNotebook(id)
+}
+
+func (g *guardedRepo) GetTags() ([]entity.Tag, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return nil, err
+	}
+	return g.ToyNoteRepo.GetTags()
+}
+
+func (g *guardedRepo) GetPosts(pagination entity.Pagination) (entity.PostPage, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.PostPage{}, err
+	}
+	return g.ToyNoteRepo.GetPosts(pagination)
+}
+
+func (g *guardedRepo) GetPost(id uint) (entity.Post, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.Post{}, err
+	}
+	return g.ToyNoteRepo.GetPost(id)
+}
+
+func (g *guardedRepo) GetTrash(pagination entity.Pagination) (entity.PostPage, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.PostPage{}, err
+	}
+	return g.ToyNoteRepo.GetTrash(pagination)
+}
+
+func (g *guardedRepo) GetRevisions(postId uint, pagination entity.Pagination) (entity.RevisionPage, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.RevisionPage{}, err
+	}
+	return g.ToyNoteRepo.GetRevisions(postId, pagination)
+}
+
+func (g *guardedRepo) GetRevision(id uint) (entity.PostRevision, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.PostRevision{}, err
+	}
+	return g.ToyNoteRepo.GetRevision(id)
+}
+
+func (g *guardedRepo) DiffRevisions(from, to uint) (entity.RevisionDiff, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.RevisionDiff{}, err
+	}
+	return g.ToyNoteRepo.DiffRevisions(from, to)
+}
+
+// the rendered HTML is cached, which is not a write of the posts themselves
+func (g *guardedRepo) RenderPosts(posts []entity.Post) error {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.RenderPosts(posts)
+}
+
+func (g *guardedRepo) GetPostLinks(id uint) ([]entity.LinkedPost, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return nil, err
+	}
+	return g.ToyNoteRepo.GetPostLinks(id)
+}
+
+func (g *guardedRepo) GetBacklinks(id uint) ([]entity.LinkedPost, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return nil, err
+	}
+	return g.ToyNoteRepo.GetBacklinks(id)
+}
+
+func (g *guardedRepo) GetShares(postId uint) ([]entity.Share, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return nil, err
+	}
+	return g.ToyNoteRepo.GetShares(postId)
+}
+
+func (g *guardedRepo) DownloadAffiliate(id uint) (entity.FileObject, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_FILES_READ); err != nil {
+		return entity.FileObject{}, err
+	}
+	return g.ToyNoteRepo.DownloadAffiliate(id)
+}
+
+func (g *guardedRepo) SearchPostsByTags(tagIds []uint, descendants bool, pagination entity.Pagination) (entity.PostPage, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.PostPage{}, err
+	}
+	return g.ToyNoteRepo.SearchPostsByTags(tagIds, descendants, pagination)
+}
+
+func (g *guardedRepo) SearchPostsByTitle(title string, pagination entity.Pagination) (entity.PostPage, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.PostPage{}, err
+	}
+	return g.ToyNoteRepo.SearchPostsByTitle(title, pagination)
+}
+
+func (g *guardedRepo) SearchPostsByTimeRange(timeSearch entity.TimeSearch, pagination entity.Pagination) (entity.PostPage, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.PostPage{}, err
+	}
+	return g.ToyNoteRepo.SearchPostsByTimeRange(timeSearch, pagination)
+}
+
+func (g *guardedRepo) SearchPosts(text string, pagination entity.Pagination) (entity.PostSearchPage, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.PostSearchPage{}, err
+	}
+	return g.ToyNoteRepo.SearchPosts(text, pagination)
+}
+
+func (g *guardedRepo) QueryPosts(query entity.PostQuery) (entity.PostPage, error) {
+	if err := g.check(entity.ROLE_READER, entity.SCOPE_POSTS_READ); err != nil {
+		return entity.PostPage{}, err
+	}
+	return g.ToyNoteRepo.QueryPosts(query)
+}
+
+// ============================================================================
+// Editor
+// ============================================================================
+
+func (g *guardedRepo) SaveTag(tag entity.Tag) (entity.Tag, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.Tag{}, err
+	}
+	return g.ToyNoteRepo.SaveTag(tag)
+}
+
+func (g *guardedRepo) DeleteTag(id uint) error {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.DeleteTag(id)
+}
+
+func (g *guardedRepo) BulkDeleteTags(ids []uint) (entity.BulkReport, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.BulkReport{}, err
+	}
+	return g.ToyNoteRepo.BulkDeleteTags(ids)
+}
+
+func (g *guardedRepo) SaveNotebook(notebook entity.Notebook) (entity.Notebook, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.Notebook{}, err
+	}
+	return g.ToyNoteRepo.SaveNotebook(notebook)
+}
+
+func (g *guardedRepo) DeleteNotebook(id uint, moveTo *entity.Destination) error {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.DeleteNotebook(id, moveTo)
+}
+
+func (g *guardedRepo) MovePost(id uint, to entity.Destination) (entity.Post, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.Post{}, err
+	}
+	return g.ToyNoteRepo.MovePost(id, to)
+}
+
+func (g *guardedRepo) SavePost(post entity.Post) (entity.Post, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.Post{}, err
+	}
+	return g.ToyNoteRepo.SavePost(post)
+}
+
+func (g *guardedRepo) PatchPost(id uint, patch entity.PostPatch) (entity.Post, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.Post{}, err
+	}
+	return g.ToyNoteRepo.PatchPost(id, patch)
+}
+
+func (g *guardedRepo) DeletePost(id uint) ([]entity.LinkedPost, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return nil, err
+	}
+	return g.ToyNoteRepo.DeletePost(id)
+}
+
+func (g *guardedRepo) BulkTagPosts(tagging entity.BulkTagging) (entity.BulkReport, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.BulkReport{}, err
+	}
+	return g.ToyNoteRepo.BulkTagPosts(tagging)
+}
+
+func (g *guardedRepo) BulkDeletePosts(ids []uint) (entity.BulkReport, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.BulkReport{}, err
+	}
+	return g.ToyNoteRepo.BulkDeletePosts(ids)
+}
+
+func (g *guardedRepo) BulkDatePosts(dating entity.BulkDating) (entity.BulkReport, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.BulkReport{}, err
+	}
+	return g.ToyNoteRepo.BulkDatePosts(dating)
+}
+
+func (g *guardedRepo) RestorePost(id uint) error {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.RestorePost(id)
+}
+
+func (g *guardedRepo) PurgePost(id uint) error {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.PurgePost(id)
+}
+
+func (g *guardedRepo) RestoreRevision(id uint) (entity.Post, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.Post{}, err
+	}
+	return g.ToyNoteRepo.RestoreRevision(id)
+}
+
+func (g *guardedRepo) CreateShare(req entity.ShareRequest) (entity.ShareLink, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return entity.ShareLink{}, err
+	}
+	return g.ToyNoteRepo.CreateShare(req)
+}
+
+func (g *guardedRepo) RevokeShare(id uint) error {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_POSTS_WRITE); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.RevokeShare(id)
+}
+
+func (g *guardedRepo) UploadAffiliate(reader io.Reader, filename string) (entity.Affiliate, error) {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_FILES_WRITE); err != nil {
+		return entity.Affiliate{}, err
+	}
+	return g.ToyNoteRepo.UploadAffiliate(reader, filename)
+}
+
+func (g *guardedRepo) DiscardUploads(oids []string) error {
+	if err := g.check(entity.ROLE_EDITOR, entity.SCOPE_FILES_WRITE); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.DiscardUploads(oids)
+}
+
+// ============================================================================
+// Admin
+// ============================================================================
+
+func (g *guardedRepo) PurgeTrash(before time.Time) (int, error) {
+	if err := g.checkAdmin(); err != nil {
+		return 0, err
+	}
+	return g.ToyNoteRepo.PurgeTrash(before)
+}
+
+func (g *guardedRepo) GetUnownedAffiliates(pagination entity.Pagination) (entity.AffiliatePage, error) {
+	if err := g.checkAdmin(); err != nil {
+		return entity.AffiliatePage{}, err
+	}
+	return g.ToyNoteRepo.GetUnownedAffiliates(pagination)
+}
+
+func (g *guardedRepo) RebindAffiliate(postId, affiliateId uint) error {
+	if err := g.checkAdmin(); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.RebindAffiliate(postId, affiliateId)
+}
+
+func (g *guardedRepo) DeleteUnownedAffiliates(ids []uint) error {
+	if err := g.checkAdmin(); err != nil {
+		return err
+	}
+	return g.ToyNoteRepo.DeleteUnownedAffiliates(ids)
+}
+
+func (g *guardedRepo) MergeTags(sourceIds []uint, targetId uint) (entity.Tag, error) {
+	if err := g.checkAdmin(); err != nil {
+		return entity.Tag{}, err
+	}
+	return g.ToyNoteRepo.MergeTags(sourceIds, targetId)
+}
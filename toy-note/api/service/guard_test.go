@@ -0,0 +1,61 @@
+package service
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Methods which are not overridden by `guardedRepo` are promoted from the embedded
+// repository unchecked, hence every method of `ToyNoteRepo` but the "[public]" ones must
+// be overridden
+func TestGuardOverridesEveryMethod(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", nil, parser.ParseComments)
+	require.NoError(t, err)
+
+	public := make(map[string]bool)
+	overridden := make(map[string]bool)
+	for _, file := range pkgs["service"].Files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch node := n.(type) {
+			case *ast.TypeSpec:
+				iface, ok := node.Type.(*ast.InterfaceType)
+				if !ok || node.Name.Name != "ToyNoteRepo" {
+					return true
+				}
+				for _, m := range iface.Methods.List {
+					if m.Doc != nil && strings.HasPrefix(m.Doc.Text(), "[public]") {
+						for _, name := range m.Names {
+							public[name.Name] = true
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				if node.Recv == nil || len(node.Recv.List) == 0 {
+					return true
+				}
+				if star, ok := node.Recv.List[0].Type.(*ast.StarExpr); ok {
+					if ident, ok := star.X.(*ast.Ident); ok && ident.Name == "guardedRepo" {
+						overridden[node.Name.Name] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	require.NotEmpty(t, public)
+
+	repo := reflect.TypeOf((*ToyNoteRepo)(nil)).Elem()
+	for i := 0; i < repo.NumMethod(); i++ {
+		name := repo.Method(i).Name
+		if !public[name] {
+			require.True(t, overridden[name], "%s is not checked by guardedRepo", name)
+		}
+	}
+}
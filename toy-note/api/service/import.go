@@ -0,0 +1,767 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"toy-note/api/entity"
+	"toy-note/api/util"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the largest Markdown file read from an archive
+const maxImportNoteSize = 10 << 20
+
+// the uploaded file is neither a zip archive nor a tarball, or it is corrupted
+var ErrBadArchive = errors.New("not a readable zip or tar archive")
+
+// Import
+//
+// The counterpart of `Export`: a zip archive or a tarball, optionally gzipped, of Markdown
+// files with YAML front matter (see `entity.FrontMatter`) and the files they refer to.
+// Exports of other apps are read into the same notes, see `NewEnexImport` and
+// `NewJoplinImport`. Each note becomes a post:
+//   - tags are matched by name, missing ones are created nested along their paths
+//   - the attachments in the front matter, and the files the content links to by relative
+//     paths, are uploaded and bound as affiliates, and the links are rewritten into
+//     references to the affiliates
+//   - a note with the same title and date as an existing post, or as a note before it in
+//     the archive, is skipped as a duplicate
+//
+// A note which can't be imported is reported as failed along with the reason, the others
+// are imported regardless. A denied call aborts the import, since every file would fail
+// the same way.
+//
+// The archive is walked twice, once for the Markdown files and once for uploading the
+// attachments, so that attachments are streamed into the blob store without being held
+// in memory.
+type Import struct {
+	repo    ToyNoteRepo
+	archive archive
+	// notes in the order they are imported
+	notes []importNote
+	// paths of the other files, which may be attachments
+	files map[string]bool
+	// names of the files when they are uploaded by paths, the base names by default
+	names map[string]string
+}
+
+// a note read from an archive, e.g. a Markdown file
+type importNote struct {
+	// the path of the note in the archive, which identifies it in the report
+	path string
+	// the attachments are paths of files in the archive
+	matter  entity.FrontMatter
+	content string
+	// paths of the files which links in the content are to by the destinations, otherwise
+	// links are to paths relative to the note
+	links map[string]string
+	// why the note can't be imported, e.g. broken front matter
+	err error
+}
+
+// Read an archive for importing. The Markdown files are read right away, so that an
+// unreadable archive results in `ErrBadArchive` before anything is written.
+func NewImport(repo ToyNoteRepo, r io.ReaderAt, size int64) (*Import, error) {
+	a, err := openArchive(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	im := &Import{repo: repo, archive: a, files: make(map[string]bool), names: make(map[string]string)}
+	seen := make(map[string]bool)
+	err = a.walk(func(name string, r io.Reader) error {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+
+		if !isMarkdown(name) {
+			im.files[name] = true
+			return nil
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(r, maxImportNoteSize+1))
+		if err != nil {
+			return err
+		}
+		im.notes = append(im.notes, readNote(name, data))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+	}
+
+	// a Markdown file listed as an attachment of a note is an attachment, not a note
+	claimed := make(map[string]bool)
+	for _, note := range im.notes {
+		for i, p := range note.matter.Attachments {
+			note.matter.Attachments[i] = resolvePath(note.path, p)
+			claimed[note.matter.Attachments[i]] = true
+		}
+	}
+	notes := im.notes[:0]
+	for _, note := range im.notes {
+		if claimed[note.path] {
+			im.files[note.path] = true
+			continue
+		}
+		notes = append(notes, note)
+	}
+	im.notes = notes
+	sort.Slice(im.notes, func(i, j int) bool { return im.notes[i].path < im.notes[j].path })
+
+	return im, nil
+}
+
+// Import the archive, or only report what would be created in a dry run.
+func (im *Import) Run(dryRun bool) (entity.ImportReport, error) {
+	report := entity.ImportReport{
+		DryRun:      dryRun,
+		TagsCreated: []string{},
+		Files:       make([]entity.ImportFileResult, len(im.notes)),
+	}
+
+	tags, err := newImportTags(im.repo)
+	if err != nil {
+		return report, err
+	}
+
+	// plan every note, then upload the attachments of the planned ones at once
+	attachments := make([][]string, len(im.notes))
+	needed := make(map[string]bool)
+	// titles and dates of the notes planned so far
+	planned := make(map[string]bool)
+
+	for i, note := range im.notes {
+		result := &report.Files[i]
+		result.Path, result.Title = note.path, note.matter.Title
+
+		fail := func(err error) error {
+			if errors.Is(err, ErrForbidden) {
+				return err
+			}
+			result.Status, result.Error = entity.IMPORT_FAILED, err.Error()
+			return nil
+		}
+
+		if note.err != nil {
+			if err := fail(note.err); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		key := note.matter.Title + "\x00" + note.matter.Date.Truncate(time.Second).UTC().Format(time.RFC3339)
+		if planned[key] {
+			result.Status = entity.IMPORT_DUPLICATE
+			continue
+		}
+		existing, err := findDuplicate(im.repo, note.matter.Title, note.matter.Date)
+		if err != nil {
+			if err := fail(err); err != nil {
+				return report, err
+			}
+			continue
+		}
+		if existing != 0 {
+			result.Status, result.PostId = entity.IMPORT_DUPLICATE, existing
+			continue
+		}
+
+		attachments[i], err = im.attachments(note)
+		if err != nil {
+			if err := fail(err); err != nil {
+				return report, err
+			}
+			continue
+		}
+		if err := tags.plan(note.matter.Tags); err != nil {
+			if err := fail(err); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		result.Status = entity.IMPORT_CREATED
+		result.Tags = tagPathsOf(note.matter.Tags)
+		result.Attachments = attachments[i]
+		for _, p := range attachments[i] {
+			needed[p] = true
+		}
+		planned[key] = true
+	}
+	if dryRun {
+		report.TagsCreated = append(report.TagsCreated, tags.planned...)
+	} else {
+		uploaded, failures, err := im.upload(needed)
+		if err != nil {
+			if discardErr := im.discard(uploaded, nil); discardErr != nil {
+				return report, fmt.Errorf("%w, and the files uploaded are not discarded: %v", err, discardErr)
+			}
+			return report, err
+		}
+
+		// object ids of the files bound to the posts saved
+		bound := make(map[string]bool)
+		for i, note := range im.notes {
+			result := &report.Files[i]
+			if result.Status != entity.IMPORT_CREATED {
+				continue
+			}
+
+			post, err := im.save(note, tags, attachments[i], uploaded, failures)
+			result.PostId = post.Id
+			for _, a := range post.Affiliates {
+				bound[a.ObjectId] = true
+			}
+			if err != nil {
+				if errors.Is(err, ErrForbidden) {
+					if discardErr := im.discard(uploaded, bound); discardErr != nil {
+						return report, fmt.Errorf("%w, and the files uploaded are not discarded: %v", err, discardErr)
+					}
+					return report, err
+				}
+				result.Status, result.Error = entity.IMPORT_FAILED, err.Error()
+			}
+		}
+
+		report.TagsCreated = append(report.TagsCreated, tags.created...)
+
+		if err := im.discard(uploaded, bound); err != nil {
+			return report, err
+		}
+	}
+
+	for _, result := range report.Files {
+		switch result.Status {
+		case entity.IMPORT_CREATED:
+			report.Created++
+		case entity.IMPORT_DUPLICATE:
+			report.Duplicates++
+		case entity.IMPORT_FAILED:
+			report.Failed++
+		}
+	}
+
+	return report, nil
+}
+
+// the files in the archive bound to a note: its attachments, then the files its content
+// links to. A missing attachment is an error, whereas a link which isn't to a file in the
+// archive is left as it is
+func (im *Import) attachments(note importNote) ([]string, error) {
+	paths := []string{}
+	seen := make(map[string]bool)
+
+	for _, p := range note.matter.Attachments {
+		if !im.files[p] {
+			return nil, fmt.Errorf("attachment %s is not found in the archive", p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	util.RewriteLinks(note.content, func(destination string) (string, bool) {
+		if resolved, ok := im.linkedFile(note, destination); ok && !seen[resolved] {
+			seen[resolved] = true
+			paths = append(paths, resolved)
+		}
+		return "", false
+	})
+
+	return paths, nil
+}
+
+// the file in the archive a link in a note is to
+func (im *Import) linkedFile(note importNote, destination string) (string, bool) {
+	if note.links != nil {
+		p, ok := note.links[destination]
+		return p, ok && im.files[p]
+	}
+
+	if unescaped, err := url.PathUnescape(destination); err == nil {
+		destination = unescaped
+	}
+	resolved := resolvePath(note.path, destination)
+	return resolved, im.files[resolved]
+}
+
+// upload the needed files by walking the archive again. A file failed to be uploaded only
+// fails the notes it is bound to
+func (im *Import) upload(needed map[string]bool) (map[string]entity.Affiliate, map[string]error, error) {
+	uploaded := make(map[string]entity.Affiliate)
+	failures := make(map[string]error)
+	if len(needed) == 0 {
+		return uploaded, failures, nil
+	}
+
+	err := im.archive.walk(func(name string, r io.Reader) error {
+		if !needed[name] {
+			return nil
+		}
+		if _, ok := uploaded[name]; ok {
+			return nil
+		}
+		if _, ok := failures[name]; ok {
+			return nil
+		}
+
+		filename := im.names[name]
+		if filename == "" {
+			filename = path.Base(name)
+		}
+		affiliate, err := im.repo.UploadAffiliate(r, filename)
+		if err != nil {
+			if errors.Is(err, ErrForbidden) {
+				return err
+			}
+			failures[name] = err
+			return nil
+		}
+		uploaded[name] = affiliate
+		return nil
+	})
+
+	return uploaded, failures, err
+}
+
+// Discard the files uploaded which no post saved is bound to, e.g. those of the notes
+// failed to be saved. Files still referred by other affiliates are kept
+func (im *Import) discard(uploaded map[string]entity.Affiliate, bound map[string]bool) error {
+	oids := []string{}
+	for _, a := range uploaded {
+		if !bound[a.ObjectId] {
+			oids = append(oids, a.ObjectId)
+		}
+	}
+	if len(oids) == 0 {
+		return nil
+	}
+	return im.repo.DiscardUploads(oids)
+}
+
+// Create the post of a note, bound to the tags and the uploaded attachments. Missing tags
+// are created right before the post, and deleted again if it fails to be saved. Since new
+// affiliates only get ids once they are saved, links to the attachments are rewritten
+// into references to the affiliates by saving the post again.
+func (im *Import) save(
+	note importNote,
+	tags *importTags,
+	attachments []string,
+	uploaded map[string]entity.Affiliate,
+	failures map[string]error,
+) (entity.Post, error) {
+	post := entity.Post{
+		Title:    note.matter.Title,
+		Subtitle: note.matter.Subtitle,
+		Content:  note.content,
+		Date:     note.matter.Date,
+	}
+	for _, p := range attachments {
+		if err := failures[p]; err != nil {
+			return entity.Post{}, fmt.Errorf("failed to upload %s: %w", p, err)
+		}
+		// a file bound to several notes is uploaded once, and shared by their affiliates
+		post.Affiliates = append(post.Affiliates, uploaded[p])
+	}
+
+	tagIds, created, err := tags.create(note.matter.Tags)
+	if err != nil {
+		return entity.Post{}, err
+	}
+	for _, id := range tagIds {
+		post.Tags = append(post.Tags, entity.Tag{UintId: entity.UintId{Id: id}})
+	}
+
+	saved, err := im.repo.SavePost(post)
+	if err != nil {
+		if discardErr := tags.discard(created); discardErr != nil {
+			return entity.Post{}, fmt.Errorf("%w, and the tags created are not deleted: %v", err, discardErr)
+		}
+		return entity.Post{}, err
+	}
+
+	ids := make(map[string]uint, len(saved.Affiliates))
+	for _, a := range saved.Affiliates {
+		ids[a.ObjectId] = a.Id
+	}
+	content := util.RewriteLinks(note.content, func(destination string) (string, bool) {
+		resolved, ok := im.linkedFile(note, destination)
+		if !ok {
+			return "", false
+		}
+		id, ok := ids[uploaded[resolved].ObjectId]
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("affiliate:%d", id), true
+	})
+	if content == saved.Content {
+		return saved, nil
+	}
+
+	// associations are given by ids only, the loaded ones carry dates which the hooks reject
+	update := entity.Post{UintId: saved.UintId, Content: content, Version: saved.Version}
+	for _, t := range saved.Tags {
+		update.Tags = append(update.Tags, entity.Tag{UintId: t.UintId})
+	}
+	for _, a := range saved.Affiliates {
+		update.Affiliates = append(update.Affiliates, entity.Affiliate{UintId: a.UintId})
+	}
+	rewritten, err := im.repo.SavePost(update)
+	if err != nil {
+		return saved, fmt.Errorf("post %d is created, but its links are not rewritten: %w", saved.Id, err)
+	}
+
+	return rewritten, nil
+}
+
+// Find a post with the same title and date, return 0 if there is none.
+// Dates are compared to the second, since front matter may carry less precision
+func findDuplicate(repo ToyNoteRepo, title string, date time.Time) (uint, error) {
+	start := date.Truncate(time.Second)
+	search := entity.TimeSearch{Start: start, End: start.Add(time.Second - time.Nanosecond), Type: entity.DATE}
+
+	for page := 1; ; page++ {
+		posts, err := repo.SearchPostsByTimeRange(search, entity.NewPagination(page, exportPageSize))
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range posts.Items {
+			if p.Title == title {
+				return p.Id, nil
+			}
+		}
+		if len(posts.Items) == 0 || int64(page*exportPageSize) >= posts.Total {
+			return 0, nil
+		}
+	}
+}
+
+// importTags
+//
+// Tags matched by name, which is unique for each user. Missing tags are planned along
+// their paths, each nested in the previous one, and only created once a note needs them
+type importTags struct {
+	repo   ToyNoteRepo
+	byName map[string]entity.Tag
+	// the name of the parent of each tag by name, empty at the top. Tags planned have no ids
+	// until they are created, hence parents are told by names
+	parents map[string]string
+	// paths of the tags planned, and of those created so far
+	planned []string
+	created []string
+}
+
+func newImportTags(repo ToyNoteRepo) (*importTags, error) {
+	tags, err := repo.GetTags()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]entity.Tag, len(tags))
+	byId := make(map[uint]string, len(tags))
+	for _, t := range tags {
+		byName[t.Name] = t
+		byId[t.Id] = t.Name
+	}
+	parents := make(map[string]string, len(tags))
+	for _, t := range tags {
+		if t.ParentId != nil {
+			parents[t.Name] = byId[*t.ParentId]
+		}
+	}
+
+	return &importTags{repo: repo, byName: byName, parents: parents}, nil
+}
+
+// Plan the tags along the paths which are missing. Tag names are unique for each owner,
+// hence a tag found by name must be nested in the parent the path tells, otherwise the
+// path conflicts with it.
+func (t *importTags) plan(paths []string) error {
+	// nothing is planned unless all the paths are fine
+	if err := t.check(paths); err != nil {
+		return err
+	}
+
+	for _, p := range tagPathsOf(paths) {
+		names := strings.Split(p, "/")
+		for i, name := range names {
+			if _, ok := t.byName[name]; !ok {
+				t.byName[name] = entity.Tag{Name: name}
+				t.parents[name] = parentOf(names, i)
+				t.planned = append(t.planned, strings.Join(names[:i+1], "/"))
+			}
+		}
+	}
+	return nil
+}
+
+// Ids of the tags at the ends of the paths planned, the tags along them which are not
+// created yet are created. The names of those created are returned
+func (t *importTags) create(paths []string) ([]uint, []string, error) {
+	ids := []uint{}
+	created := []string{}
+	for _, p := range tagPathsOf(paths) {
+		var parent *uint
+		names := strings.Split(p, "/")
+		for i, name := range names {
+			tag := t.byName[name]
+			if tag.Id == 0 {
+				var err error
+				if tag, err = t.repo.SaveTag(entity.Tag{Name: name, ParentId: parent}); err != nil {
+					if discardErr := t.discard(created); discardErr != nil {
+						return nil, nil, fmt.Errorf("%w, and the tags created are not deleted: %v", err, discardErr)
+					}
+					return nil, nil, err
+				}
+				t.byName[name] = tag
+				t.created = append(t.created, strings.Join(names[:i+1], "/"))
+				created = append(created, name)
+			}
+			id := tag.Id
+			parent = &id
+		}
+		ids = append(ids, *parent)
+	}
+
+	return ids, created, nil
+}
+
+// Delete the tags just created by names, nested ones first, they are planned again
+func (t *importTags) discard(names []string) error {
+	for i := len(names) - 1; i >= 0; i-- {
+		if err := t.repo.DeleteTag(t.byName[names[i]].Id); err != nil {
+			return err
+		}
+		t.byName[names[i]] = entity.Tag{Name: names[i]}
+		t.created = t.created[:len(t.created)-1]
+	}
+	return nil
+}
+
+// make sure the tags along the paths are either missing or nested as the paths tell
+func (t *importTags) check(paths []string) error {
+	// parents of the tags to be created, the same tag may be given by several paths
+	planned := make(map[string]string)
+	for _, p := range tagPathsOf(paths) {
+		names := strings.Split(p, "/")
+		for i, name := range names {
+			var parent string
+			if _, ok := t.byName[name]; ok {
+				parent = t.parents[name]
+			} else if parent, ok = planned[name]; !ok {
+				planned[name] = parentOf(names, i)
+				continue
+			}
+			if parent != parentOf(names, i) {
+				where := "at the top"
+				if i > 0 {
+					where = "in " + strings.Join(names[:i], "/")
+				}
+				return fmt.Errorf("tag %s is nested elsewhere, it can't be %s as %s tells", name, where, p)
+			}
+		}
+	}
+	return nil
+}
+
+// the name of the parent of the i-th tag along a path, empty at the top
+func parentOf(names []string, i int) string {
+	if i == 0 {
+		return ""
+	}
+	return names[i-1]
+}
+
+// tag paths of front matter cleaned, e.g. ` lang / go ` into `lang/go`, empty ones are dropped
+func tagPathsOf(paths []string) []string {
+	cleaned := []string{}
+	for _, p := range paths {
+		names := []string{}
+		for _, name := range strings.Split(p, "/") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			cleaned = append(cleaned, strings.Join(names, "/"))
+		}
+	}
+	return cleaned
+}
+
+// Parse a Markdown file, its front matter is optional. Without a title the file name is
+// the title, and without a date the time of the import is the date
+func readNote(name string, data []byte) importNote {
+	note := importNote{path: name}
+	if len(data) > maxImportNoteSize {
+		note.err = fmt.Errorf("file is larger than %d bytes", maxImportNoteSize)
+		return note
+	}
+
+	content := strings.TrimPrefix(string(data), "\ufeff")
+	if matter, rest, ok := splitFrontMatter(content); ok {
+		if err := yaml.Unmarshal([]byte(matter), &note.matter); err != nil {
+			note.err = fmt.Errorf("invalid front matter: %w", err)
+			return note
+		}
+		content = rest
+	}
+	note.content = content
+
+	note.matter.Title = strings.TrimSpace(note.matter.Title)
+	if note.matter.Title == "" {
+		note.matter.Title = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	}
+	if note.matter.Date.IsZero() {
+		note.matter.Date = time.Now()
+	}
+
+	return note
+}
+
+// split `---\n<yaml>---\n\n<content>` as written by `Export`, the blank line is optional
+func splitFrontMatter(s string) (string, string, bool) {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if !strings.HasPrefix(s, "---\n") {
+		return "", "", false
+	}
+
+	rest := s[len("---\n"):]
+	var end int
+	switch {
+	case strings.HasPrefix(rest, "---\n"):
+		end = 0
+	default:
+		i := strings.Index(rest, "\n---\n")
+		if i < 0 {
+			if !strings.HasSuffix(rest, "\n---") {
+				return "", "", false
+			}
+			return rest[:len(rest)-len("---")], "", true
+		}
+		end = i + 1
+	}
+
+	return rest[:end], strings.TrimPrefix(rest[end+len("---\n"):], "\n"), true
+}
+
+func isMarkdown(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// the path of a file in an archive, relative to the Markdown file referring to it
+func resolvePath(notePath, p string) string {
+	return cleanPath(path.Join(path.Dir(notePath), p))
+}
+
+// a path in an archive without `./`, `../` or a leading `/`, and separated by `/`
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+}
+
+// an archive which can be walked more than once, the regular files in it are given to the
+// function by their cleaned paths
+type archive interface {
+	walk(fn func(name string, r io.Reader) error) error
+}
+
+// Open a zip archive or a tarball, told by the leading bytes
+func openArchive(r io.ReaderAt, size int64) (archive, error) {
+	magic := make([]byte, 4)
+	n, _ := r.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK")):
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+		}
+		return zipArchive{zr}, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return tarArchive{r: r, size: size, gzip: true}, nil
+	case n == 0:
+		return nil, fmt.Errorf("%w: empty file", ErrBadArchive)
+	default:
+		return tarArchive{r: r, size: size}, nil
+	}
+}
+
+type zipArchive struct {
+	r *zip.Reader
+}
+
+func (a zipArchive) walk(fn func(name string, r io.Reader) error) error {
+	for _, f := range a.r.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(cleanPath(f.Name), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type tarArchive struct {
+	r    io.ReaderAt
+	size int64
+	gzip bool
+}
+
+func (a tarArchive) walk(fn func(name string, r io.Reader) error) error {
+	var r io.Reader = io.NewSectionReader(a.r, 0, a.size)
+	if a.gzip {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		if err := fn(cleanPath(header.Name), tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// types of the items of a Joplin export, the `type_` property
+const (
+	joplinNote     = "1"
+	joplinResource = "4"
+	joplinTag      = "5"
+	joplinNoteTag  = "6"
+)
+
+// an item of a Joplin RAW export: the title, a blank line and the body, then a blank line
+// and `key: value` properties
+type joplinItem struct {
+	path  string
+	title string
+	body  string
+	props map[string]string
+}
+
+func parseJoplinItem(name, data string) joplinItem {
+	data = strings.TrimRight(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	item := joplinItem{path: name, props: make(map[string]string)}
+
+	// the properties are the lines after the last blank line, an item without a title,
+	// e.g. a note tag, has nothing but properties
+	head, props := "", data
+	if i := strings.LastIndex(data, "\n\n"); i >= 0 {
+		head, props = data[:i], data[i+len("\n\n"):]
+	}
+	for _, line := range strings.Split(props, "\n") {
+		if i := strings.Index(line, ":"); i > 0 {
+			item.props[line[:i]] = strings.TrimPrefix(line[i+1:], " ")
+		}
+	}
+
+	lines := strings.SplitN(head, "\n", 2)
+	item.title = strings.TrimSpace(lines[0])
+	if len(lines) > 1 {
+		item.body = strings.TrimPrefix(lines[1], "\n")
+	}
+
+	return item
+}
+
+// the date of a Joplin note, set by the user or else when it was created
+func (item joplinItem) date() time.Time {
+	for _, key := range []string{"user_created_time", "created_time", "user_updated_time", "updated_time"} {
+		if date, err := time.Parse(time.RFC3339, item.props[key]); err == nil {
+			return date
+		}
+	}
+	return time.Now()
+}
+
+// Prepare an import of a Joplin RAW export, the export directory in a zip archive or
+// a tarball. Each item of the export is a Markdown file named by its id along with its
+// properties, and the files of the resources are under `resources/`, named by their ids.
+// Notes are bound to their tags, and links to resources in the content, e.g.
+// `![](:/0123abcd)`, are rewritten into references to the affiliates. Notebooks, i.e. the
+// folders of Joplin, are not imported.
+func NewJoplinImport(repo ToyNoteRepo, r io.ReaderAt, size int64) (*Import, error) {
+	a, err := openArchive(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	im := &Import{repo: repo, archive: a, files: make(map[string]bool), names: make(map[string]string)}
+	items := []joplinItem{}
+	// files of the resources by ids
+	resourceFiles := make(map[string]string)
+
+	err = a.walk(func(name string, r io.Reader) error {
+		if !isMarkdown(name) {
+			im.files[name] = true
+			if path.Base(path.Dir(name)) == "resources" {
+				base := path.Base(name)
+				resourceFiles[strings.TrimSuffix(base, path.Ext(base))] = name
+			}
+			return nil
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(r, maxImportNoteSize+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > maxImportNoteSize {
+			im.notes = append(im.notes, importNote{
+				path: name,
+				err:  fmt.Errorf("file is larger than %d bytes", maxImportNoteSize),
+			})
+			return nil
+		}
+		items = append(items, parseJoplinItem(name, string(data)))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+	}
+
+	tags := make(map[string]string)
+	noteTags := make(map[string][]string)
+	links := make(map[string]string)
+	for _, item := range items {
+		switch item.props["type_"] {
+		case joplinTag:
+			tags[item.props["id"]] = item.title
+		case joplinResource:
+			id := item.props["id"]
+			if p, ok := resourceFiles[id]; ok {
+				links[":/"+id] = p
+				if item.title != "" {
+					im.names[p] = item.title
+				}
+			}
+		}
+	}
+	for _, item := range items {
+		if item.props["type_"] == joplinNoteTag {
+			if tag, ok := tags[item.props["tag_id"]]; ok {
+				noteTags[item.props["note_id"]] = append(noteTags[item.props["note_id"]], tag)
+			}
+		}
+	}
+
+	found := len(im.notes) > 0
+	for _, item := range items {
+		if item.props["type_"] == "" {
+			continue
+		}
+		found = true
+		if item.props["type_"] != joplinNote {
+			continue
+		}
+
+		note := importNote{path: item.path, content: item.body, links: links}
+		note.matter.Title = item.title
+		if note.matter.Title == "" {
+			note.matter.Title = item.props["id"]
+		}
+		note.matter.Date = item.date()
+		note.matter.Tags = noteTags[item.props["id"]]
+		sort.Strings(note.matter.Tags)
+		if item.props["encryption_applied"] == "1" {
+			note.err = errors.New("note is encrypted, disable encryption in Joplin before exporting")
+		}
+		im.notes = append(im.notes, note)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: no Joplin items found", ErrBadArchive)
+	}
+	sort.Slice(im.notes, func(i, j int) bool { return im.notes[i].path < im.notes[j].path })
+
+	return im, nil
+}
@@ -0,0 +1,16 @@
+package service
+
+import (
+	"toy-note/api/entity"
+	"toy-note/api/util"
+)
+
+// The wiki-style links in the content of a saved post, see `entity.PostLink`
+func postLinks(post entity.Post) []entity.PostLink {
+	links := []entity.PostLink{}
+	for _, target := range util.WikiLinks(post.Content) {
+		links = append(links, entity.NewPostLink(post.Id, target))
+	}
+
+	return links
+}
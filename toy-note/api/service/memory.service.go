@@ -0,0 +1,2240 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"toy-note/api/entity"
+	"toy-note/logger"
+	"unicode"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+In-memory service
+
+A `ToyNoteRepo` implementation which keeps users, tags, posts, affiliates and files in memory.
+It has no external dependency, hence it is suitable for tests and offline development.
+All the data is lost once the process exits.
+*/
+type MemoryToyNoteService struct {
+	accounts
+	logger *zap.SugaredLogger
+	// the user the service is scoped to, see `ForOwner`. 0 means not scoped
+	owner uint
+	// the notebook listings and searches of posts are scoped to, see `InNotebook`
+	notebook entity.NotebookScope
+	*memoryStore
+}
+
+// data shared by a `MemoryToyNoteService` and all its scoped views
+type memoryStore struct {
+	mu         sync.RWMutex
+	tags       map[uint]entity.Tag
+	posts      map[uint]entity.Post
+	postsTags  map[uint][]uint
+	affiliates map[uint]entity.Affiliate
+	files      map[string][]byte
+	revisions  map[uint]entity.PostRevision
+	shares     map[uint]entity.Share
+	links      map[uint]entity.PostLink
+	notebooks  map[uint]entity.Notebook
+
+	tagSeq       uint
+	postSeq      uint
+	affiliateSeq uint
+	fileSeq      uint
+	revisionSeq  uint
+	shareSeq     uint
+	linkSeq      uint
+	notebookSeq  uint
+}
+
+// tokens signs and verifies the tokens of users
+func NewMemoryToyNoteService(logger *logger.ToyNoteLogger, tokens *Tokens) *MemoryToyNoteService {
+	return &MemoryToyNoteService{
+		accounts: accounts{store: newMemoryUserStore(), tokens: tokens},
+		logger:   logger.NewSugar("MemoryToyNoteService"),
+		memoryStore: &memoryStore{
+			tags:       make(map[uint]entity.Tag),
+			posts:      make(map[uint]entity.Post),
+			postsTags:  make(map[uint][]uint),
+			affiliates: make(map[uint]entity.Affiliate),
+			files:      make(map[string][]byte),
+			revisions:  make(map[uint]entity.PostRevision),
+			shares:     make(map[uint]entity.Share),
+			links:      make(map[uint]entity.PostLink),
+			notebooks:  make(map[uint]entity.Notebook),
+		},
+	}
+}
+
+// make sure `MemoryToyNoteService` implements all methods required by `ToyNoteRepo` interface
+var _ ToyNoteRepo = (*MemoryToyNoteService)(nil)
+
+// and `UserRepo` as well
+var _ UserRepo = (*MemoryToyNoteService)(nil)
+
+func (s *MemoryToyNoteService) ForOwner(userId uint) ToyNoteRepo {
+	return &MemoryToyNoteService{
+		accounts:    s.accounts,
+		logger:      s.logger,
+		owner:       userId,
+		notebook:    s.notebook,
+		memoryStore: s.memoryStore,
+	}
+}
+
+func (s *MemoryToyNoteService) InNotebook(scope entity.NotebookScope) ToyNoteRepo {
+	return &MemoryToyNoteService{
+		accounts:    s.accounts,
+		logger:      s.logger,
+		owner:       s.owner,
+		notebook:    scope,
+		memoryStore: s.memoryStore,
+	}
+}
+
+// ============================================================================
+// Helpers, callers must hold the lock
+// ============================================================================
+
+// whether a row owned by a user can be seen by the service, see `ForOwner`
+func (s *MemoryToyNoteService) owns(ownerId uint) bool {
+	return s.owner == 0 || ownerId == s.owner
+}
+
+// fill a stored post with its tags and affiliates
+func (s *MemoryToyNoteService) loadPost(id uint) entity.Post {
+	post := s.posts[id]
+
+	post.Tags = []entity.Tag{}
+	for _, tid := range s.postsTags[id] {
+		post.Tags = append(post.Tags, s.tags[tid])
+	}
+
+	post.Affiliates = []entity.Affiliate{}
+	for _, aid := range s.sortedAffiliateIds() {
+		if s.affiliates[aid].PostRefer == id {
+			post.Affiliates = append(post.Affiliates, s.affiliates[aid])
+		}
+	}
+
+	return post
+}
+
+// whether a post is in the notebook the service is scoped to, see `InNotebook`
+func (s *MemoryToyNoteService) inNotebook(post entity.Post) bool {
+	if s.notebook.NotebookId == 0 {
+		return true
+	}
+	for id := post.NotebookId; id != nil; id = s.notebooks[*id].ParentId {
+		if *id == s.notebook.NotebookId {
+			return true
+		}
+		if !s.notebook.Recursive {
+			return false
+		}
+	}
+	return false
+}
+
+// ids of posts which are not trashed
+func (s *MemoryToyNoteService) sortedPostIds() []uint {
+	ids := make([]uint, 0, len(s.posts))
+	for id, p := range s.posts {
+		if !p.DeletedAt.Valid && s.owns(p.OwnerId) && s.inNotebook(p) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (s *MemoryToyNoteService) sortedTrashIds() []uint {
+	ids := make([]uint, 0)
+	for id, p := range s.posts {
+		if p.DeletedAt.Valid && s.owns(p.OwnerId) && s.inNotebook(p) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// a revision of a post owned by the user, including trashed posts
+func (s *MemoryToyNoteService) ownsRevision(revision entity.PostRevision) bool {
+	post, ok := s.posts[revision.PostId]
+	return ok && s.owns(post.OwnerId)
+}
+
+// the newest revision of a post at a version, of a post owned by the user
+func (s *MemoryToyNoteService) newestRevision(postId, version uint) (entity.PostRevision, bool) {
+	var newest entity.PostRevision
+	for _, r := range s.revisions {
+		if r.PostId == postId && r.Version == version && r.Id > newest.Id && s.ownsRevision(r) {
+			newest = r
+		}
+	}
+	return newest, newest.Id != 0
+}
+
+// a trashed post
+func (s *MemoryToyNoteService) trashedPost(id uint) (entity.Post, bool) {
+	post, ok := s.posts[id]
+	if !ok || !post.DeletedAt.Valid || !s.owns(post.OwnerId) {
+		return entity.Post{}, false
+	}
+	return post, true
+}
+
+// a post which is not trashed
+func (s *MemoryToyNoteService) livePost(id uint) (entity.Post, bool) {
+	post, ok := s.posts[id]
+	if !ok || post.DeletedAt.Valid || !s.owns(post.OwnerId) {
+		return entity.Post{}, false
+	}
+	return post, true
+}
+
+// replace the links of a saved post by the ones in its content
+func (s *MemoryToyNoteService) replacePostLinks(post entity.Post) {
+	for lid, l := range s.links {
+		if l.SourceId == post.Id {
+			delete(s.links, lid)
+		}
+	}
+	for _, l := range postLinks(post) {
+		s.linkSeq++
+		l.Id = s.linkSeq
+		s.links[l.Id] = l
+	}
+}
+
+// all links in order of creation
+func (s *MemoryToyNoteService) sortedLinks() []entity.PostLink {
+	links := make([]entity.PostLink, 0, len(s.links))
+	for _, l := range s.links {
+		links = append(links, l)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Id < links[j].Id })
+	return links
+}
+
+// Resolve a link to a live post of the owner. A link by title is resolved to the oldest
+// post of the title, case-insensitive
+func (s *MemoryToyNoteService) linkTarget(ownerId uint, link entity.PostLink) (entity.Post, bool) {
+	if link.TargetId != nil {
+		post, ok := s.posts[*link.TargetId]
+		if !ok || post.DeletedAt.Valid || post.OwnerId != ownerId {
+			return entity.Post{}, false
+		}
+		return post, true
+	}
+
+	var oldest entity.Post
+	for _, post := range s.posts {
+		if post.DeletedAt.Valid || post.OwnerId != ownerId || !strings.EqualFold(post.Title, link.TargetTitle) {
+			continue
+		}
+		if oldest.Id == 0 || post.Id < oldest.Id {
+			oldest = post
+		}
+	}
+	return oldest, oldest.Id != 0
+}
+
+// the links to a post from other live posts of the same owner
+func (s *MemoryToyNoteService) backlinks(target entity.Post) []entity.LinkedPost {
+	linked := []entity.LinkedPost{}
+	for _, l := range s.sortedLinks() {
+		source, ok := s.posts[l.SourceId]
+		if !ok || source.Id == target.Id || source.DeletedAt.Valid || source.OwnerId != target.OwnerId {
+			continue
+		}
+		if resolved, ok := s.linkTarget(target.OwnerId, l); !ok || resolved.Id != target.Id {
+			continue
+		}
+		linked = append(linked, entity.LinkedPost{
+			Link:   l.Target(),
+			PostId: source.Id,
+			Title:  source.Title,
+		})
+	}
+	return linked
+}
+
+// Delete files which are no longer referred by any affiliate.
+// The same file can be bound to different affiliates, e.g. an affiliate given twice.
+func (s *MemoryToyNoteService) deleteUnreferredFiles(oids []string) {
+	referred := make(map[string]bool)
+	for _, a := range s.affiliates {
+		referred[a.ObjectId] = true
+	}
+
+	for _, oid := range oids {
+		if !referred[oid] {
+			delete(s.files, oid)
+		}
+	}
+}
+
+// ids of affiliates owned by the user
+func (s *MemoryToyNoteService) sortedAffiliateIds() []uint {
+	ids := make([]uint, 0, len(s.affiliates))
+	for id, a := range s.affiliates {
+		if s.owns(a.OwnerId) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// same semantic as `paginationToLimitOffset` in the persistence layer:
+// a non-positive size means no limit, and a non-positive offset means no offset
+func paginate(length int, pagination entity.Pagination) (int, int) {
+	start := (pagination.Page - 1) * pagination.Size
+	if start < 0 {
+		start = 0
+	}
+	if start > length {
+		start = length
+	}
+
+	end := length
+	if pagination.Size > 0 && start+pagination.Size < length {
+		end = start + pagination.Size
+	}
+
+	return start, end
+}
+
+// compare two posts by a sort field, returns -1, 0 or 1
+func comparePosts(a, b entity.Post, field entity.SortField) int {
+	switch field {
+	case entity.SORT_TITLE:
+		return strings.Compare(a.Title, b.Title)
+	case entity.SORT_CREATED_AT:
+		return compareTime(a.CreatedAt, b.CreatedAt)
+	case entity.SORT_UPDATED_AT:
+		return compareTime(a.UpdatedAt, b.UpdatedAt)
+	default:
+		return compareTime(a.Date, b.Date)
+	}
+}
+
+func compareIds(a, b uint) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Load a page of posts by ids, ordered by the sort field and id.
+// The same semantic as `PgRepository.getPosts`, including the keyset cursor over (date, id).
+func (s *MemoryToyNoteService) getPosts(
+	ids []uint,
+	sortField entity.SortField,
+	order entity.SortOrder,
+	pagination entity.Pagination,
+) (entity.PostPage, error) {
+	sorted := make([]uint, len(ids))
+	copy(sorted, ids)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		c := comparePosts(s.posts[sorted[i]], s.posts[sorted[j]], sortField)
+		if c == 0 {
+			c = compareIds(sorted[i], sorted[j])
+		}
+		if order == entity.ASC {
+			return c < 0
+		}
+		return c > 0
+	})
+
+	page := entity.PostPage{
+		Items: []entity.Post{},
+		Total: int64(len(sorted)),
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+	}
+
+	var start, end int
+	if pagination.Cursor != "" {
+		if sortField != entity.SORT_DATE {
+			return entity.PostPage{}, errors.New("cursor is only available when sorted by date")
+		}
+		cursor, err := entity.DecodePostCursor(pagination.Cursor)
+		if err != nil {
+			return entity.PostPage{}, err
+		}
+
+		// skip posts up to the cursor
+		start = len(sorted)
+		for i, id := range sorted {
+			c := compareTime(s.posts[id].Date, cursor.Date)
+			if c == 0 {
+				c = compareIds(id, cursor.Id)
+			}
+			if (order == entity.ASC && c > 0) || (order != entity.ASC && c < 0) {
+				start = i
+				break
+			}
+		}
+		end = len(sorted)
+		if pagination.Size > 0 && start+pagination.Size < end {
+			end = start + pagination.Size
+		}
+	} else {
+		start, end = paginate(len(sorted), pagination)
+	}
+
+	for _, id := range sorted[start:end] {
+		page.Items = append(page.Items, s.loadPost(id))
+	}
+
+	if end < len(sorted) && len(page.Items) > 0 && sortField == entity.SORT_DATE {
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = entity.PostCursor{Date: last.Date, Id: last.Id}.Encode()
+	}
+
+	return page, nil
+}
+
+// make sure all the tags and existing affiliates of a post can be found, and they are
+// owned by the user
+func (s *MemoryToyNoteService) checkAssociations(post entity.Post) error {
+	for _, t := range post.Tags {
+		if stored, ok := s.tags[t.Id]; !ok || !s.owns(stored.OwnerId) {
+			return fmt.Errorf("tag %d not found", t.Id)
+		}
+	}
+	for _, a := range post.Affiliates {
+		if stored, ok := s.affiliates[a.Id]; a.Id != 0 && (!ok || !s.owns(stored.OwnerId)) {
+			return fmt.Errorf("affiliate %d not found", a.Id)
+		}
+	}
+	if post.NotebookId != nil {
+		if stored, ok := s.notebooks[*post.NotebookId]; !ok || !s.owns(stored.OwnerId) {
+			return fmt.Errorf("notebook %d not found", *post.NotebookId)
+		}
+	}
+
+	return nil
+}
+
+// Make sure the parent of a notebook is owned, and it is neither the notebook itself nor
+// nested in it, which would make a cycle
+func (s *MemoryToyNoteService) checkParent(id uint, parentId *uint) error {
+	for ancestor := parentId; ancestor != nil; {
+		if *ancestor == id {
+			return fmt.Errorf("notebook %d can't be nested in itself", id)
+		}
+		notebook, ok := s.notebooks[*ancestor]
+		if !ok || !s.owns(notebook.OwnerId) {
+			return fmt.Errorf("notebook %d not found", *ancestor)
+		}
+		ancestor = notebook.ParentId
+	}
+
+	return nil
+}
+
+// Make sure the parent of a tag is owned, and it is neither the tag itself nor nested in it,
+// which would make a cycle
+func (s *MemoryToyNoteService) checkTagParent(id uint, parentId *uint) error {
+	for ancestor := parentId; ancestor != nil; {
+		if *ancestor == id {
+			return fmt.Errorf("tag %d can't be nested in itself", id)
+		}
+		tag, ok := s.tags[*ancestor]
+		if !ok || !s.owns(tag.OwnerId) {
+			return fmt.Errorf("tag %d not found", *ancestor)
+		}
+		ancestor = tag.ParentId
+	}
+
+	return nil
+}
+
+// whether a post bound to the tags is bound to the given tag, or to a tag nested in it
+// if descendants are included
+func (s *MemoryToyNoteService) hasTag(bound []uint, tagId uint, descendants bool) bool {
+	for _, tid := range bound {
+		for id := &tid; id != nil; id = s.tags[*id].ParentId {
+			if *id == tagId {
+				return true
+			}
+			if !descendants {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// bind tags to a post, replacing the previous ones
+func (s *MemoryToyNoteService) bindTags(postId uint, tags []entity.Tag) {
+	tids := []uint{}
+	for _, t := range tags {
+		tids = append(tids, t.Id)
+	}
+	s.postsTags[postId] = tids
+}
+
+// bind affiliates to a post, new affiliates are created and existing ones are rebound.
+// Affiliates previously bound to the post but not given are unbound.
+func (s *MemoryToyNoteService) bindAffiliates(postId uint, affiliates []entity.Affiliate) {
+	for id, a := range s.affiliates {
+		if a.PostRefer == postId {
+			a.PostRefer = 0
+			s.affiliates[id] = a
+		}
+	}
+
+	now := time.Now()
+	for _, a := range affiliates {
+		if a.Id == 0 {
+			s.affiliateSeq++
+			a.Id = s.affiliateSeq
+			a.OwnerId = s.owner
+			a.CreatedAt = now
+		} else {
+			stored := s.affiliates[a.Id]
+			if a.ObjectId == "" {
+				a.ObjectId = stored.ObjectId
+			}
+			if a.Filename == "" {
+				a.Filename = stored.Filename
+			}
+			if a.ContentType == "" {
+				a.ContentType = stored.ContentType
+				a.Size = stored.Size
+				a.Checksum = stored.Checksum
+			}
+			a.OwnerId = stored.OwnerId
+			a.CreatedAt = stored.CreatedAt
+		}
+		a.PostRefer = postId
+		a.UpdatedAt = now
+		s.affiliates[a.Id] = a
+	}
+}
+
+// ============================================================================
+// Tag
+// ============================================================================
+
+func (s *MemoryToyNoteService) GetTags() ([]entity.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]uint, 0, len(s.tags))
+	for id, t := range s.tags {
+		if s.owns(t.OwnerId) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	tags := []entity.Tag{}
+	for _, id := range ids {
+		tags = append(tags, s.tags[id])
+	}
+
+	return tags, nil
+}
+
+func (s *MemoryToyNoteService) SaveTag(tag entity.Tag) (entity.Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// tag names are unique for each owner
+	owner := s.owner
+	if stored, ok := s.tags[tag.Id]; ok {
+		owner = stored.OwnerId
+	}
+	for _, t := range s.tags {
+		if t.Id != tag.Id && tag.Name != "" && t.Name == tag.Name && t.OwnerId == owner {
+			return entity.Tag{}, fmt.Errorf("tag name %s already exists", tag.Name)
+		}
+	}
+
+	now := time.Now()
+
+	if tag.Id == 0 {
+		if tag.Name == "" {
+			return entity.Tag{}, fmt.Errorf("tag name is required")
+		}
+		if tag.ParentId != nil && *tag.ParentId == 0 {
+			tag.ParentId = nil
+		}
+		if err := s.checkTagParent(0, tag.ParentId); err != nil {
+			return entity.Tag{}, err
+		}
+		s.tagSeq++
+		tag.Id = s.tagSeq
+		tag.Posts = nil
+		tag.Version = 1
+		tag.OwnerId = s.owner
+		tag.CreatedAt = now
+		tag.UpdatedAt = now
+		s.tags[tag.Id] = tag
+		return tag, nil
+	}
+
+	stored, ok := s.tags[tag.Id]
+	if !ok || !s.owns(stored.OwnerId) {
+		return entity.Tag{}, fmt.Errorf("tag %d not found", tag.Id)
+	}
+	if tag.Version != 0 && tag.Version != stored.Version {
+		return entity.Tag{}, &entity.ConflictError{
+			Resource:       "tag",
+			Id:             tag.Id,
+			Version:        tag.Version,
+			Current:        stored,
+			CurrentVersion: stored.Version,
+		}
+	}
+
+	// an empty parent keeps the current one, 0 moves the tag to the top
+	if tag.ParentId != nil && *tag.ParentId == 0 {
+		stored.ParentId = nil
+	} else if tag.ParentId != nil {
+		if err := s.checkTagParent(tag.Id, tag.ParentId); err != nil {
+			return entity.Tag{}, err
+		}
+		stored.ParentId = tag.ParentId
+	}
+
+	// only non-zero fields are updated, the same as `gorm.DB.Updates`
+	if tag.Name != "" {
+		stored.Name = tag.Name
+	}
+	if tag.Description != "" {
+		stored.Description = tag.Description
+	}
+	if tag.Color != "" {
+		stored.Color = tag.Color
+	}
+	stored.Version++
+	stored.UpdatedAt = now
+	s.tags[tag.Id] = stored
+
+	return stored, nil
+}
+
+func (s *MemoryToyNoteService) DeleteTag(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleteTag(id)
+}
+
+// callers must hold the lock
+func (s *MemoryToyNoteService) deleteTag(id uint) error {
+	tag, ok := s.tags[id]
+	if !ok || !s.owns(tag.OwnerId) {
+		return fmt.Errorf("tag %d not found", id)
+	}
+	delete(s.tags, id)
+
+	// the tags nested in it are kept, one level up
+	for tid, t := range s.tags {
+		if t.ParentId != nil && *t.ParentId == id {
+			t.ParentId = tag.ParentId
+			s.tags[tid] = t
+		}
+	}
+
+	// unbind the tag from all posts
+	for pid, tids := range s.postsTags {
+		rest := []uint{}
+		for _, tid := range tids {
+			if tid != id {
+				rest = append(rest, tid)
+			}
+		}
+		s.postsTags[pid] = rest
+	}
+
+	return nil
+}
+
+func (s *MemoryToyNoteService) MergeTags(sourceIds []uint, targetId uint) (entity.Tag, error) {
+	if len(sourceIds) == 0 {
+		return entity.Tag{}, errors.New("source tags are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target, ok := s.tags[targetId]
+	if !ok || !s.owns(target.OwnerId) {
+		return entity.Tag{}, fmt.Errorf("tag %d not found", targetId)
+	}
+	sources := make(map[uint]bool)
+	for _, id := range sourceIds {
+		if id == targetId {
+			return entity.Tag{}, fmt.Errorf("tag %d can't be merged into itself", id)
+		}
+		source, ok := s.tags[id]
+		if !ok || !s.owns(source.OwnerId) {
+			return entity.Tag{}, fmt.Errorf("tag %d not found", id)
+		}
+		if source.OwnerId != target.OwnerId {
+			return entity.Tag{}, fmt.Errorf("tag %d and tag %d are owned by different users", id, targetId)
+		}
+		sources[id] = true
+	}
+
+	// otherwise the target would be nested in itself, once the tags nested in the
+	// sources are moved to it
+	for ancestor := target.ParentId; ancestor != nil; ancestor = s.tags[*ancestor].ParentId {
+		if sources[*ancestor] {
+			return entity.Tag{}, fmt.Errorf("tag %d is nested in tag %d, which can't be merged into it", targetId, *ancestor)
+		}
+	}
+
+	// a post bound to both a source and the target is bound to the target only once
+	for pid, tids := range s.postsTags {
+		rest := []uint{}
+		merged := false
+		for _, tid := range tids {
+			if sources[tid] || tid == targetId {
+				if merged {
+					continue
+				}
+				tid = targetId
+				merged = true
+			}
+			rest = append(rest, tid)
+		}
+		s.postsTags[pid] = rest
+	}
+
+	for tid, t := range s.tags {
+		if t.ParentId != nil && sources[*t.ParentId] {
+			t.ParentId = &targetId
+			s.tags[tid] = t
+		}
+	}
+	for id := range sources {
+		delete(s.tags, id)
+	}
+
+	return s.tags[targetId], nil
+}
+
+// ============================================================================
+// Notebook
+// ============================================================================
+
+func (s *MemoryToyNoteService) GetNotebookTree() ([]entity.NotebookNode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	notebooks := []entity.Notebook{}
+	for _, n := range s.notebooks {
+		if s.owns(n.OwnerId) {
+			notebooks = append(notebooks, n)
+		}
+	}
+
+	counts := make(map[uint]int64)
+	for _, p := range s.posts {
+		if p.NotebookId != nil && !p.DeletedAt.Valid && s.owns(p.OwnerId) {
+			counts[*p.NotebookId]++
+		}
+	}
+
+	return entity.NewNotebookTree(notebooks, counts), nil
+}
+
+func (s *MemoryToyNoteService) GetNotebook(id uint) (entity.Notebook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	notebook, ok := s.notebooks[id]
+	if !ok || !s.owns(notebook.OwnerId) {
+		return entity.Notebook{}, fmt.Errorf("notebook %d not found", id)
+	}
+	return notebook, nil
+}
+
+func (s *MemoryToyNoteService) SaveNotebook(notebook entity.Notebook) (entity.Notebook, error) {
+	notebook, err := checkNotebook(notebook)
+	if err != nil {
+		return entity.Notebook{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkParent(notebook.Id, notebook.ParentId); err != nil {
+		return entity.Notebook{}, err
+	}
+
+	now := time.Now()
+	if notebook.Id == 0 {
+		s.notebookSeq++
+		notebook.Id = s.notebookSeq
+		notebook.OwnerId = s.owner
+		notebook.CreatedAt = now
+	} else {
+		stored, ok := s.notebooks[notebook.Id]
+		if !ok || !s.owns(stored.OwnerId) {
+			return entity.Notebook{}, fmt.Errorf("notebook %d not found", notebook.Id)
+		}
+		// the parent is replaced as well, an empty parent moves the notebook to the root
+		notebook.OwnerId = stored.OwnerId
+		notebook.CreatedAt = stored.CreatedAt
+	}
+	notebook.UpdatedAt = now
+	s.notebooks[notebook.Id] = notebook
+
+	return notebook, nil
+}
+
+func (s *MemoryToyNoteService) DeleteNotebook(id uint, moveTo *entity.Destination) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notebook, ok := s.notebooks[id]
+	if !ok || !s.owns(notebook.OwnerId) {
+		return fmt.Errorf("notebook %d not found", id)
+	}
+
+	if moveTo == nil {
+		// trashed posts are counted as well, otherwise they would be restored nowhere
+		for _, n := range s.notebooks {
+			if n.ParentId != nil && *n.ParentId == id {
+				return entity.ErrNotebookNotEmpty
+			}
+		}
+		for _, p := range s.posts {
+			if p.NotebookId != nil && *p.NotebookId == id {
+				return entity.ErrNotebookNotEmpty
+			}
+		}
+	} else {
+		if err := s.checkParent(id, moveTo.NotebookId); err != nil {
+			return err
+		}
+		for nid, n := range s.notebooks {
+			if n.ParentId != nil && *n.ParentId == id {
+				n.ParentId = moveTo.NotebookId
+				n.UpdatedAt = time.Now()
+				s.notebooks[nid] = n
+			}
+		}
+		for pid, p := range s.posts {
+			if p.NotebookId != nil && *p.NotebookId == id {
+				p.NotebookId = moveTo.NotebookId
+				s.posts[pid] = p
+			}
+		}
+	}
+
+	delete(s.notebooks, id)
+
+	return nil
+}
+
+func (s *MemoryToyNoteService) MovePost(id uint, to entity.Destination) (entity.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	post, ok := s.livePost(id)
+	if !ok {
+		return entity.Post{}, fmt.Errorf("post %d not found", id)
+	}
+	if to.NotebookId != nil {
+		if notebook, ok := s.notebooks[*to.NotebookId]; !ok || !s.owns(notebook.OwnerId) {
+			return entity.Post{}, fmt.Errorf("notebook %d not found", *to.NotebookId)
+		}
+	}
+
+	// moving is not an update of the post itself, the version is kept
+	post.NotebookId = to.NotebookId
+	s.posts[id] = post
+
+	return s.loadPost(id), nil
+}
+
+// ============================================================================
+// Post
+// ============================================================================
+
+func (s *MemoryToyNoteService) GetPosts(pagination entity.Pagination) (entity.PostPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getPosts(s.sortedPostIds(), entity.SORT_DATE, entity.DESC, pagination)
+}
+
+func (s *MemoryToyNoteService) GetPost(id uint) (entity.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.livePost(id); !ok {
+		return entity.Post{}, fmt.Errorf("post %d not found", id)
+	}
+
+	return s.loadPost(id), nil
+}
+
+func (s *MemoryToyNoteService) SavePost(post entity.Post) (entity.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkAssociations(post); err != nil {
+		return entity.Post{}, err
+	}
+
+	// trash is only managed by `DeletePost` and `RestorePost`
+	post.DeletedAt = gorm.DeletedAt{}
+
+	now := time.Now()
+
+	if post.Id == 0 {
+		if err := checkReferences(post, nil); err != nil {
+			return entity.Post{}, err
+		}
+		s.postSeq++
+		post.Id = s.postSeq
+		post.Version = 1
+		post.OwnerId = s.owner
+		post.CreatedAt = now
+	} else {
+		stored, ok := s.livePost(post.Id)
+		if !ok {
+			return entity.Post{}, fmt.Errorf("post %d not found", post.Id)
+		}
+		if post.Version != 0 && post.Version != stored.Version {
+			return entity.Post{}, &entity.ConflictError{
+				Resource:       "post",
+				Id:             post.Id,
+				Version:        post.Version,
+				Current:        s.loadPost(post.Id),
+				CurrentVersion: stored.Version,
+			}
+		}
+		current := s.loadPost(post.Id)
+		if err := checkReferences(post, &current); err != nil {
+			return entity.Post{}, err
+		}
+		post.Version = stored.Version + 1
+		post.OwnerId = stored.OwnerId
+
+		// only non-zero fields are updated, the same as `gorm.DB.Updates`
+		if post.Title == "" {
+			post.Title = stored.Title
+		}
+		if post.Subtitle == "" {
+			post.Subtitle = stored.Subtitle
+		}
+		if post.Content == "" {
+			post.Content = stored.Content
+		}
+		if post.Date.IsZero() {
+			post.Date = stored.Date
+		}
+		if post.NotebookId == nil {
+			post.NotebookId = stored.NotebookId
+		}
+		post.CreatedAt = stored.CreatedAt
+	}
+	post.UpdatedAt = now
+
+	s.bindTags(post.Id, post.Tags)
+	s.bindAffiliates(post.Id, post.Affiliates)
+
+	stored := post
+	stored.Tags = nil
+	stored.Affiliates = nil
+	s.posts[post.Id] = stored
+
+	saved := s.recordRevision(post.Id)
+	s.replacePostLinks(saved)
+
+	return saved, nil
+}
+
+// record a revision of a saved post, and return the post loaded with its tags and affiliates
+func (s *MemoryToyNoteService) recordRevision(postId uint) entity.Post {
+	post := s.loadPost(postId)
+
+	s.revisionSeq++
+	revision := entity.NewPostRevision(post)
+	revision.Id = s.revisionSeq
+	if s.owner != 0 {
+		author := s.owner
+		revision.AuthorId = &author
+	}
+	revision.CreatedAt = time.Now()
+	s.revisions[revision.Id] = revision
+
+	return post
+}
+
+func (s *MemoryToyNoteService) PatchPost(id uint, patch entity.PostPatch) (entity.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.livePost(id)
+	if !ok {
+		return entity.Post{}, fmt.Errorf("post %d not found", id)
+	}
+	current := s.loadPost(id)
+	changes, err := patchChanges(current, patch)
+	if err != nil {
+		return entity.Post{}, err
+	}
+	if changes.Version != 0 && changes.Version != stored.Version {
+		return entity.Post{}, &entity.ConflictError{
+			Resource:       "post",
+			Id:             id,
+			Version:        changes.Version,
+			Current:        current,
+			CurrentVersion: stored.Version,
+		}
+	}
+	if changes.Empty() {
+		return current, nil
+	}
+
+	for _, tid := range changes.AddTagIds {
+		if tag, ok := s.tags[tid]; !ok || !s.owns(tag.OwnerId) {
+			return entity.Post{}, fmt.Errorf("tag %d not found", tid)
+		}
+	}
+	for _, aid := range changes.AddAffiliateIds {
+		if a, ok := s.affiliates[aid]; !ok || !s.owns(a.OwnerId) {
+			return entity.Post{}, fmt.Errorf("affiliate %d not found", aid)
+		}
+	}
+
+	if changes.Title != nil {
+		stored.Title = *changes.Title
+	}
+	if changes.Subtitle != nil {
+		stored.Subtitle = *changes.Subtitle
+	}
+	if changes.Content != nil {
+		stored.Content = *changes.Content
+	}
+	if changes.Date != nil {
+		stored.Date = *changes.Date
+	}
+
+	removed := make(map[uint]bool)
+	for _, tid := range changes.RemoveTagIds {
+		removed[tid] = true
+	}
+	bound := make(map[uint]bool)
+	tids := []uint{}
+	for _, tid := range append(append([]uint{}, s.postsTags[id]...), changes.AddTagIds...) {
+		if !removed[tid] && !bound[tid] {
+			bound[tid] = true
+			tids = append(tids, tid)
+		}
+	}
+	s.postsTags[id] = tids
+
+	// affiliates are rebound and unbound the same as by `bindAffiliates`
+	now := time.Now()
+	for _, aid := range changes.AddAffiliateIds {
+		a := s.affiliates[aid]
+		a.PostRefer = id
+		a.UpdatedAt = now
+		s.affiliates[aid] = a
+	}
+	for _, aid := range changes.RemoveAffiliateIds {
+		if a, ok := s.affiliates[aid]; ok && a.PostRefer == id {
+			a.PostRefer = 0
+			a.UpdatedAt = now
+			s.affiliates[aid] = a
+		}
+	}
+
+	s.bumpVersion(stored)
+	saved := s.loadPost(id)
+	s.replacePostLinks(saved)
+
+	return saved, nil
+}
+
+func (s *MemoryToyNoteService) DeletePost(id uint) ([]entity.LinkedPost, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	post, ok := s.livePost(id)
+	if !ok {
+		return nil, fmt.Errorf("post %d not found", id)
+	}
+
+	backlinks := s.backlinks(post)
+	for i := range backlinks {
+		backlinks[i].Broken = true
+	}
+
+	// soft delete, tags and affiliates stay bound to the post
+	post.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.posts[id] = post
+
+	return backlinks, nil
+}
+
+func (s *MemoryToyNoteService) GetTrash(pagination entity.Pagination) (entity.PostPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getPosts(s.sortedTrashIds(), entity.SORT_DATE, entity.DESC, pagination)
+}
+
+func (s *MemoryToyNoteService) RestorePost(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	post, ok := s.trashedPost(id)
+	if !ok {
+		return fmt.Errorf("post %d not found in trash", id)
+	}
+
+	post.DeletedAt = gorm.DeletedAt{}
+	s.posts[id] = post
+
+	return nil
+}
+
+func (s *MemoryToyNoteService) PurgePost(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.purgePost(id)
+}
+
+// callers must hold the lock
+func (s *MemoryToyNoteService) purgePost(id uint) error {
+	if _, ok := s.trashedPost(id); !ok {
+		return fmt.Errorf("post %d not found in trash", id)
+	}
+
+	// affiliates belong to the post, they are deleted along with it
+	var oids []string
+	for aid, a := range s.affiliates {
+		if a.PostRefer == id {
+			oids = append(oids, a.ObjectId)
+			delete(s.affiliates, aid)
+		}
+	}
+	s.deleteUnreferredFiles(oids)
+
+	for rid, r := range s.revisions {
+		if r.PostId == id {
+			delete(s.revisions, rid)
+		}
+	}
+
+	for sid, sh := range s.shares {
+		if sh.PostId == id {
+			delete(s.shares, sid)
+		}
+	}
+
+	// links to the post are kept, they are reported as broken
+	for lid, l := range s.links {
+		if l.SourceId == id {
+			delete(s.links, lid)
+		}
+	}
+
+	delete(s.postsTags, id)
+	delete(s.posts, id)
+
+	return nil
+}
+
+func (s *MemoryToyNoteService) PurgeTrash(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, id := range s.sortedTrashIds() {
+		if s.posts[id].DeletedAt.Time.Before(before) {
+			if err := s.purgePost(id); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// ============================================================================
+// Revision
+// ============================================================================
+
+func (s *MemoryToyNoteService) GetRevisions(postId uint, pagination entity.Pagination) (entity.RevisionPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := []entity.PostRevision{}
+	for _, r := range s.revisions {
+		if r.PostId == postId && s.ownsRevision(r) {
+			revisions = append(revisions, r)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Id > revisions[j].Id })
+
+	start, end := paginate(len(revisions), pagination)
+
+	return entity.RevisionPage{
+		Items: revisions[start:end],
+		Total: int64(len(revisions)),
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+	}, nil
+}
+
+func (s *MemoryToyNoteService) GetRevision(id uint) (entity.PostRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revision, ok := s.revisions[id]
+	if !ok || !s.ownsRevision(revision) {
+		return entity.PostRevision{}, fmt.Errorf("revision %d not found", id)
+	}
+
+	return revision, nil
+}
+
+func (s *MemoryToyNoteService) DiffRevisions(from, to uint) (entity.RevisionDiff, error) {
+	fromRevision, err := s.GetRevision(from)
+	if err != nil {
+		return entity.RevisionDiff{}, err
+	}
+
+	toRevision, err := s.GetRevision(to)
+	if err != nil {
+		return entity.RevisionDiff{}, err
+	}
+
+	return diffRevisions(fromRevision, toRevision)
+}
+
+func (s *MemoryToyNoteService) RestoreRevision(id uint) (entity.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revision, ok := s.revisions[id]
+	if !ok || !s.ownsRevision(revision) {
+		return entity.Post{}, fmt.Errorf("revision %d not found", id)
+	}
+
+	post, ok := s.livePost(revision.PostId)
+	if !ok {
+		return entity.Post{}, fmt.Errorf("post %d not found", revision.PostId)
+	}
+
+	// tags deleted since then are skipped
+	tags := []entity.Tag{}
+	for _, tid := range revision.TagIds {
+		if t, ok := s.tags[tid]; ok && s.owns(t.OwnerId) {
+			tags = append(tags, t)
+		}
+	}
+
+	// affiliates deleted or bound to another post since then are skipped
+	affiliates := []entity.Affiliate{}
+	for _, aid := range revision.AffiliateIds {
+		if a, ok := s.affiliates[aid]; ok && s.owns(a.OwnerId) && (a.PostRefer == 0 || a.PostRefer == post.Id) {
+			affiliates = append(affiliates, a)
+		}
+	}
+
+	// zero values (e.g. an empty subtitle) are restored as well
+	post.Title = revision.Title
+	post.Subtitle = revision.Subtitle
+	post.Content = revision.Content
+	post.Date = revision.Date
+	post.Version++
+	post.UpdatedAt = time.Now()
+	s.posts[post.Id] = post
+
+	s.bindTags(post.Id, tags)
+	s.bindAffiliates(post.Id, affiliates)
+
+	saved := s.recordRevision(post.Id)
+	s.replacePostLinks(saved)
+
+	return saved, nil
+}
+
+func (s *MemoryToyNoteService) RenderPosts(posts []entity.Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached := make(map[uint]string)
+	for _, post := range posts {
+		if revision, ok := s.newestRevision(post.Id, post.Version); ok && revision.ContentHtml != "" {
+			cached[post.Id] = revision.ContentHtml
+		}
+	}
+
+	rendered, err := renderPosts(posts, cached)
+	if err != nil {
+		return err
+	}
+	for _, post := range rendered {
+		if revision, ok := s.newestRevision(post.Id, post.Version); ok {
+			revision.ContentHtml = post.ContentHtml
+			s.revisions[revision.Id] = revision
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================
+// Bulk
+// ============================================================================
+
+// the posts which can be changed, the others are failed
+func (s *MemoryToyNoteService) bulkPosts(ids []uint) ([]uint, map[uint]error) {
+	found := []uint{}
+	errs := make(map[uint]error)
+	for _, id := range ids {
+		if _, ok := s.livePost(id); ok {
+			found = append(found, id)
+		} else {
+			errs[id] = fmt.Errorf("post %d not found", id)
+		}
+	}
+	return found, errs
+}
+
+// save a new version of a changed post, and record its revision
+func (s *MemoryToyNoteService) bumpVersion(post entity.Post) {
+	post.Version++
+	post.UpdatedAt = time.Now()
+	s.posts[post.Id] = post
+	s.recordRevision(post.Id)
+}
+
+func (s *MemoryToyNoteService) BulkTagPosts(tagging entity.BulkTagging) (entity.BulkReport, error) {
+	tagging, err := checkBulkTagging(tagging)
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := make(map[uint]bool)
+	for _, id := range tagging.RemoveTagIds {
+		removed[id] = true
+	}
+	for _, id := range append(append([]uint{}, tagging.AddTagIds...), tagging.RemoveTagIds...) {
+		if tag, ok := s.tags[id]; !ok || !s.owns(tag.OwnerId) {
+			return entity.BulkReport{}, fmt.Errorf("tag %d not found", id)
+		}
+	}
+
+	found, errs := s.bulkPosts(tagging.PostIds)
+	for _, id := range found {
+		bound := make(map[uint]bool)
+		tids := []uint{}
+		for _, tid := range s.postsTags[id] {
+			if !removed[tid] {
+				bound[tid] = true
+				tids = append(tids, tid)
+			}
+		}
+		for _, tid := range tagging.AddTagIds {
+			if !bound[tid] {
+				bound[tid] = true
+				tids = append(tids, tid)
+			}
+		}
+		s.postsTags[id] = tids
+		s.bumpVersion(s.posts[id])
+	}
+
+	return entity.NewBulkReport(tagging.PostIds, errs), nil
+}
+
+func (s *MemoryToyNoteService) BulkDeletePosts(ids []uint) (entity.BulkReport, error) {
+	ids, err := entity.NormalizeBulkIds(ids)
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, errs := s.bulkPosts(ids)
+	now := time.Now()
+	for _, id := range found {
+		// soft delete, tags and affiliates stay bound to the posts
+		post := s.posts[id]
+		post.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		s.posts[id] = post
+	}
+
+	return entity.NewBulkReport(ids, errs), nil
+}
+
+func (s *MemoryToyNoteService) BulkDatePosts(dating entity.BulkDating) (entity.BulkReport, error) {
+	dating, err := checkBulkDating(dating)
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, errs := s.bulkPosts(dating.PostIds)
+	for _, id := range found {
+		post := s.posts[id]
+		post.Date = dating.Date
+		s.bumpVersion(post)
+	}
+
+	return entity.NewBulkReport(dating.PostIds, errs), nil
+}
+
+func (s *MemoryToyNoteService) BulkDeleteTags(ids []uint) (entity.BulkReport, error) {
+	ids, err := entity.NormalizeBulkIds(ids)
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// one by one, since a tag may be nested in another one deleted before it
+	errs := make(map[uint]error)
+	for _, id := range ids {
+		if err := s.deleteTag(id); err != nil {
+			errs[id] = err
+		}
+	}
+
+	return entity.NewBulkReport(ids, errs), nil
+}
+
+// ============================================================================
+// Link
+// ============================================================================
+
+func (s *MemoryToyNoteService) GetPostLinks(id uint) ([]entity.LinkedPost, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	source, ok := s.livePost(id)
+	if !ok {
+		return nil, fmt.Errorf("post %d not found", id)
+	}
+
+	linked := []entity.LinkedPost{}
+	for _, l := range s.sortedLinks() {
+		if l.SourceId != source.Id {
+			continue
+		}
+		target, ok := s.linkTarget(source.OwnerId, l)
+		linked = append(linked, entity.LinkedPost{
+			Link:   l.Target(),
+			PostId: target.Id,
+			Title:  target.Title,
+			Broken: !ok,
+		})
+	}
+
+	return linked, nil
+}
+
+func (s *MemoryToyNoteService) GetBacklinks(id uint) ([]entity.LinkedPost, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	target, ok := s.livePost(id)
+	if !ok {
+		return nil, fmt.Errorf("post %d not found", id)
+	}
+
+	return s.backlinks(target), nil
+}
+
+// ============================================================================
+// Share
+// ============================================================================
+
+func (s *MemoryToyNoteService) CreateShare(req entity.ShareRequest) (entity.ShareLink, error) {
+	token, share, err := newShare(req)
+	if err != nil {
+		return entity.ShareLink{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	post, ok := s.livePost(share.PostId)
+	if !ok {
+		return entity.ShareLink{}, fmt.Errorf("post %d not found", share.PostId)
+	}
+
+	s.shareSeq++
+	share.Id = s.shareSeq
+	share.OwnerId = post.OwnerId
+	share.CreatedAt = time.Now()
+	s.shares[share.Id] = share
+
+	return entity.ShareLink{Share: share, Token: token}, nil
+}
+
+func (s *MemoryToyNoteService) GetShares(postId uint) ([]entity.Share, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shares := []entity.Share{}
+	for _, sh := range s.shares {
+		if sh.PostId == postId && s.owns(sh.OwnerId) {
+			shares = append(shares, sh)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Id > shares[j].Id })
+
+	return shares, nil
+}
+
+func (s *MemoryToyNoteService) RevokeShare(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	share, ok := s.shares[id]
+	if !ok || share.RevokedAt != nil || !s.owns(share.OwnerId) {
+		return fmt.Errorf("share %d not found", id)
+	}
+
+	now := time.Now()
+	share.RevokedAt = &now
+	s.shares[id] = share
+
+	return nil
+}
+
+func (s *MemoryToyNoteService) OpenShare(token, password string, format entity.ContentFormat) (entity.SharedPost, error) {
+	s.mu.RLock()
+	share, post, err := s.openShare(token, password)
+	s.mu.RUnlock()
+	if err != nil {
+		return entity.SharedPost{}, err
+	}
+	if format == entity.FORMAT_HTML {
+		posts := []entity.Post{post}
+		if err := s.ForOwner(share.OwnerId).RenderPosts(posts); err != nil {
+			return entity.SharedPost{}, err
+		}
+		post = posts[0]
+	}
+
+	return entity.NewSharedPost(post, share), nil
+}
+
+func (s *MemoryToyNoteService) DownloadSharedAffiliate(token, password string, affiliateId uint) (entity.FileObject, error) {
+	s.mu.RLock()
+	share, post, err := s.openShare(token, password)
+	s.mu.RUnlock()
+	if err != nil {
+		return entity.FileObject{}, err
+	}
+	if err := sharedAffiliate(post, affiliateId); err != nil {
+		return entity.FileObject{}, err
+	}
+
+	return s.ForOwner(share.OwnerId).DownloadAffiliate(affiliateId)
+}
+
+// find a share link by its token, of any owner, and the post it shares.
+// Callers must hold the lock
+func (s *MemoryToyNoteService) openShare(token, password string) (entity.Share, entity.Post, error) {
+	hash := hashToken(token)
+
+	for _, share := range s.shares {
+		if share.TokenHash != hash {
+			continue
+		}
+		if err := checkShare(share, password); err != nil {
+			return entity.Share{}, entity.Post{}, err
+		}
+		post, ok := s.posts[share.PostId]
+		if !ok || post.DeletedAt.Valid {
+			return entity.Share{}, entity.Post{}, ErrShareNotFound
+		}
+		return share, s.loadPost(post.Id), nil
+	}
+
+	return entity.Share{}, entity.Post{}, ErrShareNotFound
+}
+
+// ============================================================================
+// Affiliate
+// ============================================================================
+
+func (s *MemoryToyNoteService) UploadAffiliate(reader io.Reader, filename string) (entity.Affiliate, error) {
+	buffered := bufio.NewReaderSize(reader, sniffLen)
+	contentType := sniffContentType(buffered, filename)
+
+	data, err := ioutil.ReadAll(buffered)
+	if err != nil {
+		return entity.Affiliate{}, err
+	}
+	checksum := sha256.Sum256(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// mimic a MongoDB ObjectId, which is a 24 characters hex string
+	s.fileSeq++
+	oid := fmt.Sprintf("%024x", s.fileSeq)
+	s.files[oid] = data
+
+	s.logger.Debug(fmt.Sprintf("File %s uploaded, size: %v", filename, len(data)))
+
+	return entity.Affiliate{
+		ObjectId:    oid,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Checksum:    hex.EncodeToString(checksum[:]),
+	}, nil
+}
+
+func (s *MemoryToyNoteService) DiscardUploads(oids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteUnreferredFiles(oids)
+	return nil
+}
+
+func (s *MemoryToyNoteService) DownloadAffiliate(id uint) (entity.FileObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	affiliate, ok := s.affiliates[id]
+	if !ok || !s.owns(affiliate.OwnerId) {
+		return entity.FileObject{}, fmt.Errorf("affiliate %d not found", id)
+	}
+
+	data, ok := s.files[affiliate.ObjectId]
+	if !ok {
+		return entity.FileObject{}, fmt.Errorf("file %s not found", affiliate.ObjectId)
+	}
+
+	contentType := affiliate.ContentType
+	if contentType == "" {
+		contentType = guessContentType(affiliate.Filename)
+	}
+
+	return entity.FileObject{
+		Filename:    affiliate.Filename,
+		ContentType: contentType,
+		Content:     nopSeekCloser{bytes.NewReader(data)},
+		Size:        int64(len(data)),
+		ModTime:     affiliate.UpdatedAt,
+		ETag:        fmt.Sprintf(`"%s"`, affiliate.ObjectId),
+	}, nil
+}
+
+// like `ioutil.NopCloser`, but keeps the reader seekable
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+func (s *MemoryToyNoteService) GetUnownedAffiliates(pagination entity.Pagination) (entity.AffiliatePage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	unowned := []entity.Affiliate{}
+	for _, id := range s.sortedAffiliateIds() {
+		if s.affiliates[id].PostRefer == 0 {
+			unowned = append(unowned, s.affiliates[id])
+		}
+	}
+
+	start, end := paginate(len(unowned), pagination)
+
+	return entity.AffiliatePage{
+		Items: unowned[start:end],
+		Total: int64(len(unowned)),
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+	}, nil
+}
+
+func (s *MemoryToyNoteService) RebindAffiliate(postId, affiliateId uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	affiliate, ok := s.affiliates[affiliateId]
+	if !ok || !s.owns(affiliate.OwnerId) {
+		return fmt.Errorf("affiliate %d not found", affiliateId)
+	}
+	post, ok := s.livePost(postId)
+	if !ok {
+		return fmt.Errorf("post %d not found", postId)
+	}
+	if post.OwnerId != affiliate.OwnerId {
+		return fmt.Errorf("affiliate %d and post %d are owned by different users", affiliateId, postId)
+	}
+
+	affiliate.PostRefer = postId
+	affiliate.UpdatedAt = time.Now()
+	s.affiliates[affiliateId] = affiliate
+
+	return nil
+}
+
+func (s *MemoryToyNoteService) DeleteUnownedAffiliates(ids []uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var oids []string
+	for _, id := range ids {
+		affiliate, ok := s.affiliates[id]
+		if !ok || affiliate.PostRefer != 0 || !s.owns(affiliate.OwnerId) {
+			continue
+		}
+		oids = append(oids, affiliate.ObjectId)
+		delete(s.affiliates, id)
+	}
+	s.deleteUnreferredFiles(oids)
+
+	return nil
+}
+
+// ============================================================================
+// Search
+// ============================================================================
+
+func (s *MemoryToyNoteService) SearchPostsByTags(tagIds []uint, descendants bool, pagination entity.Pagination) (entity.PostPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// a post matches only if it is bound to all the given tags
+	ids := []uint{}
+	for _, pid := range s.sortedPostIds() {
+		matched := len(tagIds) > 0
+		for _, tid := range tagIds {
+			if !s.hasTag(s.postsTags[pid], tid, descendants) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			ids = append(ids, pid)
+		}
+	}
+
+	return s.getPosts(ids, entity.SORT_DATE, entity.DESC, pagination)
+}
+
+func (s *MemoryToyNoteService) SearchPostsByTitle(title string, pagination entity.Pagination) (entity.PostPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := []uint{}
+	for _, pid := range s.sortedPostIds() {
+		if strings.Contains(s.posts[pid].Title, title) {
+			ids = append(ids, pid)
+		}
+	}
+
+	return s.getPosts(ids, entity.SORT_DATE, entity.DESC, pagination)
+}
+
+// inclusive on both sides, the same as sql `BETWEEN`
+func inTimeRange(post entity.Post, timeSearch entity.TimeSearch) bool {
+	var t time.Time
+	switch timeSearch.Type {
+	case entity.CREATE:
+		t = post.CreatedAt
+	case entity.MODIFY:
+		t = post.UpdatedAt
+	default:
+		t = post.Date
+	}
+
+	return !t.Before(timeSearch.Start) && !t.After(timeSearch.End)
+}
+
+func (s *MemoryToyNoteService) SearchPostsByTimeRange(timeSearch entity.TimeSearch, pagination entity.Pagination) (entity.PostPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := []uint{}
+	for _, pid := range s.sortedPostIds() {
+		if inTimeRange(s.posts[pid], timeSearch) {
+			ids = append(ids, pid)
+		}
+	}
+
+	return s.getPosts(ids, entity.SORT_DATE, entity.DESC, pagination)
+}
+
+// split text into lower-cased words, roughly the same as the `simple` text search
+// configuration of Postgres
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func countTerm(words []string, term string) int {
+	n := 0
+	for _, w := range words {
+		if w == term {
+			n++
+		}
+	}
+	return n
+}
+
+// a window of the content around the first matched word, matched words are marked
+func searchSnippet(content string, terms map[string]bool) string {
+	const maxWords = 30
+
+	words := strings.Fields(content)
+	first := 0
+	for i, w := range words {
+		if matchesAny(w, terms) {
+			first = i
+			break
+		}
+	}
+
+	start := first - 5
+	if start < 0 {
+		start = 0
+	}
+	end := start + maxWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	snippet := make([]string, 0, end-start)
+	for _, w := range words[start:end] {
+		if matchesAny(w, terms) {
+			w = "<mark>" + w + "</mark>"
+		}
+		snippet = append(snippet, w)
+	}
+
+	return strings.Join(snippet, " ")
+}
+
+func matchesAny(word string, terms map[string]bool) bool {
+	for _, t := range searchTerms(word) {
+		if terms[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// Every word of the text must be found, and words prefixed by "-" must not be found,
+// which is a subset of `websearch_to_tsquery`
+func parseSearchText(text string) (map[string]bool, map[string]bool) {
+	included := map[string]bool{}
+	excluded := map[string]bool{}
+	for _, field := range strings.Fields(text) {
+		target := included
+		if strings.HasPrefix(field, "-") {
+			target = excluded
+		}
+		for _, t := range searchTerms(field) {
+			target[t] = true
+		}
+	}
+
+	return included, excluded
+}
+
+// Whether a post matches the parsed search text, and its rank with the same weights as `ts_rank`
+func searchRank(post entity.Post, included, excluded map[string]bool) (float64, bool) {
+	if len(included) == 0 {
+		return 0, false
+	}
+
+	title := searchTerms(post.Title)
+	subtitle := searchTerms(post.Subtitle)
+	content := searchTerms(post.Content)
+
+	rank := 0.0
+	for t := range included {
+		n := []int{countTerm(title, t), countTerm(subtitle, t), countTerm(content, t)}
+		if n[0]+n[1]+n[2] == 0 {
+			return 0, false
+		}
+		rank += 1.0*float64(n[0]) + 0.4*float64(n[1]) + 0.2*float64(n[2])
+	}
+	for t := range excluded {
+		if countTerm(title, t)+countTerm(subtitle, t)+countTerm(content, t) > 0 {
+			return 0, false
+		}
+	}
+
+	return rank, true
+}
+
+func (s *MemoryToyNoteService) SearchPosts(text string, pagination entity.Pagination) (entity.PostSearchPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	included, excluded := parseSearchText(text)
+
+	results := []entity.PostSearchResult{}
+	for _, pid := range s.sortedPostIds() {
+		post := s.posts[pid]
+		rank, matched := searchRank(post, included, excluded)
+		if !matched {
+			continue
+		}
+
+		results = append(results, entity.PostSearchResult{
+			Post:    s.loadPost(pid),
+			Rank:    rank,
+			Snippet: searchSnippet(post.Content, included),
+		})
+	}
+
+	// best matches first, newer posts first for the same rank
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Post.Id > results[j].Post.Id
+	})
+
+	start, end := paginate(len(results), pagination)
+
+	return entity.PostSearchPage{
+		Items: results[start:end],
+		Total: int64(len(results)),
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+	}, nil
+}
+
+// ============================================================================
+// Query
+// ============================================================================
+
+// whether the tags bound to a post satisfy the tag criteria of a query
+func (s *MemoryToyNoteService) matchTags(bound []uint, query entity.PostQuery) bool {
+	for _, tid := range query.ExcludeTagIds {
+		if s.hasTag(bound, tid, query.TagDescendants) {
+			return false
+		}
+	}
+
+	if len(query.TagIds) == 0 {
+		return true
+	}
+	if query.TagMatch == entity.MATCH_ANY {
+		for _, tid := range query.TagIds {
+			if s.hasTag(bound, tid, query.TagDescendants) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, tid := range query.TagIds {
+		if !s.hasTag(bound, tid, query.TagDescendants) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MemoryToyNoteService) QueryPosts(query entity.PostQuery) (entity.PostPage, error) {
+	if err := query.Normalize(); err != nil {
+		return entity.PostPage{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	included, excluded := parseSearchText(query.Text)
+
+	ids := []uint{}
+	for _, pid := range s.sortedPostIds() {
+		post := s.posts[pid]
+
+		if !s.matchTags(s.postsTags[pid], query) {
+			continue
+		}
+		if query.Text != "" {
+			if _, matched := searchRank(post, included, excluded); !matched {
+				continue
+			}
+		}
+		if query.Time != nil && !inTimeRange(post, *query.Time) {
+			continue
+		}
+
+		ids = append(ids, pid)
+	}
+
+	return s.getPosts(ids, query.Sort, query.Order, query.Pagination())
+}
+
+// ============================================================================
+// User
+// ============================================================================
+
+// users and refresh tokens in memory, which implements `userStore`
+type memoryUserStore struct {
+	mu       sync.RWMutex
+	users    map[uint]entity.User
+	sessions map[string]entity.RefreshToken
+	keys     map[uint]entity.ApiKey
+
+	userSeq    uint
+	sessionSeq uint
+	keySeq     uint
+}
+
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{
+		users:    make(map[uint]entity.User),
+		sessions: make(map[string]entity.RefreshToken),
+		keys:     make(map[uint]entity.ApiKey),
+	}
+}
+
+func (m *memoryUserStore) CreateUser(user entity.User) (entity.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Name == user.Name {
+			return entity.User{}, fmt.Errorf("user %s already exists", user.Name)
+		}
+	}
+
+	// the first user is the admin, see `persistence.PgRepository.CreateUser`
+	if len(m.users) == 0 {
+		user.Role = entity.ROLE_ADMIN
+	}
+
+	now := time.Now()
+	m.userSeq++
+	user.Id = m.userSeq
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	m.users[user.Id] = user
+
+	return user, nil
+}
+
+func (m *memoryUserStore) GetUser(id uint) (entity.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return entity.User{}, fmt.Errorf("user %d not found", id)
+	}
+
+	return user, nil
+}
+
+func (m *memoryUserStore) GetUserByName(name string) (entity.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+
+	return entity.User{}, fmt.Errorf("user %s not found", name)
+}
+
+func (m *memoryUserStore) UpdateUserRole(id uint, role entity.Role) (entity.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return entity.User{}, fmt.Errorf("user %d not found", id)
+	}
+	user.Role = role
+	user.UpdatedAt = time.Now()
+	m.users[id] = user
+
+	return user, nil
+}
+
+func (m *memoryUserStore) CreateRefreshToken(token entity.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessionSeq++
+	token.Id = m.sessionSeq
+	token.CreatedAt = time.Now()
+	m.sessions[token.TokenHash] = token
+
+	return nil
+}
+
+func (m *memoryUserStore) UseRefreshToken(hash string) (entity.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.sessions[hash]
+	if !ok || token.RevokedAt != nil || !token.ExpiresAt.After(time.Now()) {
+		return entity.RefreshToken{}, errors.New("refresh token not found")
+	}
+
+	now := time.Now()
+	token.RevokedAt = &now
+	m.sessions[hash] = token
+
+	return token, nil
+}
+
+func (m *memoryUserStore) GetRefreshToken(hash string) (entity.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.sessions[hash]
+	if !ok {
+		return entity.RefreshToken{}, errors.New("refresh token not found")
+	}
+
+	return token, nil
+}
+
+func (m *memoryUserStore) RevokeRefreshTokens(userId uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range m.sessions {
+		if token.UserId == userId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			m.sessions[hash] = token
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryUserStore) CreateApiKey(key entity.ApiKey) (entity.ApiKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.keys {
+		if k.Prefix == key.Prefix {
+			return entity.ApiKey{}, fmt.Errorf("api key %s already exists", key.Prefix)
+		}
+	}
+
+	m.keySeq++
+	key.Id = m.keySeq
+	key.CreatedAt = time.Now()
+	m.keys[key.Id] = key
+
+	return key, nil
+}
+
+func (m *memoryUserStore) GetApiKeys(userId uint) ([]entity.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []entity.ApiKey{}
+	for _, k := range m.keys {
+		if k.UserId == userId {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Id > keys[j].Id })
+
+	return keys, nil
+}
+
+func (m *memoryUserStore) GetApiKeyByPrefix(prefix string) (entity.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.keys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+
+	return entity.ApiKey{}, fmt.Errorf("api key %s not found", prefix)
+}
+
+func (m *memoryUserStore) RevokeApiKey(userId uint, prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, k := range m.keys {
+		if k.Prefix == prefix && k.RevokedAt == nil && (userId == 0 || k.UserId == userId) {
+			now := time.Now()
+			k.RevokedAt = &now
+			m.keys[id] = k
+			return nil
+		}
+	}
+
+	return fmt.Errorf("api key %s not found", prefix)
+}
+
+func (m *memoryUserStore) TouchApiKey(id uint, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k, ok := m.keys[id]; ok {
+		k.LastUsedAt = &at
+		m.keys[id] = k
+	}
+
+	return nil
+}
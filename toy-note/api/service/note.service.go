@@ -1,7 +1,9 @@
 package service
 
 import (
-	"context"
+	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"time"
 	"toy-note/api/entity"
@@ -9,6 +11,7 @@ import (
 	"toy-note/logger"
 
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 /*
@@ -19,33 +22,50 @@ In fact, for most of the small projects, service layer is not a necessity, becau
 logic is almost the same as persistence layer's methods.
 */
 type ToyNoteService struct {
+	accounts
 	logger *zap.SugaredLogger
 	pg     *persistence.PgRepository
-	mongo  *persistence.MongoRepository
+	blob   persistence.BlobStore
 }
 
+// blob is where affiliate files are stored, e.g. `persistence.MongoRepository` (GridFS)
+// or `persistence.FsRepository` (local filesystem).
+// tokens signs and verifies the tokens of users.
 func NewToyNoteService(
 	logger *logger.ToyNoteLogger,
 	pgConn persistence.PgConn,
-	mongoConn persistence.MongoConn,
+	blob persistence.BlobStore,
+	tokens *Tokens,
 ) (*ToyNoteService, error) {
 	pg, err := persistence.NewPgRepository(logger, pgConn)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	mongo, err := persistence.NewMongoRepository(ctx, logger, mongoConn)
+	return &ToyNoteService{
+		accounts: accounts{store: &pg, tokens: tokens},
+		logger:   logger.NewSugar("ToyNoteService"),
+		pg:       &pg,
+		blob:     blob,
+	}, nil
+}
+
+// `UserRepo` backed by Postgres alone, for tools which manage users without serving notes,
+// e.g. the `apikey` command. Tables are migrated to the latest schema.
+func NewUserService(
+	logger *logger.ToyNoteLogger,
+	pgConn persistence.PgConn,
+	tokens *Tokens,
+) (UserRepo, error) {
+	pg, err := persistence.NewPgRepository(logger, pgConn)
 	if err != nil {
 		return nil, err
 	}
+	if err := pg.AutoMigrate(); err != nil {
+		return nil, err
+	}
 
-	return &ToyNoteService{
-		logger: logger.NewSugar("ToyNoteService"),
-		pg:     &pg,
-		mongo:  &mongo,
-	}, nil
+	return accounts{store: &pg, tokens: tokens}, nil
 }
 
 func (s *ToyNoteService) Init() error {
@@ -62,6 +82,27 @@ func (s *ToyNoteService) Init() error {
 // make sure `ToyNoteService` implements all methods required by `ToyNoteRepo` interface
 var _ ToyNoteRepo = (*ToyNoteService)(nil)
 
+// and `UserRepo` as well
+var _ UserRepo = (*ToyNoteService)(nil)
+
+func (s *ToyNoteService) ForOwner(userId uint) ToyNoteRepo {
+	return &ToyNoteService{
+		accounts: s.accounts,
+		logger:   s.logger,
+		pg:       s.pg.ForOwner(userId),
+		blob:     s.blob,
+	}
+}
+
+func (s *ToyNoteService) InNotebook(scope entity.NotebookScope) ToyNoteRepo {
+	return &ToyNoteService{
+		accounts: s.accounts,
+		logger:   s.logger,
+		pg:       s.pg.InNotebook(scope),
+		blob:     s.blob,
+	}
+}
+
 func (s *ToyNoteService) GetTags() ([]entity.Tag, error) {
 	return s.pg.GetTags()
 }
@@ -78,69 +119,352 @@ func (s *ToyNoteService) DeleteTag(id uint) error {
 	return s.pg.DeleteTag(id)
 }
 
-func (s *ToyNoteService) GetPosts(pagination entity.Pagination) ([]entity.Post, error) {
+func (s *ToyNoteService) MergeTags(sourceIds []uint, targetId uint) (entity.Tag, error) {
+	if len(sourceIds) == 0 {
+		return entity.Tag{}, errors.New("source tags are required")
+	}
+	return s.pg.MergeTags(sourceIds, targetId)
+}
+
+func (s *ToyNoteService) BulkDeleteTags(ids []uint) (entity.BulkReport, error) {
+	ids, err := entity.NormalizeBulkIds(ids)
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+	return s.pg.BulkDeleteTags(ids)
+}
+
+func (s *ToyNoteService) GetNotebookTree() ([]entity.NotebookNode, error) {
+	return s.pg.GetNotebookTree()
+}
+
+func (s *ToyNoteService) GetNotebook(id uint) (entity.Notebook, error) {
+	return s.pg.GetNotebook(id)
+}
+
+func (s *ToyNoteService) SaveNotebook(notebook entity.Notebook) (entity.Notebook, error) {
+	notebook, err := checkNotebook(notebook)
+	if err != nil {
+		return entity.Notebook{}, err
+	}
+
+	if notebook.Id == 0 {
+		return s.pg.CreateNotebook(notebook)
+	}
+	return s.pg.UpdateNotebook(notebook)
+}
+
+func (s *ToyNoteService) DeleteNotebook(id uint, moveTo *entity.Destination) error {
+	return s.pg.DeleteNotebook(id, moveTo)
+}
+
+func (s *ToyNoteService) MovePost(id uint, to entity.Destination) (entity.Post, error) {
+	return s.pg.MovePost(id, to)
+}
+
+func (s *ToyNoteService) GetPosts(pagination entity.Pagination) (entity.PostPage, error) {
 	return s.pg.GetPosts(pagination)
 }
 
+func (s *ToyNoteService) GetPost(id uint) (entity.Post, error) {
+	return s.pg.GetPost(id)
+}
+
 func (s *ToyNoteService) SavePost(post entity.Post) (entity.Post, error) {
+	// trash is only managed by `DeletePost` and `RestorePost`
+	post.DeletedAt = gorm.DeletedAt{}
+
 	if post.Id == 0 {
+		if err := checkReferences(post, nil); err != nil {
+			return entity.Post{}, err
+		}
 		return s.pg.CreatePost(post)
-	} else {
-		return s.pg.UpdatePost(post)
 	}
+
+	current, err := s.pg.GetPost(post.Id)
+	if err != nil {
+		return entity.Post{}, err
+	}
+	// a stale version is reported as a conflict by `UpdatePost`, rather than by the content
+	if post.Version == 0 || post.Version == current.Version {
+		if err := checkReferences(post, &current); err != nil {
+			return entity.Post{}, err
+		}
+	}
+
+	return s.pg.UpdatePost(post)
 }
 
-func (s *ToyNoteService) DeletePost(id uint) error {
-	return s.pg.DeletePost(id)
+func (s *ToyNoteService) PatchPost(id uint, patch entity.PostPatch) (entity.Post, error) {
+	current, err := s.pg.GetPost(id)
+	if err != nil {
+		return entity.Post{}, err
+	}
+	changes, err := patchChanges(current, patch)
+	if err != nil {
+		return entity.Post{}, err
+	}
+	if changes.Empty() && (changes.Version == 0 || changes.Version == current.Version) {
+		return current, nil
+	}
+
+	return s.pg.PatchPost(id, changes)
 }
 
-func (s *ToyNoteService) UploadAffiliate(reader io.Reader, filename string) (string, error) {
-	return s.mongo.UploadFile(reader, filename)
+func (s *ToyNoteService) DeletePost(id uint) ([]entity.LinkedPost, error) {
+	backlinks, err := s.pg.GetBacklinks(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.pg.DeletePost(id); err != nil {
+		return nil, err
+	}
+
+	for i := range backlinks {
+		backlinks[i].Broken = true
+	}
+	return backlinks, nil
 }
 
-func (s *ToyNoteService) DownloadAffiliate(id uint) (entity.FileObject, error) {
-	affiliate, err := s.pg.GetAffiliate(id)
+func (s *ToyNoteService) BulkTagPosts(tagging entity.BulkTagging) (entity.BulkReport, error) {
+	tagging, err := checkBulkTagging(tagging)
 	if err != nil {
-		return entity.FileObject{}, err
+		return entity.BulkReport{}, err
 	}
-	return s.mongo.DownloadFile(affiliate.Filename, affiliate.ObjectId)
+	return s.pg.BulkTagPosts(tagging)
 }
 
-func (s *ToyNoteService) GetUnownedAffiliates(pagination entity.Pagination) ([]entity.Affiliate, error) {
-	affiliates, err := s.pg.GetUnownedAffiliates(pagination)
+func (s *ToyNoteService) BulkDeletePosts(ids []uint) (entity.BulkReport, error) {
+	ids, err := entity.NormalizeBulkIds(ids)
 	if err != nil {
-		return nil, err
+		return entity.BulkReport{}, err
 	}
+	return s.pg.BulkDeletePosts(ids)
+}
 
-	return affiliates, nil
+func (s *ToyNoteService) BulkDatePosts(dating entity.BulkDating) (entity.BulkReport, error) {
+	dating, err := checkBulkDating(dating)
+	if err != nil {
+		return entity.BulkReport{}, err
+	}
+	return s.pg.BulkDatePosts(dating)
 }
 
-func (s *ToyNoteService) RebindAffiliate(postId, affiliateId uint) error {
-	// get affiliate, if not found, return error
-	affiliate, err := s.pg.GetAffiliate(affiliateId)
+func (s *ToyNoteService) GetTrash(pagination entity.Pagination) (entity.PostPage, error) {
+	return s.pg.GetTrash(pagination)
+}
+
+func (s *ToyNoteService) RestorePost(id uint) error {
+	return s.pg.RestorePost(id)
+}
+
+func (s *ToyNoteService) PurgePost(id uint) error {
+	oids, err := s.pg.PurgePost(id)
 	if err != nil {
 		return err
 	}
 
-	// get post, if not found, return error
-	post, err := s.pg.GetPost(postId)
+	return s.deleteUnreferredFiles(oids)
+}
+
+func (s *ToyNoteService) PurgeTrash(before time.Time) (int, error) {
+	ids, err := s.pg.GetTrashedBefore(before)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if err := s.PurgePost(id); err != nil {
+			return i, err
+		}
+	}
+
+	return len(ids), nil
+}
+
+func (s *ToyNoteService) GetRevisions(postId uint, pagination entity.Pagination) (entity.RevisionPage, error) {
+	return s.pg.GetRevisions(postId, pagination)
+}
+
+func (s *ToyNoteService) GetRevision(id uint) (entity.PostRevision, error) {
+	return s.pg.GetRevision(id)
+}
+
+func (s *ToyNoteService) DiffRevisions(from, to uint) (entity.RevisionDiff, error) {
+	fromRevision, err := s.pg.GetRevision(from)
+	if err != nil {
+		return entity.RevisionDiff{}, err
+	}
+
+	toRevision, err := s.pg.GetRevision(to)
+	if err != nil {
+		return entity.RevisionDiff{}, err
+	}
+
+	return diffRevisions(fromRevision, toRevision)
+}
+
+func (s *ToyNoteService) RestoreRevision(id uint) (entity.Post, error) {
+	return s.pg.RestoreRevision(id)
+}
+
+func (s *ToyNoteService) GetPostLinks(id uint) ([]entity.LinkedPost, error) {
+	return s.pg.GetPostLinks(id)
+}
+
+func (s *ToyNoteService) GetBacklinks(id uint) ([]entity.LinkedPost, error) {
+	return s.pg.GetBacklinks(id)
+}
+
+func (s *ToyNoteService) RenderPosts(posts []entity.Post) error {
+	cached, err := s.pg.GetRenderedContents(posts)
 	if err != nil {
 		return err
 	}
 
-	// update post
-	post.Affiliates = append(
-		post.Affiliates,
-		affiliate,
-	)
-	post, err = s.pg.UpdatePost(post)
+	rendered, err := renderPosts(posts, cached)
 	if err != nil {
 		return err
 	}
+	for _, post := range rendered {
+		if err := s.pg.SaveRenderedContent(post.Id, post.Version, post.ContentHtml); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+func (s *ToyNoteService) CreateShare(req entity.ShareRequest) (entity.ShareLink, error) {
+	token, share, err := newShare(req)
+	if err != nil {
+		return entity.ShareLink{}, err
+	}
+
+	share, err = s.pg.CreateShare(share)
+	if err != nil {
+		return entity.ShareLink{}, err
+	}
+
+	return entity.ShareLink{Share: share, Token: token}, nil
+}
+
+func (s *ToyNoteService) GetShares(postId uint) ([]entity.Share, error) {
+	return s.pg.GetShares(postId)
+}
+
+func (s *ToyNoteService) RevokeShare(id uint) error {
+	return s.pg.RevokeShare(id)
+}
+
+func (s *ToyNoteService) OpenShare(token, password string, format entity.ContentFormat) (entity.SharedPost, error) {
+	share, post, err := s.openShare(token, password)
+	if err != nil {
+		return entity.SharedPost{}, err
+	}
+	if format == entity.FORMAT_HTML {
+		posts := []entity.Post{post}
+		if err := s.ForOwner(share.OwnerId).RenderPosts(posts); err != nil {
+			return entity.SharedPost{}, err
+		}
+		post = posts[0]
+	}
+
+	return entity.NewSharedPost(post, share), nil
+}
+
+func (s *ToyNoteService) DownloadSharedAffiliate(token, password string, affiliateId uint) (entity.FileObject, error) {
+	share, post, err := s.openShare(token, password)
+	if err != nil {
+		return entity.FileObject{}, err
+	}
+	if err := sharedAffiliate(post, affiliateId); err != nil {
+		return entity.FileObject{}, err
+	}
+
+	return s.ForOwner(share.OwnerId).DownloadAffiliate(affiliateId)
+}
+
+// find a share link and the post it shares, a trashed post can't be opened either
+func (s *ToyNoteService) openShare(token, password string) (entity.Share, entity.Post, error) {
+	share, err := s.pg.GetShareByTokenHash(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return entity.Share{}, entity.Post{}, ErrShareNotFound
+	}
+	if err != nil {
+		return entity.Share{}, entity.Post{}, err
+	}
+	if err := checkShare(share, password); err != nil {
+		return entity.Share{}, entity.Post{}, err
+	}
+
+	post, err := s.pg.ForOwner(share.OwnerId).GetPost(share.PostId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return entity.Share{}, entity.Post{}, ErrShareNotFound
+	}
+	if err != nil {
+		return entity.Share{}, entity.Post{}, err
+	}
+
+	return share, post, nil
+}
+
+func (s *ToyNoteService) UploadAffiliate(reader io.Reader, filename string) (entity.Affiliate, error) {
+	buffered := bufio.NewReaderSize(reader, sniffLen)
+	contentType := sniffContentType(buffered, filename)
+
+	meta, err := s.blob.UploadFile(buffered, filename, contentType)
+	if err != nil {
+		return entity.Affiliate{}, err
+	}
+
+	return entity.Affiliate{
+		ObjectId:    meta.ObjectId,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        meta.Size,
+		Checksum:    meta.Checksum,
+	}, nil
+}
+
+func (s *ToyNoteService) DiscardUploads(oids []string) error {
+	return s.deleteUnreferredFiles(oids)
+}
+
+func (s *ToyNoteService) DownloadAffiliate(id uint) (entity.FileObject, error) {
+	affiliate, err := s.pg.GetAffiliate(id)
+	if err != nil {
+		return entity.FileObject{}, err
+	}
+
+	fo, err := s.blob.DownloadFile(affiliate.Filename, affiliate.ObjectId)
+	if err != nil {
+		return entity.FileObject{}, err
+	}
+	fo.ContentType = affiliate.ContentType
+	if fo.ContentType == "" {
+		fo.ContentType = guessContentType(affiliate.Filename)
+	}
+	// files are never modified once uploaded, so the object id identifies the content
+	fo.ModTime = affiliate.UpdatedAt
+	fo.ETag = fmt.Sprintf(`"%s"`, affiliate.ObjectId)
+
+	return fo, nil
+}
+
+func (s *ToyNoteService) GetUnownedAffiliates(pagination entity.Pagination) (entity.AffiliatePage, error) {
+	affiliates, err := s.pg.GetUnownedAffiliates(pagination)
+	if err != nil {
+		return entity.AffiliatePage{}, err
+	}
+
+	return affiliates, nil
+}
+
+func (s *ToyNoteService) RebindAffiliate(postId, affiliateId uint) error {
+	return s.pg.RebindAffiliate(affiliateId, postId)
+}
+
 func (s *ToyNoteService) DeleteUnownedAffiliates(ids []uint) error {
 	// get all unowned affiliates from PG
 	oa, err := s.pg.GetUnownedAffiliatesByIds(ids)
@@ -154,29 +478,63 @@ func (s *ToyNoteService) DeleteUnownedAffiliates(ids []uint) error {
 		oids[a] = oa[a].ObjectId
 	}
 
-	// delete all unowned affiliates from Mongo
-	err = s.mongo.DeleteFiles(oids)
+	// delete all unowned affiliates from PG
+	err = s.pg.DeleteUnownedAffiliates(ids)
 	if err != nil {
 		return err
 	}
 
-	// delete all unowned affiliates from PG
-	err = s.pg.DeleteUnownedAffiliates(ids)
+	return s.deleteUnreferredFiles(oids)
+}
+
+// Delete files of deleted affiliates from the blob store.
+// A content-addressed blob store gives the same object id to identical files,
+// so files still referred by other affiliates must be kept.
+func (s *ToyNoteService) deleteUnreferredFiles(oids []string) error {
+	referred, err := s.pg.GetReferredObjectIds(oids)
 	if err != nil {
 		return err
 	}
+	keep := make(map[string]bool, len(referred))
+	for _, oid := range referred {
+		keep[oid] = true
+	}
+	var unreferred []string
+	for _, oid := range oids {
+		if !keep[oid] {
+			unreferred = append(unreferred, oid)
+		}
+	}
+
+	// delete files from the blob store
+	if len(unreferred) > 0 {
+		if err := s.blob.DeleteFiles(unreferred); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (s *ToyNoteService) SearchPostsByTags(tagIds []uint, pagination entity.Pagination) ([]entity.Post, error) {
-	return s.pg.GetPostsByTags(tagIds, pagination)
+func (s *ToyNoteService) SearchPostsByTags(tagIds []uint, descendants bool, pagination entity.Pagination) (entity.PostPage, error) {
+	return s.pg.GetPostsByTags(tagIds, descendants, pagination)
 }
 
-func (s *ToyNoteService) SearchPostsByTitle(title string, pagination entity.Pagination) ([]entity.Post, error) {
+func (s *ToyNoteService) SearchPostsByTitle(title string, pagination entity.Pagination) (entity.PostPage, error) {
 	return s.pg.GetPostsByTitle(title, pagination)
 }
 
-func (s *ToyNoteService) SearchPostsByTimeRange(timeSearch entity.TimeSearch, pagination entity.Pagination) ([]entity.Post, error) {
+func (s *ToyNoteService) SearchPostsByTimeRange(timeSearch entity.TimeSearch, pagination entity.Pagination) (entity.PostPage, error) {
 	return s.pg.GetPostsByTimeRange(timeSearch, pagination)
 }
+
+func (s *ToyNoteService) SearchPosts(text string, pagination entity.Pagination) (entity.PostSearchPage, error) {
+	return s.pg.SearchPosts(text, pagination)
+}
+
+func (s *ToyNoteService) QueryPosts(query entity.PostQuery) (entity.PostPage, error) {
+	if err := query.Normalize(); err != nil {
+		return entity.PostPage{}, err
+	}
+	return s.pg.QueryPosts(query)
+}
@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"os"
 	"testing"
+	"time"
 	"toy-note/api/entity"
 	"toy-note/api/persistence"
 	"toy-note/logger"
@@ -28,12 +30,28 @@ var mongoConn = persistence.MongoConn{
 	Pass: "secret",
 }
 
+// tokens signed by a fixed key, short-lived enough for tests
+func newTestTokens() *Tokens {
+	tokens, err := NewTokens("test-only-secret-of-at-least-32-bytes", time.Minute, time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	return tokens
+}
+
 func newService() (*ToyNoteService, error) {
 	if err := logger.Init("debug", logPath, true); err != nil {
 		panic(err)
 	}
 
-	return NewToyNoteService(logger.TNLogger, sqlConn, mongoConn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mongo, err := persistence.NewMongoRepository(ctx, logger.TNLogger, mongoConn)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewToyNoteService(logger.TNLogger, sqlConn, &mongo, newTestTokens())
 }
 
 func TestNewService(t *testing.T) {
@@ -64,20 +82,15 @@ func TestDownloadAffiliate(t *testing.T) {
 	reader, err := os.Open("test.log")
 	require.NoError(t, err)
 
-	// 2. Upload the file to mongo, and get the file ObjectId
-	id, err := s.UploadAffiliate(reader, filenameUsedForSaving)
+	// 2. Upload the file to mongo, and get the file ObjectId and metadata
+	affiliate, err := s.UploadAffiliate(reader, filenameUsedForSaving)
 	require.NoError(t, err)
 
 	// 3. Create a post with affiliate (with the file ObjectId)
 	post := entity.Post{
-		Title:   "test",
-		Content: "test note service",
-		Affiliates: []entity.Affiliate{
-			{
-				ObjectId: id,
-				Filename: filenameUsedForSaving,
-			},
-		},
+		Title:      "test",
+		Content:    "test note service",
+		Affiliates: []entity.Affiliate{affiliate},
 	}
 
 	post, err = s.SavePost(post)
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"toy-note/api/entity"
+)
+
+// check the name of a notebook to be saved, which is trimmed
+func checkNotebook(notebook entity.Notebook) (entity.Notebook, error) {
+	notebook.Name = strings.TrimSpace(notebook.Name)
+	if notebook.Name == "" || len(notebook.Name) > 100 {
+		return entity.Notebook{}, errors.New("name must be 1 to 100 characters")
+	}
+	if notebook.ParentId != nil && *notebook.ParentId == 0 {
+		notebook.ParentId = nil
+	}
+
+	return notebook, nil
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"toy-note/api/entity"
+	"toy-note/api/util"
+)
+
+// a patch can't be applied to a post, e.g. it is malformed or changes a read-only field
+var ErrBadPatch = errors.New("invalid patch")
+
+// the document a patch is applied to: the post as it is responded, along with the
+// operations on its associations, see `entity.PostPatch`
+type patchDocument struct {
+	entity.Post
+	AddTags          []uint `json:"add_tags"`
+	RemoveTags       []uint `json:"remove_tags"`
+	AddAffiliates    []uint `json:"add_affiliates"`
+	RemoveAffiliates []uint `json:"remove_affiliates"`
+}
+
+// Apply a patch to the stored post, and tell the changes. Tags and affiliates are changed
+// by the ones added to or removed from the arrays, as well as by the operations.
+// References to affiliates in the content are checked as `SavePost` does, unless the
+// version is stale, which is reported as a conflict by the repository instead.
+func patchChanges(current entity.Post, patch entity.PostPatch) (entity.PostChanges, error) {
+	data, err := json.Marshal(patchDocument{
+		Post:             current,
+		AddTags:          []uint{},
+		RemoveTags:       []uint{},
+		AddAffiliates:    []uint{},
+		RemoveAffiliates: []uint{},
+	})
+	if err != nil {
+		return entity.PostChanges{}, err
+	}
+
+	switch patch.Format {
+	case entity.PATCH_MERGE:
+		data, err = util.MergePatch(data, patch.Patch)
+	case entity.PATCH_JSON:
+		data, err = util.JSONPatch(data, patch.Patch)
+	default:
+		err = fmt.Errorf("unsupported format %q", patch.Format)
+	}
+	if errors.Is(err, util.ErrPatchTest) {
+		return entity.PostChanges{}, err
+	}
+	if err != nil {
+		return entity.PostChanges{}, fmt.Errorf("%w: %v", ErrBadPatch, err)
+	}
+
+	var patched patchDocument
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&patched); err != nil {
+		return entity.PostChanges{}, fmt.Errorf("%w: %v", ErrBadPatch, err)
+	}
+
+	changes, err := diffPost(current, patched)
+	if err != nil {
+		return entity.PostChanges{}, fmt.Errorf("%w: %v", ErrBadPatch, err)
+	}
+
+	// the version given in the patch is the version being patched, the same as in `SavePost`
+	changes.Version = patch.Version
+	if patched.Version != current.Version {
+		if patch.Version != 0 && patch.Version != patched.Version {
+			return entity.PostChanges{}, fmt.Errorf(
+				"%w: version %d does not match If-Match version %d", ErrBadPatch, patched.Version, patch.Version,
+			)
+		}
+		changes.Version = patched.Version
+	}
+	if changes.Version != 0 && changes.Version != current.Version {
+		return changes, nil
+	}
+
+	// an empty content refers to nothing, whereas `checkReferences` would take it as kept
+	post := patchedPost(current, changes)
+	if post.Content == "" {
+		return changes, nil
+	}
+	if err := checkReferences(post, &current); err != nil {
+		return entity.PostChanges{}, err
+	}
+	return changes, nil
+}
+
+// tell the changes of a patched post, read-only fields must be kept
+func diffPost(current entity.Post, patched patchDocument) (entity.PostChanges, error) {
+	post := patched.Post
+	switch {
+	case post.Id != current.Id:
+		return entity.PostChanges{}, errors.New("id can't be patched")
+	case post.OwnerId != current.OwnerId:
+		return entity.PostChanges{}, errors.New("owner_id can't be patched")
+	case (post.NotebookId == nil) != (current.NotebookId == nil) ||
+		(post.NotebookId != nil && *post.NotebookId != *current.NotebookId):
+		return entity.PostChanges{}, errors.New("notebook_id can't be patched, move the post instead")
+	case !post.CreatedAt.Equal(current.CreatedAt) || !post.UpdatedAt.Equal(current.UpdatedAt):
+		return entity.PostChanges{}, errors.New("created_at and updated_at can't be patched")
+	case post.DeletedAt.Valid != current.DeletedAt.Valid:
+		return entity.PostChanges{}, errors.New("deleted_at can't be patched, delete the post instead")
+	case post.ContentHtml != current.ContentHtml:
+		return entity.PostChanges{}, errors.New("content_html can't be patched")
+	}
+
+	var changes entity.PostChanges
+	if post.Title != current.Title {
+		if strings.TrimSpace(post.Title) == "" {
+			return entity.PostChanges{}, errors.New("title is required")
+		}
+		changes.Title = &post.Title
+	}
+	if post.Subtitle != current.Subtitle {
+		changes.Subtitle = &post.Subtitle
+	}
+	if post.Content != current.Content {
+		changes.Content = &post.Content
+	}
+	if !post.Date.Equal(current.Date) {
+		if post.Date.IsZero() {
+			return entity.PostChanges{}, errors.New("date is required")
+		}
+		changes.Date = &post.Date
+	}
+
+	currentTags := []uint{}
+	for _, t := range current.Tags {
+		currentTags = append(currentTags, t.Id)
+	}
+	patchedTags := []uint{}
+	for _, t := range post.Tags {
+		if t.Id == 0 {
+			return entity.PostChanges{}, errors.New("tags must be given by ids, create new tags by save-tag")
+		}
+		patchedTags = append(patchedTags, t.Id)
+	}
+	var err error
+	changes.AddTagIds, changes.RemoveTagIds, err = patchIds(
+		"tag", currentTags, patchedTags, patched.AddTags, patched.RemoveTags,
+	)
+	if err != nil {
+		return entity.PostChanges{}, err
+	}
+
+	currentAffiliates := []uint{}
+	for _, a := range current.Affiliates {
+		currentAffiliates = append(currentAffiliates, a.Id)
+	}
+	patchedAffiliates := []uint{}
+	for _, a := range post.Affiliates {
+		if a.Id == 0 {
+			return entity.PostChanges{}, errors.New("affiliates must be given by ids, upload new files by save-post")
+		}
+		patchedAffiliates = append(patchedAffiliates, a.Id)
+	}
+	changes.AddAffiliateIds, changes.RemoveAffiliateIds, err = patchIds(
+		"affiliate", currentAffiliates, patchedAffiliates, patched.AddAffiliates, patched.RemoveAffiliates,
+	)
+	if err != nil {
+		return entity.PostChanges{}, err
+	}
+
+	return changes, nil
+}
+
+// Ids added and removed by both the patched array and the operations, in the order they
+// are given. An id must not be both added and removed.
+func patchIds(name string, current, patched, add, remove []uint) ([]uint, []uint, error) {
+	inCurrent := make(map[uint]bool)
+	for _, id := range current {
+		inCurrent[id] = true
+	}
+	inPatched := make(map[uint]bool)
+	for _, id := range patched {
+		inPatched[id] = true
+	}
+
+	added := []uint{}
+	isAdded := make(map[uint]bool)
+	for _, id := range append(append([]uint{}, patched...), add...) {
+		if !isAdded[id] && (!inCurrent[id] || !inPatched[id]) {
+			isAdded[id] = true
+			added = append(added, id)
+		}
+	}
+	removed := []uint{}
+	isRemoved := make(map[uint]bool)
+	for _, id := range current {
+		if !inPatched[id] {
+			isRemoved[id] = true
+			removed = append(removed, id)
+		}
+	}
+	for _, id := range remove {
+		if !isRemoved[id] {
+			isRemoved[id] = true
+			removed = append(removed, id)
+		}
+	}
+
+	for _, id := range added {
+		if isRemoved[id] {
+			return nil, nil, fmt.Errorf("%s %d can't be both added and removed", name, id)
+		}
+	}
+	return added, removed, nil
+}
+
+// the stored post with the changes applied, affiliates are only told by ids
+func patchedPost(current entity.Post, changes entity.PostChanges) entity.Post {
+	post := current
+	if changes.Content != nil {
+		post.Content = *changes.Content
+	}
+
+	removed := make(map[uint]bool)
+	for _, id := range changes.RemoveAffiliateIds {
+		removed[id] = true
+	}
+	post.Affiliates = []entity.Affiliate{}
+	for _, a := range current.Affiliates {
+		if !removed[a.Id] {
+			post.Affiliates = append(post.Affiliates, a)
+		}
+	}
+	for _, id := range changes.AddAffiliateIds {
+		post.Affiliates = append(post.Affiliates, entity.Affiliate{UintId: entity.UintId{Id: id}})
+	}
+
+	return post
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"toy-note/api/entity"
+	"toy-note/api/util"
+)
+
+// Check the affiliates referred to by the content of a post being saved are bound to it,
+// see `entity.ReferenceError`. `current` is the stored post on update, nil on create.
+// An empty content of an update keeps the stored content, which is checked instead.
+func checkReferences(post entity.Post, current *entity.Post) error {
+	content := post.Content
+	if current != nil && content == "" {
+		content = current.Content
+	}
+
+	bound := make(map[uint]bool)
+	for _, a := range post.Affiliates {
+		if a.Id != 0 {
+			bound[a.Id] = true
+		}
+	}
+	missing := []uint{}
+	for _, id := range util.AffiliateRefs(content) {
+		if !bound[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// the update would unbind affiliates which are still referred to
+	if current != nil {
+		wasBound := make(map[uint]bool)
+		for _, a := range current.Affiliates {
+			wasBound[a.Id] = true
+		}
+		unbinding := []uint{}
+		for _, id := range missing {
+			if wasBound[id] {
+				unbinding = append(unbinding, id)
+			}
+		}
+		if len(unbinding) > 0 {
+			return &entity.ReferenceError{PostId: post.Id, AffiliateIds: unbinding, Unbinding: true}
+		}
+	}
+
+	return &entity.ReferenceError{PostId: post.Id, AffiliateIds: missing}
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"toy-note/api/entity"
+	"toy-note/api/util"
+)
+
+// Fill `content_html` of the posts, either by the cached HTML of their post ids, or rendered
+// from their content. The rendered posts are returned, so that they are cached by the caller.
+func renderPosts(posts []entity.Post, cached map[uint]string) ([]entity.Post, error) {
+	rendered := []entity.Post{}
+	for i := range posts {
+		if html, ok := cached[posts[i].Id]; ok {
+			posts[i].ContentHtml = html
+			continue
+		}
+
+		html, err := util.RenderMarkdown(posts[i].Content)
+		if err != nil {
+			return nil, err
+		}
+		posts[i].ContentHtml = html
+		rendered = append(rendered, posts[i])
+	}
+
+	return rendered, nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"io"
+	"time"
 	"toy-note/api/entity"
 )
 
@@ -9,49 +10,232 @@ import (
 //
 // Define an interface for a ToyNote repository
 type ToyNoteRepo interface {
+	// A view of the repository scoped to a user: tags, posts and affiliates of other users
+	// can neither be found nor bound, and everything created is owned by the user.
+	// The repository itself is not scoped, it is only used by background jobs.
+	ForOwner(userId uint) ToyNoteRepo
+
+	// A view of the repository whose listings and searches of posts, i.e. `GetPosts`,
+	// `GetTrash`, the `SearchPosts*` methods and `QueryPosts`, as well as `PurgeTrash`, are
+	// limited to the posts in a notebook, and optionally the notebooks nested in it.
+	// Everything else is the same as the repository
+	InNotebook(scope entity.NotebookScope) ToyNoteRepo
+
 	// Get all tags
 	GetTags() ([]entity.Tag, error)
 
 	// Create/Update a tag
 	// - If the tag Id is null, create a new tag
-	// - If the tag Id is not null, update the existing tag
+	// - If the tag Id is not null, update the existing tag. If the version is given but
+	//   stale, the tag is not updated and an `*entity.ConflictError` is returned
+	// A tag can't be nested in itself, nor in the tags nested in it
 	SaveTag(tag entity.Tag) (entity.Tag, error)
 
-	// Delete an existing tag
+	// Delete an existing tag, the tags nested in it are moved to its parent
 	DeleteTag(uint) error
 
-	// Get posts by pagination
-	GetPosts(entity.Pagination) ([]entity.Post, error)
+	// [admin] Merge the source tags into the target tag in one transaction: the posts bound
+	// to the sources are bound to the target instead, the tags nested in the sources are
+	// moved to the target, then the sources are deleted. All the tags must be owned by
+	// the same user, and the target can't be nested in a source
+	MergeTags(sourceIds []uint, targetId uint) (entity.Tag, error)
+
+	// Delete tags one after another in one transaction, the same as `DeleteTag`.
+	// A tag not found is reported as failed, the others are deleted regardless
+	BulkDeleteTags([]uint) (entity.BulkReport, error)
+
+	// Get all notebooks as trees, each along with the number of posts right in it
+	GetNotebookTree() ([]entity.NotebookNode, error)
+
+	// Get a notebook by id
+	GetNotebook(uint) (entity.Notebook, error)
+
+	// Create/Update a notebook
+	// - If the notebook Id is null, create a new notebook
+	// - If the notebook Id is not null, update the name and the parent of the notebook.
+	//   A notebook can't be nested in itself, nor in the notebooks nested in it
+	SaveNotebook(entity.Notebook) (entity.Notebook, error)
+
+	// Delete a notebook. If the destination is given, the notebooks and posts in it are
+	// moved there, otherwise a notebook which is not empty results in
+	// `entity.ErrNotebookNotEmpty`. Trashed posts count, hence posts are never orphaned
+	DeleteNotebook(uint, *entity.Destination) error
+
+	// Move a post to a notebook or the root, which is not recorded as a revision
+	MovePost(uint, entity.Destination) (entity.Post, error)
+
+	// Get a page of posts, newest first.
+	// Pagination is either by page, or by the `next_cursor` of the previous page.
+	GetPosts(entity.Pagination) (entity.PostPage, error)
+
+	// Get a post by id, with its tags and affiliates
+	GetPost(uint) (entity.Post, error)
 
 	// Create/Update a post
 	// - If the post Id is null, create a new post
-	// - If the post Id is not null, update the existing post
+	// - If the post Id is not null, update the existing post. If the version is given but
+	//   stale, the post is not updated and an `*entity.ConflictError` is returned
 	SavePost(entity.Post) (entity.Post, error)
 
-	// Delete an existing post
-	DeletePost(uint) error
+	// Patch an existing post by a JSON Merge Patch or a JSON Patch, see `entity.PostPatch`.
+	// Fields omitted by the patch are kept, and so are the tags and affiliates which are not
+	// operated on. A patch which can't be applied is `ErrBadPatch`, a failed test of a JSON
+	// Patch is `util.ErrPatchTest`, and a stale version is an `*entity.ConflictError`
+	PatchPost(uint, entity.PostPatch) (entity.Post, error)
+
+	// Move an existing post to trash, it can be restored later.
+	// The links to the post from other posts are broken, they are returned
+	DeletePost(uint) ([]entity.LinkedPost, error)
+
+	// Add tags to and remove tags from posts in one transaction, the other tags of the posts
+	// are kept. Every post changed gets a new version recorded as a revision, so that an
+	// editor of a stale version can't revert the change. The tags must be found, whereas
+	// a post not found is reported as failed and the others are changed regardless
+	BulkTagPosts(entity.BulkTagging) (entity.BulkReport, error)
+
+	// Move posts to trash in one transaction, the same as `DeletePost` but broken links
+	// are not returned. A post not found is reported as failed
+	BulkDeletePosts([]uint) (entity.BulkReport, error)
+
+	// Set the date of posts in one transaction, a new version of each is recorded as
+	// a revision. A post not found is reported as failed
+	BulkDatePosts(entity.BulkDating) (entity.BulkReport, error)
+
+	// Get trashed posts by pagination
+	GetTrash(entity.Pagination) (entity.PostPage, error)
+
+	// Restore a trashed post, with the tags and affiliates it was bound to
+	RestorePost(uint) error
+
+	// Permanently delete a trashed post, its affiliates and their files
+	PurgePost(uint) error
+
+	// [admin] Permanently delete posts trashed before the given time, return the number of
+	// purged posts
+	PurgeTrash(time.Time) (int, error)
+
+	// Get revisions of a post by pagination, newest first.
+	// A revision is recorded every time a post is saved.
+	GetRevisions(uint, entity.Pagination) (entity.RevisionPage, error)
+
+	// Get a revision by id
+	GetRevision(uint) (entity.PostRevision, error)
+
+	// Line-level diff between two revisions of the same post, from the first to the second
+	DiffRevisions(uint, uint) (entity.RevisionDiff, error)
+
+	// Restore a post to a revision, which is recorded as a new revision
+	RestoreRevision(uint) (entity.Post, error)
+
+	// Fill `content_html` of the posts in place, Markdown content rendered as sanitized HTML.
+	// The HTML is cached in the revision of the version of a post, hence a post is rendered
+	// again once it is updated
+	RenderPosts([]entity.Post) error
 
-	// Upload an affiliate
-	UploadAffiliate(io.Reader, string) (string, error)
+	// Get the wiki-style links in the content of a post, `[[Post Title]]` or `[[post:123]]`,
+	// along with the posts they are resolved to. A link to a missing or trashed post is broken
+	GetPostLinks(uint) ([]entity.LinkedPost, error)
 
-	// Download an affiliate
+	// Get the links to a post from other posts, the posts are not trashed
+	GetBacklinks(uint) ([]entity.LinkedPost, error)
+
+	// Create a public read-only link of a post, the token of the link is only returned once.
+	// The link is optionally protected by a password, and expires at the given time
+	CreateShare(entity.ShareRequest) (entity.ShareLink, error)
+
+	// Get all share links of a post, newest first
+	GetShares(uint) ([]entity.Share, error)
+
+	// Revoke a share link
+	RevokeShare(uint) error
+
+	// [public] Open a share link by its token and password, and get the shared post, whose
+	// content is rendered if the format is HTML.
+	// An unknown, revoked or expired link results in `ErrShareNotFound`, a wrong password
+	// in `ErrSharePassword`
+	OpenShare(token, password string, format entity.ContentFormat) (entity.SharedPost, error)
+
+	// [public] Download an affiliate of a shared post, by the same token and password
+	DownloadSharedAffiliate(token, password string, affiliateId uint) (entity.FileObject, error)
+
+	// Upload an affiliate file, the reader is streamed into the blob store.
+	// The returned affiliate is not saved yet, it carries the object id, content type,
+	// size and checksum of the file, and it is supposed to be bound to a post.
+	UploadAffiliate(io.Reader, string) (entity.Affiliate, error)
+
+	// Delete uploaded files by object ids, which failed to be bound to a post.
+	// Files referred by any affiliate are kept
+	DiscardUploads([]string) error
+
+	// Download an affiliate, the content of the returned `FileObject` is a stream
+	// which must be closed by the caller
 	DownloadAffiliate(uint) (entity.FileObject, error)
 
 	// [admin] Get all unowned affiliates by pagination
-	GetUnownedAffiliates(entity.Pagination) ([]entity.Affiliate, error)
+	GetUnownedAffiliates(entity.Pagination) (entity.AffiliatePage, error)
 
-	// [admin] Rebind a unowned affiliate to a post
+	// [admin] Rebind a unowned affiliate to a post, both must be owned by the same user
 	RebindAffiliate(uint, uint) error
 
 	// [admin] Remove affiliates, which will remove affiliates files from mongo as well
 	DeleteUnownedAffiliates([]uint) error
 
-	// Search posts by tags
-	SearchPostsByTags([]uint, entity.Pagination) ([]entity.Post, error)
+	// Search posts bound to all the given tags, newest first. If descendants are included,
+	// a post bound to a tag nested in a given tag at any depth is bound to the given tag
+	SearchPostsByTags(tagIds []uint, descendants bool, pagination entity.Pagination) (entity.PostPage, error)
+
+	// Search posts by title, newest first
+	SearchPostsByTitle(string, entity.Pagination) (entity.PostPage, error)
+
+	// Search posts by time range, newest first
+	SearchPostsByTimeRange(entity.TimeSearch, entity.Pagination) (entity.PostPage, error)
+
+	// Full-text search over title, subtitle and content, best matches first.
+	// Each result carries a highlighted snippet of the content. A cursor is not available.
+	SearchPosts(string, entity.Pagination) (entity.PostSearchPage, error)
+
+	// Find posts by a combination of tags, text and time range, sorted and paginated.
+	// The query is normalized first, an invalid query results in an error.
+	QueryPosts(entity.PostQuery) (entity.PostPage, error)
+}
+
+// UserRepo
+//
+// Define an interface for user accounts and their tokens
+type UserRepo interface {
+	// Create a user, the password is hashed
+	Register(entity.Credentials) (entity.User, error)
+
+	// Check the name and password of a user, then issue an access token and a refresh token
+	Login(entity.Credentials) (entity.TokenPair, error)
+
+	// Exchange a refresh token for a new pair of tokens. A refresh token can be used only
+	// once, using it again revokes all the refresh tokens of the user
+	Refresh(string) (entity.TokenPair, error)
+
+	// Revoke a refresh token
+	Logout(string) error
+
+	// Verify an access token, and return the user it was issued to
+	Authenticate(string) (entity.User, error)
+
+	// [admin] Grant a role to a user, by an admin who is not the user.
+	// Access tokens already issued keep the previous role until they expire
+	SetRole(by entity.User, userId uint, role entity.Role) (entity.User, error)
+
+	// Find a user by name
+	FindUser(string) (entity.User, error)
+
+	// Create an API key of a user with the given scopes, the key is only returned once
+	CreateApiKey(uint, entity.ApiKeyRequest) (entity.NewApiKey, error)
+
+	// Get all API keys of a user, newest first
+	GetApiKeys(uint) ([]entity.ApiKey, error)
 
-	// Search posts by title
-	SearchPostsByTitle(string, entity.Pagination) ([]entity.Post, error)
+	// Revoke an API key of a user by its prefix, of any user if the user id is 0
+	RevokeApiKey(uint, string) error
 
-	// Search posts by time range
-	SearchPostsByTimeRange(entity.TimeSearch, entity.Pagination) ([]entity.Post, error)
+	// Verify an API key, and return the user it was created for along with the key.
+	// Unlike an access token, the user is read from the store, hence has the current role
+	AuthenticateApiKey(string) (entity.User, entity.ApiKey, error)
 }
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"toy-note/api/entity"
+	"toy-note/api/util"
+)
+
+// Diff two revisions of the same post, from the older one to the newer one
+func diffRevisions(from, to entity.PostRevision) (entity.RevisionDiff, error) {
+	if from.PostId != to.PostId {
+		return entity.RevisionDiff{}, fmt.Errorf(
+			"revision %d and %d belong to different posts", from.Id, to.Id,
+		)
+	}
+
+	tagsAdded, tagsRemoved := diffIds(from.TagIds, to.TagIds)
+	affiliatesAdded, affiliatesRemoved := diffIds(from.AffiliateIds, to.AffiliateIds)
+
+	return entity.RevisionDiff{
+		PostId:            from.PostId,
+		From:              from.Id,
+		To:                to.Id,
+		Title:             util.DiffLines(from.Title, to.Title),
+		Subtitle:          util.DiffLines(from.Subtitle, to.Subtitle),
+		Content:           util.DiffLines(from.Content, to.Content),
+		DateFrom:          from.Date,
+		DateTo:            to.Date,
+		TagsAdded:         tagsAdded,
+		TagsRemoved:       tagsRemoved,
+		AffiliatesAdded:   affiliatesAdded,
+		AffiliatesRemoved: affiliatesRemoved,
+	}, nil
+}
+
+// ids only in `to` are added, ids only in `from` are removed
+func diffIds(from, to []uint) ([]uint, []uint) {
+	inFrom := make(map[uint]bool, len(from))
+	for _, id := range from {
+		inFrom[id] = true
+	}
+	inTo := make(map[uint]bool, len(to))
+	for _, id := range to {
+		inTo[id] = true
+	}
+
+	added := []uint{}
+	for _, id := range to {
+		if !inFrom[id] {
+			added = append(added, id)
+		}
+	}
+	removed := []uint{}
+	for _, id := range from {
+		if !inTo[id] {
+			removed = append(removed, id)
+		}
+	}
+
+	return added, removed
+}
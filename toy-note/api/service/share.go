@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"toy-note/api/entity"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// an unknown, revoked or expired link, which are not distinguished
+var ErrShareNotFound = errors.New("share link not found")
+
+var ErrSharePassword = errors.New("share link requires a valid password")
+
+// Create the record of a share link, the token itself is returned along with it
+func newShare(req entity.ShareRequest) (string, entity.Share, error) {
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return "", entity.Share{}, errors.New("expires_at must be in the future")
+	}
+	if len(req.Password) > maxPasswordLen {
+		return "", entity.Share{}, fmt.Errorf("password must be at most %d bytes", maxPasswordLen)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", entity.Share{}, err
+	}
+	share := entity.Share{
+		PostId:    req.PostId,
+		TokenHash: hashToken(token),
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", entity.Share{}, err
+		}
+		share.PasswordHash = string(hash)
+		share.Protected = true
+	}
+
+	return token, share, nil
+}
+
+// Check a share link can be opened with the password
+func checkShare(share entity.Share, password string) error {
+	if !share.Active(time.Now()) {
+		return ErrShareNotFound
+	}
+	if share.Protected &&
+		bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+		return ErrSharePassword
+	}
+
+	return nil
+}
+
+// The affiliate of a shared post, visitors can't download anything else by the link
+func sharedAffiliate(post entity.Post, affiliateId uint) error {
+	for _, a := range post.Affiliates {
+		if a.Id == affiliateId {
+			return nil
+		}
+	}
+	return fmt.Errorf("affiliate %d: %w", affiliateId, ErrShareNotFound)
+}
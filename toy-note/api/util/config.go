@@ -15,6 +15,14 @@ type Config struct {
 	MONGO_USER string
 	MONGO_PASS string
 	MONGO_DB   string
+	BLOB_STORE string
+	FS_ROOT    string
+	// days before trashed posts are purged, 0 keeps them forever
+	TRASH_RETENTION_DAYS int
+	// key signing access tokens
+	JWT_SECRET               string
+	ACCESS_TOKEN_TTL_MINUTES int
+	REFRESH_TOKEN_TTL_DAYS   int
 }
 
 func LoadConfig(prod bool, path string) (config Config, err error) {
@@ -20,6 +20,12 @@ func TestDevConfig(t *testing.T) {
 	require.Equal(t, cfg.MONGO_USER, "root")
 	require.Equal(t, cfg.MONGO_PASS, "secret")
 	require.Equal(t, cfg.MONGO_DB, "dev")
+	require.Equal(t, cfg.BLOB_STORE, "mongo")
+	require.Equal(t, cfg.FS_ROOT, "../../../data")
+	require.Equal(t, cfg.TRASH_RETENTION_DAYS, 30)
+	require.Equal(t, cfg.JWT_SECRET, "dev-only-secret-do-not-use-in-production")
+	require.Equal(t, cfg.ACCESS_TOKEN_TTL_MINUTES, 15)
+	require.Equal(t, cfg.REFRESH_TOKEN_TTL_DAYS, 30)
 }
 
 func TestProdConfig(t *testing.T) {
@@ -36,4 +42,9 @@ func TestProdConfig(t *testing.T) {
 	require.Equal(t, cfg.MONGO_USER, "root")
 	require.Equal(t, cfg.MONGO_PASS, "secret")
 	require.Equal(t, cfg.MONGO_DB, "dev")
+	require.Equal(t, cfg.BLOB_STORE, "mongo")
+	require.Equal(t, cfg.FS_ROOT, "/var/lib/toy-note")
+	require.Equal(t, cfg.TRASH_RETENTION_DAYS, 30)
+	require.Equal(t, cfg.ACCESS_TOKEN_TTL_MINUTES, 15)
+	require.Equal(t, cfg.REFRESH_TOKEN_TTL_DAYS, 30)
 }
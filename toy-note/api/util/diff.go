@@ -0,0 +1,63 @@
+package util
+
+import (
+	"strings"
+	"toy-note/api/entity"
+)
+
+// Line-level diff of two texts, based on the longest common subsequence of lines.
+// Deleted lines come before inserted lines when a block of lines is replaced.
+func DiffLines(a, b string) []entity.DiffLine {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := []entity.DiffLine{}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			diff = append(diff, entity.DiffLine{Op: entity.DIFF_EQUAL, Text: x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, entity.DiffLine{Op: entity.DIFF_DELETE, Text: x[i]})
+			i++
+		default:
+			diff = append(diff, entity.DiffLine{Op: entity.DIFF_INSERT, Text: y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		diff = append(diff, entity.DiffLine{Op: entity.DIFF_DELETE, Text: x[i]})
+	}
+	for ; j < len(y); j++ {
+		diff = append(diff, entity.DiffLine{Op: entity.DIFF_INSERT, Text: y[j]})
+	}
+
+	return diff
+}
+
+// an empty text has no lines, rather than an empty line
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
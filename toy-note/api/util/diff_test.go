@@ -0,0 +1,39 @@
+package util
+
+import (
+	"testing"
+	"toy-note/api/entity"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := "first\nsecond\nthird"
+	b := "first\nchanged\nthird\nfourth"
+
+	require.Equal(t, []entity.DiffLine{
+		{Op: entity.DIFF_EQUAL, Text: "first"},
+		{Op: entity.DIFF_DELETE, Text: "second"},
+		{Op: entity.DIFF_INSERT, Text: "changed"},
+		{Op: entity.DIFF_EQUAL, Text: "third"},
+		{Op: entity.DIFF_INSERT, Text: "fourth"},
+	}, DiffLines(a, b))
+}
+
+func TestDiffLinesEmpty(t *testing.T) {
+	require.Empty(t, DiffLines("", ""))
+
+	require.Equal(t, []entity.DiffLine{
+		{Op: entity.DIFF_INSERT, Text: "new"},
+	}, DiffLines("", "new"))
+
+	require.Equal(t, []entity.DiffLine{
+		{Op: entity.DIFF_DELETE, Text: "old"},
+	}, DiffLines("old", ""))
+
+	// line endings are normalized
+	require.Equal(t, []entity.DiffLine{
+		{Op: entity.DIFF_EQUAL, Text: "a"},
+		{Op: entity.DIFF_EQUAL, Text: "b"},
+	}, DiffLines("a\r\nb", "a\nb"))
+}
@@ -0,0 +1,353 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// elements which start a block of their own, the others are inline
+var enmlBlocks = map[string]bool{
+	"html": true, "body": true, "en-note": true, "div": true, "p": true, "center": true, "section": true,
+	"article": true, "header": true, "footer": true, "address": true, "dl": true, "dd": true, "dt": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "pre": true, "blockquote": true, "table": true, "hr": true,
+}
+
+// characters escaped in text, otherwise they may be taken as Markdown
+var enmlEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`,
+)
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// self-closed elements of ENML, which HTML takes as opened
+var enmlSelfClosed = regexp.MustCompile(`<(en-media|en-todo|en-crypt)(\s[^>]*?)?/>`)
+
+// Convert ENML, the XHTML content of Evernote notes, into Markdown.
+// An `<en-media>` is an image or a link to the file the function finds by its hash, it is
+// dropped if the file is not found, so is an `<en-crypt>` since it can't be decrypted.
+func EnmlToMarkdown(enml string, media func(hash string) (filename, destination string, ok bool)) (string, error) {
+	enml = enmlSelfClosed.ReplaceAllString(enml, "<$1$2></$1>")
+	doc, err := html.Parse(strings.NewReader(enml))
+	if err != nil {
+		return "", err
+	}
+
+	c := enmlConverter{media: media}
+	md := blankLines.ReplaceAllString(c.blocks(doc, "\n\n"), "\n\n")
+	md = strings.TrimSpace(md)
+	if md == "" {
+		return "", nil
+	}
+	return md + "\n", nil
+}
+
+type enmlConverter struct {
+	media func(hash string) (filename, destination string, ok bool)
+}
+
+// the children of a node as blocks joined by the separator, runs of inline children are
+// paragraphs
+func (c enmlConverter) blocks(n *html.Node, sep string) string {
+	var blocks []string
+	var inline strings.Builder
+
+	flush := func() {
+		if p := paragraph(inline.String()); p != "" {
+			blocks = append(blocks, p)
+		}
+		inline.Reset()
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && enmlBlocks[child.Data] {
+			flush()
+			if b := c.block(child); strings.TrimSpace(b) != "" {
+				blocks = append(blocks, b)
+			}
+			continue
+		}
+		inline.WriteString(c.inline(child))
+	}
+	flush()
+
+	return strings.Join(blocks, sep)
+}
+
+// lines of inline Markdown trimmed, and joined by hard line breaks
+func paragraph(s string) string {
+	lines := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\\\n")
+}
+
+func (c enmlConverter) block(n *html.Node) string {
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := strings.Join(strings.Fields(c.children(n)), " ")
+		if text == "" {
+			return ""
+		}
+		return strings.Repeat("#", int(n.Data[1]-'0')) + " " + text
+	case "hr":
+		return "---"
+	case "pre":
+		return fence(textContent(n))
+	case "blockquote":
+		return prefixLines(c.blocks(n, "\n\n"), "> ", "> ")
+	case "ul", "ol":
+		return c.list(n)
+	case "table":
+		return c.table(n)
+	case "div":
+		// code blocks of Evernote are divs styled by `-en-codeblock`, a line each child
+		if strings.Contains(attr(n, "style"), "-en-codeblock") {
+			lines := []string{}
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				lines = append(lines, strings.TrimSuffix(textContent(child), "\n"))
+			}
+			return fence(strings.Join(lines, "\n"))
+		}
+	}
+
+	return c.blocks(n, "\n\n")
+}
+
+func (c enmlConverter) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return enmlEscaper.Replace(collapseSpaces(n.Data))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "br":
+		return "\n"
+	case "b", "strong":
+		return wrap(c.children(n), "**")
+	case "i", "em":
+		return wrap(c.children(n), "*")
+	case "s", "strike", "del":
+		return wrap(c.children(n), "~~")
+	case "code", "kbd", "samp", "tt":
+		return "`" + strings.ReplaceAll(textContent(n), "`", "") + "`"
+	case "a":
+		text, href := c.children(n), attr(n, "href")
+		if href == "" {
+			return text
+		}
+		if strings.TrimSpace(text) == "" {
+			text = href
+		}
+		return "[" + strings.TrimSpace(text) + "](" + destination(href) + ")"
+	case "img":
+		return "![" + enmlEscaper.Replace(attr(n, "alt")) + "](" + destination(attr(n, "src")) + ")"
+	case "en-media":
+		filename, dest, ok := c.media(attr(n, "hash"))
+		if !ok {
+			return ""
+		}
+		if strings.HasPrefix(attr(n, "type"), "image/") {
+			return "![" + enmlEscaper.Replace(filename) + "](" + destination(dest) + ")"
+		}
+		return "[" + enmlEscaper.Replace(filename) + "](" + destination(dest) + ")"
+	case "en-todo":
+		if attr(n, "checked") == "true" {
+			return "- [x] "
+		}
+		return "- [ ] "
+	case "en-crypt", "script", "style", "head", "title":
+		return ""
+	}
+
+	return c.children(n)
+}
+
+// the children of a node as inline Markdown, blocks among them are put on lines of their own
+func (c enmlConverter) children(n *html.Node) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && enmlBlocks[child.Data] {
+			b.WriteString("\n" + c.block(child) + "\n")
+			continue
+		}
+		b.WriteString(c.inline(child))
+	}
+	return b.String()
+}
+
+// items of a list, nested lists are indented under their items
+func (c enmlConverter) list(n *html.Node) string {
+	items := []string{}
+	i := 1
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+
+		var content string
+		if child.Data == "li" {
+			content = c.blocks(child, "\n")
+		} else {
+			// e.g. a list nested right in a list
+			content = c.block(child)
+		}
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+
+		marker := "- "
+		if n.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", i)
+		}
+		i++
+		items = append(items, prefixLines(content, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+// a table of GitHub flavored Markdown, the first row is the header
+func (c enmlConverter) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.Data != "tr" {
+				walk(child)
+				continue
+			}
+			row := []string{}
+			for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+					text := strings.Join(strings.Fields(c.children(cell)), " ")
+					row = append(row, strings.ReplaceAll(text, "|", `\|`))
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	lines := []string{}
+	for i, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", width))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// the text of a node as it is, line breaks included
+func textContent(n *html.Node) string {
+	switch {
+	case n.Type == html.TextNode:
+		return n.Data
+	case n.Type == html.ElementNode && n.Data == "br":
+		return "\n"
+	}
+
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+		if child.Type == html.ElementNode && enmlBlocks[child.Data] {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// a fenced code block, the fence is longer than any run of backticks in the code
+func fence(code string) string {
+	f := "```"
+	for strings.Contains(code, f) {
+		f += "`"
+	}
+	return f + "\n" + strings.Trim(code, "\n") + "\n" + f
+}
+
+// prefix the first line and the rest differently, e.g. the marker of a list item
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			lines[i] = first + line
+		case line != "":
+			lines[i] = rest + line
+		default:
+			lines[i] = strings.TrimRight(rest, " ")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wrap inline Markdown by a delimiter, spaces are kept outside, since `** bold**` isn't bold
+func wrap(s, delimiter string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	lead := s[:strings.Index(s, trimmed)]
+	trail := s[len(lead)+len(trimmed):]
+	return lead + delimiter + trimmed + delimiter + trail
+}
+
+// a link destination, in angle brackets if it has spaces or parentheses
+func destination(url string) string {
+	if strings.ContainsAny(url, " ()") {
+		return "<" + url + ">"
+	}
+	return url
+}
+
+// runs of whitespace into a single space, the same as browsers render
+func collapseSpaces(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnmlToMarkdown(t *testing.T) {
+	enml := `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note>
+<h2>Shopping   list</h2>
+<div>Buy <b>milk</b> and <i>eggs</i>&nbsp;at 5*2</div>
+<div><br/></div>
+<div>first line<br/>second line</div>
+<ul><li>one</li><li>two<ul><li>nested</li></ul></li></ul>
+<ol><li>first</li><li>second</li></ol>
+<div><en-todo checked="true"/>done</div>
+<div><en-todo/>todo</div>
+<div>see <a href="https://example.com/a b">the site</a></div>
+<div><en-media hash="0a1b" type="image/png"/></div>
+<div><en-media hash="ffff" type="application/pdf"/> after</div>
+<div><en-media hash="dead" type="image/png"/></div>
+<en-crypt cipher="AES" length="128">c2VjcmV0</en-crypt>
+<pre>func main() {
+	fmt.Println("*")
+}</pre>
+<div style="box-sizing: border-box; -en-codeblock:true;"><div>a := 1</div><div>b := 2</div></div>
+<blockquote><div>quoted</div><div>twice</div></blockquote>
+<table><tr><th>name</th><th>qty</th></tr><tr><td>milk</td><td>1|2</td></tr></table>
+<hr/>
+</en-note>`
+
+	md, err := EnmlToMarkdown(enml, func(hash string) (string, string, bool) {
+		switch hash {
+		case "0a1b":
+			return "photo_1.png", "files/photo.png", true
+		case "ffff":
+			return "spec.pdf", "files/spec.pdf", true
+		}
+		return "", "", false
+	})
+	require.NoError(t, err)
+	require.Equal(t, "## Shopping list\n\n"+
+		"Buy **milk** and *eggs* at 5\\*2\n\n"+
+		"first line\\\nsecond line\n\n"+
+		"- one\n- two\n  - nested\n\n"+
+		"1. first\n2. second\n\n"+
+		"- [x] done\n\n"+
+		"- [ ] todo\n\n"+
+		"see [the site](<https://example.com/a b>)\n\n"+
+		"![photo\\_1.png](files/photo.png)\n\n"+
+		"[spec.pdf](files/spec.pdf) after\n\n"+
+		"```\nfunc main() {\n\tfmt.Println(\"*\")\n}\n```\n\n"+
+		"```\na := 1\nb := 2\n```\n\n"+
+		"> quoted\n>\n> twice\n\n"+
+		"| name | qty |\n| --- | --- |\n| milk | 1\\|2 |\n\n"+
+		"---\n",
+		md,
+	)
+
+	md, err = EnmlToMarkdown(`<en-note><div><br/></div></en-note>`, nil)
+	require.NoError(t, err)
+	require.Empty(t, md)
+}
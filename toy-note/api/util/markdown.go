@@ -0,0 +1,236 @@
+package util
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// links and images of post content may refer to an affiliate of the post by its id,
+// e.g. `![diagram](affiliate:42)` or `[spec](affiliate:42)`
+const affiliateScheme = "affiliate:"
+
+// CommonMark along with the GitHub flavored extensions: tables, task lists, strikethrough
+// and autolinks. Raw HTML is passed through, and left to the sanitizer.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		// the `align` attribute rather than inline styles, which are stripped
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.TaskList,
+		extension.Strikethrough,
+		extension.Linkify,
+	),
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+// what is left of the rendered HTML: the usual user generated content, plus the classes
+// of code blocks, e.g. `language-go`, which client side highlighters pick up, and the
+// checkboxes of task lists
+var sanitizer = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	p.AllowURLSchemes(strings.TrimSuffix(affiliateScheme, ":"))
+	return p
+}()
+
+// Render Markdown into HTML which is safe to be embedded in a page
+func RenderMarkdown(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+
+	return sanitizer.Sanitize(buf.String()), nil
+}
+
+// Ids of the affiliates referred to by links and images, see `affiliateScheme`.
+// Ids are in order of appearance without duplicates, and code is never searched.
+func AffiliateRefs(source string) []uint {
+	src := []byte(source)
+	doc := markdown.Parser().Parse(text.NewReader(src))
+
+	ids := []uint{}
+	seen := make(map[uint]bool)
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		var destination []byte
+		switch node := n.(type) {
+		case *ast.Link:
+			destination = node.Destination
+		case *ast.Image:
+			destination = node.Destination
+		default:
+			return ast.WalkContinue, nil
+		}
+
+		if id, ok := parseAffiliateRef(string(destination)); ok && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	return ids
+}
+
+func parseAffiliateRef(destination string) (uint, bool) {
+	if !strings.HasPrefix(destination, affiliateScheme) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(destination, affiliateScheme), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// `href` and `src` attributes of rendered HTML, which refer to an affiliate
+var affiliateAttr = regexp.MustCompile(`(href|src)="` + affiliateScheme + `(\d+)"`)
+
+// Rewrite the references to affiliates in HTML rendered by `RenderMarkdown` into the urls
+// given by the function, e.g. download urls
+func ResolveAffiliateRefs(html string, url func(id uint) string) string {
+	return affiliateAttr.ReplaceAllStringFunc(html, func(attr string) string {
+		m := affiliateAttr.FindStringSubmatch(attr)
+		id, ok := parseAffiliateRef(affiliateScheme + m[2])
+		if !ok {
+			return attr
+		}
+		return m[1] + `="` + url(id) + `"`
+	})
+}
+
+// the destination of an inline link or image, or of a link reference definition
+var linkDestination = regexp.MustCompile(`(\]\(\s*<?|\]:[ \t]*<?)([^\s()<>]+)`)
+
+// Rewrite the destinations of links and images in Markdown source, including link reference
+// definitions. A destination is kept if the function gives nothing, and code is never
+// rewritten.
+func RewriteLinks(source string, rewrite func(destination string) (string, bool)) string {
+	src := []byte(source)
+	doc := markdown.Parser().Parse(text.NewReader(src))
+
+	// byte ranges of code and raw HTML, which are left as they are
+	var code []text.Segment
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.CodeBlock, *ast.FencedCodeBlock, *ast.HTMLBlock:
+			lines := node.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				code = append(code, lines.At(i))
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeSpan:
+			for c := node.FirstChild(); c != nil; c = c.NextSibling() {
+				if t, ok := c.(*ast.Text); ok {
+					code = append(code, t.Segment)
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.RawHTML:
+			for i := 0; i < node.Segments.Len(); i++ {
+				code = append(code, node.Segments.At(i))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	inCode := func(pos int) bool {
+		for _, seg := range code {
+			if pos >= seg.Start && pos < seg.Stop {
+				return true
+			}
+		}
+		return false
+	}
+
+	var buf strings.Builder
+	last := 0
+	for _, m := range linkDestination.FindAllStringSubmatchIndex(source, -1) {
+		if inCode(m[0]) {
+			continue
+		}
+		rewritten, ok := rewrite(source[m[4]:m[5]])
+		if !ok {
+			continue
+		}
+		// the prefix up to the destination is kept, e.g. `](`
+		buf.WriteString(source[last:m[4]])
+		buf.WriteString(rewritten)
+		last = m[5]
+	}
+	buf.WriteString(source[last:])
+
+	return buf.String()
+}
+
+// Rewrite the references to affiliates in Markdown source into the urls given by the
+// function, e.g. relative paths of exported files, see `RewriteLinks`
+func RewriteAffiliateRefs(source string, url func(id uint) (string, bool)) string {
+	return RewriteLinks(source, func(destination string) (string, bool) {
+		id, ok := parseAffiliateRef(destination)
+		if !ok {
+			return "", false
+		}
+		return url(id)
+	})
+}
+
+// `[[Post Title]]` or `[[post:123]]`, within a line
+var wikiLink = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// Targets of wiki-style links, e.g. `Post Title` of `[[Post Title]]`, trimmed.
+// Targets are in order of appearance without duplicates, and code is never searched.
+func WikiLinks(source string) []string {
+	src := []byte(source)
+	doc := markdown.Parser().Parse(text.NewReader(src))
+
+	// the text outside code, blocks are separated by new lines so that a link never spans them
+	var buf bytes.Buffer
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch node := n.(type) {
+		case *ast.CodeSpan, *ast.CodeBlock, *ast.FencedCodeBlock, *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			if entering {
+				buf.Write(node.Segment.Value(src))
+				if node.SoftLineBreak() || node.HardLineBreak() {
+					buf.WriteByte('\n')
+				}
+			}
+		default:
+			if !entering && n.Type() == ast.TypeBlock {
+				buf.WriteByte('\n')
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	targets := []string{}
+	seen := make(map[string]bool)
+	for _, m := range wikiLink.FindAllStringSubmatch(buf.String(), -1) {
+		target := strings.TrimSpace(m[1])
+		if target != "" && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+
+	return targets
+}
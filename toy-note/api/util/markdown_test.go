@@ -0,0 +1,123 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	html, err := RenderMarkdown("# Title\n\nSome *emphasis* and `code`.")
+	require.NoError(t, err)
+	require.Equal(t, "<h1>Title</h1>\n<p>Some <em>emphasis</em> and <code>code</code>.</p>\n", html)
+}
+
+func TestRenderMarkdownGFM(t *testing.T) {
+	html, err := RenderMarkdown("| a | b |\n|:--|--:|\n| 1 | 2 |\n\n- [x] done\n- [ ] todo\n\n~~gone~~ https://example.com")
+	require.NoError(t, err)
+
+	require.Contains(t, html, `<th align="left">a</th>`)
+	require.Contains(t, html, `<td align="right">2</td>`)
+	require.Contains(t, html, `<li><input checked="" disabled="" type="checkbox"> done</li>`)
+	require.Contains(t, html, `<li><input disabled="" type="checkbox"> todo</li>`)
+	require.Contains(t, html, `<del>gone</del>`)
+	require.Contains(t, html, `<a href="https://example.com" rel="nofollow">https://example.com</a>`)
+}
+
+func TestRenderMarkdownCodeBlock(t *testing.T) {
+	html, err := RenderMarkdown("```go\nfmt.Println(\"<hi>\")\n```")
+	require.NoError(t, err)
+	require.Equal(t, "<pre><code class=\"language-go\">fmt.Println(&#34;&lt;hi&gt;&#34;)\n</code></pre>\n", html)
+}
+
+func TestRenderMarkdownSanitized(t *testing.T) {
+	html, err := RenderMarkdown(
+		"<script>alert(1)</script>\n\n" +
+			"[link](javascript:alert(1)) <img src=\"x.png\" onerror=\"alert(1)\"> <b class=\"evil\">bold</b>",
+	)
+	require.NoError(t, err)
+
+	require.NotContains(t, html, "script")
+	require.NotContains(t, html, "javascript")
+	require.NotContains(t, html, "onerror")
+	require.NotContains(t, html, "evil")
+	require.Contains(t, html, `<img src="x.png">`)
+	require.Contains(t, html, `<b>bold</b>`)
+}
+
+func TestAffiliateRefs(t *testing.T) {
+	source := "![diagram](affiliate:42) [spec](affiliate:7 \"title\") [again](affiliate:42)\n\n" +
+		"[by reference][spec] `[code](affiliate:9)` [web](https://example.com) [bad](affiliate:x)\n\n" +
+		"    [indented code](affiliate:8)\n\n" +
+		"[spec]: affiliate:5\n"
+
+	require.Equal(t, []uint{42, 7, 5}, AffiliateRefs(source))
+	require.Empty(t, AffiliateRefs("no references"))
+}
+
+func TestResolveAffiliateRefs(t *testing.T) {
+	html, err := RenderMarkdown("![diagram](affiliate:42) [spec](affiliate:7) `affiliate:9`")
+	require.NoError(t, err)
+
+	resolved := ResolveAffiliateRefs(html, func(id uint) string {
+		return fmt.Sprintf("/api/download-file/%d", id)
+	})
+	require.Equal(t,
+		`<p><img src="/api/download-file/42" alt="diagram"> `+
+			`<a href="/api/download-file/7" rel="nofollow">spec</a> <code>affiliate:9</code></p>`+"\n",
+		resolved,
+	)
+}
+
+func TestRewriteAffiliateRefs(t *testing.T) {
+	source := "![diagram](affiliate:42) [spec]( affiliate:7 \"title\") [gone](affiliate:3)\n\n" +
+		"`[code](affiliate:42)` plain affiliate:42\n\n" +
+		"    [indented code](affiliate:42)\n\n" +
+		"[spec]: affiliate:7\n"
+
+	rewritten := RewriteAffiliateRefs(source, func(id uint) (string, bool) {
+		if id == 3 {
+			return "", false
+		}
+		return fmt.Sprintf("attachments/%d.png", id), true
+	})
+	require.Equal(t,
+		"![diagram](attachments/42.png) [spec]( attachments/7.png \"title\") [gone](affiliate:3)\n\n"+
+			"`[code](affiliate:42)` plain affiliate:42\n\n"+
+			"    [indented code](affiliate:42)\n\n"+
+			"[spec]: attachments/7.png\n",
+		rewritten,
+	)
+}
+
+func TestRewriteLinks(t *testing.T) {
+	source := "![photo](images/a.png) [doc](<notes/b.pdf>) [web](https://example.com \"title\")\n\n" +
+		"`[code](images/a.png)`\n\n" +
+		"[ref]: images/a.png\n"
+
+	rewritten := RewriteLinks(source, func(destination string) (string, bool) {
+		if strings.HasPrefix(destination, "https:") {
+			return "", false
+		}
+		return "affiliate:" + strings.ToUpper(destination), true
+	})
+	require.Equal(t,
+		"![photo](affiliate:IMAGES/A.PNG) [doc](<affiliate:NOTES/B.PDF>) [web](https://example.com \"title\")\n\n"+
+			"`[code](images/a.png)`\n\n"+
+			"[ref]: affiliate:IMAGES/A.PNG\n",
+		rewritten,
+	)
+}
+
+func TestWikiLinks(t *testing.T) {
+	source := "See [[Post Title]] and [[ post:12 ]], [[Post Title]] again\n\n" +
+		"- [[In list]]\n\n" +
+		"| head |\n| --- |\n| [[cell]] |\n\n" +
+		"`[[in code]]` [[*emph*]] [[not\nclosed]] [[]]\n\n" +
+		"```\n[[fenced]]\n```\n"
+
+	require.Equal(t, []string{"Post Title", "post:12", "In list", "cell", "emph"}, WikiLinks(source))
+	require.Empty(t, WikiLinks("no links"))
+}
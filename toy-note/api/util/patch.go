@@ -0,0 +1,367 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// a `test` operation of a JSON Patch failed, the document is not as the patch expects
+var ErrPatchTest = errors.New("patch test failed")
+
+// Apply a JSON Merge Patch (RFC 7396) to a JSON document. Members of the patch replace the
+// ones of the document, objects are merged recursively, and a null removes the member.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], value)
+		}
+	}
+	return t
+}
+
+// an operation of a JSON Patch
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// Apply a JSON Patch (RFC 6902) to a JSON document. The operations are applied in order,
+// and the patch fails as a whole if any of them fails. A failed `test` is `ErrPatchTest`.
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+
+	for i, op := range ops {
+		target, err = applyOperation(target, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc interface{}, op patchOperation) (interface{}, error) {
+	if op.Path == nil {
+		return nil, errors.New("path is required")
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("value is required by %s", op.Op)
+		}
+		if value, err = decodeJSON(*op.Value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("from is required by %s", op.Op)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = getPointer(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			// the copy must not share objects or arrays with the original
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = decodeJSON(data); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if isPrefix(from, path) && len(from) < len(path) {
+			return nil, fmt.Errorf("%s can't be moved into itself", *op.From)
+		}
+		if doc, err = removePointer(doc, from); err != nil {
+			return nil, err
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unknown op %q", op.Op)
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return addPointer(doc, path, value)
+	case "remove":
+		return removePointer(doc, path)
+	case "replace":
+		if _, err := getPointer(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, err = removePointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, value)
+	default:
+		current, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, fmt.Errorf("%w: %s", ErrPatchTest, *op.Path)
+		}
+		return doc, nil
+	}
+}
+
+// decode JSON keeping numbers as they are, so that ids are never rounded
+func decodeJSON(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+// Parse a JSON Pointer (RFC 6901) into its reference tokens, the empty pointer is
+// the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// the index of an array element, `-` is past the last element which is only valid if
+// it may be appended
+func arrayIndex(token string, n int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return n, nil
+	}
+	// no sign and no leading zeros
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.IndexFunc(token, func(c rune) bool {
+		return c < '0' || c > '9'
+	}) >= 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > n || (i == n && !appending) {
+		return 0, fmt.Errorf("array index %d out of bounds", i)
+	}
+	return i, nil
+}
+
+func getPointer(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%q not found in a scalar", token)
+		}
+	}
+	return doc, nil
+}
+
+// Change the parent of the target by fn, and return the document changed. Arrays are
+// replaced by the ones returned by fn, since they may be resized.
+func withParent(
+	doc interface{},
+	path []string,
+	fn func(parent interface{}, token string) (interface{}, error),
+) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := getPointer(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = withParent(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(node), false)
+		node[i] = child
+	}
+	return doc, nil
+}
+
+func addPointer(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return withParent(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("%q can't be added to a scalar", token)
+		}
+	})
+}
+
+func removePointer(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("the whole document can't be removed")
+	}
+
+	return withParent(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%q not found in a scalar", token)
+		}
+	})
+}
+
+// whether two decoded JSON values are equal, numbers are compared by value, e.g. 1 and 1.0
+func jsonEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	doc := `{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`
+	patch := `{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`
+
+	patched, err := MergePatch([]byte(doc), []byte(patch))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"title": "Hello!",
+		"author": {"givenName": "John"},
+		"tags": ["example"],
+		"content": "This will be unchanged",
+		"phoneNumber": "+01-123-456-7890"
+	}`, string(patched))
+
+	// a patch which is not an object replaces the whole document
+	patched, err = MergePatch([]byte(doc), []byte(`["a"]`))
+	require.NoError(t, err)
+	require.JSONEq(t, `["a"]`, string(patched))
+
+	_, err = MergePatch([]byte(doc), []byte(`{"title":`))
+	require.Error(t, err)
+}
+
+func TestJSONPatch(t *testing.T) {
+	doc := `{"foo":["bar","baz"],"a/b":1,"m~n":{"id":12345678901234567890}}`
+
+	patched, err := JSONPatch([]byte(doc), []byte(`[
+		{"op": "test", "path": "/a~1b", "value": 1.0},
+		{"op": "add", "path": "/foo/1", "value": "qux"},
+		{"op": "add", "path": "/foo/-", "value": "end"},
+		{"op": "remove", "path": "/foo/0"},
+		{"op": "replace", "path": "/a~1b", "value": {"x": 1}},
+		{"op": "copy", "from": "/a~1b", "path": "/copied"},
+		{"op": "add", "path": "/copied/y", "value": 2},
+		{"op": "move", "from": "/m~0n/id", "path": "/id"}
+	]`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"foo": ["qux", "baz", "end"],
+		"a/b": {"x": 1},
+		"copied": {"x": 1, "y": 2},
+		"m~n": {},
+		"id": 12345678901234567890
+	}`, string(patched))
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	doc := `{"foo":["bar"],"obj":{"a":1}}`
+
+	for _, patch := range []string{
+		`{"op": "add"}`,
+		`[{"op": "add", "path": "/x"}]`,
+		`[{"op": "remove", "path": "/missing"}]`,
+		`[{"op": "replace", "path": "/missing", "value": 1}]`,
+		`[{"op": "add", "path": "/foo/2", "value": 1}]`,
+		`[{"op": "add", "path": "/foo/01", "value": 1}]`,
+		`[{"op": "remove", "path": "/foo/-"}]`,
+		`[{"op": "move", "from": "/obj", "path": "/obj/b"}]`,
+		`[{"op": "remove", "path": ""}]`,
+		`[{"op": "add", "path": "foo", "value": 1}]`,
+		`[{"op": "merge", "path": "/foo", "value": 1}]`,
+	} {
+		_, err := JSONPatch([]byte(doc), []byte(patch))
+		require.Error(t, err, patch)
+		require.NotErrorIs(t, err, ErrPatchTest, patch)
+	}
+
+	// a failed test is told apart from an invalid patch
+	_, err := JSONPatch([]byte(doc), []byte(`[
+		{"op": "add", "path": "/foo/-", "value": "baz"},
+		{"op": "test", "path": "/obj", "value": {"a": 2}}
+	]`))
+	require.ErrorIs(t, err, ErrPatchTest)
+}
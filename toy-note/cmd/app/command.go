@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"toy-note/api/entity"
+	"toy-note/api/service"
+)
+
+const commandUsage = `commands:
+  apikey create -user <name> -name <name> -scopes <scope,...>
+  apikey list -user <name>
+  apikey revoke -prefix <prefix>
+
+scopes: posts:read, posts:write, files:read, files:write`
+
+// Run a command given by the arguments, and print the result to out
+func runCommand(users service.UserRepo, args []string, out io.Writer) error {
+	if len(args) < 2 || args[0] != "apikey" {
+		return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), commandUsage)
+	}
+
+	switch args[1] {
+	case "create":
+		return createApiKey(users, args[2:], out)
+	case "list":
+		return listApiKeys(users, args[2:], out)
+	case "revoke":
+		return revokeApiKey(users, args[2:], out)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), commandUsage)
+	}
+}
+
+func createApiKey(users service.UserRepo, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+	userName := fs.String("user", "", "name of the user who owns the key")
+	name := fs.String("name", "", "what the key is used for")
+	scopes := fs.String("scopes", "", "comma separated scopes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := users.FindUser(*userName)
+	if err != nil {
+		return fmt.Errorf("user %q not found: %w", *userName, err)
+	}
+	scopeList, err := entity.ParseScopes(*scopes)
+	if err != nil {
+		return err
+	}
+
+	key, err := users.CreateApiKey(user.Id, entity.ApiKeyRequest{Name: *name, Scopes: scopeList})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Created API key %s for %s, it is only shown once:\n%s\n", key.Prefix, user.Name, key.Key)
+	return nil
+}
+
+func listApiKeys(users service.UserRepo, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("apikey list", flag.ContinueOnError)
+	userName := fs.String("user", "", "name of the user who owns the keys")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := users.FindUser(*userName)
+	if err != nil {
+		return fmt.Errorf("user %q not found: %w", *userName, err)
+	}
+	keys, err := users.GetApiKeys(user.Id)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tNAME\tSCOPES\tLAST USED\tREVOKED")
+	for _, k := range keys {
+		scopes := make([]string, len(k.Scopes))
+		for i, s := range k.Scopes {
+			scopes[i] = string(s)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			k.Prefix, k.Name, strings.Join(scopes, ","), formatTime(k.LastUsedAt), formatTime(k.RevokedAt))
+	}
+	return w.Flush()
+}
+
+func revokeApiKey(users service.UserRepo, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("apikey revoke", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "prefix of the key, as listed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// the operator may revoke a key of any user
+	if err := users.RevokeApiKey(0, *prefix); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Revoked API key %s\n", *prefix)
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
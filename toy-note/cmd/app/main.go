@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"os"
+	"time"
 	"toy-note/api/controller"
 	"toy-note/api/persistence"
 	"toy-note/api/service"
@@ -28,9 +32,12 @@ const envPath = "../../env"
 // @host                     localhost:8080
 // @BasePath                 /api
 // @query.collection.format  multi
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
 func main() {
 
-	// Determine the api environment
+	// Determine the api environment, the remaining arguments are a command, see `runCommand`
 	mode := flag.String("m", "dev", "dev or prod")
 	flag.Parse()
 
@@ -72,45 +79,167 @@ func main() {
 		Sslmode: "disable",
 	}
 
-	// Mongo
-	mongoConn := persistence.MongoConn{
-		Host: config.MONGO_HOST,
-		Port: config.MONGO_PORT,
-		User: config.MONGO_USER,
-		Pass: config.MONGO_PASS,
+	// Signing keys of access tokens
+	tokens, err := service.NewTokens(
+		config.JWT_SECRET,
+		time.Duration(config.ACCESS_TOKEN_TTL_MINUTES)*time.Minute,
+		time.Duration(config.REFRESH_TOKEN_TTL_DAYS)*24*time.Hour,
+	)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Run a command instead of the server, e.g. `apikey create`
+	if flag.NArg() > 0 {
+		users, err := service.NewUserService(logger.TNLogger, pgConn, tokens)
+		if err != nil {
+			log.Panic(err)
+		}
+		if err := runCommand(users, flag.Args(), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Blob store for affiliate files
+	var blob persistence.BlobStore
+	switch config.BLOB_STORE {
+	case "fs":
+		fsConn := persistence.FsConn{
+			Root: config.FS_ROOT,
+		}
+
+		fs, err := persistence.NewFsRepository(logger.TNLogger, fsConn)
+		if err != nil {
+			log.Panic(err)
+		}
+		blob = &fs
+	default:
+		mongoConn := persistence.MongoConn{
+			Host: config.MONGO_HOST,
+			Port: config.MONGO_PORT,
+			User: config.MONGO_USER,
+			Pass: config.MONGO_PASS,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		mongo, err := persistence.NewMongoRepository(ctx, logger.TNLogger, mongoConn)
+		cancel()
+		if err != nil {
+			log.Panic(err)
+		}
+		blob = &mongo
 	}
 
 	// Initialize service
-	toyNoteService, err := service.NewToyNoteService(logger.TNLogger, pgConn, mongoConn)
+	toyNoteService, err := service.NewToyNoteService(logger.TNLogger, pgConn, blob, tokens)
 	if err != nil {
 		log.Panic(err)
 	}
+	// migrate tables to the latest schema before serving
+	if err := toyNoteService.Init(); err != nil {
+		log.Panic(err)
+	}
 
 	// Initialize controller
 	toyNoteController := controller.NewToyNoteController(logger.TNLogger, toyNoteService)
-	if err != nil {
-		log.Panic(err)
-	}
+	authController := controller.NewAuthController(logger.TNLogger, toyNoteService)
+	adminController := controller.NewAdminController(logger.TNLogger, toyNoteService, toyNoteService)
 
 	// Gin
 	router := gin.New()
 
-	// Api group
-	api := router.Group("/api")
+	// Auth group, public
+	auth := router.Group("/api/auth")
+	{
+		auth.POST("/register", authController.Register)
+		auth.POST("/login", authController.Login)
+		auth.POST("/refresh", authController.Refresh)
+		auth.POST("/logout", authController.Logout)
+	}
+
+	// Shared posts, public
+	shared := router.Group("/api/shared")
+	{
+		shared.GET("/:token", toyNoteController.GetSharedPost)
+		shared.GET("/:token/download-file/:id", toyNoteController.DownloadSharedAffiliate)
+		shared.HEAD("/:token/download-file/:id", toyNoteController.DownloadSharedAffiliate)
+	}
+
+	// Api group, every route requires an access token
+	api := router.Group("/api", authController.Authenticate)
 	{
 		api.GET("/get-tags", toyNoteController.GetTags)
 		api.POST("/save-tag", toyNoteController.SaveTag)
 		api.DELETE("/delete-tag/:id", toyNoteController.DeleteTag)
 
+		api.GET("/get-notebooks", toyNoteController.GetNotebooks)
+		api.GET("/get-notebook/:id", toyNoteController.GetNotebook)
+		api.POST("/save-notebook", toyNoteController.SaveNotebook)
+		api.DELETE("/delete-notebook/:id", toyNoteController.DeleteNotebook)
+		api.POST("/move-post/:id", toyNoteController.MovePost)
+
 		api.GET("/get-posts", toyNoteController.GetPosts)
+		api.GET("/get-post/:id", toyNoteController.GetPost)
 		api.POST("/save-post", toyNoteController.SavePost)
+		api.PATCH("/posts/:id", toyNoteController.PatchPost)
 		api.DELETE("/delete-post/:id", toyNoteController.DeletePost)
 
+		api.GET("/get-trash", toyNoteController.GetTrash)
+		api.POST("/restore-post/:id", toyNoteController.RestorePost)
+		api.DELETE("/purge-post/:id", toyNoteController.PurgePost)
+
+		api.GET("/get-revisions/:id", toyNoteController.GetRevisions)
+		api.GET("/get-revision/:id", toyNoteController.GetRevision)
+		api.GET("/diff-revisions", toyNoteController.DiffRevisions)
+		api.POST("/restore-revision/:id", toyNoteController.RestoreRevision)
+
+		api.POST("/create-share", toyNoteController.CreateShare)
+		api.GET("/get-shares/:id", toyNoteController.GetShares)
+		api.DELETE("/revoke-share/:id", toyNoteController.RevokeShare)
+
+		api.GET("/posts/:id/links", toyNoteController.GetPostLinks)
+		api.GET("/posts/:id/backlinks", toyNoteController.GetBacklinks)
+
 		api.GET("/download-file/:id", toyNoteController.DownloadAffiliate)
+		api.HEAD("/download-file/:id", toyNoteController.DownloadAffiliate)
 
 		api.GET("/search-posts-by-tags", toyNoteController.SearchPostsByTags)
 		api.GET("/search-posts-by-title", toyNoteController.SearchPostsByTitle)
 		api.GET("/search-posts-by-time", toyNoteController.SearchPostsByTime)
+		api.GET("/search-posts", toyNoteController.SearchPosts)
+		api.GET("/query-posts", toyNoteController.QueryPosts)
+		api.POST("/query-posts", toyNoteController.QueryPostsByBody)
+
+		api.GET("/export", toyNoteController.ExportPosts)
+		api.GET("/export/:id", toyNoteController.ExportPost)
+		api.POST("/import", toyNoteController.ImportPosts)
+
+		api.POST("/bulk-tag-posts", toyNoteController.BulkTagPosts)
+		api.POST("/bulk-delete-posts", toyNoteController.BulkDeletePosts)
+		api.POST("/bulk-date-posts", toyNoteController.BulkDatePosts)
+		api.POST("/bulk-delete-tags", toyNoteController.BulkDeleteTags)
+
+		api.POST("/create-api-key", authController.CreateApiKey)
+		api.GET("/get-api-keys", authController.GetApiKeys)
+		api.DELETE("/revoke-api-key/:prefix", authController.RevokeApiKey)
+	}
+
+	// Admin group, permissions are checked by the service
+	admin := api.Group("/admin")
+	{
+		admin.GET("/get-unowned-affiliates", adminController.GetUnownedAffiliates)
+		admin.POST("/rebind-affiliate", adminController.RebindAffiliate)
+		admin.DELETE("/delete-unowned-affiliates", adminController.DeleteUnownedAffiliates)
+		admin.POST("/merge-tags", adminController.MergeTags)
+		admin.POST("/set-user-role/:id", adminController.SetUserRole)
+	}
+
+	// Purge expired trash periodically, a non-positive retention keeps trash forever
+	if config.TRASH_RETENTION_DAYS > 0 {
+		retention := time.Duration(config.TRASH_RETENTION_DAYS) * 24 * time.Hour
+		go purgeTrash(toyNoteService, retention, time.Hour)
 	}
 
 	// Swagger documention
@@ -120,3 +249,21 @@ func main() {
 	log.Info("Starting toy-note API...")
 	router.Run()
 }
+
+// Purge posts trashed longer than the retention, every interval
+func purgeTrash(repo service.ToyNoteRepo, retention, interval time.Duration) {
+	log := logger.TNLogger.NewSugar("purgeTrash")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		n, err := repo.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			log.Error(err)
+		}
+		if n > 0 {
+			log.Info(fmt.Sprintf("Purged %d expired posts from trash", n))
+		}
+	}
+}
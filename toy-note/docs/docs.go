@@ -31,22 +31,31 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/delete-post/{id}": {
+        "/admin/delete-unowned-affiliates": {
             "delete": {
-                "description": "delete a post by ID",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Delete unowned affiliates by IDs, along with their files. Bound affiliates are skipped.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "post"
+                    "admin"
                 ],
-                "summary": "delete a post by ID",
+                "summary": "delete unowned affiliates",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "post ID",
-                        "name": "id",
-                        "in": "path",
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "affiliate IDs",
+                        "name": "ids",
+                        "in": "query",
                         "required": true
                     }
                 ],
@@ -57,8 +66,14 @@ var doc = `{
                             "$ref": "#/definitions/controller.successMessage"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/controller.errorMessage"
                         }
@@ -66,22 +81,34 @@ var doc = `{
                 }
             }
         },
-        "/delete-tag/{id}": {
-            "delete": {
-                "description": "delete a tag by ID",
+        "/admin/get-unowned-affiliates": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get affiliates which are not bound to any post, of all users.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tag"
+                    "admin"
                 ],
-                "summary": "delete a tag by ID",
+                "summary": "get unowned affiliates",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "tag ID",
-                        "name": "id",
-                        "in": "path",
+                        "description": "page number",
+                        "name": "page",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
                         "required": true
                     }
                 ],
@@ -89,11 +116,17 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controller.successMessage"
+                            "$ref": "#/definitions/entity.AffiliatePage"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/controller.errorMessage"
                         }
@@ -101,22 +134,38 @@ var doc = `{
                 }
             }
         },
-        "/download-file": {
-            "get": {
-                "description": "download an affiliate by ID",
+        "/admin/merge-tags": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Merge the source tags into the target tag in one transaction, all the tags must be owned by the same user.\nPosts bound to the sources are bound to the target instead, the tags nested in the sources are moved to the target,\nthen the sources are deleted.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "affiliate"
+                    "admin"
                 ],
-                "summary": "download an affiliate by ID",
+                "summary": "merge tags",
                 "parameters": [
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "source tag IDs",
+                        "name": "source_ids",
+                        "in": "query",
+                        "required": true
+                    },
                     {
                         "type": "integer",
-                        "description": "affiliate ID",
-                        "name": "id",
-                        "in": "path",
+                        "description": "target tag ID",
+                        "name": "target_id",
+                        "in": "query",
                         "required": true
                     }
                 ],
@@ -124,11 +173,17 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controller.downloadSuccess"
+                            "$ref": "#/definitions/entity.Tag"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/controller.errorMessage"
                         }
@@ -136,28 +191,33 @@ var doc = `{
                 }
             }
         },
-        "/get-posts": {
-            "get": {
-                "description": "get all posts with pagination restriction",
+        "/admin/rebind-affiliate": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Rebind an unowned affiliate to a post, both must be owned by the same user.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "post"
+                    "admin"
                 ],
-                "summary": "get all posts",
+                "summary": "rebind an unowned affiliate",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "page number",
-                        "name": "page",
+                        "description": "post ID",
+                        "name": "post_id",
                         "in": "query",
                         "required": true
                     },
                     {
                         "type": "integer",
-                        "description": "page size",
-                        "name": "size",
+                        "description": "affiliate ID",
+                        "name": "affiliate_id",
                         "in": "query",
                         "required": true
                     }
@@ -166,71 +226,106 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/entity.Post"
-                            }
+                            "$ref": "#/definitions/controller.successMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
                         }
                     }
                 }
             }
         },
-        "/get-tags": {
-            "get": {
-                "description": "get all tags without limit or offset",
+        "/admin/set-user-role/{id}": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Grant ` + "`" + `reader` + "`" + `, ` + "`" + `editor` + "`" + ` or ` + "`" + `admin` + "`" + ` to a user, admins can't change their own role.\nAccess tokens already issued keep the previous role until they expire.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tag"
+                    "admin"
+                ],
+                "summary": "set the role of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "reader, editor or admin",
+                        "name": "role",
+                        "in": "query",
+                        "required": true
+                    }
                 ],
-                "summary": "get all tags",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/entity.Tag"
-                            }
+                            "$ref": "#/definitions/entity.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
                         }
                     }
                 }
             }
         },
-        "/save-post": {
+        "/auth/login": {
             "post": {
-                "description": "Save post can be used to create a new post or update an existing post.\nIf id is not provided, it will create a new post; Otherwise, it will update\nan existing post.",
+                "description": "Log in by name and password, and get an access token and a refresh token.\nThe access token is given by ` + "`" + `Authorization: Bearer \u003ctoken\u003e` + "`" + ` to the other routes,\nthe refresh token is exchanged for new tokens once the access token expires.",
                 "consumes": [
-                    "multipart/form-data"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "post"
+                    "auth"
                 ],
-                "summary": "create/update a post",
+                "summary": "log in",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "post data",
+                        "description": "name and password",
                         "name": "data",
-                        "in": "formData",
-                        "required": true
-                    },
-                    {
-                        "type": "file",
-                        "description": "affiliate files",
-                        "name": "files",
-                        "in": "formData"
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.Credentials"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/entity.Post"
+                            "$ref": "#/definitions/entity.TokenPair"
                         }
                     },
                     "400": {
@@ -238,13 +333,19 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/controller.errorMessage"
                         }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
                     }
                 }
             }
         },
-        "/save-tag": {
+        "/auth/logout": {
             "post": {
-                "description": "create a new tag or update an existing tag, based on whether the tag ID is provided",
+                "description": "Revoke a refresh token. The access token is still valid until it expires.",
                 "consumes": [
                     "application/json"
                 ],
@@ -252,17 +353,17 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "tag"
+                    "auth"
                 ],
-                "summary": "create/update a tag",
+                "summary": "log out",
                 "parameters": [
                     {
-                        "description": "tag data",
+                        "description": "refresh token",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/entity.Tag"
+                            "$ref": "#/definitions/entity.RefreshRequest"
                         }
                     }
                 ],
@@ -270,7 +371,7 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/entity.Tag"
+                            "$ref": "#/definitions/controller.successMessage"
                         }
                     },
                     "400": {
@@ -278,214 +379,3454 @@ var doc = `{
                         "schema": {
                             "$ref": "#/definitions/controller.errorMessage"
                         }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
                     }
                 }
             }
         },
-        "/search-posts-by-tags": {
-            "get": {
-                "description": "get posts by tags",
+        "/auth/refresh": {
+            "post": {
+                "description": "Exchange a refresh token for a new access token and a new refresh token.\nA refresh token can be used only once, using it again logs out all the sessions of the user.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "post"
+                    "auth"
                 ],
-                "summary": "get posts by tags",
+                "summary": "refresh tokens",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "page number",
-                        "name": "page",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "page size",
-                        "name": "size",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "tag ids",
-                        "name": "ids",
-                        "in": "query",
-                        "required": true
+                        "description": "refresh token",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.RefreshRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/entity.Post"
-                            }
+                            "$ref": "#/definitions/entity.TokenPair"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
                         }
                     }
                 }
             }
         },
-        "/search-posts-by-time": {
-            "get": {
-                "description": "get posts by title",
+        "/auth/register": {
+            "post": {
+                "description": "Register a user by name and password, the name is unique.\nThe password must be 8 to 72 bytes.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "post"
+                    "auth"
                 ],
-                "summary": "get posts by title",
+                "summary": "register a user",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "page number",
-                        "name": "page",
-                        "in": "query",
-                        "required": true
+                        "description": "name and password",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.Credentials"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.User"
+                        }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/bulk-date-posts": {
+            "post": {
+                "security": [
                     {
-                        "type": "integer",
-                        "description": "page size",
-                        "name": "size",
-                        "in": "query",
-                        "required": true
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Set the date of many posts at once, in one transaction. Each post gets a new version recorded as\na revision. A post not found is reported as failed without aborting the others.\nAt most 1000 posts can be changed at once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bulk"
+                ],
+                "summary": "move posts to a date",
+                "parameters": [
+                    {
+                        "description": "posts and date",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.BulkDating"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.BulkReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/bulk-delete-posts": {
+            "post": {
+                "security": [
                     {
-                        "type": "string",
-                        "description": "time start",
-                        "name": "start",
-                        "in": "query",
-                        "required": true
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Move many posts to trash at once, in one transaction. A post not found is reported as failed without\naborting the others. At most 1000 posts can be deleted at once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bulk"
+                ],
+                "summary": "move posts to trash",
+                "parameters": [
+                    {
+                        "description": "post IDs",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.BulkIds"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.BulkReport"
+                        }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/bulk-delete-tags": {
+            "post": {
+                "security": [
                     {
-                        "type": "string",
-                        "description": "time end",
-                        "name": "end",
-                        "in": "query",
-                        "required": true
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Delete many tags at once, in one transaction. The tags nested in a deleted tag are moved to its\nparent. A tag not found is reported as failed without aborting the others.\nAt most 1000 tags can be deleted at once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bulk"
+                ],
+                "summary": "delete tags",
+                "parameters": [
+                    {
+                        "description": "tag IDs",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.BulkIds"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.BulkReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/bulk-tag-posts": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Add tags to and remove tags from many posts at once, the other tags of the posts are kept. All the\nposts are changed in one transaction, each gets a new version recorded as a revision.\nThe tags must be found, whereas a post not found is reported as failed without aborting the others.\nAt most 1000 posts can be changed at once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bulk"
+                ],
+                "summary": "add tags to and remove tags from posts",
+                "parameters": [
+                    {
+                        "description": "posts and tags",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.BulkTagging"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.BulkReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/create-api-key": {
+            "post": {
+                "security": [
                     {
-                        "type": "string",
-                        "description": "time type",
-                        "name": "type",
-                        "in": "query"
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Create an API key for scripts and integrations, limited to the given scopes:\n` + "`" + `posts:read` + "`" + `, ` + "`" + `posts:write` + "`" + `, ` + "`" + `files:read` + "`" + ` and ` + "`" + `files:write` + "`" + `.\nThe key is given by ` + "`" + `Authorization: Bearer \u003ckey\u003e` + "`" + ` the same as an access token,\nand it is only responded once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "create an API key",
+                "parameters": [
+                    {
+                        "description": "name and scopes",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.ApiKeyRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/entity.Post"
-                            }
+                            "$ref": "#/definitions/entity.NewApiKey"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/create-share": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Create a public read-only link of a post, which is opened by ` + "`" + `shared/{token}` + "`" + ` without an account.\nThe token is only responded once. Optionally protected by a password, which visitors give\nby HTTP basic auth, and expires at ` + "`" + `expires_at` + "`" + `.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "share"
+                ],
+                "summary": "create a share link of a post",
+                "parameters": [
+                    {
+                        "description": "post ID, password and expiry",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.ShareRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.ShareLink"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
                         }
                     }
                 }
             }
         },
-        "/search-posts-by-title": {
-            "get": {
-                "description": "get posts by title",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "post"
-                ],
-                "summary": "get posts by title",
-                "parameters": [
-                    {
-                        "type": "integer",
-                        "description": "page number",
-                        "name": "page",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "page size",
-                        "name": "size",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "post title",
-                        "name": "title",
-                        "in": "query",
-                        "required": true
+        "/delete-notebook/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Delete a notebook by ID. A notebook which still has notebooks or posts (trashed posts included)\nis refused with 409 Conflict, unless ` + "`" + `move_to` + "`" + ` is given: either ` + "`" + `root` + "`" + ` or the ID of another notebook,\nwhere its notebooks and posts are moved to.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notebook"
+                ],
+                "summary": "delete a notebook by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "notebook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "root or a notebook ID",
+                        "name": "move_to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.successMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/delete-post/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Move a post to trash by ID, it can be restored by ` + "`" + `restore-post` + "`" + ` until it is purged.\nThe wiki-style links to the post from other posts are broken, they are responded in ` + "`" + `broken_links` + "`" + `.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "delete a post by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.deleteMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/delete-tag/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "delete a tag by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tag"
+                ],
+                "summary": "delete a tag by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "tag ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.successMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/diff-revisions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Line-level diff between two revisions of the same post, from ` + "`" + `from` + "`" + ` to ` + "`" + `to` + "`" + `.\nTitle, subtitle and content are diffed line by line (` + "`" + `=` + "`" + ` kept, ` + "`" + `-` + "`" + ` deleted, ` + "`" + `+` + "`" + ` inserted),\ntags and affiliates by ids.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "revision"
+                ],
+                "summary": "diff two revisions",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "revision ID",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "revision ID",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.RevisionDiff"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/download-file/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Download an affiliate by ID, the file is streamed from the blob store.\nSupports ` + "`" + `Range` + "`" + ` requests (206 Partial Content) and conditional requests\n(` + "`" + `If-None-Match` + "`" + `, ` + "`" + `If-Modified-Since` + "`" + `, 304 Not Modified).\nWith ` + "`" + `inline=1` + "`" + `, images, audios, videos, PDF and text files are served inline\nwith their content type, so that they can be previewed by browsers.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "affiliate"
+                ],
+                "summary": "download an affiliate by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "affiliate ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "preview in browser",
+                        "name": "inline",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "bytes range, e.g. bytes=0-1023",
+                        "name": "Range",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified of a cached copy",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "not modified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "416": {
+                        "description": "range not satisfiable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/export": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Export the posts found by the same criteria as ` + "`" + `GET /query-posts` + "`" + ` as a zip archive, streamed as it is written.\nEach post is a Markdown file with YAML front matter (title, subtitle, date, tags, created_at, updated_at),\nits affiliates are files under ` + "`" + `attachments/` + "`" + `, and references to them are rewritten into relative links.",
+                "produces": [
+                    "application/zip"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "export posts",
+                "parameters": [
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "tag ids",
+                        "name": "tag_ids",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "all or any",
+                        "name": "tag_match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "excluded tag ids",
+                        "name": "exclude_tag_ids",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "tags stand for the tags nested in them as well",
+                        "name": "tag_descendants",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "full-text search",
+                        "name": "text",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "time start",
+                        "name": "start",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "time end",
+                        "name": "end",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "time type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "sort field",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "asc or desc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/export/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Export a post as a zip archive, the same as ` + "`" + `GET /export` + "`" + ` but for a single post.",
+                "produces": [
+                    "application/zip"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "export a post",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/get-api-keys": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get all API keys of the user, including revoked ones, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "get API keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/entity.ApiKey"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/get-notebook/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notebook"
+                ],
+                "summary": "get a notebook by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "notebook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Notebook"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/get-notebooks": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get all notebooks as trees, each with the number of posts right in it. Notebooks are ordered by name.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notebook"
+                ],
+                "summary": "get all notebooks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/entity.NotebookNode"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/get-post/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get a post by ID, with its tags and affiliates.\nIts version is responded as ` + "`" + `ETag` + "`" + `, which can be sent back by ` + "`" + `If-Match` + "`" + ` on save.\nWith ` + "`" + `format=html` + "`" + `, the Markdown content is rendered as sanitized HTML in ` + "`" + `content_html` + "`" + `.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "get a post by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Post"
+                        }
+                    },
+                    "304": {
+                        "description": "not modified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/get-posts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get all posts with pagination restriction, newest first.\nInstead of ` + "`" + `page` + "`" + `, the ` + "`" + `next_cursor` + "`" + ` of the previous page can be given as ` + "`" + `cursor` + "`" + `,\nwhich is not shifted by posts added in the meanwhile.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "get all posts",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page number, required without cursor",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/get-revision/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get a revision by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "revision"
+                ],
+                "summary": "get a revision by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "revision ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostRevision"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/get-revisions/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get revisions of a post by pagination, newest first. A revision is recorded every time the post is saved.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "revision"
+                ],
+                "summary": "get revisions of a post",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page number",
+                        "name": "page",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.RevisionPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/get-shares/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get all share links of a post, including revoked and expired ones, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "share"
+                ],
+                "summary": "get share links of a post",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/entity.Share"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/get-tags": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get all tags without limit or offset",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tag"
+                ],
+                "summary": "get all tags",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/entity.Tag"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/get-trash": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get trashed posts with pagination restriction, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "trash"
+                ],
+                "summary": "get trashed posts",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page number, required without cursor",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/import": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Import a zip archive or a tarball, optionally gzipped, of Markdown files with YAML front matter,\ne.g. an archive of ` + "`" + `GET /export` + "`" + `. Each Markdown file becomes a post, tags are matched by name\nor created, and the attachments in the front matter as well as the files linked by relative\npaths are uploaded as affiliates.\nBy ` + "`" + `source` + "`" + `, an ENEX file of Evernote, or a RAW export directory of Joplin in a zip archive or\na tarball, is imported the same way: ENML is converted into Markdown, and resources become affiliates.\nA note with the same title and date as an existing post is skipped as a duplicate, and a note\nwhich can't be imported is reported as failed without aborting the others.\nIn a dry run nothing is written, and the report tells what would be created.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "import"
+                ],
+                "summary": "import posts",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "archive or ENEX file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default), enex or joplin",
+                        "name": "source",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "only report what would be created",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.ImportReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/move-post/{id}": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Move a post to a notebook, or to the root if ` + "`" + `notebook_id` + "`" + ` is empty. The post is not updated otherwise,\nits version is kept.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notebook"
+                ],
+                "summary": "move a post to a notebook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "destination",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.Destination"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Post"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/posts/{id}": {
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Patch a post by a JSON Merge Patch (` + "`" + `application/merge-patch+json` + "`" + `, or ` + "`" + `application/json` + "`" + `) or\na JSON Patch (` + "`" + `application/json-patch+json` + "`" + `), applied to the post as it is responded.\nFields omitted are kept, and so are the tags and affiliates which are not operated on. Besides\n` + "`" + `tags` + "`" + ` and ` + "`" + `affiliates` + "`" + `, tags and existing affiliates are bound and unbound by ids in\n` + "`" + `add_tags` + "`" + `, ` + "`" + `remove_tags` + "`" + `, ` + "`" + `add_affiliates` + "`" + ` and ` + "`" + `remove_affiliates` + "`" + `, which are empty arrays.\nThe version being patched can be given by ` + "`" + `version` + "`" + ` or ` + "`" + `If-Match` + "`" + `, a stale version is rejected\nwith the current post. A failed ` + "`" + `test` + "`" + ` of a JSON Patch is a conflict as well.",
+                "consumes": [
+                    "application/json",
+                    "application/merge-patch+json",
+                    "application/json-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "patch a post",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "merge patch or JSON patch",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being patched",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Post"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.conflictMessage"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/controller.referenceMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/posts/{id}/backlinks": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the posts linking to a post, trashed posts are excluded.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "link"
+                ],
+                "summary": "get backlinks of a post",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/entity.LinkedPost"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/posts/{id}/links": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the wiki-style links in the content of a post, ` + "`" + `[[Post Title]]` + "`" + ` or ` + "`" + `[[post:123]]` + "`" + `, in order of appearance.\nA link by title is resolved to the oldest post of the title, case-insensitive. A link to a missing or trashed post is broken.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "link"
+                ],
+                "summary": "get links of a post",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/entity.LinkedPost"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/purge-post/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Permanently delete a trashed post by ID, along with its affiliates and their files.\nOnly trashed posts can be purged.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "trash"
+                ],
+                "summary": "purge a trashed post by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.successMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/query-posts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Find posts by a combination of tags, text and time range, all the given criteria must be met.\n` + "`" + `tag_match` + "`" + ` is ` + "`" + `all` + "`" + ` (default) or ` + "`" + `any` + "`" + `; ` + "`" + `sort` + "`" + ` is ` + "`" + `date` + "`" + ` (default), ` + "`" + `created_at` + "`" + `, ` + "`" + `updated_at` + "`" + ` or ` + "`" + `title` + "`" + `;\n` + "`" + `order` + "`" + ` is ` + "`" + `desc` + "`" + ` (default) or ` + "`" + `asc` + "`" + `. The time range is optional, but ` + "`" + `start` + "`" + ` and ` + "`" + `end` + "`" + ` must be given together.\n` + "`" + `cursor` + "`" + ` (the ` + "`" + `next_cursor` + "`" + ` of the previous page) takes the place of ` + "`" + `page` + "`" + ` when sorted by date.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "query posts",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page number, required without cursor",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "tag ids",
+                        "name": "tag_ids",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "all or any",
+                        "name": "tag_match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "excluded tag ids",
+                        "name": "exclude_tag_ids",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "tags stand for the tags nested in them as well",
+                        "name": "tag_descendants",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "full-text search",
+                        "name": "text",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "time start",
+                        "name": "start",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "time end",
+                        "name": "end",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "time type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "sort field",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "asc or desc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The same as ` + "`" + `GET /query-posts` + "`" + `, but the query is given as a json body, and the notebook as query parameters.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "query posts by a json body",
+                "parameters": [
+                    {
+                        "description": "query",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostQuery"
+                        }
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/restore-post/{id}": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Restore a trashed post by ID, with the tags and affiliates it was bound to.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "trash"
+                ],
+                "summary": "restore a trashed post by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "post ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.successMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/restore-revision/{id}": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Restore a post to a revision by ID, which is recorded as a new revision.\nTags and affiliates deleted or bound to another post since then are skipped.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "revision"
+                ],
+                "summary": "restore a post to a revision",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "revision ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Post"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/revoke-api-key/{prefix}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revoke an API key of the user by its prefix.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "revoke an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key prefix",
+                        "name": "prefix",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.successMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/revoke-share/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revoke a share link by ID, it can't be opened anymore.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "share"
+                ],
+                "summary": "revoke a share link",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "share ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.successMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/save-notebook": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Create a new notebook or update an existing notebook, based on whether the notebook ID is provided.\nOn update, both the name and the parent are replaced, an empty ` + "`" + `parent_id` + "`" + ` moves the notebook to the root.\nA notebook can't be nested in itself, nor in the notebooks nested in it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notebook"
+                ],
+                "summary": "create/update a notebook",
+                "parameters": [
+                    {
+                        "description": "notebook data",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.Notebook"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Notebook"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/save-post": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Save post can be used to create a new post or update an existing post.\nIf id is not provided, it will create a new post; Otherwise, it will update\nan existing post.\nThe version being edited can be given in \"data\" or by ` + "`" + `If-Match` + "`" + `,\na stale version is rejected with the current post.\nThe content may refer to affiliates bound to the post by ` + "`" + `![](affiliate:42)` + "`" + ` or\n` + "`" + `[spec](affiliate:42)` + "`" + `, referring to any other affiliate is rejected, as well as\nunbinding an affiliate which is still referred to.\nThe \"data\" field must precede \"files\" fields, since files are streamed.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "create/update a post",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "post data",
+                        "name": "data",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "affiliate files",
+                        "name": "files",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being edited",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Post"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.conflictMessage"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/controller.referenceMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/save-tag": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Create a new tag or update an existing tag, based on whether the tag ID is provided.\nThe version being edited can be given in the body or by ` + "`" + `If-Match` + "`" + `,\na stale version is rejected with the current tag.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tag"
+                ],
+                "summary": "create/update a tag",
+                "parameters": [
+                    {
+                        "description": "tag data",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/entity.Tag"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being edited",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Tag"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.conflictMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/search-posts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Full-text search over title, subtitle and content, best matches first.\nQuoted phrases, \"or\" and \"-\" (exclusion) are supported. Each result comes\nwith a snippet of the content, in which matched words are wrapped by ` + "`" + `\u003cmark\u003e\u003c/mark\u003e` + "`" + `.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "full-text search posts",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page number",
+                        "name": "page",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "search text",
+                        "name": "text",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostSearchPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/search-posts-by-tags": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get posts by tags",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "get posts by tags",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page number, required without cursor",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "tag ids",
+                        "name": "ids",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the tags nested in them",
+                        "name": "descendants",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostPage"
+                        }
+                    }
+                }
+            }
+        },
+        "/search-posts-by-time": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get posts by title",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "get posts by title",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page number, required without cursor",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "time start",
+                        "name": "start",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "time end",
+                        "name": "end",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "time type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostPage"
+                        }
+                    }
+                }
+            }
+        },
+        "/search-posts-by-title": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get posts by title",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "post"
+                ],
+                "summary": "get posts by title",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page number, required without cursor",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "post title",
+                        "name": "title",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only posts in the notebook",
+                        "name": "notebook_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "including the notebooks nested in it",
+                        "name": "recursive",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.PostPage"
+                        }
+                    }
+                }
+            }
+        },
+        "/shared/{token}": {
+            "get": {
+                "description": "Get the post shared by a link, no account is required.\nA password protected link requires the password by HTTP basic auth, the user name is ignored.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "share"
+                ],
+                "summary": "open a share link",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "share token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.SharedPost"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/shared/{token}/download-file/{id}": {
+            "get": {
+                "description": "Download an affiliate of the post shared by a link, the same as ` + "`" + `download-file` + "`" + `.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "share"
+                ],
+                "summary": "download a file of a share link",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "share token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "affiliate ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "preview in browser",
+                        "name": "inline",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "bytes range, e.g. bytes=0-1023",
+                        "name": "Range",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.errorMessage"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "controller.conflictMessage": {
+            "type": "object",
+            "properties": {
+                "current": {},
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.deleteMessage": {
+            "type": "object",
+            "properties": {
+                "broken_links": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.LinkedPost"
+                    }
+                },
+                "success": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.errorMessage": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.referenceMessage": {
+            "type": "object",
+            "properties": {
+                "affiliate_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.successMessage": {
+            "type": "object",
+            "properties": {
+                "success": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.Affiliate": {
+            "type": "object",
+            "properties": {
+                "checksum": {
+                    "type": "string"
+                },
+                "content_type": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "object_id": {
+                    "type": "string"
+                },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "post_refer": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.AffiliatePage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Affiliate"
+                    }
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.ApiKey": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_used_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.ApiKeyRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "scopes"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "entity.BulkDating": {
+            "type": "object",
+            "properties": {
+                "date": {
+                    "type": "string"
+                },
+                "post_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "entity.BulkIds": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "entity.BulkItemResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "ok": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "entity.BulkReport": {
+            "type": "object",
+            "properties": {
+                "failed": {
+                    "type": "integer"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.BulkItemResult"
+                    }
+                },
+                "succeeded": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.BulkTagging": {
+            "type": "object",
+            "properties": {
+                "add_tag_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "post_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "remove_tag_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "entity.Credentials": {
+            "type": "object",
+            "required": [
+                "name",
+                "password"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.Destination": {
+            "type": "object",
+            "properties": {
+                "notebook_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.DiffLine": {
+            "type": "object",
+            "properties": {
+                "op": {
+                    "type": "string"
+                },
+                "text": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.ImportFileResult": {
+            "type": "object",
+            "properties": {
+                "attachments": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "post_id": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "title": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.ImportReport": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "duplicates": {
+                    "type": "integer"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.ImportFileResult"
+                    }
+                },
+                "tags_created": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "entity.LinkedPost": {
+            "type": "object",
+            "properties": {
+                "broken": {
+                    "description": "the target doesn't exist or is trashed, only links can be broken",
+                    "type": "boolean"
+                },
+                "link": {
+                    "description": "as written in ` + "`" + `[[]]` + "`" + `, e.g. ` + "`" + `Post Title` + "`" + ` or ` + "`" + `post:123` + "`" + `",
+                    "type": "string"
+                },
+                "post_id": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.NewApiKey": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "last_used_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.Notebook": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "parent_id": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.NotebookNode": {
+            "type": "object",
+            "properties": {
+                "children": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.NotebookNode"
+                    }
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "parent_id": {
+                    "type": "integer"
+                },
+                "post_count": {
+                    "description": "posts right in the notebook, trashed posts are not counted",
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.Post": {
+            "type": "object",
+            "properties": {
+                "affiliates": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Affiliate"
+                    }
+                },
+                "content": {
+                    "type": "string"
+                },
+                "content_html": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "date": {
+                    "type": "string"
+                },
+                "deleted_at": {
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "notebook_id": {
+                    "type": "integer"
+                },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "subtitle": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Tag"
+                    }
+                },
+                "title": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.PostPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Post"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.PostQuery": {
+            "type": "object",
+            "properties": {
+                "cursor": {
+                    "type": "string"
+                },
+                "exclude_tag_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "order": {
+                    "type": "string"
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "sort": {
+                    "type": "string"
+                },
+                "tag_descendants": {
+                    "type": "boolean"
+                },
+                "tag_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "tag_match": {
+                    "type": "string"
+                },
+                "text": {
+                    "type": "string"
+                },
+                "time": {
+                    "$ref": "#/definitions/entity.TimeSearch"
+                }
+            }
+        },
+        "entity.PostRevision": {
+            "type": "object",
+            "properties": {
+                "affiliate_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
                     }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/entity.Post"
-                            }
-                        }
+                },
+                "author_id": {
+                    "type": "integer"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "date": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "post_id": {
+                    "type": "integer"
+                },
+                "subtitle": {
+                    "type": "string"
+                },
+                "tag_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
                     }
+                },
+                "title": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
                 }
             }
-        }
-    },
-    "definitions": {
-        "controller.downloadSuccess": {
+        },
+        "entity.PostSearchPage": {
             "type": "object",
             "properties": {
-                "filename": {
-                    "type": "string"
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.PostSearchResult"
+                    }
+                },
+                "page": {
+                    "type": "integer"
                 },
                 "size": {
                     "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
                 }
             }
         },
-        "controller.errorMessage": {
+        "entity.PostSearchResult": {
             "type": "object",
             "properties": {
-                "error": {
+                "post": {
+                    "$ref": "#/definitions/entity.Post"
+                },
+                "rank": {
+                    "type": "number"
+                },
+                "snippet": {
                     "type": "string"
                 }
             }
         },
-        "controller.successMessage": {
+        "entity.RefreshRequest": {
             "type": "object",
+            "required": [
+                "refresh_token"
+            ],
             "properties": {
-                "success": {
+                "refresh_token": {
                     "type": "string"
                 }
             }
         },
-        "entity.Affiliate": {
+        "entity.RevisionDiff": {
+            "type": "object",
+            "properties": {
+                "affiliates_added": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "affiliates_removed": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "content": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.DiffLine"
+                    }
+                },
+                "date_from": {
+                    "type": "string"
+                },
+                "date_to": {
+                    "type": "string"
+                },
+                "from": {
+                    "type": "integer"
+                },
+                "post_id": {
+                    "type": "integer"
+                },
+                "subtitle": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.DiffLine"
+                    }
+                },
+                "tags_added": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "tags_removed": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "title": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.DiffLine"
+                    }
+                },
+                "to": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.RevisionPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.PostRevision"
+                    }
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.Share": {
             "type": "object",
             "properties": {
                 "created_at": {
                     "type": "string"
                 },
-                "filename": {
+                "expires_at": {
                     "type": "string"
                 },
                 "id": {
                     "type": "integer"
                 },
-                "object_id": {
+                "owner_id": {
+                    "type": "integer"
+                },
+                "post_id": {
+                    "type": "integer"
+                },
+                "protected": {
+                    "type": "boolean"
+                },
+                "revoked_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.ShareLink": {
+            "type": "object",
+            "properties": {
+                "created_at": {
                     "type": "string"
                 },
-                "post_refer": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
                     "type": "integer"
                 },
-                "updated_at": {
+                "owner_id": {
+                    "type": "integer"
+                },
+                "post_id": {
+                    "type": "integer"
+                },
+                "protected": {
+                    "type": "boolean"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "token": {
                     "type": "string"
                 }
             }
         },
-        "entity.Post": {
+        "entity.ShareRequest": {
+            "type": "object",
+            "required": [
+                "post_id"
+            ],
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "password": {
+                    "description": "optional, visitors give it by HTTP basic auth",
+                    "type": "string"
+                },
+                "post_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.SharedPost": {
             "type": "object",
             "properties": {
                 "affiliates": {
@@ -497,14 +3838,14 @@ var doc = `{
                 "content": {
                     "type": "string"
                 },
-                "created_at": {
+                "content_html": {
                     "type": "string"
                 },
                 "date": {
                     "type": "string"
                 },
-                "id": {
-                    "type": "integer"
+                "expires_at": {
+                    "type": "string"
                 },
                 "subtitle": {
                     "type": "string"
@@ -512,14 +3853,11 @@ var doc = `{
                 "tags": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/entity.Tag"
+                        "type": "string"
                     }
                 },
                 "title": {
                     "type": "string"
-                },
-                "updated_at": {
-                    "type": "string"
                 }
             }
         },
@@ -541,17 +3879,85 @@ var doc = `{
                 "name": {
                     "type": "string"
                 },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "parent_id": {
+                    "type": "integer"
+                },
                 "posts": {
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/entity.Post"
                     }
                 },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.TimeSearch": {
+            "type": "object",
+            "properties": {
+                "end": {
+                    "type": "string"
+                },
+                "start": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "integer"
+                }
+            }
+        },
+        "entity.TokenPair": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "description": "seconds before the access token expires",
+                    "type": "integer"
+                },
+                "refresh_token": {
+                    "type": "string"
+                },
+                "token_type": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.User": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "role": {
+                    "type": "string"
+                },
                 "updated_at": {
                     "type": "string"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 